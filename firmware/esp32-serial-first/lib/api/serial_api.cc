@@ -6,7 +6,7 @@ namespace lib {
 namespace api {
 
 void SerialApi::sendSliders(const std::string& data) {
-  Serial.println(data.c_str());
+  writeLine(data);
 
   // Optionally read "OK\n" response but timeout silently if not received
   readResponse();
@@ -16,7 +16,7 @@ bool SerialApi::sendMuteButton(int button_index, bool state) {
   // Build message format: "MuteButton|index|state\n"
   std::string message = "MuteButton|" + std::to_string(button_index) + "|" +
                         (state ? "1" : "0");
-  Serial.println(message.c_str());
+  writeLine(message);
 
   // Parse response: "OK\n"
   std::string response = readResponse();
@@ -28,7 +28,7 @@ bool SerialApi::sendMuteButton(int button_index, bool state) {
 bool SerialApi::sendSwitchOutput(int device_index) {
   // Build message format: "SwitchOutput|index\n"
   std::string message = "SwitchOutput|" + std::to_string(device_index);
-  Serial.println(message.c_str());
+  writeLine(message);
 
   // Parse response: "OK\n"
   std::string response = readResponse();
@@ -37,20 +37,157 @@ bool SerialApi::sendSwitchOutput(int device_index) {
   return (response == "OK");
 }
 
+bool SerialApi::sendLayer(int layer_index) {
+  // Build message format: "Layer|index\n"
+  std::string message = "Layer|" + std::to_string(layer_index);
+  writeLine(message);
+
+  // Parse response: "OK\n" or "ERROR\n"
+  std::string response = readResponse();
+
+  // Return true if we got "OK", false on timeout/error
+  return (response == "OK");
+}
+
+int SerialApi::sendHello(int protocol_version, int num_sliders,
+                         int num_buttons, int num_device_leds,
+                         const std::string& firmware_version,
+                         bool offer_binary_sliders) {
+  // Build message format:
+  // "Hello|protocol|sliders|buttons|device_leds|firmware_version[|binary]\n"
+  std::string message = "Hello|" + std::to_string(protocol_version) + "|" +
+                        std::to_string(num_sliders) + "|" +
+                        std::to_string(num_buttons) + "|" +
+                        std::to_string(num_device_leds) + "|" +
+                        firmware_version;
+  if (offer_binary_sliders) {
+    message += "|binary";
+  }
+  writeLine(message);
+
+  // Parse response: "Hello|protocol[|capabilities]\n"
+  std::vector<std::string> parts = parseResponse(readResponse());
+  if (parts.size() < 2 || parts[0] != "Hello") {
+    return -1;
+  }
+
+  _binary_sliders = offer_binary_sliders && parts.size() == 3 &&
+                    parts[2].find("binary") != std::string::npos;
+
+  return parseInt(parts[1]);
+}
+
+void SerialApi::sendSliderValues(const std::vector<int>& values) {
+  std::vector<uint8_t> frame = {0xA5, static_cast<uint8_t>(values.size())};
+
+  for (size_t i = 0; i < values.size(); i++) {
+    uint16_t value = constrain(values[i], 0, 4095);
+    if (i % 2 == 0) {
+      frame.push_back(value >> 4);
+      frame.push_back((value & 0xF) << 4);
+    } else {
+      frame.back() |= value >> 8;
+      frame.push_back(value & 0xFF);
+    }
+  }
+
+  Serial.write(frame.data(), frame.size());
+}
+
+void SerialApi::writeLine(const std::string& line) {
+  if (!_framed) {
+    Serial.println(line.c_str());
+    return;
+  }
+
+  // Sequence numbers wrap around to 1, as 0 tells the backend we restarted
+  std::string body = std::to_string(_next_seq) + ":" + line;
+  _next_seq = _next_seq == 65535 ? 1 : _next_seq + 1;
+
+  char crc[5];
+  snprintf(crc, sizeof(crc), "%04X", crc16(body));
+  Serial.println(("@" + body + "*" + crc).c_str());
+}
+
+bool SerialApi::unframe(const std::string& frame, std::string* line) {
+  size_t crc_start = frame.rfind('*');
+  size_t seq_end = frame.find(':');
+  if (frame.empty() || frame[0] != '@' || crc_start == std::string::npos ||
+      seq_end == std::string::npos || seq_end > crc_start ||
+      frame.size() - crc_start - 1 != 4) {
+    return false;
+  }
+
+  std::string body = frame.substr(1, crc_start - 1);
+  if (strtoul(frame.substr(crc_start + 1).c_str(), nullptr, 16) !=
+      crc16(body)) {
+    return false;
+  }
+
+  *line = frame.substr(seq_end + 1, crc_start - seq_end - 1);
+  return true;
+}
+
+uint16_t SerialApi::crc16(const std::string& data) {
+  uint16_t crc = 0xFFFF;
+  for (char c : data) {
+    crc ^= static_cast<uint16_t>(static_cast<uint8_t>(c)) << 8;
+    for (int bit = 0; bit < 8; bit++) {
+      crc = (crc & 0x8000) ? (crc << 1) ^ 0x1021 : crc << 1;
+    }
+  }
+  return crc;
+}
+
+bool SerialApi::handlePush(const std::string& line) {
+  // Lines from the main loop haven't been unframed yet
+  std::string unframed = line;
+  if (_framed && !line.empty() && line[0] == '@' && !unframe(line, &unframed)) {
+    return false;
+  }
+
+  std::vector<std::string> parts = parseResponse(unframed);
+  if (parts.empty() ||
+      (parts[0] != "MuteState" && parts[0] != "OutputDevice" &&
+       parts[0] != "Layer")) {
+    return false;
+  }
+
+  if (_push_handler) {
+    _push_handler(parts);
+  }
+
+  return true;
+}
+
 std::string SerialApi::readResponse() {
   unsigned long start_time = millis();
 
-  // Wait for data to be available or timeout
-  while (!Serial.available()) {
-    if (millis() - start_time > _timeout_ms) {
-      return "";  // Timeout - return empty string
+  while (true) {
+    // Wait for data to be available or timeout
+    while (!Serial.available()) {
+      if (millis() - start_time > _timeout_ms) {
+        return "";  // Timeout - return empty string
+      }
+      delay(1);  // Small delay to prevent busy-waiting
+    }
+
+    // Read the response
+    String response = Serial.readStringUntil('\n');
+    response.trim();
+
+    // Once we frame our lines, the backend frames its replies too. Corrupt
+    // ones are treated like a missed reply.
+    std::string line(response.c_str());
+    if (_framed && !unframe(line, &line)) {
+      continue;
     }
-    delay(1);  // Small delay to prevent busy-waiting
-  }
 
-  // Read the response
-  String response = Serial.readStringUntil('\n');
-  return std::string(response.c_str());
+    // The backend can push state at any time, which isn't our response
+    if (!handlePush(line)) {
+      return line;
+    }
+  }
 }
 
 std::vector<std::string> SerialApi::parseResponse(const std::string& response) {
@@ -3,6 +3,7 @@
 
 #include <Arduino.h>
 
+#include <functional>
 #include <string>
 #include <vector>
 
@@ -11,7 +12,27 @@ namespace api {
 
 class SerialApi {
  public:
-  SerialApi() : _timeout_ms(100) {}
+  // Receives the fields of a line the backend pushed on its own, such as
+  // {"MuteState", "0", "1"}, {"OutputDevice", "1"} or {"Layer", "2"}
+  using PushHandler = std::function<void(const std::vector<std::string>&)>;
+
+  SerialApi() : SerialApi(false) {}
+
+  // A framed api wraps every line as "@<seq>:<line>*<crc16>", so that the
+  // backend can drop lines that were corrupted on the way
+  explicit SerialApi(bool framed)
+      : _timeout_ms(100),
+        _framed(framed),
+        _next_seq(0),
+        _binary_sliders(false) {}
+
+  // Set the handler for lines the backend pushes when state changes outside
+  // of this board (e.g. the mic is muted from the OS)
+  void setPushHandler(PushHandler handler) { _push_handler = handler; }
+
+  // Handle a line that arrived outside of a request, returns true if it was
+  // a pushed line
+  bool handlePush(const std::string& line);
 
   // Send slider values and optionally wait for "OK\n" response
   void sendSliders(const std::string& data);
@@ -22,8 +43,39 @@ class SerialApi {
   // Send output device switch request and return true if acknowledged
   bool sendSwitchOutput(int device_index);
 
+  // Send slider layer switch request and return true if acknowledged. The
+  // backend refuses layers that its config doesn't have.
+  bool sendLayer(int layer_index);
+
+  // Identify this board to the backend and return the backend's protocol
+  // version, or -1 if it didn't reply. Offering binary sliders switches to
+  // sendSliderValues' binary frames if the backend confirms them.
+  int sendHello(int protocol_version, int num_sliders, int num_buttons,
+                int num_device_leds, const std::string& firmware_version,
+                bool offer_binary_sliders = false);
+
+  // Whether the backend agreed on binary slider frames during the handshake
+  inline bool binarySliders() const { return _binary_sliders; }
+
+  // Send slider values as a binary frame: 0xA5, the number of values, then
+  // the values packed as 12-bit big-endian integers. Not acknowledged.
+  void sendSliderValues(const std::vector<int>& values);
+
  private:
   const int _timeout_ms;
+  const bool _framed;
+  uint16_t _next_seq;
+  bool _binary_sliders;
+  PushHandler _push_handler;
+
+  // Helper to write a line, framed if enabled
+  void writeLine(const std::string& line);
+
+  // Helper to unwrap a framed line, returns false if it's corrupt
+  bool unframe(const std::string& frame, std::string* line);
+
+  // CRC-16/CCITT-FALSE, as used by the backend
+  static uint16_t crc16(const std::string& data);
 
   // Helper to read response with timeout
   std::string readResponse();
@@ -22,6 +22,23 @@
 #define AUDIO_DEVICE_SELECTOR_BUTTON_DEV_0_LED_PIN 18
 #define AUDIO_DEVICE_SELECTOR_BUTTON_DEV_1_LED_PIN 19
 
+#define FIRMWARE_VERSION "1.3.0"
+#define PROTOCOL_VERSION 3
+#define NUM_DEVICE_LEDS 2
+
+// Cycle through the backend's slider layers with a button, and light an LED
+// while any layer but the first is active. -1 if the board doesn't have them
+#define LAYER_BUTTON_PIN -1
+#define LAYER_LED_PIN -1
+
+// Frame every line with a sequence number and a CRC, so that the backend can
+// drop corrupted ones (e.g. on long or noisy USB cables)
+#define FRAMED_PROTOCOL false
+
+// Offer to send slider values as compact binary frames instead of text, which
+// allows for higher update rates on boards with many sliders
+#define BINARY_SLIDERS false
+
 using lib::api::SerialApi;
 using lib::input_components::AudioDeviceSelector;
 using lib::input_components::MuteButton;
@@ -31,6 +48,14 @@ SerialApi *serial_api = nullptr;
 std::vector<MuteButton *> *mute_buttons = nullptr;
 std::vector<Slider *> *sliders = nullptr;
 AudioDeviceSelector *audio_device_selector = nullptr;
+int active_layer = 0;
+
+void showLayer(int layer) {
+  active_layer = layer;
+  if (LAYER_LED_PIN >= 0) {
+    digitalWrite(LAYER_LED_PIN, layer != 0 ? HIGH : LOW);
+  }
+}
 
 void setup() {
   Serial.begin(115200);
@@ -61,7 +86,31 @@ void setup() {
       AUDIO_DEVICE_SELECTOR_BUTTON_DEV_1_LED_PIN, output_devices_mute_button,
       []() { esp_restart(); });
 
-  serial_api = new SerialApi();
+  if (LAYER_BUTTON_PIN >= 0) {
+    pinMode(LAYER_BUTTON_PIN, INPUT_PULLUP);
+  }
+  if (LAYER_LED_PIN >= 0) {
+    pinMode(LAYER_LED_PIN, OUTPUT);
+  }
+
+  serial_api = new SerialApi(FRAMED_PROTOCOL);
+
+  // Keep the LEDs in sync with changes made outside of this board
+  serial_api->setPushHandler([](const std::vector<std::string> &parts) {
+    if (parts[0] == "MuteState") {
+      for (size_t i = 1; i < parts.size() && i <= mute_buttons->size(); i++) {
+        mute_buttons->at(i - 1)->setActiveSessionMuteState(parts[i] == "1");
+      }
+    } else if (parts[0] == "OutputDevice" && parts.size() == 2) {
+      int device = atoi(parts[1].c_str());
+      if (device >= 0 && device != audio_device_selector->getActiveDevice()) {
+        audio_device_selector->setActiveDevice(device);
+      }
+    } else if (parts[0] == "Layer" && parts.size() == 2) {
+      // The layer was switched from the tray or a hotkey
+      showLayer(atoi(parts[1].c_str()));
+    }
+  });
 
   // // Visually indicate that the system is ready.
   // util::sequentialLEDOn(MUTE_BUTTON_0_LED_PIN, MUTE_BUTTON_1_LED_PIN,
@@ -115,7 +164,14 @@ void loop() {
         digitalWrite(MUTE_BUTTON_0_LED_PIN, LOW);  // Turn off blink LED
         util::sequentialLEDOn(MUTE_BUTTON_0_LED_PIN, MUTE_BUTTON_1_LED_PIN,
                               AUDIO_DEVICE_SELECTOR_BUTTON_DEV_1_LED_PIN, 300);
-                                // Initialize state and sync with backend
+
+        // 0. Identify ourselves, so the backend can check its config against
+        // this board. Older backends don't reply, which is fine.
+        serial_api->sendHello(PROTOCOL_VERSION, sliders->size(),
+                              mute_buttons->size(), NUM_DEVICE_LEDS,
+                              FIRMWARE_VERSION, BINARY_SLIDERS);
+
+        // Initialize state and sync with backend
         // 1. Set default output device to speakers (device 0)
         audio_device_selector->setActiveDevice(0);
         serial_api->sendSwitchOutput(0);  // Notify backend of initial device
@@ -127,15 +183,14 @@ void loop() {
     return;
   }
 
-  // Check for unsolicited messages from backend (e.g., "Connected")
-  // NOTE: This consumes the message from the serial buffer. In the current design,
-  // "Connected" is the only unsolicited message and is sent right at startup before
-  // any request/response exchanges happen, so there's no conflict with SerialApi.
-  // If we add more unsolicited messages in the future, we'll need a message router.
+  // Check for unsolicited messages from backend (e.g., "Connected" or pushed
+  // state). Pushes that arrive while waiting for a response are handled by
+  // SerialApi itself.
   if (Serial.available()) {
     String incoming = Serial.readStringUntil('\n');
     incoming.trim();
     // Ignore any other unsolicited messages during normal operation
+    serial_api->handlePush(std::string(incoming.c_str()));
   }
 
   // PRIORITY 1: Check mute buttons FIRST (most critical for user responsiveness)
@@ -159,8 +214,22 @@ void loop() {
     new_device = value;
   }
 
+  // PRIORITY 2b: Check layer button (pressed = LOW with the pullup)
+  static bool previous_layer_button = false;
+  static unsigned long last_layer_press = 0;
+  bool layer_pressed = false;
+  if (LAYER_BUTTON_PIN >= 0) {
+    bool pressed = digitalRead(LAYER_BUTTON_PIN) == LOW;
+    if (pressed && !previous_layer_button && millis() - last_layer_press > 200) {
+      layer_pressed = true;
+      last_layer_press = millis();
+    }
+    previous_layer_button = pressed;
+  }
+
   // PRIORITY 3: Read slider values with threshold-based change detection
   std::string sliders_data = "Sliders";
+  std::vector<int> slider_values;
   bool sliders_changed = false;
   std::vector<int> auto_mute_action(mute_buttons->size(), -1);  // -1=no action, 0=unmute, 1=mute
 
@@ -168,6 +237,7 @@ void loop() {
     auto [changed, value] = sliders->at(i)->getValue();
     sliders_data += "|";
     sliders_data += std::to_string(value);
+    slider_values.push_back(value);
 
     // Check if change is significant enough to send (reduce jitter/spam)
     bool significant_change = false;
@@ -297,9 +367,24 @@ void loop() {
     }
   }
 
+  // 2b. Send layer switches. This board doesn't know how many layers the
+  // backend has, so after the last one is refused it starts over
+  if (layer_pressed) {
+    int next_layer = active_layer + 1;
+    if (serial_api->sendLayer(next_layer)) {
+      showLayer(next_layer);
+    } else if (serial_api->sendLayer(0)) {
+      showLayer(0);
+    }
+  }
+
   // 3. Send slider changes only when significant (lower priority, reduced spam)
   if (sliders_changed) {
-    serial_api->sendSliders(sliders_data);
+    if (serial_api->binarySliders()) {
+      serial_api->sendSliderValues(slider_values);
+    } else {
+      serial_api->sendSliders(sliders_data);
+    }
   }
 
   delay(50);
@@ -3,13 +3,16 @@ module github.com/tomerhh/deej
 go 1.14
 
 require (
+	github.com/eclipse/paho.mqtt.golang v1.2.0
 	github.com/fsnotify/fsnotify v1.4.9
 	github.com/gen2brain/beeep v0.0.0-20200420150314-13046a26d502
 	github.com/getlantern/ops v0.0.0-20200403153110-8476b16edcd6 // indirect
 	github.com/getlantern/systray v0.0.0-20200324212034-d3ab4fd25d99
 	github.com/go-ole/go-ole v1.2.6
 	github.com/gopherjs/gopherjs v0.0.0-20200217142428-fce0ec30dd00 // indirect
+	github.com/gorilla/websocket v1.4.2
 	github.com/jacobsa/go-serial v0.0.0-20180131005756-15cf729a72d4
+	github.com/jezek/xgb v1.1.1
 	github.com/jfreymuth/pulse v0.0.0-20200608153616-84b2d752b9d4
 	github.com/lxn/walk v0.0.0-20191128110447-55ccb3a9f5c1 // indirect
 	github.com/lxn/win v0.0.0-20191128105842-2da648fda5b4
@@ -17,6 +20,6 @@ require (
 	github.com/moutend/go-wca v0.3.0
 	github.com/spf13/viper v1.7.1
 	github.com/thoas/go-funk v0.7.0
-	go.bug.st/serial v1.6.4 // indirect
+	go.bug.st/serial v1.6.4
 	go.uber.org/zap v1.15.0
 )
@@ -0,0 +1,522 @@
+package deej
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// the API is an optional HTTP server on localhost, which lets other tools (i.e. stream deck plugins) read and
+// drive deej with JSON requests. every request needs the configured token as "Authorization: Bearer <token>"
+const (
+	apiPathPrefix        = "/api/"
+	apiPathSessions      = "/api/sessions"
+	apiPathSliders       = "/api/sliders"
+	apiPathRefresh       = "/api/refresh"
+	apiPathOutputDevices = "/api/output-devices"
+	apiPathEvents        = "/api/events"
+
+	defaultAPIAddress = "127.0.0.1:7392"
+
+	// tokens have to be long enough to not be guessed by whatever else runs on this machine
+	minAPITokenLength = 16
+
+	maxAPIRequestSize  = 4096
+	apiShutdownTimeout = 2 * time.Second
+)
+
+// apiConfig holds every config value of the API server
+type apiConfig struct {
+	Enabled bool
+	Address string
+	Token   string
+}
+
+// apiServer serves the API whenever it's enabled, and follows its config through reloads
+type apiServer struct {
+	deej   *Deej
+	logger *zap.SugaredLogger
+
+	config apiConfig
+	server *http.Server
+	events *eventStream
+
+	// the last position of every slider, as reported by slider move events. -1 until a slider moves
+	sliderValues []float32
+	sliderLock   sync.Mutex
+}
+
+// apiSession describes a single audio session
+type apiSession struct {
+	Key    string  `json:"key"`
+	Volume float32 `json:"volume"`
+	Mute   bool    `json:"mute"`
+}
+
+// apiSessionChange is the body of a session change request, where either field can be left out
+type apiSessionChange struct {
+	Volume *float32 `json:"volume"`
+	Mute   *bool    `json:"mute"`
+}
+
+// apiSlider describes a single slider and the targets it controls right now
+type apiSlider struct {
+	Index   int      `json:"index"`
+	Value   *float32 `json:"value"`
+	Targets []string `json:"targets"`
+}
+
+// apiSliders describes every slider, along with the layer and profile that their targets come from
+type apiSliders struct {
+	Layer     int         `json:"layer"`
+	LayerName string      `json:"layer_name"`
+	Profile   string      `json:"profile"`
+	Sliders   []apiSlider `json:"sliders"`
+}
+
+// apiOutputDevice describes one of the configured output devices
+type apiOutputDevice struct {
+	Index int      `json:"index"`
+	Names []string `json:"names"`
+}
+
+// apiOutputDevices describes the configured output devices, and which of them is the default (-1 for none)
+type apiOutputDevices struct {
+	Devices []apiOutputDevice `json:"devices"`
+	Current int               `json:"current"`
+}
+
+// apiOutputDeviceChange is the body of an output device switch request
+type apiOutputDeviceChange struct {
+	Index *int `json:"index"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func newAPIServer(deej *Deej, logger *zap.SugaredLogger) *apiServer {
+	logger = logger.Named("api")
+
+	a := &apiServer{
+		deej:   deej,
+		logger: logger,
+		events: newEventStream(logger),
+	}
+
+	logger.Debug("Created API server instance")
+
+	return a
+}
+
+// initialize follows slider moves, controller events and config reloads, and starts serving if the API is enabled
+func (a *apiServer) initialize() {
+	sliderMoveChannel := a.deej.subscribeToSliderMoveEvents()
+	muteButtonChannel, outputDeviceChannel, connectionChannel := a.deej.subscribeToControllerEvents()
+	configReloadedChannel := a.deej.config.SubscribeToChanges()
+
+	a.applyConfig()
+
+	go func() {
+		for {
+			select {
+			case event := <-sliderMoveChannel:
+				a.recordSliderMove(event)
+				a.events.publish(eventTypeSlider, sliderStreamEvent{Slider: event.SliderID, Value: event.PercentValue})
+			case event := <-muteButtonChannel:
+				a.events.publish(eventTypeMuteButton, muteButtonStreamEvent{Button: event.MuteButtonID, Mute: event.mute})
+			case deviceIdx := <-outputDeviceChannel:
+				a.events.publish(eventTypeOutputDevice, outputDeviceStreamEvent{Index: deviceIdx})
+			case event := <-connectionChannel:
+				a.events.publish(eventTypeConnection, connectionStreamEvent{Connected: event.Connected, Transport: event.Transport})
+			case <-configReloadedChannel:
+				a.applyConfig()
+				a.events.publish(eventTypeConfigReload, nil)
+			}
+		}
+	}()
+}
+
+// applyConfig starts, stops or restarts the server when its config changed
+func (a *apiServer) applyConfig() {
+	config := a.deej.config.API
+	if config == a.config {
+		return
+	}
+
+	a.stop()
+	a.config = config
+
+	if !config.Enabled {
+		return
+	}
+
+	if err := a.start(); err != nil {
+		a.logger.Warnw("Failed to start API server", "address", config.Address, "error", err)
+		a.deej.notifier.Notify("Can't start the API!",
+			fmt.Sprintf("Please check the api section in %s: %s", userConfigFilepath, err))
+	}
+}
+
+func (a *apiServer) start() error {
+
+	// listen before serving, so that a taken port is reported right away
+	listener, err := net.Listen("tcp", a.config.Address)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", a.config.Address, err)
+	}
+
+	a.server = &http.Server{Handler: a.handler(a.config.Token)}
+
+	go func(server *http.Server) {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			a.logger.Warnw("API server stopped", "error", err)
+		}
+	}(a.server)
+
+	a.logger.Infow("Serving API", "address", listener.Addr())
+
+	return nil
+}
+
+func (a *apiServer) stop() {
+	if a.server == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), apiShutdownTimeout)
+	defer cancel()
+
+	if err := a.server.Shutdown(ctx); err != nil {
+		a.logger.Warnw("Failed to stop API server gracefully", "error", err)
+	}
+
+	a.events.closeClients()
+
+	a.server = nil
+	a.logger.Debug("Stopped API server")
+}
+
+func (a *apiServer) recordSliderMove(event SliderMoveEvent) {
+	a.sliderLock.Lock()
+	defer a.sliderLock.Unlock()
+
+	for len(a.sliderValues) <= event.SliderID {
+		a.sliderValues = append(a.sliderValues, -1)
+	}
+
+	a.sliderValues[event.SliderID] = event.PercentValue
+}
+
+// handler routes API requests, after checking that they carry the given token
+func (a *apiServer) handler(token string) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(apiPathSessions, a.handleSessions)
+	mux.HandleFunc(apiPathSessions+"/", a.handleSession)
+	mux.HandleFunc(apiPathSliders, a.handleSliders)
+	mux.HandleFunc(apiPathRefresh, a.handleRefresh)
+	mux.HandleFunc(apiPathOutputDevices, a.handleOutputDevices)
+	mux.Handle(apiPathEvents, a.events)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !apiAuthorized(r, token) {
+			a.logger.Warnw("Rejected unauthorized API request", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+			writeAPIError(w, http.StatusUnauthorized, "missing or invalid token")
+
+			return
+		}
+
+		if a.deej.Verbose() {
+			a.logger.Debugw("Handling API request", "method", r.Method, "path", r.URL.Path)
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxAPIRequestSize)
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// apiAuthorized returns true if the request carries the given token. the comparison takes the same time
+// however much of the token matches, so that it can't be guessed one character at a time
+func apiAuthorized(r *http.Request, token string) bool {
+	requestToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	// browsers can't set headers on WebSockets. query strings end up in logs more easily, so they're only for those
+	if requestToken == "" && r.URL.Path == apiPathEvents {
+		requestToken = r.URL.Query().Get("token")
+	}
+	if token == "" || requestToken == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(requestToken), []byte(token)) == 1
+}
+
+// handleSessions lists every audio session, sorted by key
+func (a *apiServer) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	sessions := []apiSession{}
+	for _, session := range a.deej.sessions.allSessions() {
+		sessions = append(sessions, apiSession{Key: session.Key(), Volume: session.GetVolume(), Mute: session.GetMute()})
+	}
+
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].Key < sessions[j].Key
+	})
+
+	writeAPIResponse(w, sessions)
+}
+
+// handleSession changes the volume and/or mute state of every session that a target resolves to,
+// which can be a session key as well as a special target such as master, mic or deej.current
+func (a *apiServer) handleSession(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodPost, http.MethodPatch) {
+		return
+	}
+
+	target := strings.TrimPrefix(r.URL.Path, apiPathSessions+"/")
+	if target == "" {
+		writeAPIError(w, http.StatusNotFound, "missing session")
+		return
+	}
+
+	change := apiSessionChange{}
+	if !readAPIRequest(w, r, &change) {
+		return
+	}
+
+	if change.Volume == nil && change.Mute == nil {
+		writeAPIError(w, http.StatusBadRequest, "expected volume and/or mute")
+		return
+	}
+
+	if change.Volume != nil && (*change.Volume < 0 || *change.Volume > 1) {
+		writeAPIError(w, http.StatusBadRequest, "volume must be between 0.0 and 1.0")
+		return
+	}
+
+	sessions := a.deej.sessions.targetSessions(target)
+	if len(sessions) == 0 {
+		writeAPIError(w, http.StatusNotFound, fmt.Sprintf("no sessions for '%s'", target))
+		return
+	}
+
+	a.logger.Infow("Changing sessions from API", "target", target, "sessions", len(sessions))
+
+	result := []apiSession{}
+
+	for _, session := range sessions {
+		if change.Volume != nil {
+			if err := session.SetVolume(*change.Volume); err != nil {
+				a.logger.Warnw("Failed to set session volume from API", "session", session, "error", err)
+				writeAPIError(w, http.StatusInternalServerError, fmt.Sprintf("set volume: %s", err))
+
+				return
+			}
+		}
+
+		if change.Mute != nil {
+			if err := session.SetMute(*change.Mute); err != nil {
+				a.logger.Warnw("Failed to set session mute state from API", "session", session, "error", err)
+				writeAPIError(w, http.StatusInternalServerError, fmt.Sprintf("set mute: %s", err))
+
+				return
+			}
+		}
+
+		result = append(result, apiSession{Key: session.Key(), Volume: session.GetVolume(), Mute: session.GetMute()})
+	}
+
+	if change.Mute != nil {
+		a.deej.sessions.requestStatePush()
+	}
+
+	writeAPIResponse(w, result)
+}
+
+// handleSliders describes every slider, its last position and the targets it controls in the active layer and profile
+func (a *apiServer) handleSliders(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	layer := a.deej.ActiveLayer()
+	profileName, _ := a.deej.ActiveProfile()
+
+	a.sliderLock.Lock()
+	values := append([]float32{}, a.sliderValues...)
+	a.sliderLock.Unlock()
+
+	numSliders := len(values)
+	if mapped := a.deej.config.numMappedSliders(); mapped > numSliders {
+		numSliders = mapped
+	}
+
+	result := apiSliders{
+		Layer:     layer,
+		LayerName: a.deej.config.layerName(layer),
+		Profile:   profileName,
+		Sliders:   make([]apiSlider, numSliders),
+	}
+
+	for sliderIdx := range result.Sliders {
+		slider := apiSlider{Index: sliderIdx, Targets: []string{}}
+
+		if sliderIdx < len(values) && values[sliderIdx] >= 0 {
+			slider.Value = &values[sliderIdx]
+		}
+
+		if targets, ok := a.deej.config.sliderTargets(profileName, layer, sliderIdx); ok {
+			slider.Targets = targets
+		}
+
+		result.Sliders[sliderIdx] = slider
+	}
+
+	writeAPIResponse(w, result)
+}
+
+// handleRefresh re-acquires every audio session
+func (a *apiServer) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	a.logger.Info("Refreshing sessions from API")
+
+	// performance: scripts could call this in a loop, but so could users clicking the tray item,
+	// and a forced refresh is the only way to get rid of stuck sessions
+	a.deej.sessions.refreshSessions(true)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleOutputDevices lists the configured output devices, or switches to one of them
+func (a *apiServer) handleOutputDevices(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		change := apiOutputDeviceChange{}
+		if !readAPIRequest(w, r, &change) {
+			return
+		}
+
+		if change.Index == nil {
+			writeAPIError(w, http.StatusBadRequest, "expected index")
+			return
+		}
+
+		if _, ok := a.deej.config.AvailableOutputDeviceMapping.get(*change.Index); !ok {
+			writeAPIError(w, http.StatusNotFound, fmt.Sprintf("no output device %d in available_output_device", *change.Index))
+			return
+		}
+
+		a.logger.Infow("Switching output device from API", "index", *change.Index)
+
+		event := ToggleOutoutDeviceClickEvent{selectedOutputDevice: *change.Index}
+		state, err := a.deej.sessions.handleToggleOutputDeviceClickedEventAndGetState(event)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		a.events.publish(eventTypeOutputDevice, outputDeviceStreamEvent{Index: state.selectedOutputDevice})
+	}
+
+	result := apiOutputDevices{Devices: []apiOutputDevice{}, Current: -1}
+
+	a.deej.config.AvailableOutputDeviceMapping.iterate(func(deviceIdx int, deviceNames []string) {
+		result.Devices = append(result.Devices, apiOutputDevice{Index: deviceIdx, Names: deviceNames})
+	})
+
+	sort.Slice(result.Devices, func(i, j int) bool {
+		return result.Devices[i].Index < result.Devices[j].Index
+	})
+
+	if len(result.Devices) > 0 {
+		result.Current = a.deej.sessions.getCurrentOutputDeviceIndex()
+	}
+
+	writeAPIResponse(w, result)
+}
+
+// allowMethods returns true if the request uses one of the given methods, and responds with an error otherwise
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeAPIError(w, http.StatusMethodNotAllowed, fmt.Sprintf("use %s", strings.Join(methods, " or ")))
+
+	return false
+}
+
+// readAPIRequest decodes a JSON request body, and responds with an error if it can't
+func readAPIRequest(w http.ResponseWriter, r *http.Request, body interface{}) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %s", err))
+		return false
+	}
+
+	return true
+}
+
+func writeAPIResponse(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiError{Error: message})
+}
+
+// apiConfigFromConfig reads the api section of the user config, and makes sure it's only reachable from this machine
+func (cc *CanonicalConfig) apiConfigFromConfig() (apiConfig, error) {
+	config := apiConfig{
+		Enabled: cc.userConfig.GetBool(configKeyAPIEnabled),
+		Address: cc.userConfig.GetString(configKeyAPIAddress),
+		Token:   cc.userConfig.GetString(configKeyAPIToken),
+	}
+
+	if !config.Enabled {
+		return config, nil
+	}
+
+	host, _, err := net.SplitHostPort(config.Address)
+	if err != nil {
+		return apiConfig{}, fmt.Errorf("invalid address '%s': %w", config.Address, err)
+	}
+
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return apiConfig{}, errors.New("address has to be on localhost, i.e. 127.0.0.1")
+	}
+
+	if len(config.Token) < minAPITokenLength {
+		return apiConfig{}, fmt.Errorf("token has to be at least %d characters long", minAPITokenLength)
+	}
+
+	return config, nil
+}
@@ -0,0 +1,298 @@
+package deej
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+const testAPIToken = "0123456789abcdef"
+
+const testAPIConfig = `
+slider_mapping:
+  0: master
+  1: [spotify.exe, discord.exe]
+available_output_device:
+  0: ["Speakers"]
+  1: ["Headphones"]
+api:
+  enabled: true
+  address: 127.0.0.1:0
+  token: 0123456789abcdef
+`
+
+// newTestAPIServer creates an API server for a session map with the given sessions
+func newTestAPIServer(t *testing.T, sf *fakeSessionFinder) *apiServer {
+	m := newTestSessionMap(t, testAPIConfig, sf)
+	m.deej.sessions = m
+
+	return newAPIServer(m.deej, zap.NewNop().Sugar())
+}
+
+// apiRequest sends a request with the test token to the API, and decodes its JSON response into result if it's given
+func apiRequest(t *testing.T, handler http.Handler, method string, path string, body string, result interface{}) int {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+testAPIToken)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if result != nil && w.Code < 300 {
+		if err := json.Unmarshal(w.Body.Bytes(), result); err != nil {
+			t.Fatalf("%s %s: failed to decode response '%s': %v", method, path, w.Body.String(), err)
+		}
+	}
+
+	return w.Code
+}
+
+// TestAPIConfig tests that the API only listens on localhost, and only with a token that's hard to guess
+func TestAPIConfig(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, testAPIConfig)
+	defer cleanup()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if api := deej.config.API; !api.Enabled || api.Address != "127.0.0.1:0" || api.Token != testAPIToken {
+		t.Errorf("Expected the API config from the file, got %+v", api)
+	}
+
+	for _, test := range []struct {
+		api   string
+		fails bool
+	}{
+		{"api:\n  enabled: false\n  address: 0.0.0.0:7392", false},
+		{"api:\n  enabled: true\n  address: localhost:7392\n  token: " + testAPIToken, false},
+		{"api:\n  enabled: true\n  address: '[::1]:7392'\n  token: " + testAPIToken, false},
+		{"api:\n  enabled: true\n  token: " + testAPIToken, false},
+		{"api:\n  enabled: true\n  address: 0.0.0.0:7392\n  token: " + testAPIToken, true},
+		{"api:\n  enabled: true\n  address: 192.168.1.10:7392\n  token: " + testAPIToken, true},
+		{"api:\n  enabled: true\n  address: 127.0.0.1\n  token: " + testAPIToken, true},
+		{"api:\n  enabled: true\n  token: secret", true},
+		{"api:\n  enabled: true", true},
+	} {
+		cleanup := createTestConfig(t, "slider_mapping:\n  0: master\n"+test.api+"\n")
+
+		if err := deej.config.Load(); (err != nil) != test.fails {
+			t.Errorf("'%s': expected failure %v, got %v", test.api, test.fails, err)
+		}
+
+		cleanup()
+	}
+}
+
+// TestAPIAuth tests that requests without the token are turned away
+func TestAPIAuth(t *testing.T) {
+	a := newTestAPIServer(t, &fakeSessionFinder{})
+	handler := a.handler(testAPIToken)
+
+	for _, authorization := range []string{"", "Bearer", "Bearer ", "Bearer 0123456789abcdeX", testAPIToken + "0"} {
+		r := httptest.NewRequest(http.MethodGet, apiPathSessions, nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("'%s': expected status %d, got %d", authorization, http.StatusUnauthorized, w.Code)
+		}
+	}
+
+	// a server without a token takes no requests at all
+	r := httptest.NewRequest(http.MethodGet, apiPathSessions, nil)
+	r.Header.Set("Authorization", "Bearer ")
+
+	w := httptest.NewRecorder()
+	a.handler("").ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d without a token, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	if code := apiRequest(t, handler, http.MethodGet, apiPathSessions, "", nil); code != http.StatusOK {
+		t.Errorf("Expected status %d with the token, got %d", http.StatusOK, code)
+	}
+}
+
+// TestAPISessions tests listing sessions and changing their volume and mute state
+func TestAPISessions(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	master := newFakeSession(logger, masterSessionName)
+	spotify := newFakeSession(logger, "spotify.exe")
+	discord := newFakeSession(logger, "discord.exe")
+	discord.mute = true
+
+	a := newTestAPIServer(t, &fakeSessionFinder{sessions: []Session{spotify, master, discord}})
+	handler := a.handler(testAPIToken)
+
+	sessions := []apiSession{}
+	if code := apiRequest(t, handler, http.MethodGet, apiPathSessions, "", &sessions); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	expected := []apiSession{{"discord.exe", 1, true}, {"master", 1, false}, {"spotify.exe", 1, false}}
+	if len(sessions) != len(expected) {
+		t.Fatalf("Expected sessions %v, got %v", expected, sessions)
+	}
+
+	for idx := range expected {
+		if sessions[idx] != expected[idx] {
+			t.Errorf("Expected sessions %v, got %v", expected, sessions)
+			break
+		}
+	}
+
+	for _, test := range []struct {
+		method   string
+		path     string
+		body     string
+		expected int
+	}{
+		{http.MethodPatch, apiPathSessions + "/Spotify.exe", `{"volume": 0.25}`, http.StatusOK},
+		{http.MethodPost, apiPathSessions + "/master", `{"volume": 0.5, "mute": true}`, http.StatusOK},
+		{http.MethodPatch, apiPathSessions + "/discord.exe", `{"mute": false}`, http.StatusOK},
+		{http.MethodPatch, apiPathSessions + "/chrome.exe", `{"volume": 0.1}`, http.StatusNotFound},
+		{http.MethodPatch, apiPathSessions + "/", `{"volume": 0.1}`, http.StatusNotFound},
+		{http.MethodPatch, apiPathSessions + "/spotify.exe", `{"volume": 1.5}`, http.StatusBadRequest},
+		{http.MethodPatch, apiPathSessions + "/spotify.exe", `{}`, http.StatusBadRequest},
+		{http.MethodPatch, apiPathSessions + "/spotify.exe", `{"volume": "loud"}`, http.StatusBadRequest},
+		{http.MethodPatch, apiPathSessions + "/spotify.exe", `{"balance": 0.5}`, http.StatusBadRequest},
+		{http.MethodGet, apiPathSessions + "/spotify.exe", "", http.StatusMethodNotAllowed},
+		{http.MethodDelete, apiPathSessions, "", http.StatusMethodNotAllowed},
+	} {
+		if code := apiRequest(t, handler, test.method, test.path, test.body, nil); code != test.expected {
+			t.Errorf("%s %s %s: expected status %d, got %d", test.method, test.path, test.body, test.expected, code)
+		}
+	}
+
+	if spotify.volume != 0.25 || master.volume != 0.5 || !master.mute || discord.mute {
+		t.Errorf("Expected spotify at 0.25 and a muted master at 0.5 and discord unmuted, got %.2f, %.2f (%v) and %v",
+			spotify.volume, master.volume, master.mute, discord.mute)
+	}
+
+	if code := apiRequest(t, handler, http.MethodPost, apiPathRefresh, "", nil); code != http.StatusNoContent {
+		t.Errorf("Expected status %d for a refresh, got %d", http.StatusNoContent, code)
+	}
+
+	if code := apiRequest(t, handler, http.MethodGet, apiPathSessions, "", &sessions); code != http.StatusOK || len(sessions) != 3 {
+		t.Errorf("Expected 3 sessions after a refresh, got %v (status %d)", sessions, code)
+	}
+}
+
+// TestAPISliders tests reading slider positions along with the targets they control
+func TestAPISliders(t *testing.T) {
+	a := newTestAPIServer(t, &fakeSessionFinder{})
+	handler := a.handler(testAPIToken)
+
+	a.recordSliderMove(SliderMoveEvent{SliderID: 1, PercentValue: 0.75})
+	a.recordSliderMove(SliderMoveEvent{SliderID: 2, PercentValue: 0.5})
+
+	result := apiSliders{}
+	if code := apiRequest(t, handler, http.MethodGet, apiPathSliders, "", &result); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	if result.Layer != 0 || result.LayerName != "default" || result.Profile != "" || len(result.Sliders) != 3 {
+		t.Fatalf("Expected 3 sliders in the default layer without a profile, got %+v", result)
+	}
+
+	for sliderIdx, test := range []struct {
+		value   float32
+		targets string
+	}{
+		{-1, "master"},
+		{0.75, "spotify.exe,discord.exe"},
+		{0.5, ""},
+	} {
+		slider := result.Sliders[sliderIdx]
+
+		value := float32(-1)
+		if slider.Value != nil {
+			value = *slider.Value
+		}
+
+		if targets := strings.Join(slider.Targets, ","); value != test.value || targets != test.targets {
+			t.Errorf("Expected slider %d at %.2f controlling '%s', got %.2f controlling '%s'",
+				slider.Index, test.value, test.targets, value, targets)
+		}
+	}
+
+	if code := apiRequest(t, handler, http.MethodPost, apiPathSliders, "", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %d, got %d", http.StatusMethodNotAllowed, code)
+	}
+}
+
+// TestAPIOutputDevices tests listing the configured output devices and switching between them
+func TestAPIOutputDevices(t *testing.T) {
+	sf := &fakeSessionFinder{currentOutputDevice: []string{"Speakers"}}
+	a := newTestAPIServer(t, sf)
+	handler := a.handler(testAPIToken)
+
+	result := apiOutputDevices{}
+	if code := apiRequest(t, handler, http.MethodGet, apiPathOutputDevices, "", &result); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	if len(result.Devices) != 2 || result.Devices[1].Names[0] != "Headphones" || result.Current != 0 {
+		t.Errorf("Expected speakers and headphones with the speakers current, got %+v", result)
+	}
+
+	if code := apiRequest(t, handler, http.MethodPost, apiPathOutputDevices, `{"index": 1}`, &result); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	if result.Current != 1 || len(sf.switchedTo) != 1 || sf.switchedTo[0] != "Headphones" {
+		t.Errorf("Expected a switch to the headphones, got %+v and switches %v", result, sf.switchedTo)
+	}
+
+	for _, test := range []struct {
+		body     string
+		expected int
+	}{
+		{`{"index": 5}`, http.StatusNotFound},
+		{`{}`, http.StatusBadRequest},
+		{`{"index": "Speakers"}`, http.StatusBadRequest},
+	} {
+		if code := apiRequest(t, handler, http.MethodPost, apiPathOutputDevices, test.body, nil); code != test.expected {
+			t.Errorf("%s: expected status %d, got %d", test.body, test.expected, code)
+		}
+	}
+
+	sf.switchErr = errors.New("device unplugged")
+
+	if code := apiRequest(t, handler, http.MethodPost, apiPathOutputDevices, `{"index": 0}`, nil); code != http.StatusInternalServerError {
+		t.Errorf("Expected status %d for a failed switch, got %d", http.StatusInternalServerError, code)
+	}
+}
+
+// TestAPIServerFollowsConfig tests that the server starts and stops with config reloads
+func TestAPIServerFollowsConfig(t *testing.T) {
+	a := newTestAPIServer(t, &fakeSessionFinder{})
+
+	a.applyConfig()
+	defer a.stop()
+
+	if a.server == nil {
+		t.Fatal("Expected the server to start when the API is enabled")
+	}
+
+	a.deej.config.API = apiConfig{Address: defaultAPIAddress}
+	a.applyConfig()
+
+	if a.server != nil {
+		t.Error("Expected the server to stop when the API is disabled")
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
 
 	"github.com/tomerhh/deej/pkg/deej"
 )
@@ -15,6 +16,12 @@ var (
 	verbose bool
 )
 
+const (
+
+	// 'deej calibrate' records the range of every slider instead of running deej
+	commandCalibrate = "calibrate"
+)
+
 func init() {
 	flag.BoolVar(&verbose, "verbose", false, "show verbose logs (useful for debugging)")
 	flag.BoolVar(&verbose, "v", false, "shorthand for --verbose")
@@ -59,6 +66,14 @@ func main() {
 		d.SetVersion(versionString)
 	}
 
+	if flag.Arg(0) == commandCalibrate {
+		if err = d.Calibrate(os.Stdin, os.Stdout); err != nil {
+			named.Fatalw("Failed to calibrate sliders", "error", err)
+		}
+
+		return
+	}
+
 	// onwards, to glory
 	if err = d.Initialize(); err != nil {
 		named.Fatalw("Failed to initialize deej", "error", err)
@@ -3,6 +3,7 @@ package deej
 import (
 	"fmt"
 	"path"
+	"sort"
 	"strings"
 	"time"
 
@@ -23,10 +24,48 @@ type CanonicalConfig struct {
 	SerialConnectionInfo struct {
 		COMPort  string
 		BaudRate uint
+		USBIDs   []string
 	}
 
+	ConnectionInfo struct {
+		Type    string
+		Address string
+	}
+
+	// every board that deej connects to, which is just the one above unless there's a list. see controllers.go
+	Controllers []controllerConfig
+
 	InvertSliders bool
 
+	SliderCalibration *sliderCalibrations
+	SliderCurves      *sliderCurves
+	SliderFilters     *sliderFilters
+
+	// sliders in pickup mode, see slider_pickup.go
+	SlidersInPickup map[int]bool
+
+	// every slider mapping layer, starting with SliderMapping itself. see slider_layers.go
+	SliderLayers []sliderLayer
+	LayerHotkey  string
+
+	// global hotkeys that change targets' volumes, see hotkey_actions.go
+	Hotkeys []hotkeyAction
+
+	// mappings that the focused app switches to, see profiles.go
+	Profiles []profile
+
+	// the local HTTP API, see api.go
+	API apiConfig
+
+	// the MQTT bridge, see mqtt.go
+	MQTT mqttConfig
+
+	// the OSC server, see osc.go
+	OSC oscConfig
+
+	// the MIDI controller, see midi.go
+	MIDI midiConfig
+
 	NoiseReductionLevel string
 
 	logger             *zap.SugaredLogger
@@ -55,8 +94,42 @@ const (
 	configKeyAvailableOutputDeviceMapping = "available_output_device"
 	configKeyInvertSliders                = "invert_sliders"
 	configKeyNoiseReductionLevel          = "noise_reduction"
+	configKeySliderCalibration            = "slider_calibration"
+	configKeySliderCurve                  = "slider_curve"
+	configKeySliderFilter                 = "slider_filter"
+	configKeySliderPickup                 = "slider_pickup"
+	configKeySliderLayers                 = "slider_layers"
+	configKeyLayerHotkey                  = "layer_hotkey"
+	configKeyHotkeys                      = "hotkeys"
+	configKeyProfiles                     = "profiles"
+	configKeyAPIEnabled                   = "api.enabled"
+	configKeyAPIAddress                   = "api.address"
+	configKeyAPIToken                     = "api.token"
+	configKeyMQTTEnabled                  = "mqtt.enabled"
+	configKeyMQTTBroker                   = "mqtt.broker"
+	configKeyMQTTUsername                 = "mqtt.username"
+	configKeyMQTTPassword                 = "mqtt.password"
+	configKeyMQTTClientID                 = "mqtt.client_id"
+	configKeyMQTTTopicPrefix              = "mqtt.topic_prefix"
+	configKeyMQTTDiscovery                = "mqtt.discovery"
+	configKeyMQTTDiscoveryPrefix          = "mqtt.discovery_prefix"
+	configKeyOSCEnabled                   = "osc.enabled"
+	configKeyOSCAddress                   = "osc.address"
+	configKeyOSCFeedbackPort              = "osc.feedback_port"
+	configKeyMIDIEnabled                  = "midi.enabled"
+	configKeyMIDIInput                    = "midi.input"
+	configKeyMIDIOutput                   = "midi.output"
+	configKeyMIDIChannel                  = "midi.channel"
+	configKeyMIDILearn                    = "midi.learn"
+	configKeyMIDISliders                  = "midi.sliders"
+	configKeyMIDIMuteButtons              = "midi.mute_buttons"
+	configKeyMIDIOutputDevices            = "midi.output_devices"
 	configKeySerialPort                   = "serial_connection_info.com_port"
 	configKeyBaudRate                     = "serial_connection_info.baud_rate"
+	configKeySerialUSBIDs                 = "serial_connection_info.usb_ids"
+	configKeyConnectionType               = "connection.type"
+	configKeyConnectionAddress            = "connection.address"
+	configKeyControllers                  = "controllers"
 
 	defaultBaudRate = 115200
 )
@@ -88,6 +161,27 @@ func NewConfig(logger *zap.SugaredLogger, notifier Notifier) (*CanonicalConfig,
 
 	userConfig.SetDefault(configKeySerialPort, "auto")
 	userConfig.SetDefault(configKeyBaudRate, 115200)
+	userConfig.SetDefault(configKeySerialUSBIDs, defaultSerialUSBIDs)
+	userConfig.SetDefault(configKeyConnectionType, transportTypeSerial)
+	userConfig.SetDefault(configKeyConnectionAddress, "")
+
+	userConfig.SetDefault(configKeyAPIEnabled, false)
+	userConfig.SetDefault(configKeyAPIAddress, defaultAPIAddress)
+
+	userConfig.SetDefault(configKeyMQTTEnabled, false)
+	userConfig.SetDefault(configKeyMQTTBroker, defaultMQTTBroker)
+	userConfig.SetDefault(configKeyMQTTClientID, defaultMQTTClientID)
+	userConfig.SetDefault(configKeyMQTTTopicPrefix, defaultMQTTTopicPrefix)
+	userConfig.SetDefault(configKeyMQTTDiscovery, true)
+	userConfig.SetDefault(configKeyMQTTDiscoveryPrefix, defaultMQTTDiscoveryPrefix)
+
+	userConfig.SetDefault(configKeyOSCEnabled, false)
+	userConfig.SetDefault(configKeyOSCAddress, defaultOSCAddress)
+	userConfig.SetDefault(configKeyOSCFeedbackPort, defaultOSCFeedbackPort)
+
+	userConfig.SetDefault(configKeyMIDIEnabled, false)
+	userConfig.SetDefault(configKeyMIDIChannel, 0)
+	userConfig.SetDefault(configKeyMIDILearn, false)
 
 	internalConfig := viper.New()
 	internalConfig.SetConfigName(internalConfigName)
@@ -138,6 +232,9 @@ func (cc *CanonicalConfig) Load() error {
 	// canonize the configuration with viper's helpers
 	if err := cc.populateFromVipers(); err != nil {
 		cc.logger.Warnw("Failed to populate config fields", "error", err)
+		cc.notifier.Notify("Invalid configuration!",
+			fmt.Sprintf("Please check %s: %s", userConfigFilepath, err))
+
 		return fmt.Errorf("populate config fields: %w", err)
 	}
 
@@ -147,7 +244,25 @@ func (cc *CanonicalConfig) Load() error {
 		"muteButtonMapping", cc.MuteButtonMapping,
 		"availableOutputDeviceMapping", cc.AvailableOutputDeviceMapping,
 		"serialConnectionInfo", cc.SerialConnectionInfo,
-		"invertSliders", cc.InvertSliders)
+		"connectionInfo", cc.ConnectionInfo,
+		"controllers", cc.controllerNames(),
+		"invertSliders", cc.InvertSliders,
+		"sliderCalibration", cc.SliderCalibration,
+		"sliderCurves", cc.SliderCurves,
+		"sliderFilters", cc.SliderFilters,
+		"slidersInPickup", cc.SlidersInPickup,
+		"sliderLayers", cc.layerNames(),
+		"layerHotkey", cc.LayerHotkey,
+		"hotkeys", len(cc.Hotkeys),
+		"profiles", cc.profileNames(),
+		"apiEnabled", cc.API.Enabled,
+		"apiAddress", cc.API.Address,
+		"mqttEnabled", cc.MQTT.Enabled,
+		"mqttBroker", cc.MQTT.Broker,
+		"oscEnabled", cc.OSC.Enabled,
+		"oscAddress", cc.OSC.Address,
+		"midiEnabled", cc.MIDI.Enabled,
+		"midiInput", cc.MIDI.Input)
 
 	return nil
 }
@@ -238,15 +353,154 @@ func (cc *CanonicalConfig) populateFromVipers() error {
 
 	cc.SerialConnectionInfo.COMPort = cc.userConfig.GetString(configKeySerialPort)
 	cc.SerialConnectionInfo.BaudRate = cc.userConfig.GetUint(configKeyBaudRate)
+	cc.SerialConnectionInfo.USBIDs = cc.userConfig.GetStringSlice(configKeySerialUSBIDs)
+
+	cc.ConnectionInfo.Type = cc.userConfig.GetString(configKeyConnectionType)
+	cc.ConnectionInfo.Address = cc.userConfig.GetString(configKeyConnectionAddress)
+
+	controllers, err := controllersFromConfig(cc.userConfig, connectionConfigFrom(cc))
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", configKeyControllers, err)
+	}
+
+	cc.Controllers = controllers
 
 	cc.InvertSliders = cc.userConfig.GetBool(configKeyInvertSliders)
 	cc.NoiseReductionLevel = cc.userConfig.GetString(configKeyNoiseReductionLevel)
 
+	sliderCalibration, err := sliderCalibrationsFromConfigs(cc.userConfig, cc.internalConfig, cc.InvertSliders)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", configKeySliderCalibration, err)
+	}
+
+	cc.SliderCalibration = sliderCalibration
+
+	sliderCurves, err := sliderCurvesFromConfig(cc.userConfig)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", configKeySliderCurve, err)
+	}
+
+	cc.SliderCurves = sliderCurves
+
+	sliderFilters, err := sliderFiltersFromConfig(cc.userConfig, cc.NoiseReductionLevel)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", configKeySliderFilter, err)
+	}
+
+	cc.SliderFilters = sliderFilters
+
+	slidersInPickup, err := slidersInPickupFromConfig(cc.userConfig)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", configKeySliderPickup, err)
+	}
+
+	cc.SlidersInPickup = slidersInPickup
+
+	sliderLayers, err := sliderLayersFromConfig(cc.userConfig, cc.SliderMapping)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", configKeySliderLayers, err)
+	}
+
+	cc.SliderLayers = sliderLayers
+	cc.LayerHotkey = cc.userConfig.GetString(configKeyLayerHotkey)
+
+	if cc.LayerHotkey != "" {
+		if _, err := parseHotkey(cc.LayerHotkey); err != nil {
+			return fmt.Errorf("parse %s: %w", configKeyLayerHotkey, err)
+		}
+	}
+
+	hotkeys, err := hotkeyActionsFromConfig(cc.userConfig, cc.LayerHotkey)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", configKeyHotkeys, err)
+	}
+
+	cc.Hotkeys = hotkeys
+
+	profiles, err := profilesFromConfig(cc.userConfig)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", configKeyProfiles, err)
+	}
+
+	cc.Profiles = profiles
+
+	api, err := cc.apiConfigFromConfig()
+	if err != nil {
+		return fmt.Errorf("parse api: %w", err)
+	}
+
+	cc.API = api
+
+	mqtt, err := cc.mqttConfigFromConfig()
+	if err != nil {
+		return fmt.Errorf("parse mqtt: %w", err)
+	}
+
+	cc.MQTT = mqtt
+
+	osc, err := cc.oscConfigFromConfig()
+	if err != nil {
+		return fmt.Errorf("parse osc: %w", err)
+	}
+
+	cc.OSC = osc
+
+	midi, err := cc.midiConfigFromConfig()
+	if err != nil {
+		return fmt.Errorf("parse midi: %w", err)
+	}
+
+	cc.MIDI = midi
+
 	cc.logger.Debug("Populated config fields from vipers")
 
 	return nil
 }
 
+// saveSliderRanges records calibrated slider ranges in the internal config, replacing the ones
+// previously recorded for the same sliders. they take effect the next time the config is loaded
+func (cc *CanonicalConfig) saveSliderRanges(ranges map[int]sliderRange) (string, error) {
+	sliderIdxs := make([]int, 0, len(ranges))
+	for sliderIdx := range ranges {
+		sliderIdxs = append(sliderIdxs, sliderIdx)
+	}
+
+	sort.Ints(sliderIdxs)
+
+	for _, sliderIdx := range sliderIdxs {
+		sliderKey := fmt.Sprintf("%s.%s.%d", configKeySliderCalibration, calibrationKeySliders, sliderIdx)
+
+		cc.internalConfig.Set(sliderKey+"."+calibrationKeyMin, ranges[sliderIdx].Min)
+		cc.internalConfig.Set(sliderKey+"."+calibrationKeyMax, ranges[sliderIdx].Max)
+	}
+
+	filepath, err := cc.writeInternalConfig()
+	if err != nil {
+		return "", err
+	}
+
+	cc.logger.Infow("Saved slider calibration", "path", filepath, "ranges", ranges)
+
+	return filepath, nil
+}
+
+// writeInternalConfig saves the internal config with whatever was set on it, and returns where it went
+func (cc *CanonicalConfig) writeInternalConfig() (string, error) {
+	if err := util.EnsureDirExists(internalConfigPath); err != nil {
+		cc.logger.Warnw("Failed to create internal config directory", "error", err)
+		return "", fmt.Errorf("create internal config directory: %w", err)
+	}
+
+	filepath := path.Join(internalConfigPath, internalConfigFilepath)
+
+	if err := cc.internalConfig.WriteConfigAs(filepath); err != nil {
+		cc.logger.Warnw("Failed to write internal config", "path", filepath, "error", err)
+		return "", fmt.Errorf("write internal config: %w", err)
+	}
+
+	return filepath, nil
+}
+
 func (cc *CanonicalConfig) onConfigReloaded() {
 	cc.logger.Debug("Notifying consumers about configuration reload")
 
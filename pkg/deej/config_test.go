@@ -2,15 +2,31 @@ package deej
 
 import (
 	"os"
+	"sync"
 	"testing"
 
 	"go.uber.org/zap"
 )
 
-// mockNotifier implements the Notifier interface for testing
-type mockNotifier struct{}
+// mockNotifier implements the Notifier interface for testing, and records the titles it was asked to show
+type mockNotifier struct {
+	titles []string
+	lock   sync.Mutex
+}
+
+func (m *mockNotifier) Notify(title, message string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.titles = append(m.titles, title)
+}
+
+func (m *mockNotifier) notified() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 
-func (m *mockNotifier) Notify(title, message string) {}
+	return append([]string{}, m.titles...)
+}
 
 // TestSerialConfigAutoDetection tests that the config loads with auto-detection
 func TestSerialConfigAutoDetection(t *testing.T) {
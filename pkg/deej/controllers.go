@@ -0,0 +1,393 @@
+package deej
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// controllers let deej take sliders and buttons from several boards at once, i.e. a fader box and a button box.
+// every controller has its own connection, which comes and goes on its own, and offsets that its slider and
+// mute button indexes are shifted by. that way their sliders and buttons share the same mappings, as if they
+// were on a single board. without a list of controllers, the top-level connection is the only one
+const (
+	controllerKeyName             = "name"
+	controllerKeyType             = "type"
+	controllerKeyAddress          = "address"
+	controllerKeyCOMPort          = "com_port"
+	controllerKeyBaudRate         = "baud_rate"
+	controllerKeySliderOffset     = "slider_offset"
+	controllerKeyMuteButtonOffset = "mute_button_offset"
+	controllerKeySliders          = "sliders"
+
+	// how often a controller that wasn't there when deej started is looked for again
+	controllerRetryInterval = 5 * time.Second
+)
+
+// controllerConfig is a single board's connection, and where its sliders and mute buttons go in the mappings
+type controllerConfig struct {
+	Name             string
+	SliderOffset     int
+	MuteButtonOffset int
+
+	// how many sliders the board sends, for firmware that doesn't say so in its handshake. 0 if it's unknown
+	Sliders int
+
+	connection connectionConfig
+}
+
+// controllersFromConfig reads the list of controllers from the user config, or returns a single one
+// with the given top-level connection if there's no list
+func controllersFromConfig(userConfig *viper.Viper, connection connectionConfig) ([]controllerConfig, error) {
+	if !userConfig.IsSet(configKeyControllers) {
+		return []controllerConfig{{connection: connection}}, nil
+	}
+
+	rawControllers, ok := userConfig.Get(configKeyControllers).([]interface{})
+	if !ok || len(rawControllers) == 0 {
+		return nil, errors.New("expected a list of controllers")
+	}
+
+	controllers := []controllerConfig{}
+	names := map[string]bool{}
+	autoDetecting := 0
+
+	for idx, rawController := range rawControllers {
+		fields, ok := stringKeyedMap(rawController)
+		if !ok {
+			return nil, fmt.Errorf("controller %d: expected a connection and offsets", idx)
+		}
+
+		controller := controllerConfig{
+			Name: fmt.Sprintf("controller %d", idx),
+			connection: connectionConfig{
+				transportType: transportTypeSerial,
+				comPort:       "auto",
+				baudRate:      connection.baudRate,
+			},
+		}
+
+		for key, value := range map[string]*string{
+			controllerKeyName:    &controller.Name,
+			controllerKeyType:    &controller.connection.transportType,
+			controllerKeyAddress: &controller.connection.address,
+			controllerKeyCOMPort: &controller.connection.comPort,
+		} {
+			switch rawValue := fields[key].(type) {
+			case nil:
+			case string:
+				*value = rawValue
+			default:
+				return nil, fmt.Errorf("controller %d: invalid %s '%v'", idx, key, rawValue)
+			}
+		}
+
+		if names[strings.ToLower(controller.Name)] {
+			return nil, fmt.Errorf("controller %d: %s '%s' is taken", idx, controllerKeyName, controller.Name)
+		}
+
+		names[strings.ToLower(controller.Name)] = true
+		controller.connection.transportType = strings.ToLower(controller.connection.transportType)
+
+		for key, value := range map[string]*int{
+			controllerKeySliderOffset:     &controller.SliderOffset,
+			controllerKeyMuteButtonOffset: &controller.MuteButtonOffset,
+			controllerKeySliders:          &controller.Sliders,
+		} {
+			switch rawValue := fields[key].(type) {
+			case nil:
+			case int:
+				if rawValue < 0 {
+					return nil, fmt.Errorf("controller %s: %s can't be negative", controller.Name, key)
+				}
+
+				*value = rawValue
+			default:
+				return nil, fmt.Errorf("controller %s: invalid %s '%v'", controller.Name, key, rawValue)
+			}
+		}
+
+		switch baudRate := fields[controllerKeyBaudRate].(type) {
+		case nil:
+		case int:
+			if baudRate <= 0 {
+				return nil, fmt.Errorf("controller %s: invalid %s %d", controller.Name, controllerKeyBaudRate, baudRate)
+			}
+
+			controller.connection.baudRate = uint(baudRate)
+		default:
+			return nil, fmt.Errorf("controller %s: invalid %s '%v'", controller.Name, controllerKeyBaudRate, baudRate)
+		}
+
+		transport, err := newTransport(controller.connection)
+		if err != nil {
+			return nil, fmt.Errorf("controller %s: %w", controller.Name, err)
+		}
+
+		// auto-detection takes the first board it finds, so two controllers would both get the same one
+		if serialTransport, ok := transport.(*serialTransport); ok && serialTransport.autoDetect() {
+			autoDetecting++
+		}
+
+		if autoDetecting > 1 {
+			return nil, fmt.Errorf("controller %s: only one controller can auto-detect its %s", controller.Name, controllerKeyCOMPort)
+		}
+
+		controllers = append(controllers, controller)
+	}
+
+	return controllers, nil
+}
+
+// controllerConfigAt returns the config of the controller at the given index. if there's no such controller
+// (i.e. a reload removed it), it's the top-level connection's, without offsets
+func (cc *CanonicalConfig) controllerConfigAt(controllerIdx int) controllerConfig {
+	if controllerIdx < 0 || controllerIdx >= len(cc.Controllers) {
+		return controllerConfig{connection: connectionConfigFrom(cc)}
+	}
+
+	return cc.Controllers[controllerIdx]
+}
+
+func (cc *CanonicalConfig) controllerNames() []string {
+	names := make([]string, len(cc.Controllers))
+	for controllerIdx, controller := range cc.Controllers {
+		names[controllerIdx] = controller.Name
+	}
+
+	return names
+}
+
+// controllerGroup runs a SerialIO for every configured controller, and acts like a single controller
+// towards the rest of deej. adding or removing controllers takes a restart
+type controllerGroup struct {
+	deej   *Deej
+	logger *zap.SugaredLogger
+
+	controllers []*SerialIO
+
+	// closed by Stop, which also ends the search for controllers that weren't there on Start
+	stopChannel chan bool
+	stopped     bool
+	lock        sync.Mutex
+}
+
+func newControllerGroup(deej *Deej, logger *zap.SugaredLogger) (*controllerGroup, error) {
+	g := &controllerGroup{
+		deej:        deej,
+		logger:      logger.Named("controllers"),
+		controllers: []*SerialIO{},
+		stopChannel: make(chan bool),
+	}
+
+	for controllerIdx := range deej.config.Controllers {
+		sio, err := newControllerSerialIO(deej, logger, controllerIdx)
+		if err != nil {
+			return nil, fmt.Errorf("create controller %d: %w", controllerIdx, err)
+		}
+
+		g.controllers = append(g.controllers, sio)
+	}
+
+	g.logger.Debugw("Created controller group", "controllers", deej.config.controllerNames())
+
+	return g, nil
+}
+
+// Start connects to every controller at once. it only fails if none of them connect,
+// the ones that didn't are looked for again until they do
+func (g *controllerGroup) Start() error {
+	errs := make([]error, len(g.controllers))
+
+	var wg sync.WaitGroup
+
+	for controllerIdx, sio := range g.controllers {
+		wg.Add(1)
+
+		go func(controllerIdx int, sio *SerialIO) {
+			defer wg.Done()
+			errs[controllerIdx] = sio.Start()
+		}(controllerIdx, sio)
+	}
+
+	wg.Wait()
+
+	failed := []*SerialIO{}
+	for controllerIdx, err := range errs {
+		if err != nil {
+			failed = append(failed, g.controllers[controllerIdx])
+		}
+	}
+
+	if len(failed) == len(g.controllers) && len(failed) > 0 {
+		return fmt.Errorf("start controllers: %w", errs[0])
+	}
+
+	for _, sio := range failed {
+		go g.retryStart(sio)
+	}
+
+	return nil
+}
+
+// retryStart tries to connect to a controller until it connects or the group stops, without notifying about it again
+func (g *controllerGroup) retryStart(sio *SerialIO) {
+	ticker := time.NewTicker(controllerRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.stopChannel:
+			return
+		case <-ticker.C:
+		}
+
+		// Stop mustn't miss a controller that's just connecting
+		g.lock.Lock()
+		done := g.stopped || sio.start(false) == nil
+		g.lock.Unlock()
+
+		if done {
+			return
+		}
+	}
+}
+
+// Stop disconnects from every controller
+func (g *controllerGroup) Stop() {
+	g.lock.Lock()
+	if !g.stopped {
+		g.stopped = true
+		close(g.stopChannel)
+	}
+	g.lock.Unlock()
+
+	for _, sio := range g.controllers {
+		sio.Stop()
+	}
+}
+
+// SubscribeToSliderMoveEvents returns an unbuffered channel that receives slider moves from every controller
+func (g *controllerGroup) SubscribeToSliderMoveEvents() chan SliderMoveEvent {
+	ch := make(chan SliderMoveEvent)
+
+	for _, sio := range g.controllers {
+		go func(events chan SliderMoveEvent) {
+			for event := range events {
+				ch <- event
+			}
+		}(sio.SubscribeToSliderMoveEvents())
+	}
+
+	return ch
+}
+
+// SubscribeToDeviceInfo returns an unbuffered channel that receives every controller's device info
+// whenever it identifies itself
+func (g *controllerGroup) SubscribeToDeviceInfo() chan DeviceInfo {
+	ch := make(chan DeviceInfo)
+
+	for _, sio := range g.controllers {
+		go func(infos chan DeviceInfo) {
+			for info := range infos {
+				ch <- info
+			}
+		}(sio.SubscribeToDeviceInfo())
+	}
+
+	return ch
+}
+
+// SubscribeToMuteButtonEvents returns an unbuffered channel that receives every controller's
+// mute button clicks once they were handled
+func (g *controllerGroup) SubscribeToMuteButtonEvents() chan MuteButtonClickEvent {
+	ch := make(chan MuteButtonClickEvent)
+
+	for _, sio := range g.controllers {
+		go func(events chan MuteButtonClickEvent) {
+			for event := range events {
+				ch <- event
+			}
+		}(sio.SubscribeToMuteButtonEvents())
+	}
+
+	return ch
+}
+
+// SubscribeToOutputDeviceEvents returns an unbuffered channel that receives the output device's index
+// whenever any controller switched it
+func (g *controllerGroup) SubscribeToOutputDeviceEvents() chan int {
+	ch := make(chan int)
+
+	for _, sio := range g.controllers {
+		go func(events chan int) {
+			for event := range events {
+				ch <- event
+			}
+		}(sio.SubscribeToOutputDeviceEvents())
+	}
+
+	return ch
+}
+
+// SubscribeToConnectionEvents returns an unbuffered channel that receives an event whenever any controller
+// connects or disconnects. events tell controllers apart by their transport
+func (g *controllerGroup) SubscribeToConnectionEvents() chan ConnectionEvent {
+	ch := make(chan ConnectionEvent)
+
+	for _, sio := range g.controllers {
+		go func(events chan ConnectionEvent) {
+			for event := range events {
+				ch <- event
+			}
+		}(sio.SubscribeToConnectionEvents())
+	}
+
+	return ch
+}
+
+// DeviceInfo returns what the first controller that identified itself reported, and false if none did
+func (g *controllerGroup) DeviceInfo() (DeviceInfo, bool) {
+	for _, sio := range g.controllers {
+		if info, ok := sio.DeviceInfo(); ok {
+			return info, true
+		}
+	}
+
+	return DeviceInfo{}, false
+}
+
+func (g *controllerGroup) setMuteButtonClickEventConsumer(consumer MuteButtonConsumer) {
+	for _, sio := range g.controllers {
+		sio.setMuteButtonClickEventConsumer(consumer)
+	}
+}
+
+func (g *controllerGroup) setToggleOutputDeviceEventConsumer(consumer ToggleOutputDeviceConsumer) {
+	for _, sio := range g.controllers {
+		sio.setToggleOutputDeviceEventConsumer(consumer)
+	}
+}
+
+// pushState pushes the state to every controller, but only asks for it once
+func (g *controllerGroup) pushState(getState func() ControllerState) {
+	var state *ControllerState
+
+	getStateOnce := func() ControllerState {
+		if state == nil {
+			current := getState()
+			state = &current
+		}
+
+		return *state
+	}
+
+	for _, sio := range g.controllers {
+		sio.pushState(getStateOnce)
+	}
+}
@@ -0,0 +1,320 @@
+package deej
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// failingTransport is a controller that isn't plugged in
+type failingTransport struct{}
+
+func (t failingTransport) Open() (io.ReadWriteCloser, error) {
+	return nil, errors.New("not plugged in")
+}
+
+func (t failingTransport) String() string {
+	return "nowhere"
+}
+
+const testControllersConfig = `
+slider_mapping:
+  0: master
+  1: chrome
+  2: spotify
+  3: discord
+mute_button_mapping:
+  0: master
+  1: mic
+controllers:
+  - name: faders
+    type: tcp
+    address: faders.local:5000
+    sliders: 2
+  - name: buttons
+    type: tcp
+    address: buttons.local:5000
+    slider_offset: 2
+    mute_button_offset: 1
+`
+
+// connectTestController starts reading from a controller's pipe once deej connects to it,
+// and returns the board's end once it got deej's greeting
+func connectTestController(t *testing.T, pipes *pipeTransport) (net.Conn, *bufio.Reader) {
+	select {
+	case board := <-pipes.peers:
+		reader := bufio.NewReader(board)
+
+		if line := readLineWithTimeout(t, reader); line != "Connected" {
+			t.Fatalf("Expected 'Connected' on connect, got '%s'", line)
+		}
+
+		return board, reader
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for a connection")
+		return nil, nil
+	}
+}
+
+// TestControllersConfig tests reading the list of controllers, and falling back to the top-level connection without one
+func TestControllersConfig(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, "serial_connection_info:\n  com_port: COM4\n  baud_rate: 9600\n")
+	defer cleanup()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	expected := []controllerConfig{{connection: connectionConfig{transportType: "serial", comPort: "COM4", baudRate: 9600}}}
+	if !reflect.DeepEqual(deej.config.Controllers, expected) {
+		t.Errorf("Expected the top-level connection, got %+v", deej.config.Controllers)
+	}
+
+	cleanup = createTestConfig(t, `
+serial_connection_info:
+  baud_rate: 9600
+controllers:
+  - com_port: COM4
+  - name: Buttons
+    type: UDP
+    address: ":5000"
+    slider_offset: 4
+    mute_button_offset: 2
+    sliders: 1
+  - com_port: COM5
+    baud_rate: 115200
+`)
+	defer cleanup()
+
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	expected = []controllerConfig{
+		{Name: "controller 0", connection: connectionConfig{transportType: "serial", comPort: "COM4", baudRate: 9600}},
+		{
+			Name:             "Buttons",
+			SliderOffset:     4,
+			MuteButtonOffset: 2,
+			Sliders:          1,
+			connection:       connectionConfig{transportType: "udp", address: ":5000", comPort: "auto", baudRate: 9600},
+		},
+		{Name: "controller 2", connection: connectionConfig{transportType: "serial", comPort: "COM5", baudRate: 115200}},
+	}
+
+	if !reflect.DeepEqual(deej.config.Controllers, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, deej.config.Controllers)
+	}
+
+	if controller := deej.config.controllerConfigAt(3); controller.SliderOffset != 0 || controller.connection.comPort != "auto" {
+		t.Errorf("Expected a missing controller to be the top-level one, got %+v", controller)
+	}
+
+	for _, controllers := range []string{
+		"controllers: faders",
+		"controllers: []",
+		"controllers:\n  - slider_offset: -1\n    com_port: COM4",
+		"controllers:\n  - slider_offset: two\n    com_port: COM4",
+		"controllers:\n  - baud_rate: 0\n    com_port: COM4",
+		"controllers:\n  - name: a\n    com_port: COM4\n  - name: A\n    com_port: COM5",
+		"controllers:\n  - type: tcp",
+		"controllers:\n  - com_port: COM4\n  - com_port: auto\n  - type: serial",
+	} {
+		cleanup := createTestConfig(t, controllers+"\n")
+
+		if err := deej.config.Load(); err == nil {
+			t.Errorf("'%s': expected an error, got %+v", controllers, deej.config.Controllers)
+		}
+
+		cleanup()
+	}
+}
+
+// TestControllerGroup tests that every controller's sliders and buttons are moved by its offsets, that state pushes
+// only carry a controller's own buttons, and that controllers reconnect without the others noticing
+func TestControllerGroup(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, testControllersConfig)
+	defer cleanup()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	group, err := newControllerGroup(deej, logger)
+	if err != nil {
+		t.Fatalf("Failed to create controllers: %v", err)
+	}
+
+	if len(group.controllers) != 2 {
+		t.Fatalf("Expected 2 controllers, got %d", len(group.controllers))
+	}
+
+	faderPipes := newPipeTransport()
+	buttonPipes := newPipeTransport()
+	group.controllers[0].transport = faderPipes
+	group.controllers[1].transport = buttonPipes
+
+	sliderMoves := group.SubscribeToSliderMoveEvents()
+
+	muteButtonClicks := make(chan MuteButtonClickEvent, 1)
+	group.setMuteButtonClickEventConsumer(func(events []MuteButtonClickEvent) (MuteButtonsState, error) {
+		muteButtonClicks <- events[0]
+		return MuteButtonsState{}, nil
+	})
+
+	started := make(chan error, 1)
+	go func() {
+		started <- group.Start()
+	}()
+
+	faders, faderReader := connectTestController(t, faderPipes)
+	buttons, buttonReader := connectTestController(t, buttonPipes)
+
+	if err := <-started; err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	defer group.Stop()
+
+	expectSliders := func(expected ...int) {
+		for _, sliderID := range expected {
+			select {
+			case event := <-sliderMoves:
+				if event.SliderID != sliderID {
+					t.Errorf("Expected slider %d to move, got %+v", sliderID, event)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Timed out waiting for slider %d", sliderID)
+			}
+		}
+	}
+
+	// the faders say how many sliders they have in the config, the buttons have the rest of them
+	go faders.Write([]byte("Sliders|4095|0\n"))
+	expectSliders(0, 1)
+	readLineWithTimeout(t, faderReader)
+
+	go buttons.Write([]byte("Sliders|4095|0\n"))
+	expectSliders(2, 3)
+	readLineWithTimeout(t, buttonReader)
+
+	go buttons.Write([]byte("MuteButton|0|true\n"))
+
+	select {
+	case event := <-muteButtonClicks:
+		if event.MuteButtonID != 1 || !event.mute {
+			t.Errorf("Expected mute button 1 to mute, got %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the mute button")
+	}
+
+	readLineWithTimeout(t, buttonReader)
+
+	// the button box only gets the mute button that it has
+	go buttons.Write([]byte("Hello|3|2|1|0|1.0.0\n"))
+
+	if line := readLineWithTimeout(t, buttonReader); line != "Hello|3" {
+		t.Fatalf("Expected deej's hello, got '%s'", line)
+	}
+
+	go group.pushState(func() ControllerState {
+		return ControllerState{MuteButtons: []bool{false, true, false}}
+	})
+
+	for _, expected := range []string{"MuteState|1", "OutputDevice|0", "Layer|0"} {
+		if line := readLineWithTimeout(t, buttonReader); line != expected {
+			t.Errorf("Expected '%s', got '%s'", expected, line)
+		}
+	}
+
+	if info, ok := group.DeviceInfo(); !ok || info.NumButtons != 1 {
+		t.Errorf("Expected the button box's device info, got %+v", info)
+	}
+
+	// it only has to fit into the mappings from its offsets on
+	buttonsConfig := deej.config.controllerConfigAt(1)
+
+	if mismatches := (DeviceInfo{3, 2, 1, 0, "1.0.0", false}).mismatches(deej.config, buttonsConfig); len(mismatches) != 0 {
+		t.Errorf("Expected the button box to match, got %v", mismatches)
+	}
+
+	if mismatches := (DeviceInfo{3, 3, 2, 0, "1.0.0", false}).mismatches(deej.config, buttonsConfig); len(mismatches) != 2 {
+		t.Errorf("Expected sliders and mute buttons past the mappings to mismatch, got %v", mismatches)
+	}
+
+	// unplugging the faders leaves the buttons alone, and the faders come back on their own
+	faders.Close()
+	faders, faderReader = connectTestController(t, faderPipes)
+
+	go buttons.Write([]byte("Sliders|0|4095\n"))
+	expectSliders(2, 3)
+	readLineWithTimeout(t, buttonReader)
+
+	go faders.Write([]byte("Sliders|0|4095\n"))
+	expectSliders(0, 1)
+	readLineWithTimeout(t, faderReader)
+}
+
+// TestControllerGroupWithMissingController tests that a controller that isn't there doesn't keep the others from starting,
+// and that deej only fails to start when none of them are there
+func TestControllerGroupWithMissingController(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, testControllersConfig)
+	defer cleanup()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	group, err := newControllerGroup(deej, logger)
+	if err != nil {
+		t.Fatalf("Failed to create controllers: %v", err)
+	}
+
+	group.controllers[0].transport = failingTransport{}
+	group.controllers[1].transport = failingTransport{}
+
+	if err := group.Start(); err == nil {
+		t.Error("Expected an error when no controller is there")
+	}
+
+	group, err = newControllerGroup(deej, logger)
+	if err != nil {
+		t.Fatalf("Failed to create controllers: %v", err)
+	}
+
+	buttonPipes := newPipeTransport()
+	group.controllers[0].transport = failingTransport{}
+	group.controllers[1].transport = buttonPipes
+
+	started := make(chan error, 1)
+	go func() {
+		started <- group.Start()
+	}()
+
+	connectTestController(t, buttonPipes)
+
+	if err := <-started; err != nil {
+		t.Fatalf("Expected the buttons to start without the faders, got %v", err)
+	}
+
+	group.Stop()
+
+	if notified := deej.notifier.(*mockNotifier).notified(); len(notified) != 3 {
+		t.Errorf("Expected a notification for every controller that failed to connect, got %v", notified)
+	}
+}
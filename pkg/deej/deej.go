@@ -3,8 +3,11 @@
 package deej
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"os"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
@@ -25,7 +28,27 @@ type Deej struct {
 	config                *CanonicalConfig
 	deejSlidersController DeejSlidersController
 	deejButtonsController DeejButtonsController
+	deviceInfoController  DeejDeviceInfoController
+	stateController       DeejStateController
+	eventsController      DeejEventsController
 	sessions              *sessionMap
+	api                   *apiServer
+	mqtt                  *mqttBridge
+	osc                   *oscServer
+	midi                  *midiController
+	hotkeys               *hotkeyController
+
+	// the slider layer that sliders control, see slider_layers.go
+	activeLayer    int
+	layerConsumers []chan int
+	layerLock      sync.Mutex
+
+	// the profile of the focused app, and the one picked from the tray instead. see profiles.go
+	focusedProfile    string
+	profileOverride   string
+	profileOverridden bool
+	profileConsumers  []chan string
+	profileLock       sync.Mutex
 
 	restartSessionsTicker time.Ticker
 
@@ -88,17 +111,27 @@ func (d *Deej) Initialize() error {
 		return fmt.Errorf("load config during init: %w", err)
 	}
 
-	// Create SerialIO instance that implements both slider and button controller interfaces
-	serialIO, err := NewSerialIO(d, d.logger)
+	// Create a SerialIO instance for every configured controller, which together implement every controller interface
+	controllers, err := newControllerGroup(d, d.logger)
 	if err != nil {
-		d.logger.Errorw("Failed to create SerialIO", "error", err)
-		return fmt.Errorf("create new SerialIO: %w", err)
+		d.logger.Errorw("Failed to create controllers", "error", err)
+		return fmt.Errorf("create controllers: %w", err)
 	}
 
-	// Assign SerialIO to both controllers (same instance serves both interfaces)
-	d.deejSlidersController = serialIO
-	d.deejButtonsController = serialIO
-	d.logger.Info("Created SerialIO controller")
+	// Assign the group to every controller field (the same instance serves every interface)
+	d.deejSlidersController = controllers
+	d.deejButtonsController = controllers
+	d.deviceInfoController = controllers
+	d.stateController = controllers
+	d.eventsController = controllers
+	d.logger.Infow("Created SerialIO controllers", "controllers", d.config.controllerNames())
+
+	// OSC apps, MIDI controllers, the MQTT bridge and hotkeys are more controllers, which the session map and the API
+	// have to know about before they set up
+	d.osc = newOSCServer(d, d.logger)
+	d.midi = newMIDIController(d, d.logger, newMIDIBackend())
+	d.mqtt = newMQTTBridge(d, d.logger)
+	d.hotkeys = newHotkeyController(d, d.logger)
 
 	// initialize the session map
 	if err := d.sessions.initialize(); err != nil {
@@ -106,6 +139,20 @@ func (d *Deej) Initialize() error {
 		return fmt.Errorf("init session map: %w", err)
 	}
 
+	// the API only serves if it's enabled, but follows config reloads either way
+	d.api = newAPIServer(d, d.logger)
+	d.api.initialize()
+
+	// same for the MQTT bridge and its broker
+	d.mqtt.initialize()
+
+	// and for the OSC server and MIDI controller, which only listen if they're enabled
+	d.osc.initialize()
+	d.midi.initialize()
+
+	// hotkeys only listen if there are any
+	d.hotkeys.initialize()
+
 	// decide whether to run with/without tray
 	if _, noTraySet := os.LookupEnv(envNoTray); noTraySet {
 
@@ -123,11 +170,226 @@ func (d *Deej) Initialize() error {
 	return nil
 }
 
+// Calibrate connects to the controller and records the range of every slider while the user moves them,
+// then saves it to the internal config. it talks to the user over input and output instead of the tray
+func (d *Deej) Calibrate(input io.Reader, output io.Writer) error {
+	d.logger.Debug("Calibrating sliders")
+
+	if err := d.config.Load(); err != nil {
+		d.logger.Errorw("Failed to load config for calibration", "error", err)
+		return fmt.Errorf("load config for calibration: %w", err)
+	}
+
+	controllers, err := newControllerGroup(d, d.logger)
+	if err != nil {
+		d.logger.Errorw("Failed to create controllers", "error", err)
+		return fmt.Errorf("create controllers: %w", err)
+	}
+
+	// keep consuming until deej exits, so that the read loops never block on the calibrators.
+	// every controller's sliders are calibrated on their own, and moved to their mapped indexes afterwards
+	calibrators := make([]*sliderCalibrator, len(controllers.controllers))
+
+	for controllerIdx, serialIO := range controllers.controllers {
+		calibrators[controllerIdx] = newSliderCalibrator(d.config.SliderCalibration.adcMax)
+
+		go func(calibrator *sliderCalibrator, rawValues chan []int) {
+			for values := range rawValues {
+				calibrator.record(values)
+			}
+		}(calibrators[controllerIdx], serialIO.SubscribeToRawSliderValues())
+	}
+
+	for _, serialIO := range controllers.controllers {
+		if err := serialIO.Start(); err != nil {
+			d.logger.Warnw("Failed to start serial connection", "error", err)
+			return fmt.Errorf("start serial connection: %w", err)
+		}
+
+		defer serialIO.Stop()
+	}
+
+	fmt.Fprintln(output, "Move every slider all the way down and all the way up, then press Enter")
+
+	if _, err := bufio.NewReader(input).ReadString('\n'); err != nil && err != io.EOF {
+		return fmt.Errorf("wait for user: %w", err)
+	}
+
+	ranges := map[int]sliderRange{}
+	for controllerIdx, calibrator := range calibrators {
+		sliderOffset := d.config.controllerConfigAt(controllerIdx).SliderOffset
+
+		for sliderIdx, r := range calibrator.results() {
+			ranges[sliderOffset+sliderIdx] = r
+		}
+	}
+
+	if len(ranges) == 0 {
+		return fmt.Errorf("no slider moved far enough to calibrate it")
+	}
+
+	for sliderIdx := 0; sliderIdx <= maxRangeIdx(ranges); sliderIdx++ {
+		if r, ok := ranges[sliderIdx]; ok {
+			fmt.Fprintf(output, "Slider %d: %d - %d\n", sliderIdx, r.Min, r.Max)
+		} else {
+			fmt.Fprintf(output, "Slider %d: didn't move, keeping its calibration\n", sliderIdx)
+		}
+	}
+
+	filepath, err := d.config.saveSliderRanges(ranges)
+	if err != nil {
+		return fmt.Errorf("save slider calibration: %w", err)
+	}
+
+	fmt.Fprintf(output, "Saved to %s, restart deej to apply it\n", filepath)
+
+	return nil
+}
+
 // SetVersion causes deej to add a version string to its tray menu if called before Initialize
 func (d *Deej) SetVersion(version string) {
 	d.version = version
 }
 
+// DeviceInfo returns what the connected controller reported about itself,
+// and false if it hasn't identified itself
+func (d *Deej) DeviceInfo() (DeviceInfo, bool) {
+	if d.deviceInfoController == nil {
+		return DeviceInfo{}, false
+	}
+
+	return d.deviceInfoController.DeviceInfo()
+}
+
+// sliderControllers returns every controller that sliders move on: the hardware ones, OSC apps and MIDI controllers
+func (d *Deej) sliderControllers() []DeejSlidersController {
+	controllers := []DeejSlidersController{}
+
+	if d.deejSlidersController != nil {
+		controllers = append(controllers, d.deejSlidersController)
+	}
+
+	if d.osc != nil {
+		controllers = append(controllers, d.osc)
+	}
+
+	if d.midi != nil {
+		controllers = append(controllers, d.midi)
+	}
+
+	if d.mqtt != nil {
+		controllers = append(controllers, d.mqtt)
+	}
+
+	return controllers
+}
+
+// buttonControllers returns every controller with mute and output device buttons
+func (d *Deej) buttonControllers() []DeejButtonsController {
+	controllers := []DeejButtonsController{}
+
+	if d.deejButtonsController != nil {
+		controllers = append(controllers, d.deejButtonsController)
+	}
+
+	if d.osc != nil {
+		controllers = append(controllers, d.osc)
+	}
+
+	if d.midi != nil {
+		controllers = append(controllers, d.midi)
+	}
+
+	if d.mqtt != nil {
+		controllers = append(controllers, d.mqtt)
+	}
+
+	return controllers
+}
+
+// eventsControllers returns every controller that reports what happens on it
+func (d *Deej) eventsControllers() []DeejEventsController {
+	controllers := []DeejEventsController{}
+
+	if d.eventsController != nil {
+		controllers = append(controllers, d.eventsController)
+	}
+
+	if d.osc != nil {
+		controllers = append(controllers, d.osc)
+	}
+
+	if d.midi != nil {
+		controllers = append(controllers, d.midi)
+	}
+
+	if d.hotkeys != nil {
+		controllers = append(controllers, d.hotkeys)
+	}
+
+	return controllers
+}
+
+// stateControllers returns every controller that displays audio state changes
+func (d *Deej) stateControllers() []DeejStateController {
+	controllers := []DeejStateController{}
+
+	if d.stateController != nil {
+		controllers = append(controllers, d.stateController)
+	}
+
+	if d.midi != nil {
+		controllers = append(controllers, d.midi)
+	}
+
+	return controllers
+}
+
+// subscribeToSliderMoveEvents returns an unbuffered channel that receives slider moves from every controller
+func (d *Deej) subscribeToSliderMoveEvents() chan SliderMoveEvent {
+	ch := make(chan SliderMoveEvent)
+
+	for _, controller := range d.sliderControllers() {
+		go func(events chan SliderMoveEvent) {
+			for event := range events {
+				ch <- event
+			}
+		}(controller.SubscribeToSliderMoveEvents())
+	}
+
+	return ch
+}
+
+// subscribeToControllerEvents returns unbuffered channels that receive handled mute button clicks,
+// output device switches and connection changes from every controller
+func (d *Deej) subscribeToControllerEvents() (chan MuteButtonClickEvent, chan int, chan ConnectionEvent) {
+	muteButtonChannel := make(chan MuteButtonClickEvent)
+	outputDeviceChannel := make(chan int)
+	connectionChannel := make(chan ConnectionEvent)
+
+	for _, controller := range d.eventsControllers() {
+		go func(events chan MuteButtonClickEvent) {
+			for event := range events {
+				muteButtonChannel <- event
+			}
+		}(controller.SubscribeToMuteButtonEvents())
+
+		go func(events chan int) {
+			for event := range events {
+				outputDeviceChannel <- event
+			}
+		}(controller.SubscribeToOutputDeviceEvents())
+
+		go func(events chan ConnectionEvent) {
+			for event := range events {
+				connectionChannel <- event
+			}
+		}(controller.SubscribeToConnectionEvents())
+	}
+
+	return muteButtonChannel, outputDeviceChannel, connectionChannel
+}
+
 // Verbose returns a boolean indicating whether deej is running in verbose mode
 func (d *Deej) Verbose() bool {
 	return d.verbose
@@ -146,21 +408,30 @@ func (d *Deej) setupInterruptHandler() {
 func (d *Deej) run() {
 	d.logger.Info("Run loop starting")
 
+	// switch slider layers with the layer hotkey, if there is one
+	d.setupLayerHotkey()
+
+	// switch profiles with the focused app
+	d.watchForegroundProfiles()
+
 	// watch the config file for changes
 	go d.config.WatchConfigFileChanges()
 
 	// Setup a monitor to refresh the session map every hour.
 	// This solves bugs around stale sessions when the program runs for a long time.
-	go func() {
-		for range d.restartSessionsTicker.C {
-			d.logger.Debug("Refreshing session map")
-			d.sessions.refreshSessions(true)
-		}
-	}()
+	// Session maps that are kept current by session events don't go stale, so they skip this
+	if !d.sessions.watchingSessions {
+		go func() {
+			for range d.restartSessionsTicker.C {
+				d.logger.Debug("Refreshing session map")
+				d.sessions.refreshSessions(true)
+			}
+		}()
+	}
 
-	// connect to the serial port for the first time
-	// Note: Since both controllers are the same SerialIO instance,
-	// we only need to call Start() once
+	// connect to the controllers for the first time
+	// Note: Since every controller field is the same group of controllers,
+	// we only need to call Start() once. it only fails if none of them connected
 	go func() {
 		if err := d.deejSlidersController.Start(); err != nil {
 			d.logger.Warnw("Failed to start serial connection", "error", err)
@@ -193,9 +464,30 @@ func (d *Deej) stop() error {
 
 	d.config.StopWatchingConfigFile()
 
-	// Only call Stop() once since both controllers are the same instance
+	// Only call Stop() once since every controller field is the same instance
 	d.deejSlidersController.Stop()
 
+	// stop taking API requests before the sessions they'd change are gone
+	if d.api != nil {
+		d.api.stop()
+	}
+
+	if d.mqtt != nil {
+		d.mqtt.Stop()
+	}
+
+	if d.osc != nil {
+		d.osc.Stop()
+	}
+
+	if d.midi != nil {
+		d.midi.Stop()
+	}
+
+	if d.hotkeys != nil {
+		d.hotkeys.Stop()
+	}
+
 	// release the session map
 	if err := d.sessions.release(); err != nil {
 		d.logger.Errorw("Failed to release session map", "error", err)
@@ -23,6 +23,33 @@ type DeejButtonsController interface {
 	setToggleOutputDeviceEventConsumer(ToggleOutputDeviceConsumer)
 }
 
+// DeejDeviceInfoController is implemented by controllers that identify themselves when they connect
+type DeejDeviceInfoController interface {
+	DeviceInfo() (DeviceInfo, bool)
+	SubscribeToDeviceInfo() chan DeviceInfo
+}
+
+// DeejEventsController is implemented by controllers that report what happens on them besides slider moves,
+// once deej handled it
+type DeejEventsController interface {
+	SubscribeToMuteButtonEvents() chan MuteButtonClickEvent
+	SubscribeToOutputDeviceEvents() chan int
+	SubscribeToConnectionEvents() chan ConnectionEvent
+}
+
+// DeejStateController is implemented by controllers that can display audio state changes made outside deej.
+// pushState is called whenever the state might have changed, and only asks for the current state when the controller can use it
+type DeejStateController interface {
+	pushState(getState func() ControllerState)
+}
+
+// ControllerState is the audio state that a controller reflects on its LEDs
+type ControllerState struct {
+	MuteButtons  []bool
+	OutputDevice int
+	Layer        int
+}
+
 // SliderMoveEvent represents a single slider move captured by deej
 type SliderMoveEvent struct {
 	SliderID     int
@@ -34,6 +61,12 @@ type ToggleOutoutDeviceClickEvent struct {
 	selectedOutputDevice int
 }
 
+// ConnectionEvent represents the controller connecting or disconnecting
+type ConnectionEvent struct {
+	Connected bool
+	Transport string
+}
+
 // MuteButtonClickEvent represents a single MuteButton click captured by deej
 type MuteButtonClickEvent struct {
 	MuteButtonID int
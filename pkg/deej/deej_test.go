@@ -8,6 +8,24 @@ import (
 	"go.uber.org/zap"
 )
 
+// newTestDeej creates a Deej instance for the config.yaml in the working directory,
+// without connecting to the system's audio APIs
+func newTestDeej(t testing.TB, logger *zap.SugaredLogger) *Deej {
+	notifier := &mockNotifier{}
+
+	config, err := NewConfig(logger, notifier)
+	if err != nil {
+		t.Fatalf("Failed to create config: %v", err)
+	}
+
+	return &Deej{
+		config:      config,
+		logger:      logger,
+		notifier:    notifier,
+		stopChannel: make(chan bool),
+	}
+}
+
 // TestSerialIOCreation tests that SerialIO is successfully created
 func TestSerialIOCreation(t *testing.T) {
 	configContent := `
@@ -24,12 +42,7 @@ serial_connection_info:
 	defer os.Remove("config.yaml")
 
 	logger := zap.NewNop().Sugar()
-	notifier := &mockNotifier{}
-
-	deej, err := NewDeej(logger, false)
-	if err != nil {
-		t.Fatalf("Failed to create Deej: %v", err)
-	}
+	deej := newTestDeej(t, logger)
 
 	// Load config first
 	if err := deej.config.Load(); err != nil {
@@ -70,10 +83,7 @@ serial_connection_info:
 
 	logger := zap.NewNop().Sugar()
 
-	deej, err := NewDeej(logger, false)
-	if err != nil {
-		t.Fatalf("Failed to create Deej: %v", err)
-	}
+	deej := newTestDeej(t, logger)
 
 	// Load config
 	if err := deej.config.Load(); err != nil {
@@ -99,7 +109,7 @@ serial_connection_info:
 	}
 
 	// Verify they're the same instance
-	if deej.deejSlidersController != deej.deejButtonsController {
+	if interface{}(deej.deejSlidersController) != interface{}(deej.deejButtonsController) {
 		t.Error("Expected both controllers to point to the same SerialIO instance")
 	}
 }
@@ -126,10 +136,7 @@ serial_connection_info:
 
 	logger := zap.NewNop().Sugar()
 
-	deej, err := NewDeej(logger, false)
-	if err != nil {
-		t.Fatalf("Failed to create Deej: %v", err)
-	}
+	deej := newTestDeej(t, logger)
 
 	// Load config
 	if err := deej.config.Load(); err != nil {
@@ -159,7 +166,7 @@ serial_connection_info:
 	})
 
 	// Verify consumers are registered by calling them directly
-	serialIO.handleMuteButtons([]string{"true", "false"})
+	serialIO.handleMuteButton([]string{"0", "true"})
 	serialIO.handleSwitchOutput([]string{"1"})
 
 	if !muteConsumerCalled {
@@ -188,10 +195,7 @@ serial_connection_info:
 
 	logger := zap.NewNop().Sugar()
 
-	deej, err := NewDeej(logger, false)
-	if err != nil {
-		t.Fatalf("Failed to create Deej: %v", err)
-	}
+	deej := newTestDeej(t, logger)
 
 	// Load config
 	if err := deej.config.Load(); err != nil {
@@ -0,0 +1,133 @@
+package deej
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+
+	// the version of the controller protocol that this backend speaks. firmware that reports a newer
+	// version might rely on commands that deej doesn't understand yet
+	controllerProtocolVersion = 3
+
+	// the first protocol version whose firmware expects unsolicited MuteState and OutputDevice lines.
+	// older firmware would mistake them for replies to its own commands
+	statePushProtocolVersion = 2
+
+	// the first protocol version whose firmware knows about slider layers, and expects Layer lines in state pushes
+	layerProtocolVersion = 3
+)
+
+// DeviceInfo describes a connected controller, as reported by its firmware in the Hello handshake
+type DeviceInfo struct {
+	ProtocolVersion int
+	NumSliders      int
+	NumButtons      int
+	NumDeviceLEDs   int
+	FirmwareVersion string
+
+	// whether the firmware sends binary slider frames instead of Sliders lines, see slider_frame.go
+	BinarySliders bool
+}
+
+// parseDeviceInfo parses the fields of a Hello line:
+// Hello|<protocol version>|<sliders>|<buttons>|<output device LEDs>|<firmware version>[|<capabilities>]
+// where capabilities is a comma-separated list of optional protocol features that the firmware supports
+func parseDeviceInfo(data []string) (DeviceInfo, error) {
+	if len(data) != 5 && len(data) != 6 {
+		return DeviceInfo{}, fmt.Errorf("expected 5 or 6 fields, got %d", len(data))
+	}
+
+	counts := make([]int, 4)
+
+	for idx, field := range data[:4] {
+		value, err := strconv.Atoi(field)
+		if err != nil || value < 0 {
+			return DeviceInfo{}, fmt.Errorf("invalid count or version: %q", field)
+		}
+
+		counts[idx] = value
+	}
+
+	if data[4] == "" {
+		return DeviceInfo{}, errors.New("missing firmware version")
+	}
+
+	info := DeviceInfo{
+		ProtocolVersion: counts[0],
+		NumSliders:      counts[1],
+		NumButtons:      counts[2],
+		NumDeviceLEDs:   counts[3],
+		FirmwareVersion: data[4],
+	}
+
+	// capabilities that deej doesn't know are ignored, the reply tells the firmware which ones it can use
+	if len(data) == 6 {
+		for _, capability := range strings.Split(data[5], ",") {
+			if capability == capabilityBinarySliders {
+				info.BinarySliders = info.NumSliders > 0 && info.NumSliders <= maxBinarySliders
+			}
+		}
+	}
+
+	return info, nil
+}
+
+func (info DeviceInfo) String() string {
+	return fmt.Sprintf("firmware %s, protocol v%d (%d sliders, %d buttons, %d output device LEDs)",
+		info.FirmwareVersion, info.ProtocolVersion, info.NumSliders, info.NumButtons, info.NumDeviceLEDs)
+}
+
+// mismatches compares the controller with the configured mappings, and describes every difference.
+// a controller that shares the mappings with others only has to fit into them from its offsets on
+func (info DeviceInfo) mismatches(config *CanonicalConfig, controller controllerConfig) []string {
+	mismatches := []string{}
+
+	if info.ProtocolVersion > controllerProtocolVersion {
+		mismatches = append(mismatches, fmt.Sprintf("the firmware speaks protocol v%d, but deej only supports up to v%d",
+			info.ProtocolVersion, controllerProtocolVersion))
+	}
+
+	shared := len(config.Controllers) > 1
+
+	for _, check := range []struct {
+		what       string
+		configKey  string
+		onDevice   int
+		offset     int
+		mappingLen int
+	}{
+		{"sliders", configKeySliderMapping, info.NumSliders, controller.SliderOffset, config.numMappedSliders()},
+		{"mute buttons", configKeyMuteButtonMapping, info.NumButtons, controller.MuteButtonOffset, config.MuteButtonMapping.NumSliders()},
+		{"output device LEDs", configKeyAvailableOutputDeviceMapping, info.NumDeviceLEDs, 0, config.AvailableOutputDeviceMapping.NumSliders()},
+	} {
+
+		// an empty mapping means the feature isn't used, which is fine no matter what the board has
+		if check.mappingLen == 0 {
+			continue
+		}
+
+		if shared {
+			if check.offset+check.onDevice <= check.mappingLen {
+				continue
+			}
+
+			mismatches = append(mismatches, fmt.Sprintf("the controller has %d %s from index %d on, but %s maps %d",
+				check.onDevice, check.what, check.offset, check.configKey, check.mappingLen))
+
+			continue
+		}
+
+		if check.mappingLen == check.onDevice {
+			continue
+		}
+
+		mismatches = append(mismatches, fmt.Sprintf("the controller has %d %s, but %s maps %d",
+			check.onDevice, check.what, check.configKey, check.mappingLen))
+	}
+
+	return mismatches
+}
@@ -0,0 +1,170 @@
+package deej
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TestParseDeviceInfo tests parsing the fields of a Hello line
+func TestParseDeviceInfo(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected DeviceInfo
+		fails    bool
+	}{
+		{"Hello|1|5|2|2|1.2.0", DeviceInfo{1, 5, 2, 2, "1.2.0", false}, false},
+		{"Hello|2|8|0|0|dev-build", DeviceInfo{2, 8, 0, 0, "dev-build", false}, false},
+		{"Hello|2|8|0|0|1.3.0|binary", DeviceInfo{2, 8, 0, 0, "1.3.0", true}, false},
+		{"Hello|2|8|0|0|1.3.0|teleport,binary", DeviceInfo{2, 8, 0, 0, "1.3.0", true}, false},
+		{"Hello|2|8|0|0|1.3.0|teleport", DeviceInfo{2, 8, 0, 0, "1.3.0", false}, false},
+		{"Hello|2|100|0|0|1.3.0|binary", DeviceInfo{2, 100, 0, 0, "1.3.0", false}, false},
+		{"Hello|1|5|2|2", DeviceInfo{}, true},
+		{"Hello|1|five|2|2|1.2.0", DeviceInfo{}, true},
+		{"Hello|1|-5|2|2|1.2.0", DeviceInfo{}, true},
+		{"Hello|1|5|2|2|", DeviceInfo{}, true},
+	}
+
+	for _, test := range tests {
+		info, err := parseDeviceInfo(strings.Split(test.line, "|")[1:])
+
+		if test.fails {
+			if err == nil {
+				t.Errorf("Expected an error for '%s', got %+v", test.line, info)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Failed to parse '%s': %v", test.line, err)
+			continue
+		}
+
+		if info != test.expected {
+			t.Errorf("Expected %+v for '%s', got %+v", test.expected, test.line, info)
+		}
+	}
+}
+
+// TestDeviceInfoMismatches tests that every difference between the controller and the mappings is reported
+func TestDeviceInfoMismatches(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, `
+slider_mapping:
+  0: master
+  1: chrome
+mute_button_mapping:
+  0: master
+available_output_device:
+  0: Speakers
+  1: Headphones
+`)
+	defer cleanup()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if mismatches := (DeviceInfo{1, 2, 1, 2, "1.0.0", false}).mismatches(deej.config, deej.config.controllerConfigAt(0)); len(mismatches) != 0 {
+		t.Errorf("Expected a matching controller, got %v", mismatches)
+	}
+
+	mismatches := (DeviceInfo{4, 5, 1, 0, "4.0.0", false}).mismatches(deej.config, deej.config.controllerConfigAt(0))
+
+	for _, expected := range []string{
+		"protocol v4",
+		"the controller has 5 sliders, but slider_mapping maps 2",
+		"the controller has 0 output device LEDs, but available_output_device maps 2",
+	} {
+		if !strings.Contains(strings.Join(mismatches, "; "), expected) {
+			t.Errorf("Expected the mismatches to contain '%s', got %v", expected, mismatches)
+		}
+	}
+
+	if len(mismatches) != 3 {
+		t.Errorf("Expected 3 mismatches, got %d: %v", len(mismatches), mismatches)
+	}
+}
+
+// TestHelloHandshake tests that a controller's hello is answered, exposed and validated against the mappings
+func TestHelloHandshake(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, `
+slider_mapping:
+  0: master
+  1: chrome
+mute_button_mapping:
+  0: master
+`)
+	defer cleanup()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	sio, err := NewSerialIO(deej, logger)
+	if err != nil {
+		t.Fatalf("Failed to create SerialIO: %v", err)
+	}
+
+	mockConn := &mockSerialConnection{writeBuffer: []string{}}
+	sio.conn = mockConn
+	sio.connected = true
+
+	if _, ok := sio.DeviceInfo(); ok {
+		t.Error("Expected no device info before the controller says hello")
+	}
+
+	sio.handleLine("Hello|1|3|1|0|1.2.0")
+
+	if len(mockConn.writeBuffer) != 1 || strings.TrimSpace(mockConn.writeBuffer[0]) != "Hello|3" {
+		t.Fatalf("Expected the reply 'Hello|3', got %v", mockConn.writeBuffer)
+	}
+
+	info, ok := sio.DeviceInfo()
+	if !ok || info.NumSliders != 3 || info.FirmwareVersion != "1.2.0" {
+		t.Errorf("Expected the reported device info, got %+v (%v)", info, ok)
+	}
+
+	notifier := deej.notifier.(*mockNotifier)
+	if titles := notifier.notified(); len(titles) != 1 || titles[0] != "deej - Controller mismatch" {
+		t.Errorf("Expected a single mismatch notification, got %v", titles)
+	}
+
+	// reconnecting to the same controller shouldn't notify again
+	sio.handleLine("Hello|1|3|1|0|1.2.0")
+
+	if titles := notifier.notified(); len(titles) != 1 {
+		t.Errorf("Expected the mismatch to be notified once, got %v", titles)
+	}
+
+	// the controller's slider count is what's expected from now on, even the unmapped third slider
+	eventChan := sio.SubscribeToSliderMoveEvents()
+	done := sendSliders(sio, []string{"0", "2048", "4095"})
+
+	for expected := 0; expected < 3; expected++ {
+		select {
+		case event := <-eventChan:
+			if event.SliderID != expected {
+				t.Errorf("Expected an event for slider %d, got %d", expected, event.SliderID)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for slider %d", expected)
+		}
+	}
+
+	<-done
+
+	sio.handleLine("Hello|1|3|1")
+
+	if reply := strings.TrimSpace(mockConn.writeBuffer[len(mockConn.writeBuffer)-1]); reply != "ERROR" {
+		t.Errorf("Expected 'ERROR' for an invalid hello, got '%s'", reply)
+	}
+}
@@ -0,0 +1,198 @@
+package deej
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// the event stream is a WebSocket on the API (see api.go) that sends what happens as it happens, one JSON
+// message per event. it's meant for overlays such as OBS browser sources, which can't poll fast enough to show
+// sliders moving. browsers can't set headers on WebSockets, so the token may also be given as ?token=<token>
+const (
+	eventTypeSlider       = "slider"
+	eventTypeMuteButton   = "mute_button"
+	eventTypeOutputDevice = "output_device"
+	eventTypeConfigReload = "config_reload"
+	eventTypeConnection   = "connection"
+
+	// how many events a client can fall behind before it's disconnected. sliders alone can send dozens a second,
+	// and a client that can't keep up is better off reconnecting than seeing volumes from a while ago
+	eventClientBufferSize = 256
+
+	eventWriteTimeout = 5 * time.Second
+	eventPingInterval = 30 * time.Second
+)
+
+// streamEvent is a single message on the event stream
+type streamEvent struct {
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+type sliderStreamEvent struct {
+	Slider int     `json:"slider"`
+	Value  float32 `json:"value"`
+}
+
+type muteButtonStreamEvent struct {
+	Button int  `json:"button"`
+	Mute   bool `json:"mute"`
+}
+
+type outputDeviceStreamEvent struct {
+	Index int `json:"index"`
+}
+
+type connectionStreamEvent struct {
+	Connected bool   `json:"connected"`
+	Transport string `json:"transport"`
+}
+
+// eventStream sends every published event to each connected WebSocket client
+type eventStream struct {
+	logger   *zap.SugaredLogger
+	upgrader websocket.Upgrader
+
+	clients     map[*eventClient]bool
+	clientsLock sync.Mutex
+}
+
+// eventClient is a single WebSocket connection, with the events that haven't been written to it yet
+type eventClient struct {
+	conn *websocket.Conn
+	send chan []byte
+	once sync.Once
+}
+
+func newEventStream(logger *zap.SugaredLogger) *eventStream {
+	return &eventStream{
+		logger: logger.Named("events"),
+		upgrader: websocket.Upgrader{
+
+			// the token already proves that the client is allowed in, wherever its page came from.
+			// overlays loaded from a file don't have a meaningful origin to check anyway
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+		clients: map[*eventClient]bool{},
+	}
+}
+
+// publish sends an event to every client, without waiting for any of them
+func (s *eventStream) publish(eventType string, data interface{}) {
+	s.clientsLock.Lock()
+	defer s.clientsLock.Unlock()
+
+	if len(s.clients) == 0 {
+		return
+	}
+
+	message, err := json.Marshal(streamEvent{Type: eventType, Time: time.Now(), Data: data})
+	if err != nil {
+		s.logger.Warnw("Failed to encode event", "type", eventType, "error", err)
+		return
+	}
+
+	for client := range s.clients {
+		select {
+		case client.send <- message:
+		default:
+			s.logger.Warnw("Disconnecting event stream client that fell behind", "remote", client.conn.RemoteAddr())
+			s.removeClient(client)
+		}
+	}
+}
+
+// ServeHTTP upgrades the request to a WebSocket and streams events to it until either side closes it
+func (s *eventStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+
+		// the upgrader already responded with an error
+		s.logger.Debugw("Failed to upgrade event stream request", "error", err)
+		return
+	}
+
+	client := &eventClient{conn: conn, send: make(chan []byte, eventClientBufferSize)}
+
+	s.clientsLock.Lock()
+	s.clients[client] = true
+	s.clientsLock.Unlock()
+
+	s.logger.Infow("Event stream client connected", "remote", conn.RemoteAddr())
+
+	go s.writeLoop(client)
+	s.readLoop(client)
+}
+
+// readLoop discards whatever the client sends, and notices when it goes away
+func (s *eventStream) readLoop(client *eventClient) {
+	for {
+		if _, _, err := client.conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	s.clientsLock.Lock()
+	s.removeClient(client)
+	s.clientsLock.Unlock()
+
+	s.logger.Infow("Event stream client disconnected", "remote", client.conn.RemoteAddr())
+}
+
+// writeLoop is the only writer of a client's connection, as gorilla/websocket supports one concurrent writer at most
+func (s *eventStream) writeLoop(client *eventClient) {
+	ticker := time.NewTicker(eventPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message, ok := <-client.send:
+			client.conn.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+
+			if !ok {
+				client.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				client.conn.Close()
+
+				return
+			}
+
+			if err := client.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				client.conn.Close()
+				return
+			}
+
+		case <-ticker.C:
+			client.conn.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+
+			if err := client.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				client.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// removeClient stops writing to a client, which closes its connection. the caller holds clientsLock
+func (s *eventStream) removeClient(client *eventClient) {
+	delete(s.clients, client)
+	client.once.Do(func() {
+		close(client.send)
+	})
+}
+
+// closeClients disconnects every client. the http server doesn't know about hijacked connections,
+// so they have to be closed separately when it shuts down
+func (s *eventStream) closeClients() {
+	s.clientsLock.Lock()
+	defer s.clientsLock.Unlock()
+
+	for client := range s.clients {
+		s.removeClient(client)
+	}
+}
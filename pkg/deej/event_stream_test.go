@@ -0,0 +1,195 @@
+package deej
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// dialEventStream connects to the event stream of a test server
+func dialEventStream(t *testing.T, server *httptest.Server, query string) (*websocket.Conn, *http.Response, error) {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + apiPathEvents + query
+	return websocket.DefaultDialer.Dial(url, nil)
+}
+
+// readStreamEvent reads the next event, with its data left encoded
+func readStreamEvent(t *testing.T, conn *websocket.Conn) (string, string) {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	event := struct {
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}{}
+
+	if err := conn.ReadJSON(&event); err != nil {
+		t.Fatalf("Failed to read event: %v", err)
+	}
+
+	return event.Type, string(event.Data)
+}
+
+// TestEventStream tests that events reach every client as JSON, and only clients with the token get in
+func TestEventStream(t *testing.T) {
+	a := newTestAPIServer(t, &fakeSessionFinder{currentOutputDevice: []string{"Speakers"}})
+
+	server := httptest.NewServer(a.handler(testAPIToken))
+	defer server.Close()
+	defer a.events.closeClients()
+
+	for _, query := range []string{"", "?token=secret"} {
+		if _, response, err := dialEventStream(t, server, query); err == nil || response.StatusCode != http.StatusUnauthorized {
+			t.Errorf("'%s': expected the stream to refuse the connection, got %v", query, err)
+		}
+	}
+
+	first, _, err := dialEventStream(t, server, "?token="+testAPIToken)
+	if err != nil {
+		t.Fatalf("Failed to connect with the token in the query: %v", err)
+	}
+	defer first.Close()
+
+	second, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+apiPathEvents,
+		http.Header{"Authorization": []string{"Bearer " + testAPIToken}})
+	if err != nil {
+		t.Fatalf("Failed to connect with the token in the header: %v", err)
+	}
+	defer second.Close()
+
+	// clients register right after the upgrade, which the dial doesn't wait for
+	deadline := time.Now().Add(time.Second)
+	for {
+		a.events.clientsLock.Lock()
+		clients := len(a.events.clients)
+		a.events.clientsLock.Unlock()
+
+		if clients == 2 || time.Now().After(deadline) {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	a.events.publish(eventTypeSlider, sliderStreamEvent{Slider: 2, Value: 0.5})
+	a.events.publish(eventTypeMuteButton, muteButtonStreamEvent{Button: 1, Mute: true})
+	a.events.publish(eventTypeConfigReload, nil)
+
+	// switching the output device from the API reports it too
+	if code := apiRequest(t, a.handler(testAPIToken), http.MethodPost, apiPathOutputDevices, `{"index": 1}`, nil); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	for _, conn := range []*websocket.Conn{first, second} {
+		for _, expected := range []struct {
+			eventType string
+			data      string
+		}{
+			{eventTypeSlider, `{"slider":2,"value":0.5}`},
+			{eventTypeMuteButton, `{"button":1,"mute":true}`},
+			{eventTypeConfigReload, ""},
+			{eventTypeOutputDevice, `{"index":1}`},
+		} {
+			if eventType, data := readStreamEvent(t, conn); eventType != expected.eventType || data != expected.data {
+				t.Errorf("Expected a %s event with '%s', got a %s event with '%s'", expected.eventType, expected.data, eventType, data)
+			}
+		}
+	}
+
+	// shutting down disconnects everyone
+	a.events.closeClients()
+
+	first.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := first.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("Expected the stream to close, got %v", err)
+	}
+}
+
+// TestControllerEvents tests that the controller reports handled mute button clicks, output device switches
+// and its connection coming and going
+func TestControllerEvents(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, outputDeviceTestConfig)
+	defer cleanup()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	sio, err := NewSerialIO(deej, logger)
+	if err != nil {
+		t.Fatalf("Failed to create SerialIO: %v", err)
+	}
+
+	sio.conn = &mockSerialConnection{writeBuffer: []string{}}
+	sio.connected = true
+
+	sio.setMuteButtonClickEventConsumer(func(events []MuteButtonClickEvent) (MuteButtonsState, error) {
+		return MuteButtonsState{}, nil
+	})
+
+	sio.setToggleOutputDeviceEventConsumer(func(event ToggleOutoutDeviceClickEvent) (OutputDeviceState, error) {
+
+		// the device that actually became the default
+		return OutputDeviceState{selectedOutputDevice: 0}, nil
+	})
+
+	muteButtons := sio.SubscribeToMuteButtonEvents()
+	outputDevices := sio.SubscribeToOutputDeviceEvents()
+	connections := sio.SubscribeToConnectionEvents()
+
+	received := make(chan string, 3)
+
+	go func() {
+		for {
+			select {
+			case event := <-muteButtons:
+				received <- "mute"
+				if event.MuteButtonID != 1 || !event.mute {
+					t.Errorf("Expected button 1 to mute, got %+v", event)
+				}
+			case deviceIdx := <-outputDevices:
+				received <- "device"
+				if deviceIdx != 0 {
+					t.Errorf("Expected the device that became the default, got %d", deviceIdx)
+				}
+			case event := <-connections:
+				received <- "connection"
+				if event.Connected {
+					t.Error("Expected a disconnect")
+				}
+			}
+		}
+	}()
+
+	sio.handleLine("MuteButton|1|true")
+	sio.handleLine("MuteButton|x|true")
+	sio.handleLine("SwitchOutput|1")
+	sio.Stop()
+
+	// a stopped controller that's stopped again doesn't disconnect twice
+	sio.Stop()
+
+	for _, expected := range []string{"mute", "device", "connection"} {
+		select {
+		case actual := <-received:
+			if actual != expected {
+				t.Errorf("Expected a %s event, got %s", expected, actual)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for a %s event", expected)
+		}
+	}
+
+	select {
+	case actual := <-received:
+		t.Errorf("Expected no more events, got %s", actual)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,120 @@
+package deej
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// framed lines protect the line protocol from corruption (i.e. electrical noise on long USB cables).
+// a frame looks like @<seq>:<line>*<crc>, where seq counts the controller's frames and crc is the
+// CRC-16/CCITT-FALSE of everything between the @ and the *, as 4 hex digits.
+// replies carry the sequence number of the frame they acknowledge, and lines that deej sends
+// on its own carry an empty one. firmware opts in by framing its lines, plain lines keep working
+const (
+	frameStart    = "@"
+	frameSeqEnd   = ":"
+	frameCRCStart = "*"
+
+	// sequence numbers wrap around to 1, as 0 marks a controller that (re)started counting
+	frameSeqRestart = 0
+)
+
+var errCorruptFrame = errors.New("corrupt frame")
+
+// FrameStats counts the frames received from the controller, along with the ones that were dropped
+type FrameStats struct {
+	Accepted   uint64
+	Corrupt    uint64
+	OutOfOrder uint64
+}
+
+// frameSequence tracks the sequence numbers of a controller's frames
+type frameSequence struct {
+	last    uint16
+	started bool
+}
+
+// accept returns true if seq comes after the last accepted sequence number. frames in between
+// may have been lost, but anything older is either a duplicate or arrived out of order
+func (fs *frameSequence) accept(seq uint16) bool {
+	if seq == frameSeqRestart || !fs.started {
+		fs.last = seq
+		fs.started = true
+
+		return true
+	}
+
+	// up to half of the sequence space ahead counts as newer, which allows for wrapping around
+	if delta := seq - fs.last; delta == 0 || delta >= 1<<15 {
+		return false
+	}
+
+	fs.last = seq
+
+	return true
+}
+
+func isFrame(line string) bool {
+	return strings.HasPrefix(line, frameStart)
+}
+
+// parseFrame verifies a frame's CRC, and returns its sequence number and the line it carries
+func parseFrame(frame string) (uint16, string, error) {
+	body := strings.TrimPrefix(frame, frameStart)
+
+	crcIdx := strings.LastIndex(body, frameCRCStart)
+	if crcIdx < 0 || len(body)-crcIdx-1 != 4 {
+		return 0, "", fmt.Errorf("%w: missing crc", errCorruptFrame)
+	}
+
+	crc, err := strconv.ParseUint(body[crcIdx+1:], 16, 16)
+	if err != nil {
+		return 0, "", fmt.Errorf("%w: invalid crc: %v", errCorruptFrame, err)
+	}
+
+	body = body[:crcIdx]
+
+	if expected := crc16(body); uint16(crc) != expected {
+		return 0, "", fmt.Errorf("%w: crc %04X doesn't match %04X", errCorruptFrame, crc, expected)
+	}
+
+	seqEnd := strings.Index(body, frameSeqEnd)
+	if seqEnd < 0 {
+		return 0, "", fmt.Errorf("%w: missing sequence number", errCorruptFrame)
+	}
+
+	seq, err := strconv.ParseUint(body[:seqEnd], 10, 16)
+	if err != nil {
+		return 0, "", fmt.Errorf("%w: invalid sequence number: %v", errCorruptFrame, err)
+	}
+
+	return uint16(seq), body[seqEnd+1:], nil
+}
+
+// encodeFrame frames a line with the given sequence number, which is empty for lines that don't acknowledge any
+func encodeFrame(seq string, line string) string {
+	body := seq + frameSeqEnd + line
+
+	return fmt.Sprintf("%s%s%s%04X", frameStart, body, frameCRCStart, crc16(body))
+}
+
+// crc16 computes the CRC-16/CCITT-FALSE checksum (polynomial 0x1021, initial value 0xFFFF)
+func crc16(data string) uint16 {
+	crc := uint16(0xFFFF)
+
+	for idx := 0; idx < len(data); idx++ {
+		crc ^= uint16(data[idx]) << 8
+
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
@@ -0,0 +1,161 @@
+package deej
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TestCRC16 tests the checksum against the CRC-16/CCITT-FALSE check value
+func TestCRC16(t *testing.T) {
+	if crc := crc16("123456789"); crc != 0x29B1 {
+		t.Errorf("Expected 29B1, got %04X", crc)
+	}
+}
+
+// TestParseFrame tests that intact frames are unwrapped, and that any corruption is detected
+func TestParseFrame(t *testing.T) {
+	valid := encodeFrame("42", "Sliders|0|4095")
+
+	if !strings.HasPrefix(valid, "@42:Sliders|0|4095*") || len(valid) != len("@42:Sliders|0|4095*")+4 {
+		t.Fatalf("Unexpected frame encoding '%s'", valid)
+	}
+
+	seq, line, err := parseFrame(valid)
+	if err != nil {
+		t.Fatalf("Failed to parse '%s': %v", valid, err)
+	}
+
+	if seq != 42 || line != "Sliders|0|4095" {
+		t.Errorf("Expected sequence 42 and 'Sliders|0|4095', got %d and '%s'", seq, line)
+	}
+
+	for _, corrupt := range []string{
+		strings.Replace(valid, "4095", "4096", 1),
+		strings.Replace(valid, "@42", "@43", 1),
+		valid[:len(valid)-1],
+		"@42:Sliders|0|4095",
+		"@Sliders|0|4095*" + valid[len(valid)-4:],
+		encodeFrame("", "Sliders|0|4095"),
+		encodeFrame("70000", "Sliders|0|4095"),
+	} {
+		if _, _, err := parseFrame(corrupt); !errors.Is(err, errCorruptFrame) {
+			t.Errorf("Expected '%s' to be corrupt, got %v", corrupt, err)
+		}
+	}
+}
+
+// TestFrameSequence tests that sequence numbers must increase, allowing for lost frames, wrapping and restarts
+func TestFrameSequence(t *testing.T) {
+	fs := &frameSequence{}
+
+	for _, test := range []struct {
+		seq      uint16
+		accepted bool
+	}{
+		{100, true},
+		{101, true},
+		{101, false},
+		{100, false},
+		{105, true},
+		{65535, false},
+		{0, true},
+		{1, true},
+		{32768, true},
+		{65535, true},
+		{1, true},
+	} {
+		if accepted := fs.accept(test.seq); accepted != test.accepted {
+			t.Errorf("Expected sequence %d to be accepted: %v, got %v", test.seq, test.accepted, accepted)
+		}
+	}
+}
+
+// TestFramedLines tests that framed lines are handled and acknowledged, while untrustworthy ones are dropped and counted
+func TestFramedLines(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, `
+slider_mapping:
+  0: master
+mute_button_mapping:
+  0: master
+`)
+	defer cleanup()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	sio, err := NewSerialIO(deej, logger)
+	if err != nil {
+		t.Fatalf("Failed to create SerialIO: %v", err)
+	}
+
+	mockConn := &mockSerialConnection{writeBuffer: []string{}}
+	sio.conn = mockConn
+	sio.connected = true
+
+	sio.setMuteButtonClickEventConsumer(func(events []MuteButtonClickEvent) (MuteButtonsState, error) {
+		return MuteButtonsState{MuteButtons: []bool{events[0].mute}}, nil
+	})
+
+	eventChan := sio.SubscribeToSliderMoveEvents()
+
+	// plain lines work until the controller frames its first one
+	sio.handleIncomingLine("MuteButton|0|true")
+
+	if reply := strings.TrimSpace(mockConn.writeBuffer[0]); reply != "OK" {
+		t.Errorf("Expected a plain 'OK', got '%s'", reply)
+	}
+
+	mockConn.writeBuffer = []string{}
+	done := make(chan bool)
+
+	go func() {
+		sio.handleIncomingLine(encodeFrame("7", "Sliders|4095"))
+		close(done)
+	}()
+
+	select {
+	case event := <-eventChan:
+		if event.PercentValue != 1.0 {
+			t.Errorf("Expected the framed slider at 1.0, got %.2f", event.PercentValue)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the framed slider")
+	}
+
+	<-done
+
+	if reply := strings.TrimSpace(mockConn.writeBuffer[0]); reply != encodeFrame("7", "OK") {
+		t.Errorf("Expected an 'OK' that acknowledges sequence 7, got '%s'", reply)
+	}
+
+	// none of these should make it to the handlers
+	mockConn.writeBuffer = []string{}
+
+	sio.handleIncomingLine(strings.Replace(encodeFrame("8", "Sliders|0"), "|0", "|9", 1))
+	sio.handleIncomingLine(encodeFrame("7", "Sliders|0"))
+	sio.handleIncomingLine("Sliders|0")
+
+	if len(mockConn.writeBuffer) != 0 {
+		t.Errorf("Expected dropped lines to go unanswered, got %v", mockConn.writeBuffer)
+	}
+
+	expected := FrameStats{Accepted: 1, Corrupt: 2, OutOfOrder: 1}
+	if stats := sio.FrameStats(); stats != expected {
+		t.Errorf("Expected frame stats %+v, got %+v", expected, stats)
+	}
+
+	// lines that deej sends on its own don't acknowledge anything
+	sio.sendPush("OutputDevice|1")
+
+	if line := strings.TrimSpace(mockConn.writeBuffer[0]); line != encodeFrame("", "OutputDevice|1") {
+		t.Errorf("Expected a pushed frame without a sequence number, got '%s'", line)
+	}
+}
@@ -0,0 +1,177 @@
+package deej
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// hotkeys are global key combinations, written as modifiers and a key joined by '+' (i.e. ctrl+alt+l).
+// the platform-specific listeners get them from the OS, so that they work whichever window has focus
+const (
+	hotkeyModCtrl hotkeyModifiers = 1 << iota
+	hotkeyModAlt
+	hotkeyModShift
+	hotkeyModSuper
+)
+
+type hotkeyModifiers int
+
+var hotkeyModifierNames = map[string]hotkeyModifiers{
+	"ctrl":    hotkeyModCtrl,
+	"control": hotkeyModCtrl,
+	"alt":     hotkeyModAlt,
+	"shift":   hotkeyModShift,
+	"super":   hotkeyModSuper,
+	"win":     hotkeyModSuper,
+}
+
+// named keys that hotkeys can use besides letters, digits and function keys. media keys don't need a modifier,
+// as they don't type anything that another app could be waiting for
+var (
+	hotkeyArrowKeys = map[string]bool{"up": true, "down": true, "left": true, "right": true}
+	hotkeyMediaKeys = map[string]bool{"volumeup": true, "volumedown": true, "volumemute": true}
+)
+
+// hotkey is a parsed key combination. key is a lowercase letter, a digit, a function key (f1-f12),
+// an arrow key or a media key
+type hotkey struct {
+	modifiers hotkeyModifiers
+	key       string
+}
+
+// hotkeyListener calls back whenever one of its hotkeys is pressed, until it's stopped
+type hotkeyListener interface {
+	stop()
+}
+
+// parseHotkey reads a key combination such as ctrl+alt+l. at least one modifier is required except for media keys,
+// as a global hotkey would otherwise take a key away from every other app
+func parseHotkey(value string) (hotkey, error) {
+	parts := strings.Split(strings.ToLower(strings.ReplaceAll(value, " ", "")), "+")
+
+	result := hotkey{}
+
+	for _, part := range parts[:len(parts)-1] {
+		modifier, ok := hotkeyModifierNames[part]
+		if !ok {
+			return hotkey{}, fmt.Errorf("unknown modifier '%s' in '%s'", part, value)
+		}
+
+		if result.modifiers&modifier != 0 {
+			return hotkey{}, fmt.Errorf("repeated modifier '%s' in '%s'", part, value)
+		}
+
+		result.modifiers |= modifier
+	}
+
+	result.key = parts[len(parts)-1]
+	if !validHotkeyKey(result.key) {
+		return hotkey{}, fmt.Errorf("unsupported key '%s' in '%s', use a letter, a digit, f1-f12, up, down, left, right, "+
+			"volumeup, volumedown or volumemute", result.key, value)
+	}
+
+	if result.modifiers == 0 && !hotkeyMediaKeys[result.key] {
+		return hotkey{}, fmt.Errorf("'%s' needs at least one modifier (ctrl, alt, shift or super)", value)
+	}
+
+	return result, nil
+}
+
+func validHotkeyKey(key string) bool {
+	if len(key) == 1 {
+		return (key[0] >= 'a' && key[0] <= 'z') || (key[0] >= '0' && key[0] <= '9')
+	}
+
+	if hotkeyArrowKeys[key] || hotkeyMediaKeys[key] {
+		return true
+	}
+
+	functionKey, err := hotkeyFunctionKey(key)
+	return err == nil && functionKey >= 1 && functionKey <= 12
+}
+
+// hotkeyFunctionKey returns the number of a function key, such as 5 for f5
+func hotkeyFunctionKey(key string) (int, error) {
+	if !strings.HasPrefix(key, "f") {
+		return 0, errors.New("not a function key")
+	}
+
+	return strconv.Atoi(key[1:])
+}
+
+// String returns the hotkey in its canonical form, for the logs
+func (h hotkey) String() string {
+	parts := []string{}
+
+	for _, modifier := range []struct {
+		name     string
+		modifier hotkeyModifiers
+	}{
+		{"ctrl", hotkeyModCtrl},
+		{"alt", hotkeyModAlt},
+		{"shift", hotkeyModShift},
+		{"super", hotkeyModSuper},
+	} {
+		if h.modifiers&modifier.modifier != 0 {
+			parts = append(parts, modifier.name)
+		}
+	}
+
+	return strings.Join(append(parts, h.key), "+")
+}
+
+// setupLayerHotkey listens to the configured layer hotkey, and follows it through config reloads
+func (d *Deej) setupLayerHotkey() {
+	var (
+		listener hotkeyListener
+		current  string
+	)
+
+	update := func() {
+		if d.config.LayerHotkey == current {
+			return
+		}
+
+		if listener != nil {
+			listener.stop()
+			listener = nil
+		}
+
+		current = d.config.LayerHotkey
+		if current == "" {
+			return
+		}
+
+		// the config only loads with a valid hotkey
+		hk, _ := parseHotkey(current)
+
+		// a held hotkey only switches once
+		onPress := func(_ hotkey, repeat bool) {
+			if !repeat {
+				d.NextLayer()
+			}
+		}
+
+		var err error
+		if listener, err = newHotkeysListener(d.logger, []hotkey{hk}, onPress); err != nil {
+			d.logger.Warnw("Failed to listen to layer hotkey", "hotkey", hk, "error", err)
+			d.notifier.Notify("Can't listen to layer hotkey!", fmt.Sprintf("%s: %s", hk, err))
+
+			return
+		}
+
+		d.logger.Infow("Listening to layer hotkey", "hotkey", hk)
+	}
+
+	update()
+
+	configReloadedChannel := d.config.SubscribeToChanges()
+
+	go func() {
+		for range configReloadedChannel {
+			update()
+		}
+	}()
+}
@@ -0,0 +1,347 @@
+package deej
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// hotkey actions change targets' volumes with global key combinations, i.e. ctrl+alt+m toggling the mic's mute
+// or ctrl+alt+up turning spotify up by 5%. targets are the same as the mappings', but aren't tied to a slider
+// or mute button, although targets that a slider controls are stepped along its volume curve. they're listened to
+// like the layer hotkey: registered with the OS on windows, and read from the keyboards' evdev devices on linux
+const (
+	hotkeyActionKeyKeys   = "keys"
+	hotkeyActionKeyAction = "action"
+	hotkeyActionKeyTarget = "target"
+	hotkeyActionKeyStep   = "step"
+
+	hotkeyActionMute   = "mute"
+	hotkeyActionVolume = "volume"
+
+	// percent
+	defaultHotkeyVolumeStep = 5
+
+	// key states as the listeners report them, which happen to be evdev's
+	hotkeyKeyReleased = 0
+	hotkeyKeyPressed  = 1
+	hotkeyKeyRepeated = 2
+)
+
+// hotkeyModifierKeys are the names of the keys that hold modifiers down, with either side counting
+var hotkeyModifierKeys = map[string]hotkeyModifiers{
+	"leftctrl":   hotkeyModCtrl,
+	"rightctrl":  hotkeyModCtrl,
+	"leftalt":    hotkeyModAlt,
+	"rightalt":   hotkeyModAlt,
+	"leftshift":  hotkeyModShift,
+	"rightshift": hotkeyModShift,
+	"leftmeta":   hotkeyModSuper,
+	"rightmeta":  hotkeyModSuper,
+}
+
+// hotkeyAction is what a hotkey does to its targets
+type hotkeyAction struct {
+	Hotkey  hotkey
+	Action  string
+	Targets []string
+
+	// how much the volume action changes the targets' volume by, from -1.0 to 1.0
+	Step float32
+}
+
+// hotkeyActionsFromConfig reads the hotkey actions from the user config. none of them can take the layer hotkey
+func hotkeyActionsFromConfig(userConfig *viper.Viper, layerHotkey string) ([]hotkeyAction, error) {
+	actions := []hotkeyAction{}
+
+	if !userConfig.IsSet(configKeyHotkeys) {
+		return actions, nil
+	}
+
+	rawActions, ok := userConfig.Get(configKeyHotkeys).([]interface{})
+	if !ok {
+		return nil, errors.New("expected a list of hotkeys")
+	}
+
+	taken := map[hotkey]bool{}
+	if layerHotkey != "" {
+		if hk, err := parseHotkey(layerHotkey); err == nil {
+			taken[hk] = true
+		}
+	}
+
+	for idx, rawAction := range rawActions {
+		fields, ok := stringKeyedMap(rawAction)
+		if !ok {
+			return nil, fmt.Errorf("hotkey %d: expected %s, %s and %s", idx, hotkeyActionKeyKeys, hotkeyActionKeyAction, hotkeyActionKeyTarget)
+		}
+
+		keys, ok := fields[hotkeyActionKeyKeys].(string)
+		if !ok {
+			return nil, fmt.Errorf("hotkey %d: missing %s", idx, hotkeyActionKeyKeys)
+		}
+
+		hk, err := parseHotkey(keys)
+		if err != nil {
+			return nil, fmt.Errorf("hotkey %d: %w", idx, err)
+		}
+
+		if taken[hk] {
+			return nil, fmt.Errorf("hotkey %s is taken", hk)
+		}
+
+		taken[hk] = true
+
+		action := hotkeyAction{Hotkey: hk}
+
+		switch target := fields[hotkeyActionKeyTarget].(type) {
+		case string:
+			action.Targets = []string{target}
+		case []interface{}:
+			for _, rawTarget := range target {
+				target, ok := rawTarget.(string)
+				if !ok || target == "" {
+					return nil, fmt.Errorf("hotkey %s: invalid target '%v'", hk, rawTarget)
+				}
+
+				action.Targets = append(action.Targets, target)
+			}
+		}
+
+		if len(action.Targets) == 0 || action.Targets[0] == "" {
+			return nil, fmt.Errorf("hotkey %s: missing %s", hk, hotkeyActionKeyTarget)
+		}
+
+		action.Action, _ = fields[hotkeyActionKeyAction].(string)
+		action.Action = strings.ToLower(action.Action)
+
+		switch action.Action {
+		case hotkeyActionMute:
+		case hotkeyActionVolume:
+			step := defaultHotkeyVolumeStep
+
+			if rawStep, ok := fields[hotkeyActionKeyStep]; ok {
+				if step, ok = rawStep.(int); !ok || step == 0 || step < -100 || step > 100 {
+					return nil, fmt.Errorf("hotkey %s: %s must be a percentage from -100 to 100, other than 0", hk, hotkeyActionKeyStep)
+				}
+			}
+
+			action.Step = float32(step) / 100
+		default:
+			return nil, fmt.Errorf("hotkey %s: %s must be %s or %s", hk, hotkeyActionKeyAction, hotkeyActionMute, hotkeyActionVolume)
+		}
+
+		actions = append(actions, action)
+	}
+
+	return actions, nil
+}
+
+// hotkeyMatcher follows which modifiers are held, and tells which hotkey a key press completes.
+// modifiers have to match exactly, so that ctrl+alt+m doesn't go off on ctrl+alt+shift+m
+type hotkeyMatcher struct {
+	hotkeys map[hotkey]bool
+	held    map[string]bool
+}
+
+func newHotkeyMatcher(hotkeys []hotkey) *hotkeyMatcher {
+	m := &hotkeyMatcher{hotkeys: map[hotkey]bool{}, held: map[string]bool{}}
+
+	for _, hk := range hotkeys {
+		m.hotkeys[hk] = true
+	}
+
+	return m
+}
+
+// handleKey takes a key's new state, and returns the hotkey that it completes if it was pressed or repeated
+func (m *hotkeyMatcher) handleKey(key string, state int) (hotkey, bool) {
+	if _, ok := hotkeyModifierKeys[key]; ok {
+		if state == hotkeyKeyReleased {
+			delete(m.held, key)
+		} else {
+			m.held[key] = true
+		}
+
+		return hotkey{}, false
+	}
+
+	if state == hotkeyKeyReleased {
+		return hotkey{}, false
+	}
+
+	hk := hotkey{key: key}
+	for heldKey := range m.held {
+		hk.modifiers |= hotkeyModifierKeys[heldKey]
+	}
+
+	return hk, m.hotkeys[hk]
+}
+
+// hotkeyController listens to the configured hotkeys, and applies their actions through the session map.
+// it's a controller like any other, whose mute toggles are reported like mute button clicks
+type hotkeyController struct {
+	deej   *Deej
+	logger *zap.SugaredLogger
+
+	muteButtonEventConsumers []chan MuteButtonClickEvent
+
+	// the platform's listener, which tests replace
+	newListener func(logger *zap.SugaredLogger, hotkeys []hotkey, onPress func(hk hotkey, repeat bool)) (hotkeyListener, error)
+
+	actions  []hotkeyAction
+	listener hotkeyListener
+	lock     sync.Mutex
+}
+
+func newHotkeyController(deej *Deej, logger *zap.SugaredLogger) *hotkeyController {
+	h := &hotkeyController{
+		deej:        deej,
+		logger:      logger.Named("hotkeys"),
+		newListener: newHotkeysListener,
+		actions:     []hotkeyAction{},
+	}
+
+	h.logger.Debug("Created hotkey controller")
+
+	return h
+}
+
+// initialize listens to the configured hotkeys, and follows them through config reloads
+func (h *hotkeyController) initialize() {
+	configReloadedChannel := h.deej.config.SubscribeToChanges()
+
+	h.applyConfig()
+
+	go func() {
+		for range configReloadedChannel {
+			h.applyConfig()
+		}
+	}()
+}
+
+// applyConfig listens to the configured hotkeys once they changed
+func (h *hotkeyController) applyConfig() {
+	actions := h.deej.config.Hotkeys
+
+	h.lock.Lock()
+	unchanged := reflect.DeepEqual(actions, h.actions)
+	h.lock.Unlock()
+
+	if unchanged {
+		return
+	}
+
+	h.Stop()
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.actions = actions
+	if len(actions) == 0 {
+		return
+	}
+
+	hotkeys := make([]hotkey, len(actions))
+	for idx, action := range actions {
+		hotkeys[idx] = action.Hotkey
+	}
+
+	listener, err := h.newListener(h.logger, hotkeys, h.handleHotkey)
+	if err != nil {
+		h.logger.Warnw("Failed to listen to hotkeys", "error", err)
+		h.deej.notifier.Notify("Can't listen to hotkeys!",
+			fmt.Sprintf("Please check the hotkeys section in %s: %s", userConfigFilepath, err))
+
+		return
+	}
+
+	h.listener = listener
+	h.logger.Infow("Listening to hotkeys", "hotkeys", hotkeys)
+}
+
+// Stop stops listening to hotkeys
+func (h *hotkeyController) Stop() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.listener != nil {
+		h.listener.stop()
+		h.listener = nil
+	}
+}
+
+// SubscribeToMuteButtonEvents returns an unbuffered channel that receives the new state of every mute button
+// whose targets a hotkey muted or unmuted
+func (h *hotkeyController) SubscribeToMuteButtonEvents() chan MuteButtonClickEvent {
+	ch := make(chan MuteButtonClickEvent)
+	h.muteButtonEventConsumers = append(h.muteButtonEventConsumers, ch)
+
+	return ch
+}
+
+// SubscribeToOutputDeviceEvents returns a channel that never receives, as hotkeys don't switch output devices
+func (h *hotkeyController) SubscribeToOutputDeviceEvents() chan int {
+	return make(chan int)
+}
+
+// SubscribeToConnectionEvents returns a channel that never receives, as hotkeys don't connect
+func (h *hotkeyController) SubscribeToConnectionEvents() chan ConnectionEvent {
+	return make(chan ConnectionEvent)
+}
+
+// handleHotkey applies a hotkey's action. held keys only keep changing volumes, toggling mute over and over would be no use
+func (h *hotkeyController) handleHotkey(hk hotkey, repeat bool) {
+	h.lock.Lock()
+	actions := h.actions
+	h.lock.Unlock()
+
+	for _, action := range actions {
+		if action.Hotkey != hk || (repeat && action.Action == hotkeyActionMute) {
+			continue
+		}
+
+		h.logger.Debugw("Hotkey pressed", "hotkey", hk, "action", action.Action, "targets", action.Targets)
+
+		var err error
+
+		switch action.Action {
+		case hotkeyActionMute:
+			err = h.toggleMute(action.Targets)
+		case hotkeyActionVolume:
+			err = h.deej.sessions.handleTargetsVolumeStep(action.Targets, action.Step)
+		}
+
+		if err != nil {
+			h.logger.Warnw("Failed to apply hotkey", "hotkey", hk, "error", err)
+		}
+	}
+}
+
+// toggleMute toggles the targets' mute through the session map, and reports every mute button that it flipped
+func (h *hotkeyController) toggleMute(targets []string) error {
+	m := h.deej.sessions
+
+	before := m.muteButtonStates()
+	_, err := m.handleTargetsMuteToggle(targets)
+	after := m.muteButtonStates()
+
+	for buttonIdx := range after {
+		if buttonIdx < len(before) && before[buttonIdx] == after[buttonIdx] {
+			continue
+		}
+
+		event := MuteButtonClickEvent{MuteButtonID: buttonIdx, mute: after[buttonIdx]}
+
+		for _, consumer := range h.muteButtonEventConsumers {
+			consumer <- event
+		}
+	}
+
+	return err
+}
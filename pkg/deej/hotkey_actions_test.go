@@ -0,0 +1,324 @@
+package deej
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// fakeHotkeyListener stands in for the platform's listener, and lets the test press the hotkeys it listens to
+type fakeHotkeyListener struct {
+	hotkeys []hotkey
+	onPress func(hk hotkey, repeat bool)
+	stopped bool
+}
+
+func (l *fakeHotkeyListener) stop() {
+	l.stopped = true
+}
+
+func (l *fakeHotkeyListener) press(t *testing.T, keys string, repeat bool) {
+	hk, err := parseHotkey(keys)
+	if err != nil {
+		t.Fatalf("Failed to parse '%s': %v", keys, err)
+	}
+
+	l.onPress(hk, repeat)
+}
+
+const testHotkeysConfig = `
+hotkeys:
+  - keys: ctrl+alt+m
+    action: mute
+    target: mic
+  - keys: ctrl+alt+up
+    action: volume
+    target: spotify.exe
+  - keys: ctrl+alt+down
+    action: Volume
+    target: [spotify.exe, chrome.exe]
+    step: -30
+`
+
+// TestHotkeyActionsConfig tests reading the hotkeys section, and refusing hotkeys that can't work
+func TestHotkeyActionsConfig(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, testHotkeysConfig)
+	defer cleanup()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	expected := []hotkeyAction{
+		{Hotkey: hotkey{hotkeyModCtrl | hotkeyModAlt, "m"}, Action: hotkeyActionMute, Targets: []string{"mic"}},
+		{Hotkey: hotkey{hotkeyModCtrl | hotkeyModAlt, "up"}, Action: hotkeyActionVolume, Targets: []string{"spotify.exe"}, Step: 0.05},
+		{Hotkey: hotkey{hotkeyModCtrl | hotkeyModAlt, "down"}, Action: hotkeyActionVolume, Targets: []string{"spotify.exe", "chrome.exe"}, Step: -0.3},
+	}
+
+	if !reflect.DeepEqual(deej.config.Hotkeys, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, deej.config.Hotkeys)
+	}
+
+	for _, hotkeys := range []string{
+		"hotkeys: ctrl+alt+m",
+		"hotkeys:\n  - keys: m\n    action: mute\n    target: mic",
+		"hotkeys:\n  - keys: ctrl+m\n    action: mute",
+		"hotkeys:\n  - keys: ctrl+m\n    action: toggle\n    target: mic",
+		"hotkeys:\n  - keys: ctrl+m\n    action: volume\n    target: mic\n    step: 0",
+		"hotkeys:\n  - keys: ctrl+m\n    action: volume\n    target: mic\n    step: 101",
+		"hotkeys:\n  - keys: ctrl+m\n    action: mute\n    target: mic\n  - keys: Ctrl+M\n    action: mute\n    target: master",
+		"layer_hotkey: ctrl+m\nhotkeys:\n  - keys: ctrl+m\n    action: mute\n    target: mic",
+	} {
+		cleanup := createTestConfig(t, hotkeys+"\n")
+
+		if err := deej.config.Load(); err == nil {
+			t.Errorf("'%s': expected an error, got %+v", hotkeys, deej.config.Hotkeys)
+		}
+
+		cleanup()
+	}
+}
+
+// TestHotkeyMatcher tests that key presses complete hotkeys only with exactly their modifiers held, on either side
+func TestHotkeyMatcher(t *testing.T) {
+	hotkeys := []hotkey{}
+	for _, keys := range []string{"ctrl+alt+m", "shift+up", "volumeup"} {
+		hk, _ := parseHotkey(keys)
+		hotkeys = append(hotkeys, hk)
+	}
+
+	matcher := newHotkeyMatcher(hotkeys)
+
+	tests := []struct {
+		key      string
+		state    int
+		expected string
+	}{
+		{"m", hotkeyKeyPressed, ""},
+		{"m", hotkeyKeyReleased, ""},
+		{"leftctrl", hotkeyKeyPressed, ""},
+		{"m", hotkeyKeyPressed, ""},
+		{"rightalt", hotkeyKeyPressed, ""},
+		{"m", hotkeyKeyPressed, "ctrl+alt+m"},
+		{"m", hotkeyKeyRepeated, "ctrl+alt+m"},
+		{"m", hotkeyKeyReleased, ""},
+		{"leftshift", hotkeyKeyPressed, ""},
+		{"m", hotkeyKeyPressed, ""},
+		{"leftctrl", hotkeyKeyReleased, ""},
+		{"rightalt", hotkeyKeyReleased, ""},
+		{"up", hotkeyKeyPressed, "shift+up"},
+		{"volumeup", hotkeyKeyPressed, ""},
+		{"leftshift", hotkeyKeyReleased, ""},
+		{"volumeup", hotkeyKeyPressed, "volumeup"},
+	}
+
+	for idx, test := range tests {
+		hk, matched := matcher.handleKey(test.key, test.state)
+
+		if matched != (test.expected != "") || (matched && hk.String() != test.expected) {
+			t.Errorf("%d: expected '%s' for %s (%d), got %s (%v)", idx, test.expected, test.key, test.state, hk, matched)
+		}
+	}
+}
+
+// TestHotkeyController tests that the configured hotkeys toggle mute and step volumes of their targets,
+// and that they follow config reloads
+func TestHotkeyController(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	mic := newFakeSession(logger, inputSessionName)
+	spotify := newFakeSession(logger, "spotify.exe")
+	chrome := newFakeSession(logger, "chrome.exe")
+	spotify.volume = 0.5
+	chrome.volume = 0.97
+
+	m := newTestSessionMap(t, testHotkeysConfig, &fakeSessionFinder{sessions: []Session{mic, spotify, chrome}})
+	m.deej.sessions = m
+
+	listener := &fakeHotkeyListener{}
+
+	h := newHotkeyController(m.deej, logger)
+	h.newListener = func(logger *zap.SugaredLogger, hotkeys []hotkey, onPress func(hk hotkey, repeat bool)) (hotkeyListener, error) {
+		listener.hotkeys = hotkeys
+		listener.onPress = onPress
+
+		return listener, nil
+	}
+
+	h.applyConfig()
+	defer h.Stop()
+
+	if len(listener.hotkeys) != 3 {
+		t.Fatalf("Expected to listen to 3 hotkeys, got %v", listener.hotkeys)
+	}
+
+	// holding the mute hotkey down doesn't toggle it back and forth
+	listener.press(t, "ctrl+alt+m", false)
+	listener.press(t, "ctrl+alt+m", true)
+
+	if !mic.mute {
+		t.Error("Expected the mic to mute")
+	}
+
+	listener.press(t, "ctrl+alt+m", false)
+
+	if mic.mute {
+		t.Error("Expected the mic to unmute")
+	}
+
+	// holding a volume hotkey down keeps stepping
+	listener.press(t, "ctrl+alt+up", false)
+	listener.press(t, "ctrl+alt+up", true)
+
+	if absFloat(spotify.volume-0.6) > 0.001 {
+		t.Errorf("Expected spotify to be turned up to 0.6, got %f", spotify.volume)
+	}
+
+	listener.press(t, "ctrl+alt+down", false)
+	listener.press(t, "ctrl+alt+down", false)
+
+	if spotify.volume != 0 || absFloat(chrome.volume-0.37) > 0.001 {
+		t.Errorf("Expected both to be turned down, and spotify to stop at 0, got %f and %f", spotify.volume, chrome.volume)
+	}
+
+	// a reload that changes the hotkeys listens again, one that takes all of them away stops listening
+	createTestConfig(t, "hotkeys:\n  - keys: volumemute\n    action: mute\n    target: [spotify.exe, chrome.exe]\n")
+	if err := m.deej.config.Load(); err != nil {
+		t.Fatalf("Failed to reload config: %v", err)
+	}
+
+	previous := listener
+	listener = &fakeHotkeyListener{}
+	h.applyConfig()
+
+	if !previous.stopped || len(listener.hotkeys) != 1 {
+		t.Fatalf("Expected to listen to the new hotkey instead, got %v", listener.hotkeys)
+	}
+
+	listener.press(t, "volumemute", false)
+	listener.press(t, "ctrl+alt+m", false)
+
+	if !spotify.mute || !chrome.mute || mic.mute {
+		t.Errorf("Expected only spotify and chrome to mute, got %v, %v and %v", spotify.mute, chrome.mute, mic.mute)
+	}
+
+	createTestConfig(t, "slider_mapping:\n  0: master\n")
+	if err := m.deej.config.Load(); err != nil {
+		t.Fatalf("Failed to reload config: %v", err)
+	}
+
+	h.applyConfig()
+
+	if !listener.stopped {
+		t.Error("Expected to stop listening without hotkeys")
+	}
+
+	// a listener that fails is notified about
+	h.newListener = func(logger *zap.SugaredLogger, hotkeys []hotkey, onPress func(hk hotkey, repeat bool)) (hotkeyListener, error) {
+		return nil, errors.New("taken")
+	}
+
+	createTestConfig(t, testHotkeysConfig)
+	if err := m.deej.config.Load(); err != nil {
+		t.Fatalf("Failed to reload config: %v", err)
+	}
+
+	h.applyConfig()
+
+	if notified := m.deej.notifier.(*mockNotifier).notified(); !reflect.DeepEqual(notified, []string{"Can't listen to hotkeys!"}) {
+		t.Errorf("Expected a notification about the hotkeys, got %v", notified)
+	}
+}
+
+// stuckSession is a session whose volume and mute can't be changed
+type stuckSession struct {
+	fakeSession
+}
+
+func (s *stuckSession) SetVolume(v float32) error {
+	return errors.New("stuck")
+}
+
+func (s *stuckSession) SetMute(m bool) error {
+	return errors.New("stuck")
+}
+
+// TestHotkeyControllerPipeline tests that hotkeys go through the session map like other controllers: they step
+// along the volume curve of the slider that controls their target, report the mute buttons they flip,
+// and apply to every target even if some fail
+func TestHotkeyControllerPipeline(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	mic := newFakeSession(logger, inputSessionName)
+	spotify := newFakeSession(logger, "spotify.exe")
+	chrome := newFakeSession(logger, "chrome.exe")
+	stuck := &stuckSession{*newFakeSession(logger, "discord.exe")}
+	spotify.volume = 0.2
+	chrome.volume = 0.5
+
+	config := `
+slider_mapping:
+  0: spotify.exe
+slider_curve:
+  0: log
+mute_button_mapping:
+  0: mic
+hotkeys:
+  - keys: ctrl+alt+m
+    action: mute
+    target: [discord.exe, mic]
+  - keys: ctrl+alt+up
+    action: volume
+    target: [discord.exe, spotify.exe, chrome.exe]
+`
+
+	m := newTestSessionMap(t, config, &fakeSessionFinder{sessions: []Session{mic, spotify, chrome, stuck}})
+	m.deej.sessions = m
+
+	listener := &fakeHotkeyListener{}
+
+	h := newHotkeyController(m.deej, logger)
+	h.newListener = func(logger *zap.SugaredLogger, hotkeys []hotkey, onPress func(hk hotkey, repeat bool)) (hotkeyListener, error) {
+		listener.onPress = onPress
+		return listener, nil
+	}
+
+	muteButtonEvents := h.SubscribeToMuteButtonEvents()
+
+	h.applyConfig()
+	defer h.Stop()
+
+	// discord can't be muted, the mic still is, and mute button 0 is reported as muted
+	muteHotkey, _ := parseHotkey("ctrl+alt+m")
+	go listener.onPress(muteHotkey, false)
+
+	select {
+	case event := <-muteButtonEvents:
+		if event.MuteButtonID != 0 || !event.mute {
+			t.Errorf("Expected mute button 0 to be reported as muted, got %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the mute button event")
+	}
+
+	if !mic.mute {
+		t.Error("Expected the mic to mute even though discord failed")
+	}
+
+	// spotify is on a log slider, so it goes where moving that slider up by 5% would take it. chrome isn't on a slider
+	listener.press(t, "ctrl+alt+up", false)
+
+	if absFloat(spotify.volume-0.3) > 0.001 {
+		t.Errorf("Expected spotify to be stepped along the log curve to 0.30, got %.2f", spotify.volume)
+	}
+
+	if absFloat(chrome.volume-0.55) > 0.001 {
+		t.Errorf("Expected chrome to be turned up to 0.55, got %.2f", chrome.volume)
+	}
+}
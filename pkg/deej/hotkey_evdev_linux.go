@@ -0,0 +1,184 @@
+package deej
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	evdevDevicePattern = "/dev/input/event*"
+
+	// the type of key events, see linux/input-event-codes.h
+	evdevTypeKey = 0x01
+
+	// keyboards that are plugged in later are picked up this often
+	evdevRescanInterval = 5 * time.Second
+)
+
+// the names of the evdev key codes that hotkeys can use. codes are by the key's position on a US layout
+var evdevKeyNames = map[uint16]string{
+	2: "1", 3: "2", 4: "3", 5: "4", 6: "5", 7: "6", 8: "7", 9: "8", 10: "9", 11: "0",
+	16: "q", 17: "w", 18: "e", 19: "r", 20: "t", 21: "y", 22: "u", 23: "i", 24: "o", 25: "p",
+	30: "a", 31: "s", 32: "d", 33: "f", 34: "g", 35: "h", 36: "j", 37: "k", 38: "l",
+	44: "z", 45: "x", 46: "c", 47: "v", 48: "b", 49: "n", 50: "m",
+	59: "f1", 60: "f2", 61: "f3", 62: "f4", 63: "f5", 64: "f6", 65: "f7", 66: "f8", 67: "f9", 68: "f10",
+	87: "f11", 88: "f12",
+	103: "up", 105: "left", 106: "right", 108: "down",
+	113: "volumemute", 114: "volumedown", 115: "volumeup",
+	29: "leftctrl", 97: "rightctrl",
+	56: "leftalt", 100: "rightalt",
+	42: "leftshift", 54: "rightshift",
+	125: "leftmeta", 126: "rightmeta",
+}
+
+// evdevEvent is struct input_event
+type evdevEvent struct {
+	Time  syscall.Timeval
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
+// evdevHotkeyListener reads key events from every input device that deej may read. this works under wayland
+// and without a display, but doesn't take the keys away from other apps
+type evdevHotkeyListener struct {
+	logger  *zap.SugaredLogger
+	onPress func(hk hotkey, repeat bool)
+
+	// every device feeds the same matcher, so that modifiers on one keyboard count for keys on another
+	matcher *hotkeyMatcher
+	devices map[string]*os.File
+	stopped bool
+	lock    sync.Mutex
+
+	stopChannel chan bool
+}
+
+func newHotkeysListener(logger *zap.SugaredLogger, hotkeys []hotkey, onPress func(hk hotkey, repeat bool)) (hotkeyListener, error) {
+	l := &evdevHotkeyListener{
+		logger:      logger.Named("evdev"),
+		onPress:     onPress,
+		matcher:     newHotkeyMatcher(hotkeys),
+		devices:     map[string]*os.File{},
+		stopChannel: make(chan bool),
+	}
+
+	if l.openDevices() == 0 {
+		return nil, errors.New("can't read any device in /dev/input, deej's user has to be in the input group")
+	}
+
+	go func() {
+		ticker := time.NewTicker(evdevRescanInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-l.stopChannel:
+				return
+			case <-ticker.C:
+				l.openDevices()
+			}
+		}
+	}()
+
+	return l, nil
+}
+
+// openDevices starts reading from the devices that aren't open yet, and returns how many are open
+func (l *evdevHotkeyListener) openDevices() int {
+	devicePaths, _ := filepath.Glob(evdevDevicePattern)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.stopped {
+		return 0
+	}
+
+	for _, devicePath := range devicePaths {
+		if _, open := l.devices[devicePath]; open {
+			continue
+		}
+
+		// devices that deej can't read are left alone, they might not be keyboards anyway
+		device, err := os.Open(devicePath)
+		if err != nil {
+			continue
+		}
+
+		l.logger.Debugw("Reading input device", "path", devicePath)
+		l.devices[devicePath] = device
+
+		go l.readDevice(devicePath, device)
+	}
+
+	return len(l.devices)
+}
+
+func (l *evdevHotkeyListener) readDevice(devicePath string, device *os.File) {
+	err := readEvdevKeys(device, func(code uint16, state int) {
+		key, ok := evdevKeyNames[code]
+		if !ok {
+			return
+		}
+
+		l.lock.Lock()
+		hk, matched := l.matcher.handleKey(key, state)
+		l.lock.Unlock()
+
+		if matched {
+			l.onPress(hk, state == hotkeyKeyRepeated)
+		}
+	})
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// a device that was unplugged is opened again if it comes back, but one that's closed on purpose isn't
+	if l.devices[devicePath] == device {
+		l.logger.Debugw("Stopped reading input device", "path", devicePath, "error", err)
+		delete(l.devices, devicePath)
+		device.Close()
+	}
+}
+
+// stop closes every device, which ends their reads
+func (l *evdevHotkeyListener) stop() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.stopped {
+		return
+	}
+
+	l.stopped = true
+	close(l.stopChannel)
+
+	for devicePath, device := range l.devices {
+		delete(l.devices, devicePath)
+		device.Close()
+	}
+}
+
+// readEvdevKeys reads input events until the reader fails, and passes the key events on.
+// events come in the host's byte order, which is little-endian on everything deej runs on
+func readEvdevKeys(reader io.Reader, onKey func(code uint16, state int)) error {
+	for {
+		var event evdevEvent
+		if err := binary.Read(reader, binary.LittleEndian, &event); err != nil {
+			return err
+		}
+
+		if event.Type == evdevTypeKey {
+			onKey(event.Code, int(event.Value))
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package deej
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// TestReadEvdevKeys tests that only key events are passed on, and that reading ends with the reader
+func TestReadEvdevKeys(t *testing.T) {
+	events := []evdevEvent{
+		{Type: evdevTypeKey, Code: 29, Value: hotkeyKeyPressed},
+		{Type: 0x04, Code: 0x04, Value: 29},
+		{Type: evdevTypeKey, Code: 103, Value: hotkeyKeyPressed},
+		{Type: 0x00},
+		{Type: evdevTypeKey, Code: 103, Value: hotkeyKeyRepeated},
+		{Type: evdevTypeKey, Code: 29, Value: hotkeyKeyReleased},
+	}
+
+	var buffer bytes.Buffer
+	for _, event := range events {
+		if err := binary.Write(&buffer, binary.LittleEndian, event); err != nil {
+			t.Fatalf("Failed to write event: %v", err)
+		}
+	}
+
+	type key struct {
+		code  uint16
+		state int
+	}
+
+	keys := []key{}
+	err := readEvdevKeys(&buffer, func(code uint16, state int) {
+		keys = append(keys, key{code, state})
+	})
+
+	expected := []key{{29, hotkeyKeyPressed}, {103, hotkeyKeyPressed}, {103, hotkeyKeyRepeated}, {29, hotkeyKeyReleased}}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected %v, got %v", expected, keys)
+	}
+
+	if err != io.EOF {
+		t.Errorf("Expected to stop at the end of the reader, got %v", err)
+	}
+
+	if evdevKeyNames[29] != "leftctrl" || evdevKeyNames[103] != "up" {
+		t.Error("Expected the codes to be left ctrl and up")
+	}
+}
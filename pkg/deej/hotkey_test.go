@@ -0,0 +1,56 @@
+package deej
+
+import (
+	"testing"
+)
+
+// TestParseHotkey tests parsing key combinations into their canonical form
+func TestParseHotkey(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+		fails    bool
+	}{
+		{"ctrl+alt+l", "ctrl+alt+l", false},
+		{"Alt + Ctrl + L", "ctrl+alt+l", false},
+		{"control+shift+5", "ctrl+shift+5", false},
+		{"win+f12", "super+f12", false},
+		{"super+shift+alt+ctrl+f1", "ctrl+alt+shift+super+f1", false},
+		{"ctrl+alt+Up", "ctrl+alt+up", false},
+		{"VolumeUp", "volumeup", false},
+		{"shift+volumemute", "shift+volumemute", false},
+		{"up", "", true},
+		{"ctrl+pageup", "", true},
+		{"l", "", true},
+		{"f5", "", true},
+		{"ctrl+", "", true},
+		{"ctrl+alt", "", true},
+		{"ctrl+ctrl+l", "", true},
+		{"hyper+l", "", true},
+		{"ctrl+f13", "", true},
+		{"ctrl+f0", "", true},
+		{"ctrl+space", "", true},
+		{"ctrl+l+k", "", true},
+	}
+
+	for _, test := range tests {
+		hk, err := parseHotkey(test.value)
+
+		if test.fails {
+			if err == nil {
+				t.Errorf("Expected an error for '%s', got %s", test.value, hk)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Failed to parse '%s': %v", test.value, err)
+			continue
+		}
+
+		if hk.String() != test.expected {
+			t.Errorf("Expected '%s' for '%s', got '%s'", test.expected, test.value, hk)
+		}
+	}
+}
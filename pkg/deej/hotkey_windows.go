@@ -0,0 +1,157 @@
+package deej
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+
+	"github.com/lxn/win"
+	"go.uber.org/zap"
+)
+
+const (
+	hotkeyID = 1
+
+	modAlt      = 0x0001
+	modControl  = 0x0002
+	modShift    = 0x0004
+	modWin      = 0x0008
+	modNoRepeat = 0x4000
+
+	vkF1 = 0x70
+)
+
+// the virtual-key codes of named keys
+var namedVirtualKeys = map[string]uint32{
+	"left":       0x25,
+	"up":         0x26,
+	"right":      0x27,
+	"down":       0x28,
+	"volumemute": 0xAD,
+	"volumedown": 0xAE,
+	"volumeup":   0xAF,
+}
+
+var (
+	user32                 = syscall.NewLazyDLL("user32.dll")
+	procRegisterHotKey     = user32.NewProc("RegisterHotKey")
+	procUnregisterHotKey   = user32.NewProc("UnregisterHotKey")
+	procPostThreadMessageW = user32.NewProc("PostThreadMessageW")
+)
+
+// wmHotkeyListener registers a hotkey on a thread of its own, and waits for its WM_HOTKEY messages there
+type wmHotkeyListener struct {
+	logger   *zap.SugaredLogger
+	threadID uint32
+}
+
+// newHotkeysListener registers every hotkey with a listener of its own. windows doesn't repeat them while they're held
+func newHotkeysListener(logger *zap.SugaredLogger, hotkeys []hotkey, onPress func(hk hotkey, repeat bool)) (hotkeyListener, error) {
+	listeners := wmHotkeyListeners{}
+
+	for _, hk := range hotkeys {
+		hk := hk
+
+		listener, err := registerWMHotkey(logger, hk, func() { onPress(hk, false) })
+		if err != nil {
+			listeners.stop()
+			return nil, err
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
+
+func registerWMHotkey(logger *zap.SugaredLogger, hk hotkey, onPress func()) (*wmHotkeyListener, error) {
+	logger = logger.Named("hotkey")
+
+	l := &wmHotkeyListener{logger: logger}
+	registered := make(chan error)
+
+	go func() {
+
+		// hotkey messages go to the thread that registered the hotkey
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		l.threadID = win.GetCurrentThreadId()
+
+		result, _, err := procRegisterHotKey.Call(0, hotkeyID, uintptr(hotkeyWindowsModifiers(hk)|modNoRepeat),
+			uintptr(hotkeyVirtualKey(hk)))
+		if result == 0 {
+			registered <- fmt.Errorf("register hotkey %s: %w", hk, err)
+			return
+		}
+
+		defer procUnregisterHotKey.Call(0, hotkeyID)
+
+		registered <- nil
+
+		var msg win.MSG
+		for win.GetMessage(&msg, 0, 0, 0) > 0 {
+			if msg.Message == win.WM_HOTKEY && msg.WParam == hotkeyID {
+				logger.Debugw("Hotkey pressed", "hotkey", hk)
+				onPress()
+			}
+		}
+
+		logger.Debugw("Stopped listening to hotkey", "hotkey", hk)
+	}()
+
+	if err := <-registered; err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+type wmHotkeyListeners []hotkeyListener
+
+func (l wmHotkeyListeners) stop() {
+	for _, listener := range l {
+		listener.stop()
+	}
+}
+
+// stop ends the listener's message loop, which unregisters the hotkey on its way out
+func (l *wmHotkeyListener) stop() {
+	procPostThreadMessageW.Call(uintptr(l.threadID), win.WM_QUIT, 0, 0)
+}
+
+func hotkeyWindowsModifiers(hk hotkey) uint32 {
+	modifiers := uint32(0)
+
+	for modifier, windowsModifier := range map[hotkeyModifiers]uint32{
+		hotkeyModCtrl:  modControl,
+		hotkeyModAlt:   modAlt,
+		hotkeyModShift: modShift,
+		hotkeyModSuper: modWin,
+	} {
+		if hk.modifiers&modifier != 0 {
+			modifiers |= windowsModifier
+		}
+	}
+
+	return modifiers
+}
+
+// hotkeyVirtualKey returns the virtual-key code of the hotkey's key. letters and digits
+// share their codes with their uppercase ASCII characters
+func hotkeyVirtualKey(hk hotkey) uint32 {
+	if functionKey, err := hotkeyFunctionKey(hk.key); err == nil {
+		return vkF1 + uint32(functionKey-1)
+	}
+
+	if virtualKey, ok := namedVirtualKeys[hk.key]; ok {
+		return virtualKey
+	}
+
+	key := hk.key[0]
+	if key >= 'a' && key <= 'z' {
+		key -= 'a' - 'A'
+	}
+
+	return uint32(key)
+}
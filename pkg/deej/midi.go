@@ -0,0 +1,749 @@
+package deej
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/tomerhh/deej/pkg/deej/util"
+)
+
+// MIDI controllers (i.e. a nanoKONTROL) can join or stand in for the hardware one: control change messages move
+// sliders, and notes click mute buttons and switch output devices. buttons with LEDs light up while their targets
+// are muted, or while their output device is the default. in learn mode, controls that aren't mapped yet are
+// mapped to the first free slider or mute button as they're touched, and saved to the internal config
+const (
+	midiStatusNoteOff         = 0x80
+	midiStatusNoteOn          = 0x90
+	midiStatusControlChange   = 0xB0
+	midiStatusProgramChange   = 0xC0
+	midiStatusChannelPressure = 0xD0
+	midiStatusSystem          = 0xF0
+	midiStatusRealTime        = 0xF8
+
+	midiMaxValue   = 127
+	midiMaxChannel = 16
+
+	// how often a controller that's unplugged (or never was plugged in) is looked for again
+	midiReconnectInterval = 5 * time.Second
+
+	midiReadBufferSize = 256
+)
+
+// midiConfig holds every config value of the MIDI controller. control numbers are mapped by slider, mute button
+// and output device index, and include the ones that learn mode saved. channel 0 listens on every channel
+type midiConfig struct {
+	Enabled bool
+	Input   string
+	Output  string
+	Channel int
+	Learn   bool
+
+	Sliders       map[int]int
+	MuteButtons   map[int]int
+	OutputDevices map[int]int
+}
+
+// midiMessage is a single channel message. status doesn't include the channel, which counts from 0
+type midiMessage struct {
+	status  byte
+	channel byte
+	data1   byte
+	data2   byte
+}
+
+// bytes encodes a message with two data bytes, which is every message deej sends
+func (m midiMessage) bytes() []byte {
+	return []byte{m.status | m.channel, m.data1, m.data2}
+}
+
+// midiParser turns a stream of MIDI bytes into channel messages, including ones that rely on running status.
+// system messages (i.e. sysex dumps and clock ticks) are skipped
+type midiParser struct {
+	status byte
+	data   []byte
+}
+
+// parse takes the next byte, and returns a message if it completed one
+func (p *midiParser) parse(b byte) (midiMessage, bool) {
+	switch {
+
+	// real-time messages can show up anywhere, even between another message's bytes
+	case b >= midiStatusRealTime:
+		return midiMessage{}, false
+
+	// everything up to the next status byte belongs to the system message
+	case b >= midiStatusSystem:
+		p.status = 0
+		p.data = p.data[:0]
+		return midiMessage{}, false
+
+	case b&0x80 != 0:
+		p.status = b
+		p.data = p.data[:0]
+		return midiMessage{}, false
+
+	case p.status == 0:
+		return midiMessage{}, false
+	}
+
+	p.data = append(p.data, b)
+
+	length := 2
+	if status := p.status & 0xF0; status == midiStatusProgramChange || status == midiStatusChannelPressure {
+		length = 1
+	}
+
+	if len(p.data) < length {
+		return midiMessage{}, false
+	}
+
+	message := midiMessage{status: p.status & 0xF0, channel: p.status & 0x0F, data1: p.data[0]}
+	if length == 2 {
+		message.data2 = p.data[1]
+	}
+
+	// the status stays, so that the next data bytes make another message of the same kind
+	p.data = p.data[:0]
+
+	return message, true
+}
+
+// midiBackend opens MIDI ports through the OS, see midi_linux.go and midi_windows.go
+type midiBackend interface {
+
+	// ports returns the names of every input and output port
+	ports() (inputs []string, outputs []string, err error)
+
+	// openInput and openOutput open a port by one of the names that ports returned
+	openInput(name string) (io.ReadCloser, error)
+	openOutput(name string) (io.WriteCloser, error)
+}
+
+// findMIDIPort returns the first port whose name contains the given one, ignoring case.
+// an empty name picks the first port there is
+func findMIDIPort(ports []string, name string) (string, bool) {
+	for _, port := range ports {
+		if strings.Contains(strings.ToLower(port), strings.ToLower(name)) {
+			return port, true
+		}
+	}
+
+	return "", false
+}
+
+// midiController reads a MIDI input port whenever it's enabled, and lights up its buttons on the matching output
+// port if there is one. it's a slider and buttons controller, same as SerialIO, and follows its config through
+// reloads and the controller through being unplugged
+type midiController struct {
+	deej    *Deej
+	logger  *zap.SugaredLogger
+	backend midiBackend
+
+	config    midiConfig
+	input     io.ReadCloser
+	output    io.WriteCloser
+	inputName string
+	lock      sync.Mutex
+
+	muteButtonsConsumer        MuteButtonConsumer
+	toggleOutputDeviceConsumer ToggleOutputDeviceConsumer
+
+	sliderMoveConsumers        []chan SliderMoveEvent
+	muteButtonEventConsumers   []chan MuteButtonClickEvent
+	outputDeviceEventConsumers []chan int
+	connectionEventConsumers   []chan ConnectionEvent
+
+	// the last value of every slider, every mute button's state as deej last saw it and the LEDs as they were
+	// last sent, by note
+	sliderValues map[int]float32
+	muteStates   map[int]bool
+	leds         map[byte]bool
+}
+
+func newMIDIController(deej *Deej, logger *zap.SugaredLogger, backend midiBackend) *midiController {
+	logger = logger.Named("midi")
+
+	m := &midiController{
+		deej:         deej,
+		logger:       logger,
+		backend:      backend,
+		sliderValues: map[int]float32{},
+		muteStates:   map[int]bool{},
+		leds:         map[byte]bool{},
+	}
+
+	logger.Debug("Created MIDI controller instance")
+
+	return m
+}
+
+// initialize opens the controller if MIDI is enabled, and keeps looking for it and following config reloads
+func (m *midiController) initialize() {
+	configReloadedChannel := m.deej.config.SubscribeToChanges()
+
+	m.applyConfig()
+
+	go func() {
+		ticker := time.NewTicker(midiReconnectInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				m.reconnect()
+			case <-configReloadedChannel:
+				m.applyConfig()
+			}
+		}
+	}()
+}
+
+// applyConfig reopens the controller when its config changed
+func (m *midiController) applyConfig() {
+	config := m.deej.config.MIDI
+
+	m.lock.Lock()
+	unchanged := reflect.DeepEqual(config, m.config)
+	m.lock.Unlock()
+
+	if unchanged {
+		return
+	}
+
+	m.Stop()
+
+	m.lock.Lock()
+	m.config = config
+	m.lock.Unlock()
+
+	if !config.Enabled {
+		return
+	}
+
+	if err := m.Start(); err != nil {
+		m.logger.Warnw("Failed to open MIDI controller", "input", config.Input, "error", err)
+		m.deej.notifier.Notify("Can't open the MIDI controller!",
+			fmt.Sprintf("deej will keep looking for it. Please check the midi section in %s: %s", userConfigFilepath, err))
+	}
+}
+
+// reconnect opens the controller again if it's enabled but isn't open
+func (m *midiController) reconnect() {
+	m.lock.Lock()
+	disconnected := m.config.Enabled && m.input == nil
+	m.lock.Unlock()
+
+	if !disconnected {
+		return
+	}
+
+	if err := m.Start(); err != nil {
+		m.logger.Debugw("MIDI controller still unavailable", "error", err)
+	}
+}
+
+// Start opens the configured input port and begins reading it. LED feedback goes to the output port with
+// the configured name, or the input's name if there's none, and is skipped if no such port exists
+func (m *midiController) Start() error {
+	m.lock.Lock()
+	config := m.config
+	opened := m.input != nil
+	m.lock.Unlock()
+
+	if opened {
+		return nil
+	}
+
+	inputs, outputs, err := m.backend.ports()
+	if err != nil {
+		return fmt.Errorf("list MIDI ports: %w", err)
+	}
+
+	inputName, ok := findMIDIPort(inputs, config.Input)
+	if !ok {
+		return fmt.Errorf("no MIDI input matches '%s' (found %v)", config.Input, inputs)
+	}
+
+	input, err := m.backend.openInput(inputName)
+	if err != nil {
+		return fmt.Errorf("open MIDI input %s: %w", inputName, err)
+	}
+
+	outputName := config.Output
+	if outputName == "" {
+		outputName = config.Input
+	}
+
+	var output io.WriteCloser
+
+	if name, ok := findMIDIPort(outputs, outputName); !ok {
+		m.logger.Infow("No MIDI output for LED feedback", "output", outputName)
+	} else if output, err = m.backend.openOutput(name); err != nil {
+		m.logger.Warnw("Failed to open MIDI output, skipping LED feedback", "output", name, "error", err)
+		output = nil
+	}
+
+	m.lock.Lock()
+	m.input = input
+	m.output = output
+	m.inputName = inputName
+
+	// the controller's LEDs are in whatever state it starts in, so every one of them is sent again
+	m.leds = map[byte]bool{}
+	m.lock.Unlock()
+
+	go m.readLoop(input)
+
+	m.logger.Infow("Opened MIDI controller", "input", inputName, "feedback", output != nil)
+	m.broadcastConnection(true, inputName)
+
+	return nil
+}
+
+// Stop closes the controller's ports
+func (m *midiController) Stop() {
+	m.lock.Lock()
+	input, output, inputName := m.input, m.output, m.inputName
+	m.input = nil
+	m.output = nil
+	m.lock.Unlock()
+
+	if input == nil {
+		return
+	}
+
+	m.closePorts(input, output)
+
+	m.logger.Debug("Closed MIDI controller")
+	m.broadcastConnection(false, inputName)
+}
+
+func (m *midiController) closePorts(input io.ReadCloser, output io.WriteCloser) {
+	input.Close()
+
+	if output != nil {
+		output.Close()
+	}
+}
+
+// SubscribeToSliderMoveEvents returns an unbuffered channel that receives
+// a sliderMoveEvent struct every time a control change moves a slider
+func (m *midiController) SubscribeToSliderMoveEvents() chan SliderMoveEvent {
+	ch := make(chan SliderMoveEvent)
+	m.sliderMoveConsumers = append(m.sliderMoveConsumers, ch)
+
+	return ch
+}
+
+// SubscribeToMuteButtonEvents returns an unbuffered channel that receives
+// mute button clicks once they were handled
+func (m *midiController) SubscribeToMuteButtonEvents() chan MuteButtonClickEvent {
+	ch := make(chan MuteButtonClickEvent)
+	m.muteButtonEventConsumers = append(m.muteButtonEventConsumers, ch)
+
+	return ch
+}
+
+// SubscribeToOutputDeviceEvents returns an unbuffered channel that receives the index of
+// the output device that's the default after the controller switched it
+func (m *midiController) SubscribeToOutputDeviceEvents() chan int {
+	ch := make(chan int)
+	m.outputDeviceEventConsumers = append(m.outputDeviceEventConsumers, ch)
+
+	return ch
+}
+
+// SubscribeToConnectionEvents returns an unbuffered channel that receives
+// an event whenever the controller is opened or closed
+func (m *midiController) SubscribeToConnectionEvents() chan ConnectionEvent {
+	ch := make(chan ConnectionEvent)
+	m.connectionEventConsumers = append(m.connectionEventConsumers, ch)
+
+	return ch
+}
+
+func (m *midiController) setMuteButtonClickEventConsumer(consumer MuteButtonConsumer) {
+	m.muteButtonsConsumer = consumer
+}
+
+func (m *midiController) setToggleOutputDeviceEventConsumer(consumer ToggleOutputDeviceConsumer) {
+	m.toggleOutputDeviceConsumer = consumer
+}
+
+func (m *midiController) readLoop(input io.ReadCloser) {
+	parser := &midiParser{}
+	buffer := make([]byte, midiReadBufferSize)
+
+	for {
+		n, err := input.Read(buffer)
+
+		for _, b := range buffer[:n] {
+			if message, ok := parser.parse(b); ok {
+				m.handleMessage(message)
+			}
+		}
+
+		if err == nil {
+			continue
+		}
+
+		// closing the input is how the controller stops, anything else means it's gone (i.e. unplugged)
+		m.lock.Lock()
+		failed := m.input == input
+		output, inputName := m.output, m.inputName
+		if failed {
+			m.input = nil
+			m.output = nil
+		}
+		m.lock.Unlock()
+
+		if failed {
+			m.logger.Warnw("MIDI controller disconnected", "input", inputName, "error", err)
+			m.closePorts(input, output)
+			m.broadcastConnection(false, inputName)
+		}
+
+		return
+	}
+}
+
+// handleMessage turns control changes into slider moves and notes into button clicks, on the configured channel
+func (m *midiController) handleMessage(message midiMessage) {
+	m.lock.Lock()
+	config := m.config
+	m.lock.Unlock()
+
+	if m.deej.Verbose() {
+		m.logger.Debugw("Got MIDI message", "message", message)
+	}
+
+	if config.Channel != 0 && int(message.channel) != config.Channel-1 {
+		return
+	}
+
+	switch message.status {
+	case midiStatusControlChange:
+		m.handleControlChange(config, message.data1, message.data2)
+
+	// a note on without velocity is a note off, and buttons only do something when they're pressed
+	case midiStatusNoteOn:
+		if message.data2 > 0 {
+			m.handleNote(config, message.data1)
+		}
+	}
+}
+
+func (m *midiController) handleControlChange(config midiConfig, number byte, value byte) {
+	sliderIdxs := midiMappedIndexes(config.Sliders, number)
+
+	if len(sliderIdxs) == 0 && config.Learn {
+		sliderIdxs = []int{m.learn(configKeyMIDISliders, number, "CC %d now moves slider %d")}
+	}
+
+	percentValue := util.NormalizeScalar(float32(value) / midiMaxValue)
+
+	for _, sliderIdx := range sliderIdxs {
+		m.lock.Lock()
+		previous, moved := m.sliderValues[sliderIdx]
+		m.sliderValues[sliderIdx] = percentValue
+		m.lock.Unlock()
+
+		if moved && previous == percentValue {
+			continue
+		}
+
+		event := SliderMoveEvent{
+			SliderID:     sliderIdx,
+			PercentValue: percentValue,
+		}
+
+		for _, consumer := range m.sliderMoveConsumers {
+			consumer <- event
+		}
+	}
+}
+
+func (m *midiController) handleNote(config midiConfig, note byte) {
+	buttonIdxs := midiMappedIndexes(config.MuteButtons, note)
+	deviceIdxs := midiMappedIndexes(config.OutputDevices, note)
+
+	if len(buttonIdxs) == 0 && len(deviceIdxs) == 0 && config.Learn {
+		buttonIdxs = []int{m.learn(configKeyMIDIMuteButtons, note, "Note %d now clicks mute button %d")}
+	}
+
+	for _, buttonIdx := range buttonIdxs {
+		m.clickMuteButton(buttonIdx)
+	}
+
+	for _, deviceIdx := range deviceIdxs {
+		m.switchOutputDevice(deviceIdx)
+	}
+}
+
+// clickMuteButton flips the button's state, which MIDI buttons don't keep themselves
+func (m *midiController) clickMuteButton(buttonIdx int) {
+	if m.muteButtonsConsumer == nil {
+		m.logger.Warn("No mute button consumer registered")
+		return
+	}
+
+	m.lock.Lock()
+	event := MuteButtonClickEvent{
+		MuteButtonID: buttonIdx,
+		mute:         !m.muteStates[buttonIdx],
+	}
+	m.lock.Unlock()
+
+	state, err := m.muteButtonsConsumer([]MuteButtonClickEvent{event})
+	if err != nil {
+		m.logger.Warnw("Failed to handle mute button", "event", event, "error", err)
+		return
+	}
+
+	if len(state.MuteButtons) > 0 {
+		event.mute = state.MuteButtons[0]
+	}
+
+	m.lock.Lock()
+	m.muteStates[buttonIdx] = event.mute
+	m.lock.Unlock()
+
+	for _, consumer := range m.muteButtonEventConsumers {
+		consumer <- event
+	}
+}
+
+func (m *midiController) switchOutputDevice(deviceIdx int) {
+	if m.toggleOutputDeviceConsumer == nil {
+		m.logger.Warn("No toggle output device consumer registered")
+		return
+	}
+
+	state, err := m.toggleOutputDeviceConsumer(ToggleOutoutDeviceClickEvent{selectedOutputDevice: deviceIdx})
+	if err != nil {
+		m.logger.Warnw("Failed to switch output device", "index", deviceIdx, "error", err)
+		return
+	}
+
+	for _, consumer := range m.outputDeviceEventConsumers {
+		consumer <- state.selectedOutputDevice
+	}
+}
+
+// learn maps a control to the first index under key that nothing is mapped to yet, and saves it to the
+// internal config. it's mapped for as long as deej runs even if saving it fails
+func (m *midiController) learn(key string, number byte, description string) int {
+	m.lock.Lock()
+
+	mapping := m.config.Sliders
+	if key == configKeyMIDIMuteButtons {
+		mapping = m.config.MuteButtons
+	}
+
+	idx := 0
+	for {
+		if _, taken := mapping[idx]; !taken {
+			break
+		}
+
+		idx++
+	}
+
+	// the config's own mapping is left alone, so that reloads still tell what changed
+	learned := map[int]int{idx: int(number)}
+	for otherIdx, otherNumber := range mapping {
+		learned[otherIdx] = otherNumber
+	}
+
+	if key == configKeyMIDIMuteButtons {
+		m.config.MuteButtons = learned
+	} else {
+		m.config.Sliders = learned
+	}
+
+	m.lock.Unlock()
+
+	message := fmt.Sprintf(description, number, idx)
+	m.logger.Infow("Learned MIDI control", "mapping", message)
+
+	if _, err := m.deej.config.saveMIDIMapping(key, idx, int(number)); err != nil {
+		m.logger.Warnw("Failed to save learned MIDI control", "error", err)
+		m.deej.notifier.Notify("Learned a MIDI control, but couldn't save it", message)
+	} else {
+		m.deej.notifier.Notify("Learned a MIDI control", message)
+	}
+
+	return idx
+}
+
+// pushState lights up the LEDs of muted mute buttons and of the default output device's button
+func (m *midiController) pushState(getState func() ControllerState) {
+	m.lock.Lock()
+	connected := m.input != nil
+	m.lock.Unlock()
+
+	if !connected {
+		return
+	}
+
+	// this goes through the session map, so don't hold up the read loop while it does
+	state := getState()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	// mute states also change outside deej, and the next click should flip the current one
+	for buttonIdx, muted := range state.MuteButtons {
+		m.muteStates[buttonIdx] = muted
+	}
+
+	if m.output == nil {
+		return
+	}
+
+	// more than one thing can share a note, which lights up if any of them wants it to
+	leds := map[byte]bool{}
+
+	for buttonIdx, note := range m.config.MuteButtons {
+		leds[byte(note)] = leds[byte(note)] || (buttonIdx < len(state.MuteButtons) && state.MuteButtons[buttonIdx])
+	}
+
+	for deviceIdx, note := range m.config.OutputDevices {
+		leds[byte(note)] = leds[byte(note)] || deviceIdx == state.OutputDevice
+	}
+
+	notes := make([]int, 0, len(leds))
+	for note := range leds {
+		notes = append(notes, int(note))
+	}
+
+	sort.Ints(notes)
+
+	channel := byte(0)
+	if m.config.Channel != 0 {
+		channel = byte(m.config.Channel - 1)
+	}
+
+	for _, note := range notes {
+		on := leds[byte(note)]
+		if sent, ok := m.leds[byte(note)]; ok && sent == on {
+			continue
+		}
+
+		message := midiMessage{status: midiStatusNoteOn, channel: channel, data1: byte(note)}
+		if on {
+			message.data2 = midiMaxValue
+		}
+
+		if _, err := m.output.Write(message.bytes()); err != nil {
+			m.logger.Warnw("Failed to send MIDI LED feedback", "error", err)
+			return
+		}
+
+		m.leds[byte(note)] = on
+	}
+}
+
+// broadcastConnection lets consumers know that the controller was opened or closed
+func (m *midiController) broadcastConnection(connected bool, inputName string) {
+	event := ConnectionEvent{Connected: connected, Transport: "midi://" + inputName}
+
+	for _, consumer := range m.connectionEventConsumers {
+		consumer <- event
+	}
+}
+
+// midiMappedIndexes returns the indexes that a control number is mapped to, in order
+func midiMappedIndexes(mapping map[int]int, number byte) []int {
+	idxs := []int{}
+
+	for idx, mappedNumber := range mapping {
+		if mappedNumber == int(number) {
+			idxs = append(idxs, idx)
+		}
+	}
+
+	sort.Ints(idxs)
+
+	return idxs
+}
+
+func (cc *CanonicalConfig) midiConfigFromConfig() (midiConfig, error) {
+	config := midiConfig{
+		Enabled: cc.userConfig.GetBool(configKeyMIDIEnabled),
+		Input:   cc.userConfig.GetString(configKeyMIDIInput),
+		Output:  cc.userConfig.GetString(configKeyMIDIOutput),
+		Channel: cc.userConfig.GetInt(configKeyMIDIChannel),
+		Learn:   cc.userConfig.GetBool(configKeyMIDILearn),
+	}
+
+	if !config.Enabled {
+		return config, nil
+	}
+
+	if config.Channel < 0 || config.Channel > midiMaxChannel {
+		return midiConfig{}, fmt.Errorf("channel has to be between 1 and %d, or 0 for every channel", midiMaxChannel)
+	}
+
+	for key, mapping := range map[string]*map[int]int{
+		configKeyMIDISliders:       &config.Sliders,
+		configKeyMIDIMuteButtons:   &config.MuteButtons,
+		configKeyMIDIOutputDevices: &config.OutputDevices,
+	} {
+		result, err := midiMappingFromConfigs(cc.userConfig, cc.internalConfig, key)
+		if err != nil {
+			return midiConfig{}, fmt.Errorf("%s: %w", key, err)
+		}
+
+		*mapping = result
+	}
+
+	return config, nil
+}
+
+// midiMappingFromConfigs reads the control numbers mapped by index under key. learned ones come from the
+// internal config, and the user config wins where both map the same index
+func midiMappingFromConfigs(userConfig *viper.Viper, internalConfig *viper.Viper, key string) (map[int]int, error) {
+	mapping := map[int]int{}
+
+	for _, config := range []*viper.Viper{internalConfig, userConfig} {
+		for idxString, value := range config.GetStringMap(key) {
+			idx, err := strconv.Atoi(idxString)
+			if err != nil || idx < 0 {
+				return nil, fmt.Errorf("invalid index '%s'", idxString)
+			}
+
+			number, ok := value.(int)
+			if !ok || number < 0 || number > midiMaxValue {
+				return nil, fmt.Errorf("index %d: %v isn't a number between 0 and %d", idx, value, midiMaxValue)
+			}
+
+			mapping[idx] = number
+		}
+	}
+
+	return mapping, nil
+}
+
+// saveMIDIMapping records a learned control in the internal config, which the next load merges in
+func (cc *CanonicalConfig) saveMIDIMapping(key string, idx int, number int) (string, error) {
+	cc.internalConfig.Set(fmt.Sprintf("%s.%d", key, idx), number)
+
+	filepath, err := cc.writeInternalConfig()
+	if err != nil {
+		return "", err
+	}
+
+	cc.logger.Infow("Saved learned MIDI control", "path", filepath, "key", key, "index", idx, "number", number)
+
+	return filepath, nil
+}
@@ -0,0 +1,125 @@
+package deej
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	rawMIDIDevicePattern = "/dev/snd/midiC*D*"
+
+	// ALSA describes every raw MIDI device here, starting with its name and followed by its substreams
+	rawMIDIProcFormat = "/proc/asound/card%d/midi%d"
+)
+
+// rawMIDIBackend opens ALSA's raw MIDI devices, which only takes the kernel driver and no libraries.
+// virtual ports (i.e. to test with) come from the snd-virmidi module, and connect to other apps through aconnect
+type rawMIDIBackend struct {
+	// device paths by the port names that ports returned last
+	paths map[string]string
+}
+
+func newMIDIBackend() midiBackend {
+	return &rawMIDIBackend{paths: map[string]string{}}
+}
+
+func (b *rawMIDIBackend) ports() ([]string, []string, error) {
+	devicePaths, err := filepath.Glob(rawMIDIDevicePattern)
+	if err != nil {
+		return nil, nil, fmt.Errorf("find raw MIDI devices: %w", err)
+	}
+
+	inputs := []string{}
+	outputs := []string{}
+	b.paths = map[string]string{}
+
+	for _, devicePath := range devicePaths {
+		var card, device int
+		if _, err := fmt.Sscanf(filepath.Base(devicePath), "midiC%dD%d", &card, &device); err != nil {
+			continue
+		}
+
+		name, hasInput, hasOutput := rawMIDIDeviceInfo(card, device)
+
+		// names aren't unique between identical controllers, so the ALSA address goes with them
+		name = fmt.Sprintf("%s (hw:%d,%d)", name, card, device)
+		b.paths[name] = devicePath
+
+		if hasInput {
+			inputs = append(inputs, name)
+		}
+
+		if hasOutput {
+			outputs = append(outputs, name)
+		}
+	}
+
+	return inputs, outputs, nil
+}
+
+func (b *rawMIDIBackend) openInput(name string) (io.ReadCloser, error) {
+	devicePath, ok := b.paths[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown MIDI port '%s'", name)
+	}
+
+	file, err := os.OpenFile(devicePath, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", devicePath, err)
+	}
+
+	return file, nil
+}
+
+func (b *rawMIDIBackend) openOutput(name string) (io.WriteCloser, error) {
+	devicePath, ok := b.paths[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown MIDI port '%s'", name)
+	}
+
+	file, err := os.OpenFile(devicePath, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", devicePath, err)
+	}
+
+	return file, nil
+}
+
+// rawMIDIDeviceInfo returns a device's name and whether it has inputs and outputs. devices that ALSA doesn't
+// describe are named after their address, and assumed to have both
+func rawMIDIDeviceInfo(card int, device int) (string, bool, bool) {
+	fallbackName := fmt.Sprintf("MIDI %d-%d", card, device)
+
+	file, err := os.Open(fmt.Sprintf(rawMIDIProcFormat, card, device))
+	if err != nil {
+		return fallbackName, true, true
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return fallbackName, true, true
+	}
+
+	name := strings.TrimSpace(scanner.Text())
+	hasInput := false
+	hasOutput := false
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		hasInput = hasInput || strings.HasPrefix(line, "Input")
+		hasOutput = hasOutput || strings.HasPrefix(line, "Output")
+	}
+
+	if name == "" {
+		name = fallbackName
+	}
+
+	return name, hasInput, hasOutput
+}
@@ -0,0 +1,359 @@
+package deej
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// loopbackMIDIBackend has a single port in each direction, which the test plays the controller on
+type loopbackMIDIBackend struct {
+	name string
+
+	// the controller's end of the input that's open, and whatever deej sent to the output
+	controller *io.PipeWriter
+	feedback   chan []byte
+}
+
+func newLoopbackMIDIBackend(name string) *loopbackMIDIBackend {
+	return &loopbackMIDIBackend{name: name, feedback: make(chan []byte, 64)}
+}
+
+func (b *loopbackMIDIBackend) ports() ([]string, []string, error) {
+	return []string{"Other Input", b.name}, []string{b.name}, nil
+}
+
+func (b *loopbackMIDIBackend) openInput(name string) (io.ReadCloser, error) {
+	reader, writer := io.Pipe()
+	b.controller = writer
+
+	return reader, nil
+}
+
+func (b *loopbackMIDIBackend) openOutput(name string) (io.WriteCloser, error) {
+	return &loopbackMIDIOutput{feedback: b.feedback}, nil
+}
+
+// send plays bytes on the controller, and returns once deej read them
+func (b *loopbackMIDIBackend) send(t *testing.T, data ...byte) {
+	if _, err := b.controller.Write(data); err != nil {
+		t.Errorf("Failed to send MIDI: %v", err)
+	}
+}
+
+// expectFeedback checks the next message that deej sent, or that it sent nothing if expected is nil
+func (b *loopbackMIDIBackend) expectFeedback(t *testing.T, expected []byte) {
+	select {
+	case actual := <-b.feedback:
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Expected feedback %v, got %v", expected, actual)
+		}
+	case <-time.After(50 * time.Millisecond):
+		if expected != nil {
+			t.Errorf("Expected feedback %v, got none", expected)
+		}
+	}
+}
+
+type loopbackMIDIOutput struct {
+	feedback chan []byte
+}
+
+func (o *loopbackMIDIOutput) Write(p []byte) (int, error) {
+	o.feedback <- append([]byte{}, p...)
+	return len(p), nil
+}
+
+func (o *loopbackMIDIOutput) Close() error {
+	return nil
+}
+
+const testMIDIConfig = `
+mute_button_mapping:
+  0: mic
+available_output_device:
+  0: ["Speakers"]
+  1: ["Headphones"]
+midi:
+  enabled: true
+  input: test controller
+  channel: 2
+  sliders:
+    0: 7
+    1: 8
+  mute_buttons:
+    0: 36
+  output_devices:
+    1: 37
+`
+
+// newTestMIDIController creates a controller for a session map with the given sessions and config,
+// on a loopback backend. it opens once the test calls applyConfig
+func newTestMIDIController(t *testing.T, sf *fakeSessionFinder, config string) (*midiController, *loopbackMIDIBackend) {
+	m := newTestSessionMap(t, config, sf)
+	m.deej.sessions = m
+
+	backend := newLoopbackMIDIBackend("Test Controller MIDI 1")
+
+	c := newMIDIController(m.deej, zap.NewNop().Sugar(), backend)
+	m.deej.midi = c
+
+	m.setupOnMuteButtonClicked()
+	m.setupOnToggleOutputDeviceButtonClicked()
+
+	return c, backend
+}
+
+// TestMIDIParser tests reading channel messages from a byte stream, with running status
+// and system messages in between
+func TestMIDIParser(t *testing.T) {
+	stream := []byte{
+		0x05,         // data without a status
+		0xB1, 7, 100, // control change
+		8, 0, // and another one with running status
+		0x91, 36, 0xF8, 127, // note on, with a clock tick in the middle
+		0xF0, 1, 2, 3, 0xF7, // sysex
+		4, 5, // data after it belongs to nothing
+		0xC0, 3, // program change
+	}
+
+	expected := []midiMessage{
+		{status: midiStatusControlChange, channel: 1, data1: 7, data2: 100},
+		{status: midiStatusControlChange, channel: 1, data1: 8, data2: 0},
+		{status: midiStatusNoteOn, channel: 1, data1: 36, data2: 127},
+		{status: midiStatusProgramChange, channel: 0, data1: 3},
+	}
+
+	parser := &midiParser{}
+	messages := []midiMessage{}
+
+	for _, b := range stream {
+		if message, ok := parser.parse(b); ok {
+			messages = append(messages, message)
+		}
+	}
+
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, messages)
+	}
+
+	if port, ok := findMIDIPort([]string{"Other", "nanoKONTROL2 (hw:1,0)"}, "NANOKONTROL"); !ok || port != "nanoKONTROL2 (hw:1,0)" {
+		t.Errorf("Expected to find the port ignoring case, got '%s'", port)
+	}
+
+	if port, ok := findMIDIPort([]string{"First", "Second"}, ""); !ok || port != "First" {
+		t.Errorf("Expected an empty name to pick the first port, got '%s'", port)
+	}
+}
+
+// TestMIDIConfig tests reading the midi section along with learned controls, and refusing numbers
+// that MIDI doesn't have
+func TestMIDIConfig(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, "midi:\n  enabled: true\n  sliders:\n    0: 7\n    1: 8\n")
+	defer cleanup()
+
+	cleanupPreferences := createTestPreferences(t, "midi:\n  sliders:\n    1: 20\n    2: 21\n  mute_buttons:\n    0: 40\n")
+	defer cleanupPreferences()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	expected := midiConfig{
+		Enabled:       true,
+		Sliders:       map[int]int{0: 7, 1: 8, 2: 21},
+		MuteButtons:   map[int]int{0: 40},
+		OutputDevices: map[int]int{},
+	}
+
+	if !reflect.DeepEqual(deej.config.MIDI, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, deej.config.MIDI)
+	}
+
+	for _, test := range []struct {
+		midi  string
+		fails bool
+	}{
+		{"midi:\n  enabled: false\n  channel: 20", false},
+		{"midi:\n  enabled: true\n  channel: 16", false},
+		{"midi:\n  enabled: true\n  channel: 17", true},
+		{"midi:\n  enabled: true\n  sliders:\n    0: 128", true},
+		{"midi:\n  enabled: true\n  mute_buttons:\n    x: 1", true},
+		{"midi:\n  enabled: true\n  output_devices:\n    0: loud", true},
+	} {
+		cleanup := createTestConfig(t, test.midi+"\n")
+
+		if err := deej.config.Load(); (err != nil) != test.fails {
+			t.Errorf("'%s': expected failure %v, got %v", test.midi, test.fails, err)
+		}
+
+		cleanup()
+	}
+}
+
+// TestMIDIController tests that control changes move sliders and notes click buttons on the configured channel,
+// that LEDs follow the audio state, and that an unplugged controller is opened again
+func TestMIDIController(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	mic := newFakeSession(logger, inputSessionName)
+	sf := &fakeSessionFinder{sessions: []Session{mic}, currentOutputDevice: []string{"Speakers"}}
+
+	c, backend := newTestMIDIController(t, sf, testMIDIConfig)
+
+	sliderMoves := c.SubscribeToSliderMoveEvents()
+	muteButtons := c.SubscribeToMuteButtonEvents()
+	outputDevices := c.SubscribeToOutputDeviceEvents()
+	connections := c.SubscribeToConnectionEvents()
+
+	go c.applyConfig()
+	if event := <-connections; !event.Connected || event.Transport != "midi://Test Controller MIDI 1" {
+		t.Fatalf("Expected the controller to open, got %+v", event)
+	}
+
+	defer func() {
+		go c.Stop()
+		<-connections
+	}()
+
+	expectSliderMove := func(expected SliderMoveEvent) {
+		select {
+		case event := <-sliderMoves:
+			if event != expected {
+				t.Errorf("Expected %+v, got %+v", expected, event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for %+v", expected)
+		}
+	}
+
+	// the configured channel 2 is 1 on the wire
+	go backend.send(t, 0xB1, 7, 127, 7, 127, 8, 64)
+	expectSliderMove(SliderMoveEvent{SliderID: 0, PercentValue: 1})
+	expectSliderMove(SliderMoveEvent{SliderID: 1, PercentValue: 0.5})
+
+	go backend.send(t, 0xB0, 7, 0, 0xB1, 9, 0, 7, 0)
+	expectSliderMove(SliderMoveEvent{SliderID: 0, PercentValue: 0})
+
+	// buttons flip their state on every press, and releasing them does nothing
+	for _, expectMute := range []bool{true, false} {
+		go backend.send(t, 0x91, 36, 100, 0x81, 36, 0, 0x91, 36, 0)
+
+		select {
+		case event := <-muteButtons:
+			if event.MuteButtonID != 0 || event.mute != expectMute || mic.mute != expectMute {
+				t.Errorf("Expected the mic's mute to become %v, got %+v", expectMute, event)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for the mute button")
+		}
+	}
+
+	go backend.send(t, 0x91, 37, 127)
+
+	select {
+	case deviceIdx := <-outputDevices:
+		if deviceIdx != 1 || !reflect.DeepEqual(sf.switchedTo, []string{"Headphones"}) {
+			t.Errorf("Expected a switch to the headphones, got %d and %v", deviceIdx, sf.switchedTo)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the output device")
+	}
+
+	// muting outside deej lights the button up too, and the next press unmutes
+	mic.mute = true
+	c.pushState(c.deej.sessions.getControllerState)
+
+	backend.expectFeedback(t, []byte{0x91, 36, 127})
+	backend.expectFeedback(t, []byte{0x91, 37, 127})
+
+	c.pushState(c.deej.sessions.getControllerState)
+	backend.expectFeedback(t, nil)
+
+	go backend.send(t, 0x91, 36, 127)
+	if event := <-muteButtons; event.mute {
+		t.Errorf("Expected the button to unmute after muting outside deej, got %+v", event)
+	}
+
+	// unplugging closes the controller until it's found again, which sends every LED again
+	backend.controller.CloseWithError(errors.New("unplugged"))
+	if event := <-connections; event.Connected {
+		t.Fatalf("Expected the controller to disconnect, got %+v", event)
+	}
+
+	go c.reconnect()
+	if event := <-connections; !event.Connected {
+		t.Fatalf("Expected the controller to open again, got %+v", event)
+	}
+
+	c.pushState(c.deej.sessions.getControllerState)
+	backend.expectFeedback(t, []byte{0x91, 36, 0})
+	backend.expectFeedback(t, []byte{0x91, 37, 127})
+}
+
+// TestMIDILearn tests that controls that aren't mapped yet are mapped to the first free slider and mute button,
+// and are still mapped after a reload
+func TestMIDILearn(t *testing.T) {
+	cleanupPreferences := createTestPreferences(t, "")
+	defer cleanupPreferences()
+
+	config := "midi:\n  enabled: true\n  learn: true\n  sliders:\n    0: 7\n"
+	c, backend := newTestMIDIController(t, &fakeSessionFinder{}, config)
+
+	sliderMoves := c.SubscribeToSliderMoveEvents()
+	muteButtons := c.SubscribeToMuteButtonEvents()
+
+	c.applyConfig()
+	defer c.Stop()
+
+	go backend.send(t, 0xB0, 7, 127, 9, 127, 0x90, 40, 127)
+
+	for _, expected := range []SliderMoveEvent{{SliderID: 0, PercentValue: 1}, {SliderID: 1, PercentValue: 1}} {
+		select {
+		case event := <-sliderMoves:
+			if event != expected {
+				t.Errorf("Expected %+v, got %+v", expected, event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for %+v", expected)
+		}
+	}
+
+	// the note is learned before it clicks the button, and handled after the slider moves
+	select {
+	case event := <-muteButtons:
+		if event.MuteButtonID != 0 {
+			t.Errorf("Expected the note to click mute button 0, got %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the note to be learned")
+	}
+
+	// the config that was loaded doesn't change until it's reloaded
+	if len(c.deej.config.MIDI.Sliders) != 1 {
+		t.Errorf("Expected the loaded config to stay the same, got %v", c.deej.config.MIDI.Sliders)
+	}
+
+	if err := c.deej.config.Load(); err != nil {
+		t.Fatalf("Failed to reload config: %v", err)
+	}
+
+	if sliders := c.deej.config.MIDI.Sliders; !reflect.DeepEqual(sliders, map[int]int{0: 7, 1: 9}) {
+		t.Errorf("Expected the learned slider to be saved, got %v", sliders)
+	}
+
+	if buttons := c.deej.config.MIDI.MuteButtons; !reflect.DeepEqual(buttons, map[int]int{0: 40}) {
+		t.Errorf("Expected the learned mute button to be saved, got %v", buttons)
+	}
+
+	if notified := c.deej.notifier.(*mockNotifier).notified(); !reflect.DeepEqual(notified, []string{"Learned a MIDI control", "Learned a MIDI control"}) {
+		t.Errorf("Expected a notification for every learned control, got %v", notified)
+	}
+}
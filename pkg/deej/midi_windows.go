@@ -0,0 +1,288 @@
+package deej
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	mmsysErrNoError = 0
+
+	callbackNull     = 0x00000
+	callbackFunction = 0x30000
+
+	// the only message of an input callback that carries MIDI, as a packed short message
+	mimData = 0x3C3
+
+	maxPnameLen = 32
+
+	// messages that arrive while deej is busy wait here, later ones are dropped
+	winmmInputQueueSize = 256
+)
+
+var (
+	winmm                 = syscall.NewLazyDLL("winmm.dll")
+	procMidiInGetNumDevs  = winmm.NewProc("midiInGetNumDevs")
+	procMidiInGetDevCapsW = winmm.NewProc("midiInGetDevCapsW")
+	procMidiInOpen        = winmm.NewProc("midiInOpen")
+	procMidiInStart       = winmm.NewProc("midiInStart")
+	procMidiInStop        = winmm.NewProc("midiInStop")
+	procMidiInReset       = winmm.NewProc("midiInReset")
+	procMidiInClose       = winmm.NewProc("midiInClose")
+	procMidiOutGetNumDevs = winmm.NewProc("midiOutGetNumDevs")
+	procMidiOutGetDevCaps = winmm.NewProc("midiOutGetDevCapsW")
+	procMidiOutOpen       = winmm.NewProc("midiOutOpen")
+	procMidiOutShortMsg   = winmm.NewProc("midiOutShortMsg")
+	procMidiOutReset      = winmm.NewProc("midiOutReset")
+	procMidiOutClose      = winmm.NewProc("midiOutClose")
+
+	// windows only has so many callbacks to give out, so every input shares this one and is told apart by its id
+	winmmInputCallback = syscall.NewCallback(handleWinmmInput)
+	winmmInputs        = map[uintptr]*winmmInput{}
+	winmmInputsLock    sync.Mutex
+	winmmNextInputID   uintptr
+)
+
+// MIDIINCAPSW
+type midiInCaps struct {
+	Mid           uint16
+	Pid           uint16
+	DriverVersion uint32
+	Pname         [maxPnameLen]uint16
+	Support       uint32
+}
+
+// MIDIOUTCAPSW
+type midiOutCaps struct {
+	Mid           uint16
+	Pid           uint16
+	DriverVersion uint32
+	Pname         [maxPnameLen]uint16
+	Technology    uint16
+	Voices        uint16
+	Notes         uint16
+	ChannelMask   uint16
+	Support       uint32
+}
+
+// winmmBackend opens MIDI ports through the multimedia API, which every version of windows has
+type winmmBackend struct {
+	// device ids by the port names that ports returned last
+	inputIDs  map[string]uintptr
+	outputIDs map[string]uintptr
+}
+
+func newMIDIBackend() midiBackend {
+	return &winmmBackend{inputIDs: map[string]uintptr{}, outputIDs: map[string]uintptr{}}
+}
+
+func (b *winmmBackend) ports() ([]string, []string, error) {
+	b.inputIDs = map[string]uintptr{}
+	b.outputIDs = map[string]uintptr{}
+
+	numInputs, _, _ := procMidiInGetNumDevs.Call()
+	for id := uintptr(0); id < numInputs; id++ {
+		var caps midiInCaps
+		if result, _, _ := procMidiInGetDevCapsW.Call(id, uintptr(unsafe.Pointer(&caps)), unsafe.Sizeof(caps)); result != mmsysErrNoError {
+			continue
+		}
+
+		b.inputIDs[uniqueMIDIPortName(b.inputIDs, syscall.UTF16ToString(caps.Pname[:]))] = id
+	}
+
+	numOutputs, _, _ := procMidiOutGetNumDevs.Call()
+	for id := uintptr(0); id < numOutputs; id++ {
+		var caps midiOutCaps
+		if result, _, _ := procMidiOutGetDevCaps.Call(id, uintptr(unsafe.Pointer(&caps)), unsafe.Sizeof(caps)); result != mmsysErrNoError {
+			continue
+		}
+
+		b.outputIDs[uniqueMIDIPortName(b.outputIDs, syscall.UTF16ToString(caps.Pname[:]))] = id
+	}
+
+	return sortedMIDIPortNames(b.inputIDs), sortedMIDIPortNames(b.outputIDs), nil
+}
+
+func (b *winmmBackend) openInput(name string) (io.ReadCloser, error) {
+	deviceID, ok := b.inputIDs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown MIDI port '%s'", name)
+	}
+
+	winmmInputsLock.Lock()
+	winmmNextInputID++
+	input := &winmmInput{
+		id:       winmmNextInputID,
+		messages: make(chan []byte, winmmInputQueueSize),
+		closed:   make(chan bool),
+	}
+	winmmInputs[input.id] = input
+	winmmInputsLock.Unlock()
+
+	if result, _, _ := procMidiInOpen.Call(uintptr(unsafe.Pointer(&input.handle)), deviceID, winmmInputCallback,
+		input.id, callbackFunction); result != mmsysErrNoError {
+
+		input.forget()
+		return nil, fmt.Errorf("midiInOpen: error %d", result)
+	}
+
+	if result, _, _ := procMidiInStart.Call(input.handle); result != mmsysErrNoError {
+		input.Close()
+		return nil, fmt.Errorf("midiInStart: error %d", result)
+	}
+
+	return input, nil
+}
+
+func (b *winmmBackend) openOutput(name string) (io.WriteCloser, error) {
+	deviceID, ok := b.outputIDs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown MIDI port '%s'", name)
+	}
+
+	output := &winmmOutput{}
+
+	if result, _, _ := procMidiOutOpen.Call(uintptr(unsafe.Pointer(&output.handle)), deviceID, 0, 0,
+		callbackNull); result != mmsysErrNoError {
+
+		return nil, fmt.Errorf("midiOutOpen: error %d", result)
+	}
+
+	return output, nil
+}
+
+// winmmInput hands the short messages that its callback receives to Read, as bytes
+type winmmInput struct {
+	id        uintptr
+	handle    uintptr
+	messages  chan []byte
+	pending   []byte
+	closed    chan bool
+	closeOnce sync.Once
+}
+
+// handleWinmmInput is called on a thread of windows' own, which mustn't be held up
+func handleWinmmInput(handle uintptr, message uintptr, instance uintptr, param1 uintptr, param2 uintptr) uintptr {
+	if message != mimData {
+		return 0
+	}
+
+	winmmInputsLock.Lock()
+	input, ok := winmmInputs[instance]
+	winmmInputsLock.Unlock()
+
+	status := byte(param1)
+	if !ok || status < midiStatusNoteOff || status >= midiStatusSystem {
+		return 0
+	}
+
+	data := []byte{status, byte(param1 >> 8), byte(param1 >> 16)}
+	if kind := status & 0xF0; kind == midiStatusProgramChange || kind == midiStatusChannelPressure {
+		data = data[:2]
+	}
+
+	select {
+	case input.messages <- data:
+	default:
+	}
+
+	return 0
+}
+
+func (i *winmmInput) Read(p []byte) (int, error) {
+	if len(i.pending) == 0 {
+		select {
+		case i.pending = <-i.messages:
+		case <-i.closed:
+			return 0, io.EOF
+		}
+	}
+
+	n := copy(p, i.pending)
+	i.pending = i.pending[n:]
+
+	return n, nil
+}
+
+func (i *winmmInput) Close() error {
+	i.closeOnce.Do(func() {
+		procMidiInStop.Call(i.handle)
+		procMidiInReset.Call(i.handle)
+		procMidiInClose.Call(i.handle)
+
+		i.forget()
+		close(i.closed)
+	})
+
+	return nil
+}
+
+func (i *winmmInput) forget() {
+	winmmInputsLock.Lock()
+	delete(winmmInputs, i.id)
+	winmmInputsLock.Unlock()
+}
+
+// winmmOutput sends every message written to it as a short message
+type winmmOutput struct {
+	handle uintptr
+	parser midiParser
+}
+
+func (o *winmmOutput) Write(p []byte) (int, error) {
+	for _, b := range p {
+		message, ok := o.parser.parse(b)
+		if !ok {
+			continue
+		}
+
+		packed := uintptr(message.status|message.channel) | uintptr(message.data1)<<8 | uintptr(message.data2)<<16
+
+		if result, _, _ := procMidiOutShortMsg.Call(o.handle, packed); result != mmsysErrNoError {
+			return 0, fmt.Errorf("midiOutShortMsg: error %d", result)
+		}
+	}
+
+	return len(p), nil
+}
+
+func (o *winmmOutput) Close() error {
+	procMidiOutReset.Call(o.handle)
+
+	if result, _, _ := procMidiOutClose.Call(o.handle); result != mmsysErrNoError {
+		return fmt.Errorf("midiOutClose: error %d", result)
+	}
+
+	return nil
+}
+
+// uniqueMIDIPortName numbers the names of identical controllers, which windows doesn't tell apart
+func uniqueMIDIPortName(taken map[string]uintptr, name string) string {
+	unique := name
+
+	for number := 2; ; number++ {
+		if _, ok := taken[unique]; !ok {
+			return unique
+		}
+
+		unique = fmt.Sprintf("%s (%d)", name, number)
+	}
+}
+
+// sortedMIDIPortNames returns the names in device id order, which is the order windows lists them in
+func sortedMIDIPortNames(ids map[string]uintptr) []string {
+	names := make([]string, 0, len(ids))
+	for name := range ids {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		return ids[names[i]] < ids[names[j]]
+	})
+
+	return names
+}
@@ -0,0 +1,788 @@
+package deej
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"go.uber.org/zap"
+)
+
+// the MQTT bridge publishes sliders, mute buttons, mapped sessions and the output device to an MQTT broker,
+// and takes commands to change them. Home Assistant finds the sliders, mute buttons and output device on its own
+// through discovery. every topic is under the configured prefix ("deej" by default):
+//
+//	deej/status                       "online" or "offline"
+//	deej/slider/<index>               the slider's position, 0-100
+//	deej/mute_button/<index>          "ON" if every target of the button is muted, "OFF" otherwise
+//	deej/session/<key>/volume         a mapped session's volume, 0-100
+//	deej/session/<key>/mute           "ON" or "OFF"
+//	deej/output_device                the name of the default output device, if it's one of available_output_device
+//
+// commands go to the same topics with "/set" appended. the bridge is a controller of its own, whose sliders,
+// mute buttons and output device are moved by their commands
+const (
+	mqttTopicStatus       = "status"
+	mqttTopicSlider       = "slider"
+	mqttTopicMuteButton   = "mute_button"
+	mqttTopicSession      = "session"
+	mqttTopicVolume       = "volume"
+	mqttTopicMute         = "mute"
+	mqttTopicOutputDevice = "output_device"
+	mqttTopicSet          = "set"
+
+	mqttPayloadOnline  = "online"
+	mqttPayloadOffline = "offline"
+	mqttPayloadOn      = "ON"
+	mqttPayloadOff     = "OFF"
+
+	defaultMQTTBroker          = "tcp://localhost:1883"
+	defaultMQTTClientID        = "deej"
+	defaultMQTTTopicPrefix     = "deej"
+	defaultMQTTDiscoveryPrefix = "homeassistant"
+
+	// how often sessions, mute buttons and the output device are checked for changes to publish
+	mqttStatePollInterval = time.Second
+
+	// brokers that can't be reached are tried again after this long, paho only reconnects once it's been connected
+	mqttConnectRetryInterval = 30 * time.Second
+
+	mqttConnectTimeout    = 5 * time.Second
+	mqttDisconnectTimeout = 250 // milliseconds, as paho takes them
+)
+
+// mqttConfig holds every config value of the MQTT bridge
+type mqttConfig struct {
+	Enabled         bool
+	Broker          string
+	Username        string
+	Password        string
+	ClientID        string
+	TopicPrefix     string
+	Discovery       bool
+	DiscoveryPrefix string
+}
+
+// mqttBridge keeps a connection to the broker while it's enabled, and follows its config through reloads
+type mqttBridge struct {
+	deej   *Deej
+	logger *zap.SugaredLogger
+
+	config mqttConfig
+	client mqtt.Client
+
+	lastConnectAttempt time.Time
+
+	// every slider's last position, whether it moved or was set from MQTT
+	sliderValues map[int]float32
+	sliderLock   sync.Mutex
+
+	// what was last published to each topic, only used by the bridge's goroutine
+	published map[string]string
+
+	// tells the bridge's goroutine to publish everything again, i.e. after the broker restarted
+	republish chan bool
+
+	// tells the bridge's goroutine to publish the sliders, after they moved
+	slidersMoved chan bool
+
+	configReloadedChannel chan bool
+
+	sliderMoveConsumers        []chan SliderMoveEvent
+	muteButtonsConsumer        MuteButtonConsumer
+	toggleOutputDeviceConsumer ToggleOutputDeviceConsumer
+
+	// closing stopChannel has the bridge's goroutine disconnect and end, which closes stopped.
+	// both are nil while it isn't running
+	stopChannel chan bool
+	stopped     chan bool
+	runLock     sync.Mutex
+}
+
+// mqttDiscoveryDevice groups every entity under a single device in Home Assistant
+type mqttDiscoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	SWVersion    string   `json:"sw_version,omitempty"`
+}
+
+// mqttDiscoveryEntity is a Home Assistant discovery payload, with the fields of numbers, switches and selects
+type mqttDiscoveryEntity struct {
+	Name              string              `json:"name"`
+	UniqueID          string              `json:"unique_id"`
+	StateTopic        string              `json:"state_topic"`
+	CommandTopic      string              `json:"command_topic"`
+	AvailabilityTopic string              `json:"availability_topic"`
+	Icon              string              `json:"icon,omitempty"`
+	Min               *float64            `json:"min,omitempty"`
+	Max               *float64            `json:"max,omitempty"`
+	Step              *float64            `json:"step,omitempty"`
+	UnitOfMeasurement string              `json:"unit_of_measurement,omitempty"`
+	Options           []string            `json:"options,omitempty"`
+	Device            mqttDiscoveryDevice `json:"device"`
+}
+
+func newMQTTBridge(deej *Deej, logger *zap.SugaredLogger) *mqttBridge {
+	logger = logger.Named("mqtt")
+
+	b := &mqttBridge{
+		deej:         deej,
+		logger:       logger,
+		sliderValues: map[int]float32{},
+		published:    map[string]string{},
+		republish:    make(chan bool, 1),
+		slidersMoved: make(chan bool, 1),
+	}
+
+	logger.Debug("Created MQTT bridge instance")
+
+	return b
+}
+
+// initialize follows slider moves and config reloads, and connects to the broker if the bridge is enabled
+func (b *mqttBridge) initialize() {
+	sliderMoveChannel := b.deej.subscribeToSliderMoveEvents()
+	configReloadedChannel := b.deej.config.SubscribeToChanges()
+
+	// slider moves are only recorded here and published by the bridge's goroutine, so that a broker
+	// that takes its time to connect never holds up the sliders
+	go func() {
+		for event := range sliderMoveChannel {
+			b.recordSliderValue(event)
+
+			select {
+			case b.slidersMoved <- true:
+			default:
+			}
+		}
+	}()
+
+	b.configReloadedChannel = configReloadedChannel
+	b.Start()
+}
+
+// Start runs the bridge's goroutine, which connects to the broker while the bridge is enabled
+func (b *mqttBridge) Start() error {
+	b.runLock.Lock()
+	defer b.runLock.Unlock()
+
+	if b.stopChannel != nil {
+		return nil
+	}
+
+	b.stopChannel = make(chan bool)
+	b.stopped = make(chan bool)
+
+	go b.run(b.stopChannel, b.stopped)
+
+	return nil
+}
+
+// Stop has the bridge's goroutine disconnect from the broker, and waits for it to end
+func (b *mqttBridge) Stop() {
+	b.runLock.Lock()
+	defer b.runLock.Unlock()
+
+	if b.stopChannel == nil {
+		return
+	}
+
+	close(b.stopChannel)
+	<-b.stopped
+
+	b.stopChannel = nil
+	b.stopped = nil
+}
+
+// SubscribeToSliderMoveEvents returns an unbuffered channel that receives a sliderMoveEvent
+// whenever a slider is set from MQTT
+func (b *mqttBridge) SubscribeToSliderMoveEvents() chan SliderMoveEvent {
+	ch := make(chan SliderMoveEvent)
+	b.sliderMoveConsumers = append(b.sliderMoveConsumers, ch)
+
+	return ch
+}
+
+func (b *mqttBridge) setMuteButtonClickEventConsumer(consumer MuteButtonConsumer) {
+	b.muteButtonsConsumer = consumer
+}
+
+func (b *mqttBridge) setToggleOutputDeviceEventConsumer(consumer ToggleOutputDeviceConsumer) {
+	b.toggleOutputDeviceConsumer = consumer
+}
+
+// run is the bridge's goroutine, which connects, polls and publishes. it's the only one to touch the client
+func (b *mqttBridge) run(stopChannel chan bool, stopped chan bool) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(mqttStatePollInterval)
+	defer ticker.Stop()
+
+	b.applyConfig()
+
+	for {
+		select {
+		case <-stopChannel:
+			b.disconnect()
+
+			// so that starting again connects again
+			b.config = mqttConfig{}
+			return
+		case <-b.slidersMoved:
+			b.publishSliders()
+		case <-b.configReloadedChannel:
+			b.applyConfig()
+			b.publishAll()
+		case <-b.republish:
+			b.published = map[string]string{}
+			b.publishAll()
+		case <-ticker.C:
+			b.maybeConnect()
+			b.publishState()
+		}
+	}
+}
+
+// applyConfig connects, disconnects or reconnects when the bridge's config changed
+func (b *mqttBridge) applyConfig() {
+	config := b.deej.config.MQTT
+	if config == b.config {
+		return
+	}
+
+	b.disconnect()
+	b.config = config
+	b.lastConnectAttempt = time.Time{}
+
+	b.maybeConnect()
+}
+
+// maybeConnect connects to the broker if the bridge is enabled and isn't connected, at most once per retry interval
+func (b *mqttBridge) maybeConnect() {
+	if !b.config.Enabled || b.client != nil || time.Since(b.lastConnectAttempt) < mqttConnectRetryInterval {
+		return
+	}
+
+	firstAttempt := b.lastConnectAttempt.IsZero()
+	b.lastConnectAttempt = time.Now()
+
+	if err := b.connect(); err != nil {
+		b.logger.Warnw("Failed to connect to MQTT broker", "broker", b.config.Broker, "error", err)
+
+		// only bother the user once, the broker might just not be up yet
+		if firstAttempt {
+			b.deej.notifier.Notify("Can't connect to MQTT broker!",
+				fmt.Sprintf("deej will keep trying to reach %s. Please check the mqtt section in %s",
+					b.config.Broker, userConfigFilepath))
+		}
+	}
+}
+
+func (b *mqttBridge) connect() error {
+	// paho's goroutines get their own copy of the config, as reloads change the bridge's
+	config := b.config
+
+	options := mqtt.NewClientOptions().
+		AddBroker(b.config.Broker).
+		SetClientID(b.config.ClientID).
+		SetUsername(b.config.Username).
+		SetPassword(b.config.Password).
+		SetConnectTimeout(mqttConnectTimeout).
+		SetAutoReconnect(true).
+		SetWill(b.topic(mqttTopicStatus), mqttPayloadOffline, 0, true).
+		SetOnConnectHandler(func(client mqtt.Client) {
+			b.onConnect(client, config)
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			b.logger.Warnw("Lost connection to MQTT broker, reconnecting", "error", err)
+		})
+
+	client := mqtt.NewClient(options)
+
+	token := client.Connect()
+	if !token.WaitTimeout(mqttConnectTimeout) {
+		client.Disconnect(0)
+		return errors.New("timed out")
+	}
+
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+
+	b.client = client
+	b.logger.Infow("Connected to MQTT broker", "broker", b.config.Broker)
+
+	return nil
+}
+
+// onConnect subscribes to commands and has everything published again, whenever paho (re)connects.
+// it runs on one of paho's goroutines, with the config that the client connected with
+func (b *mqttBridge) onConnect(client mqtt.Client, config mqttConfig) {
+	filters := map[string]byte{}
+	for _, filter := range []string{
+		config.topic(mqttTopicSlider, "+", mqttTopicSet),
+		config.topic(mqttTopicMuteButton, "+", mqttTopicSet),
+		config.topic(mqttTopicSession, "+", mqttTopicVolume, mqttTopicSet),
+		config.topic(mqttTopicSession, "+", mqttTopicMute, mqttTopicSet),
+		config.topic(mqttTopicOutputDevice, mqttTopicSet),
+	} {
+		filters[filter] = 0
+	}
+
+	token := client.SubscribeMultiple(filters, func(_ mqtt.Client, message mqtt.Message) {
+		b.handleCommand(config, message.Topic(), string(message.Payload()))
+	})
+
+	if token.WaitTimeout(mqttConnectTimeout) && token.Error() != nil {
+		b.logger.Warnw("Failed to subscribe to MQTT commands", "error", token.Error())
+	}
+
+	client.Publish(config.topic(mqttTopicStatus), 0, true, mqttPayloadOnline)
+
+	select {
+	case b.republish <- true:
+	default:
+	}
+}
+
+func (b *mqttBridge) disconnect() {
+	if b.client == nil {
+		return
+	}
+
+	b.client.Publish(b.topic(mqttTopicStatus), 0, true, mqttPayloadOffline).WaitTimeout(mqttConnectTimeout)
+	b.client.Disconnect(mqttDisconnectTimeout)
+
+	b.client = nil
+	b.published = map[string]string{}
+
+	b.logger.Debug("Disconnected from MQTT broker")
+}
+
+// topic joins topic levels under the configured prefix
+func (b *mqttBridge) topic(levels ...string) string {
+	return b.config.topic(levels...)
+}
+
+func (c mqttConfig) topic(levels ...string) string {
+	return strings.Join(append([]string{c.TopicPrefix}, levels...), "/")
+}
+
+// publish sends a retained message, unless it's what the topic already has
+func (b *mqttBridge) publish(topic string, payload string) {
+	if b.client == nil {
+		return
+	}
+
+	if previous, ok := b.published[topic]; ok && previous == payload {
+		return
+	}
+
+	b.published[topic] = payload
+	b.client.Publish(topic, 0, true, payload)
+}
+
+func (b *mqttBridge) publishAll() {
+	b.publishDiscovery()
+	b.publishState()
+}
+
+func (b *mqttBridge) recordSliderValue(event SliderMoveEvent) {
+	b.sliderLock.Lock()
+	defer b.sliderLock.Unlock()
+
+	b.sliderValues[event.SliderID] = event.PercentValue
+}
+
+func (b *mqttBridge) publishSliders() {
+	b.sliderLock.Lock()
+	values := map[int]float32{}
+	for sliderIdx, value := range b.sliderValues {
+		values[sliderIdx] = value
+	}
+	b.sliderLock.Unlock()
+
+	for sliderIdx, value := range values {
+		b.publish(b.topic(mqttTopicSlider, strconv.Itoa(sliderIdx)), formatMQTTPercent(value))
+	}
+}
+
+// publishState publishes whatever can change outside deej, which is why it's polled
+func (b *mqttBridge) publishState() {
+	if b.client == nil {
+		return
+	}
+
+	m := b.deej.sessions
+
+	b.publishSliders()
+
+	for buttonIdx := 0; buttonIdx < b.numMuteButtons(); buttonIdx++ {
+		payload := mqttPayloadOff
+		if targets, ok := b.deej.muteButtonTargets(buttonIdx); ok && m.targetsMuted(targets) {
+			payload = mqttPayloadOn
+		}
+
+		b.publish(b.topic(mqttTopicMuteButton, strconv.Itoa(buttonIdx)), payload)
+	}
+
+	// sessions that share a key (i.e. every process of a browser) are published as one
+	seen := map[string]bool{}
+
+	for _, session := range m.allSessions() {
+		segment := mqttTopicSegment(session.Key())
+		if seen[segment] || !m.sessionMapped(session) {
+			continue
+		}
+
+		seen[segment] = true
+
+		mute := mqttPayloadOff
+		if m.targetsMuted([]string{session.Key()}) {
+			mute = mqttPayloadOn
+		}
+
+		b.publish(b.topic(mqttTopicSession, segment, mqttTopicVolume), formatMQTTPercent(session.GetVolume()))
+		b.publish(b.topic(mqttTopicSession, segment, mqttTopicMute), mute)
+	}
+
+	if b.deej.config.AvailableOutputDeviceMapping.NumSliders() > 0 {
+		deviceName := ""
+		if deviceNames, ok := b.deej.config.AvailableOutputDeviceMapping.get(m.getCurrentOutputDeviceIndex()); ok {
+			deviceName = deviceNames[0]
+		}
+
+		b.publish(b.topic(mqttTopicOutputDevice), deviceName)
+	}
+}
+
+// publishDiscovery announces every slider, mute button and the output device to Home Assistant,
+// and takes back the ones that the config doesn't have anymore
+func (b *mqttBridge) publishDiscovery() {
+	if b.client == nil {
+		return
+	}
+
+	entities := map[string]mqttDiscoveryEntity{}
+
+	if b.config.Discovery {
+		device := mqttDiscoveryDevice{
+			Identifiers:  []string{b.config.ClientID},
+			Name:         "deej",
+			Manufacturer: "deej",
+			SWVersion:    b.deej.version,
+		}
+
+		entity := func(name string, objectID string, levels ...string) mqttDiscoveryEntity {
+			return mqttDiscoveryEntity{
+				Name:              name,
+				UniqueID:          b.config.ClientID + "_" + objectID,
+				StateTopic:        b.topic(levels...),
+				CommandTopic:      b.topic(append(levels, mqttTopicSet)...),
+				AvailabilityTopic: b.topic(mqttTopicStatus),
+				Device:            device,
+			}
+		}
+
+		min, max, step := 0.0, 100.0, 1.0
+
+		for sliderIdx := 0; sliderIdx < b.deej.config.numMappedSliders(); sliderIdx++ {
+			slider := entity(fmt.Sprintf("Slider %d", sliderIdx), fmt.Sprintf("slider_%d", sliderIdx),
+				mqttTopicSlider, strconv.Itoa(sliderIdx))
+
+			slider.Icon = "mdi:volume-high"
+			slider.Min, slider.Max, slider.Step = &min, &max, &step
+			slider.UnitOfMeasurement = "%"
+
+			entities[b.discoveryTopic("number", fmt.Sprintf("slider_%d", sliderIdx))] = slider
+		}
+
+		for buttonIdx := 0; buttonIdx < b.numMuteButtons(); buttonIdx++ {
+			button := entity(fmt.Sprintf("Mute button %d", buttonIdx), fmt.Sprintf("mute_button_%d", buttonIdx),
+				mqttTopicMuteButton, strconv.Itoa(buttonIdx))
+
+			button.Icon = "mdi:volume-off"
+
+			entities[b.discoveryTopic("switch", fmt.Sprintf("mute_button_%d", buttonIdx))] = button
+		}
+
+		if b.deej.config.AvailableOutputDeviceMapping.NumSliders() > 0 {
+			outputDevice := entity("Output device", "output_device", mqttTopicOutputDevice)
+
+			outputDevice.Icon = "mdi:speaker"
+			outputDevice.Options = b.outputDeviceNames()
+
+			entities[b.discoveryTopic("select", "output_device")] = outputDevice
+		}
+	}
+
+	// an empty retained message removes an entity
+	for topic := range b.published {
+		if _, ok := entities[topic]; !ok && strings.HasPrefix(topic, b.config.DiscoveryPrefix+"/") {
+			b.publish(topic, "")
+			delete(b.published, topic)
+		}
+	}
+
+	for topic, entity := range entities {
+		payload, err := json.Marshal(entity)
+		if err != nil {
+			b.logger.Warnw("Failed to encode discovery payload", "topic", topic, "error", err)
+			continue
+		}
+
+		b.publish(topic, string(payload))
+	}
+}
+
+func (b *mqttBridge) discoveryTopic(component string, objectID string) string {
+	return strings.Join([]string{b.config.DiscoveryPrefix, component, b.config.ClientID, objectID, "config"}, "/")
+}
+
+// numMuteButtons counts the mute buttons of every profile, so that switching profiles doesn't make entities disappear
+func (b *mqttBridge) numMuteButtons() int {
+	numButtons := b.deej.config.numMuteButtons("")
+
+	for _, name := range b.deej.config.profileNames() {
+		if profileButtons := b.deej.config.numMuteButtons(name); profileButtons > numButtons {
+			numButtons = profileButtons
+		}
+	}
+
+	return numButtons
+}
+
+// outputDeviceNames returns the first name of every output device, by index
+func (b *mqttBridge) outputDeviceNames() []string {
+	deviceIdxs := []int{}
+	deviceNames := map[int]string{}
+
+	b.deej.config.AvailableOutputDeviceMapping.iterate(func(deviceIdx int, names []string) {
+		deviceIdxs = append(deviceIdxs, deviceIdx)
+		deviceNames[deviceIdx] = names[0]
+	})
+
+	sort.Ints(deviceIdxs)
+
+	names := make([]string, len(deviceIdxs))
+	for idx, deviceIdx := range deviceIdxs {
+		names[idx] = deviceNames[deviceIdx]
+	}
+
+	return names
+}
+
+// handleCommand applies a message on one of the "/set" topics under the prefix of the given config.
+// it runs on one of paho's goroutines, and whatever it changes gets published with the next state poll
+func (b *mqttBridge) handleCommand(config mqttConfig, topic string, payload string) {
+	levels := strings.Split(strings.TrimPrefix(topic, config.TopicPrefix+"/"), "/")
+	if len(levels) < 2 || levels[len(levels)-1] != mqttTopicSet {
+		return
+	}
+
+	b.logger.Infow("Handling MQTT command", "topic", topic, "payload", payload)
+
+	if err := b.applyCommand(levels[:len(levels)-1], strings.TrimSpace(payload)); err != nil {
+		b.logger.Warnw("Ignoring invalid MQTT command", "topic", topic, "payload", payload, "error", err)
+	}
+}
+
+func (b *mqttBridge) applyCommand(levels []string, payload string) error {
+	m := b.deej.sessions
+
+	switch {
+	case len(levels) == 2 && levels[0] == mqttTopicSlider:
+		sliderIdx, err := strconv.Atoi(levels[1])
+		if err != nil {
+			return fmt.Errorf("invalid slider '%s'", levels[1])
+		}
+
+		volume, err := parseMQTTPercent(payload)
+		if err != nil {
+			return err
+		}
+
+		// slider moves are handled (and recorded by the bridge) like those of any other controller
+		event := SliderMoveEvent{SliderID: sliderIdx, PercentValue: volume}
+
+		for _, consumer := range b.sliderMoveConsumers {
+			consumer <- event
+		}
+
+	case len(levels) == 2 && levels[0] == mqttTopicMuteButton:
+		buttonIdx, err := strconv.Atoi(levels[1])
+		if err != nil {
+			return fmt.Errorf("invalid mute button '%s'", levels[1])
+		}
+
+		mute, err := parseMQTTSwitch(payload)
+		if err != nil {
+			return err
+		}
+
+		if b.muteButtonsConsumer == nil {
+			return errors.New("no mute button consumer registered")
+		}
+
+		if _, err := b.muteButtonsConsumer([]MuteButtonClickEvent{{MuteButtonID: buttonIdx, mute: mute}}); err != nil {
+			return fmt.Errorf("mute button %d: %w", buttonIdx, err)
+		}
+
+	case len(levels) == 3 && levels[0] == mqttTopicSession:
+		sessions := []Session{}
+		for _, session := range m.allSessions() {
+			if mqttTopicSegment(session.Key()) == levels[1] {
+				sessions = append(sessions, session)
+			}
+		}
+
+		if len(sessions) == 0 {
+			return fmt.Errorf("no sessions for '%s'", levels[1])
+		}
+
+		switch levels[2] {
+		case mqttTopicVolume:
+			volume, err := parseMQTTPercent(payload)
+			if err != nil {
+				return err
+			}
+
+			for _, session := range sessions {
+				if err := session.SetVolume(volume); err != nil {
+					return fmt.Errorf("set volume: %w", err)
+				}
+			}
+
+		case mqttTopicMute:
+			mute, err := parseMQTTSwitch(payload)
+			if err != nil {
+				return err
+			}
+
+			for _, session := range sessions {
+				if err := session.SetMute(mute); err != nil {
+					return fmt.Errorf("set mute: %w", err)
+				}
+			}
+
+			m.requestStatePush()
+
+		default:
+			return fmt.Errorf("unknown session topic '%s'", levels[2])
+		}
+
+	case len(levels) == 1 && levels[0] == mqttTopicOutputDevice:
+		deviceIdx := -1
+
+		b.deej.config.AvailableOutputDeviceMapping.iterate(func(idx int, names []string) {
+			for _, name := range names {
+				if strings.EqualFold(name, payload) || strconv.Itoa(idx) == payload {
+					deviceIdx = idx
+				}
+			}
+		})
+
+		if deviceIdx < 0 {
+			return fmt.Errorf("no output device '%s' in available_output_device", payload)
+		}
+
+		if b.toggleOutputDeviceConsumer == nil {
+			return errors.New("no toggle output device consumer registered")
+		}
+
+		if _, err := b.toggleOutputDeviceConsumer(ToggleOutoutDeviceClickEvent{selectedOutputDevice: deviceIdx}); err != nil {
+			return fmt.Errorf("switch output device: %w", err)
+		}
+
+	default:
+		return errors.New("unknown topic")
+	}
+
+	return nil
+}
+
+// mqttTopicSegment turns a session key into a single topic level, as keys can have characters that MQTT reserves
+func mqttTopicSegment(key string) string {
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(key)
+}
+
+func formatMQTTPercent(value float32) string {
+	return strconv.Itoa(int(math.Round(float64(value) * 100)))
+}
+
+// parseMQTTPercent parses a volume from 0 to 100
+func parseMQTTPercent(payload string) (float32, error) {
+	percent, err := strconv.ParseFloat(payload, 32)
+	if err != nil || percent < 0 || percent > 100 {
+		return 0, fmt.Errorf("expected a volume between 0 and 100, got '%s'", payload)
+	}
+
+	return float32(percent / 100), nil
+}
+
+// parseMQTTSwitch parses Home Assistant's ON and OFF, as well as anything strconv takes for a bool
+func parseMQTTSwitch(payload string) (bool, error) {
+	switch {
+	case strings.EqualFold(payload, mqttPayloadOn):
+		return true, nil
+	case strings.EqualFold(payload, mqttPayloadOff):
+		return false, nil
+	}
+
+	value, err := strconv.ParseBool(payload)
+	if err != nil {
+		return false, fmt.Errorf("expected %s or %s, got '%s'", mqttPayloadOn, mqttPayloadOff, payload)
+	}
+
+	return value, nil
+}
+
+// mqttConfigFromConfig reads the mqtt section of the user config
+func (cc *CanonicalConfig) mqttConfigFromConfig() (mqttConfig, error) {
+	config := mqttConfig{
+		Enabled:         cc.userConfig.GetBool(configKeyMQTTEnabled),
+		Broker:          cc.userConfig.GetString(configKeyMQTTBroker),
+		Username:        cc.userConfig.GetString(configKeyMQTTUsername),
+		Password:        cc.userConfig.GetString(configKeyMQTTPassword),
+		ClientID:        cc.userConfig.GetString(configKeyMQTTClientID),
+		TopicPrefix:     strings.Trim(cc.userConfig.GetString(configKeyMQTTTopicPrefix), "/"),
+		Discovery:       cc.userConfig.GetBool(configKeyMQTTDiscovery),
+		DiscoveryPrefix: strings.Trim(cc.userConfig.GetString(configKeyMQTTDiscoveryPrefix), "/"),
+	}
+
+	if !config.Enabled {
+		return config, nil
+	}
+
+	broker, err := url.Parse(config.Broker)
+	if err != nil || broker.Host == "" {
+		return mqttConfig{}, fmt.Errorf("invalid broker '%s', expected i.e. tcp://localhost:1883", config.Broker)
+	}
+
+	switch broker.Scheme {
+	case "tcp", "ssl", "tls", "ws", "wss":
+	default:
+		return mqttConfig{}, fmt.Errorf("unsupported broker scheme '%s', expected tcp, ssl, tls, ws or wss", broker.Scheme)
+	}
+
+	if config.ClientID == "" {
+		return mqttConfig{}, errors.New("missing client_id")
+	}
+
+	for name, prefix := range map[string]string{"topic_prefix": config.TopicPrefix, "discovery_prefix": config.DiscoveryPrefix} {
+		if prefix == "" || strings.ContainsAny(prefix, "+#") {
+			return mqttConfig{}, fmt.Errorf("invalid %s '%s'", name, prefix)
+		}
+	}
+
+	return config, nil
+}
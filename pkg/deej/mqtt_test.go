@@ -0,0 +1,523 @@
+package deej
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// testMQTTBroker is a minimal in-process MQTT 3.1.1 broker. it keeps retained messages and forwards
+// publishes to subscribers at QoS 0, which is all the bridge uses
+type testMQTTBroker struct {
+	listener net.Listener
+
+	retained      map[string]string
+	subscriptions map[net.Conn][]string
+	lock          sync.Mutex
+}
+
+func newTestMQTTBroker(t *testing.T) *testMQTTBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	broker := &testMQTTBroker{
+		listener:      listener,
+		retained:      map[string]string{},
+		subscriptions: map[net.Conn][]string{},
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go broker.serve(conn)
+		}
+	}()
+
+	t.Cleanup(func() {
+		listener.Close()
+
+		broker.lock.Lock()
+		for conn := range broker.subscriptions {
+			conn.Close()
+		}
+		broker.lock.Unlock()
+	})
+
+	return broker
+}
+
+func (b *testMQTTBroker) url() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+func (b *testMQTTBroker) serve(conn net.Conn) {
+	defer func() {
+		b.lock.Lock()
+		delete(b.subscriptions, conn)
+		b.lock.Unlock()
+
+		conn.Close()
+	}()
+
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+
+		b.lock.Lock()
+
+		switch p := packet.(type) {
+		case *packets.ConnectPacket:
+			b.subscriptions[conn] = nil
+			packets.NewControlPacket(packets.Connack).Write(conn)
+
+		case *packets.SubscribePacket:
+			b.subscriptions[conn] = append(b.subscriptions[conn], p.Topics...)
+
+			suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			suback.MessageID = p.MessageID
+			suback.ReturnCodes = make([]byte, len(p.Topics))
+			suback.Write(conn)
+
+		case *packets.PublishPacket:
+			if p.Retain {
+				b.retained[p.TopicName] = string(p.Payload)
+			}
+
+			b.forward(p.TopicName, string(p.Payload))
+
+			if p.Qos > 0 {
+				puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				puback.MessageID = p.MessageID
+				puback.Write(conn)
+			}
+
+		case *packets.PingreqPacket:
+			packets.NewControlPacket(packets.Pingresp).Write(conn)
+
+		case *packets.DisconnectPacket:
+			b.lock.Unlock()
+			return
+		}
+
+		b.lock.Unlock()
+	}
+}
+
+// forward sends a message to every client with a matching subscription. the caller holds lock
+func (b *testMQTTBroker) forward(topic string, payload string) {
+	publish := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	publish.TopicName = topic
+	publish.Payload = []byte(payload)
+
+	for conn, filters := range b.subscriptions {
+		for _, filter := range filters {
+			if mqttTopicMatches(filter, topic) {
+				publish.Write(conn)
+				break
+			}
+		}
+	}
+}
+
+// send publishes a message to subscribers, as another client would
+func (b *testMQTTBroker) send(topic string, payload string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.forward(topic, payload)
+}
+
+// waitForRetained waits until a topic's retained message is the expected one, and fails the test otherwise
+func (b *testMQTTBroker) waitForRetained(t *testing.T, topic string, expected string) {
+	t.Helper()
+
+	var actual string
+	var ok bool
+
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		b.lock.Lock()
+		actual, ok = b.retained[topic]
+		b.lock.Unlock()
+
+		if ok && actual == expected {
+			return
+		}
+	}
+
+	t.Errorf("Expected '%s' retained on %s, got '%s' (retained: %v)", expected, topic, actual, ok)
+}
+
+// waitForSubscriptions waits until some client subscribed to the given number of filters
+func (b *testMQTTBroker) waitForSubscriptions(t *testing.T, numFilters int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		b.lock.Lock()
+		for _, filters := range b.subscriptions {
+			if len(filters) >= numFilters {
+				b.lock.Unlock()
+				return
+			}
+		}
+		b.lock.Unlock()
+	}
+
+	t.Fatalf("Timed out waiting for %d subscriptions", numFilters)
+}
+
+// mqttTopicMatches tells whether a topic matches a subscription filter with + and # wildcards
+func mqttTopicMatches(filter string, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	for idx, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+
+		if idx >= len(topicLevels) || (level != "+" && level != topicLevels[idx]) {
+			return false
+		}
+	}
+
+	return len(filterLevels) == len(topicLevels)
+}
+
+const testMQTTConfig = `
+slider_mapping:
+  0: master
+  1: spotify.exe
+mute_button_mapping:
+  0: mic
+available_output_device:
+  0: ["Speakers"]
+  1: ["Headphones"]
+profiles:
+  - name: calls
+    mute_button_mapping:
+      1: teams.exe
+mqtt:
+  enabled: true
+  broker: %s
+`
+
+// newTestMQTTBridge creates a bridge for a session map with the given sessions, connected to the broker.
+// its buttons go to the session map, and its slider moves to the returned channel
+func newTestMQTTBridge(t *testing.T, broker *testMQTTBroker, sf *fakeSessionFinder) (*mqttBridge, chan SliderMoveEvent) {
+	m := newTestSessionMap(t, fmt.Sprintf(testMQTTConfig, broker.url()), sf)
+	m.deej.sessions = m
+
+	b := newMQTTBridge(m.deej, zap.NewNop().Sugar())
+	b.setMuteButtonClickEventConsumer(m.handleMuteButtonClickedEventsAndGetState)
+	b.setToggleOutputDeviceEventConsumer(m.handleToggleOutputDeviceClickedEventAndGetState)
+	sliderMoves := b.SubscribeToSliderMoveEvents()
+
+	b.applyConfig()
+	t.Cleanup(b.disconnect)
+
+	if b.client == nil {
+		t.Fatal("Expected the bridge to connect")
+	}
+
+	// subscribing to commands is the last thing paho does before deej publishes
+	broker.waitForSubscriptions(t, 5)
+	<-b.republish
+
+	return b, sliderMoves
+}
+
+// TestMQTTConfig tests reading the mqtt section, which only needs a broker to be enabled
+func TestMQTTConfig(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, "mqtt:\n  enabled: true\n  topic_prefix: /home/deej/\n")
+	defer cleanup()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	expected := mqttConfig{
+		Enabled:         true,
+		Broker:          defaultMQTTBroker,
+		ClientID:        defaultMQTTClientID,
+		TopicPrefix:     "home/deej",
+		Discovery:       true,
+		DiscoveryPrefix: defaultMQTTDiscoveryPrefix,
+	}
+
+	if deej.config.MQTT != expected {
+		t.Errorf("Expected %+v, got %+v", expected, deej.config.MQTT)
+	}
+
+	for _, test := range []struct {
+		mqtt  string
+		fails bool
+	}{
+		{"mqtt:\n  enabled: false\n  broker: nonsense", false},
+		{"mqtt:\n  enabled: true\n  broker: ssl://broker.local:8883\n  username: deej\n  password: secret", false},
+		{"mqtt:\n  enabled: true\n  broker: broker.local:1883", true},
+		{"mqtt:\n  enabled: true\n  broker: http://broker.local", true},
+		{"mqtt:\n  enabled: true\n  client_id: ''", true},
+		{"mqtt:\n  enabled: true\n  topic_prefix: deej/#", true},
+		{"mqtt:\n  enabled: true\n  discovery_prefix: /", true},
+	} {
+		cleanup := createTestConfig(t, test.mqtt+"\n")
+
+		if err := deej.config.Load(); (err != nil) != test.fails {
+			t.Errorf("'%s': expected failure %v, got %v", test.mqtt, test.fails, err)
+		}
+
+		cleanup()
+	}
+}
+
+// TestMQTTPublish tests that sliders, mute buttons, mapped sessions and the output device are published,
+// along with their Home Assistant discovery payloads
+func TestMQTTPublish(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	master := newFakeSession(logger, masterSessionName)
+	spotify := newFakeSession(logger, "spotify.exe")
+	chrome := newFakeSession(logger, "chrome.exe")
+	mic := newFakeSession(logger, inputSessionName)
+	mic.mute = true
+	spotify.volume = 0.42
+
+	broker := newTestMQTTBroker(t)
+	sf := &fakeSessionFinder{sessions: []Session{master, spotify, chrome, mic}, currentOutputDevice: []string{"headphones"}}
+	b, _ := newTestMQTTBridge(t, broker, sf)
+
+	b.recordSliderValue(SliderMoveEvent{SliderID: 1, PercentValue: 0.333})
+	b.publishAll()
+
+	for topic, expected := range map[string]string{
+		"deej/status":                     mqttPayloadOnline,
+		"deej/slider/1":                   "33",
+		"deej/mute_button/0":              mqttPayloadOn,
+		"deej/mute_button/1":              mqttPayloadOff,
+		"deej/session/spotify.exe/volume": "42",
+		"deej/session/spotify.exe/mute":   mqttPayloadOff,
+		"deej/session/master/volume":      "100",
+		"deej/session/mic/mute":           mqttPayloadOn,
+		"deej/output_device":              "Headphones",
+	} {
+		broker.waitForRetained(t, topic, expected)
+	}
+
+	broker.lock.Lock()
+	_, chromePublished := broker.retained["deej/session/chrome.exe/volume"]
+	broker.lock.Unlock()
+
+	if chromePublished {
+		t.Error("Expected unmapped sessions not to be published")
+	}
+
+	// the mute button that only a profile has is announced too
+	for _, topic := range []string{
+		"homeassistant/number/deej/slider_0/config",
+		"homeassistant/number/deej/slider_1/config",
+		"homeassistant/switch/deej/mute_button_0/config",
+		"homeassistant/switch/deej/mute_button_1/config",
+		"homeassistant/select/deej/output_device/config",
+	} {
+		broker.waitForRetained(t, topic, b.published[topic])
+
+		entity := mqttDiscoveryEntity{}
+		if err := json.Unmarshal([]byte(b.published[topic]), &entity); err != nil {
+			t.Errorf("Failed to decode discovery payload on %s: %v", topic, err)
+			continue
+		}
+
+		if !strings.HasPrefix(entity.StateTopic, "deej/") || entity.CommandTopic != entity.StateTopic+"/set" ||
+			entity.AvailabilityTopic != "deej/status" || entity.Device.Identifiers[0] != "deej" {
+
+			t.Errorf("Unexpected discovery payload on %s: %+v", topic, entity)
+		}
+	}
+
+	outputDevice := mqttDiscoveryEntity{}
+	json.Unmarshal([]byte(b.published["homeassistant/select/deej/output_device/config"]), &outputDevice)
+
+	if options := strings.Join(outputDevice.Options, ","); options != "Speakers,Headphones" {
+		t.Errorf("Expected output device options 'Speakers,Headphones', got '%s'", options)
+	}
+
+	// entities that the config doesn't have anymore are taken back
+	b.config.Discovery = false
+	b.publishDiscovery()
+
+	broker.waitForRetained(t, "homeassistant/number/deej/slider_0/config", "")
+
+	b.disconnect()
+
+	broker.waitForRetained(t, "deej/status", mqttPayloadOffline)
+}
+
+// TestMQTTCommands tests that commands on the "/set" topics change sliders, mute buttons, sessions and the output device
+func TestMQTTCommands(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	master := newFakeSession(logger, masterSessionName)
+	spotify := newFakeSession(logger, "spotify.exe")
+	mic := newFakeSession(logger, inputSessionName)
+
+	broker := newTestMQTTBroker(t)
+	sf := &fakeSessionFinder{sessions: []Session{master, spotify, mic}, currentOutputDevice: []string{"Speakers"}}
+	b, sliderMoves := newTestMQTTBridge(t, broker, sf)
+
+	// commands arrive from the broker on paho's goroutines, and slider commands move the slider like any controller
+	broker.send("deej/slider/1/set", "25")
+
+	select {
+	case event := <-sliderMoves:
+		if event.SliderID != 1 || event.PercentValue != 0.25 {
+			t.Fatalf("Expected slider 1 to move to 0.25, got %+v", event)
+		}
+
+		b.deej.sessions.handleSliderMoveEvent(event)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the slider command")
+	}
+
+	if spotify.volume != 0.25 {
+		t.Errorf("Expected the slider command to move spotify to 0.25, got %.2f", spotify.volume)
+	}
+
+	for _, test := range []struct {
+		topic   string
+		payload string
+	}{
+		{"deej/mute_button/0/set", "ON"},
+		{"deej/session/master/volume/set", "60"},
+		{"deej/session/spotify.exe/mute/set", "true"},
+		{"deej/output_device/set", "HEADPHONES"},
+
+		// none of these change anything
+		{"deej/session/chrome.exe/volume/set", "10"},
+		{"deej/session/master/volume/set", "150"},
+		{"deej/session/master/balance/set", "50"},
+		{"deej/mute_button/x/set", "ON"},
+		{"deej/slider/0/set", "loud"},
+		{"deej/output_device/set", "Monitor"},
+		{"deej/output_device", "Speakers"},
+	} {
+		b.handleCommand(b.config, test.topic, test.payload)
+	}
+
+	if !mic.mute || master.volume != 0.6 || !spotify.mute {
+		t.Errorf("Expected a muted mic, master at 0.60 and a muted spotify, got %v, %.2f and %v", mic.mute, master.volume, spotify.mute)
+	}
+
+	if len(sf.switchedTo) != 1 || sf.switchedTo[0] != "Headphones" {
+		t.Errorf("Expected a single switch to the headphones, got %v", sf.switchedTo)
+	}
+
+	b.handleCommand(b.config, "deej/output_device/set", "0")
+
+	if len(sf.switchedTo) != 2 || sf.switchedTo[1] != "Speakers" {
+		t.Errorf("Expected a switch to the speakers by index, got %v", sf.switchedTo)
+	}
+}
+
+// fakeSlidersController stands in for the controller, and lets the test move its sliders
+type fakeSlidersController struct {
+	sliderMoves chan SliderMoveEvent
+}
+
+func (c *fakeSlidersController) Start() error {
+	return nil
+}
+
+func (c *fakeSlidersController) Stop() {}
+
+func (c *fakeSlidersController) SubscribeToSliderMoveEvents() chan SliderMoveEvent {
+	return c.sliderMoves
+}
+
+// TestMQTTSlidersWhileConnecting tests that the sliders keep moving while the broker takes its time to answer
+func TestMQTTSlidersWhileConnecting(t *testing.T) {
+	// a broker that takes connections and never answers them
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			defer conn.Close()
+		}
+	}()
+
+	m := newTestSessionMap(t, fmt.Sprintf(testMQTTConfig, "tcp://"+listener.Addr().String()), &fakeSessionFinder{})
+	m.deej.sessions = m
+
+	sliders := &fakeSlidersController{sliderMoves: make(chan SliderMoveEvent)}
+	m.deej.deejSlidersController = sliders
+
+	b := newMQTTBridge(m.deej, zap.NewNop().Sugar())
+
+	start := time.Now()
+	b.initialize()
+
+	if time.Since(start) > time.Second {
+		t.Error("Expected initialize not to wait for the broker")
+	}
+
+	for sliderIdx := 0; sliderIdx < 3; sliderIdx++ {
+		select {
+		case sliders.sliderMoves <- SliderMoveEvent{SliderID: sliderIdx, PercentValue: 0.5}:
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out moving slider %d while the bridge connects", sliderIdx)
+		}
+	}
+}
+
+// TestMQTTStop tests that stopping the bridge has it go offline, and that starting it again connects again
+func TestMQTTStop(t *testing.T) {
+	broker := newTestMQTTBroker(t)
+
+	m := newTestSessionMap(t, fmt.Sprintf(testMQTTConfig, broker.url()), &fakeSessionFinder{})
+	m.deej.sessions = m
+
+	b := newMQTTBridge(m.deej, zap.NewNop().Sugar())
+	b.initialize()
+	defer b.Stop()
+
+	broker.waitForRetained(t, "deej/status", mqttPayloadOnline)
+
+	b.Stop()
+	broker.waitForRetained(t, "deej/status", mqttPayloadOffline)
+
+	// stopping twice does nothing
+	b.Stop()
+
+	if err := b.Start(); err != nil {
+		t.Fatalf("Failed to start again: %v", err)
+	}
+
+	broker.waitForRetained(t, "deej/status", mqttPayloadOnline)
+}
@@ -0,0 +1,533 @@
+package deej
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// the OSC server lets apps like TouchOSC act as a second controller: it takes slider moves, mute buttons and
+// output device switches as OSC messages over UDP, and sends the actual volumes back to every app that sent it
+// something, so that their faders follow changes made anywhere else
+const (
+	oscAddressPrefix       = "/deej/"
+	oscAddressSlider       = "slider"
+	oscAddressMute         = "mute"
+	oscAddressOutputDevice = "output_device"
+
+	defaultOSCAddress      = "0.0.0.0:8000"
+	defaultOSCFeedbackPort = 9000
+
+	// how often volumes are checked for changes to send back
+	oscFeedbackInterval = 200 * time.Millisecond
+
+	// a fader that's being dragged doesn't get feedback, which would make it jump back and forth
+	oscFeedbackHoldoff = 500 * time.Millisecond
+
+	// smaller volume changes aren't worth a packet
+	oscFeedbackThreshold = 0.01
+
+	maxOSCPacketSize = 65535
+)
+
+// oscConfig holds every config value of the OSC server. feedback goes to the feedback port of every
+// app that sent a message, and is off when that's 0
+type oscConfig struct {
+	Enabled      bool
+	Address      string
+	FeedbackPort int
+}
+
+// oscServer listens for OSC messages whenever it's enabled, and follows its config through reloads.
+// it's a slider and buttons controller, same as SerialIO
+type oscServer struct {
+	deej   *Deej
+	logger *zap.SugaredLogger
+
+	config oscConfig
+	conn   *net.UDPConn
+
+	muteButtonsConsumer        MuteButtonConsumer
+	toggleOutputDeviceConsumer ToggleOutputDeviceConsumer
+
+	sliderMoveConsumers        []chan SliderMoveEvent
+	muteButtonEventConsumers   []chan MuteButtonClickEvent
+	outputDeviceEventConsumers []chan int
+	connectionEventConsumers   []chan ConnectionEvent
+
+	// the apps that get feedback by their IP, what they were sent last and when each slider last moved over OSC
+	clients          map[string]net.IP
+	sentSliders      map[int]float32
+	sentMuteButtons  map[int]bool
+	sentOutputDevice int
+	sliderMoves      map[int]time.Time
+	feedbackLock     sync.Mutex
+}
+
+func newOSCServer(deej *Deej, logger *zap.SugaredLogger) *oscServer {
+	logger = logger.Named("osc")
+
+	o := &oscServer{
+		deej:   deej,
+		logger: logger,
+	}
+
+	o.resetFeedback()
+
+	logger.Debug("Created OSC server instance")
+
+	return o
+}
+
+// initialize starts listening if OSC is enabled, and keeps sending feedback and following config reloads
+func (o *oscServer) initialize() {
+	configReloadedChannel := o.deej.config.SubscribeToChanges()
+
+	o.applyConfig()
+
+	go func() {
+		ticker := time.NewTicker(oscFeedbackInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				o.sendFeedback()
+			case <-configReloadedChannel:
+				o.applyConfig()
+			}
+		}
+	}()
+}
+
+// applyConfig starts, stops or restarts the server when its config changed
+func (o *oscServer) applyConfig() {
+	config := o.deej.config.OSC
+	if config == o.config {
+		return
+	}
+
+	o.Stop()
+
+	o.feedbackLock.Lock()
+	o.config = config
+	o.feedbackLock.Unlock()
+
+	if !config.Enabled {
+		return
+	}
+
+	if err := o.Start(); err != nil {
+		o.logger.Warnw("Failed to start OSC server", "address", config.Address, "error", err)
+		o.deej.notifier.Notify("Can't start OSC!",
+			fmt.Sprintf("Please check the osc section in %s: %s", userConfigFilepath, err))
+	}
+}
+
+// Start listens on the configured address and begins handling messages
+func (o *oscServer) Start() error {
+	if o.conn != nil {
+		return nil
+	}
+
+	address, err := net.ResolveUDPAddr("udp", o.config.Address)
+	if err != nil {
+		return fmt.Errorf("resolve %s: %w", o.config.Address, err)
+	}
+
+	conn, err := net.ListenUDP("udp", address)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", o.config.Address, err)
+	}
+
+	o.feedbackLock.Lock()
+	o.conn = conn
+	o.feedbackLock.Unlock()
+
+	go o.readLoop(conn)
+
+	o.logger.Infow("Listening for OSC", "address", conn.LocalAddr())
+	o.broadcastConnection(true)
+
+	return nil
+}
+
+// Stop stops listening, and forgets the apps that got feedback
+func (o *oscServer) Stop() {
+	if o.conn == nil {
+		return
+	}
+
+	o.feedbackLock.Lock()
+	conn := o.conn
+	o.conn = nil
+	o.feedbackLock.Unlock()
+
+	conn.Close()
+
+	o.resetFeedback()
+
+	o.logger.Debug("Stopped OSC server")
+	o.broadcastConnection(false)
+}
+
+// SubscribeToSliderMoveEvents returns an unbuffered channel that receives
+// a sliderMoveEvent struct every time a slider moves over OSC
+func (o *oscServer) SubscribeToSliderMoveEvents() chan SliderMoveEvent {
+	ch := make(chan SliderMoveEvent)
+	o.sliderMoveConsumers = append(o.sliderMoveConsumers, ch)
+
+	return ch
+}
+
+// SubscribeToMuteButtonEvents returns an unbuffered channel that receives
+// mute button clicks once they were handled
+func (o *oscServer) SubscribeToMuteButtonEvents() chan MuteButtonClickEvent {
+	ch := make(chan MuteButtonClickEvent)
+	o.muteButtonEventConsumers = append(o.muteButtonEventConsumers, ch)
+
+	return ch
+}
+
+// SubscribeToOutputDeviceEvents returns an unbuffered channel that receives the index of
+// the output device that's the default after an app switched it
+func (o *oscServer) SubscribeToOutputDeviceEvents() chan int {
+	ch := make(chan int)
+	o.outputDeviceEventConsumers = append(o.outputDeviceEventConsumers, ch)
+
+	return ch
+}
+
+// SubscribeToConnectionEvents returns an unbuffered channel that receives
+// an event whenever the server starts or stops listening
+func (o *oscServer) SubscribeToConnectionEvents() chan ConnectionEvent {
+	ch := make(chan ConnectionEvent)
+	o.connectionEventConsumers = append(o.connectionEventConsumers, ch)
+
+	return ch
+}
+
+func (o *oscServer) setMuteButtonClickEventConsumer(consumer MuteButtonConsumer) {
+	o.muteButtonsConsumer = consumer
+}
+
+func (o *oscServer) setToggleOutputDeviceEventConsumer(consumer ToggleOutputDeviceConsumer) {
+	o.toggleOutputDeviceConsumer = consumer
+}
+
+func (o *oscServer) readLoop(conn *net.UDPConn) {
+	buffer := make([]byte, maxOSCPacketSize)
+
+	for {
+		n, sender, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+
+			// closing the connection is how the server stops, which isn't worth a warning
+			o.feedbackLock.Lock()
+			stopped := o.conn != conn
+			o.feedbackLock.Unlock()
+
+			if !stopped {
+				o.logger.Warnw("Failed to read OSC packet", "error", err)
+			}
+
+			return
+		}
+
+		messages, err := parseOSCPacket(buffer[:n])
+		if err != nil {
+			o.logger.Debugw("Ignoring invalid OSC packet", "sender", sender, "error", err)
+			continue
+		}
+
+		o.addClient(sender)
+
+		for _, message := range messages {
+			o.handleMessage(message)
+		}
+	}
+}
+
+// handleMessage turns a message into the slider move, mute button click or output device switch it stands for
+func (o *oscServer) handleMessage(message oscMessage) {
+	if o.deej.Verbose() {
+		o.logger.Debugw("Got OSC message", "address", message.Address, "arguments", message.Arguments)
+	}
+
+	if !strings.HasPrefix(message.Address, oscAddressPrefix) {
+		o.logger.Debugw("Ignoring OSC message outside of deej", "address", message.Address)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(message.Address, oscAddressPrefix), "/")
+
+	value, ok := message.number()
+	if !ok {
+		o.logger.Warnw("Ignoring OSC message without a number", "address", message.Address)
+		return
+	}
+
+	switch {
+	case len(parts) == 2 && parts[0] == oscAddressSlider:
+		if sliderIdx, err := strconv.Atoi(parts[1]); err == nil && sliderIdx >= 0 {
+			o.handleSlider(sliderIdx, value)
+			return
+		}
+
+	case len(parts) == 2 && parts[0] == oscAddressMute:
+		if buttonIdx, err := strconv.Atoi(parts[1]); err == nil && buttonIdx >= 0 {
+			o.handleMuteButton(buttonIdx, value >= 0.5)
+			return
+		}
+
+	case len(parts) == 1 && parts[0] == oscAddressOutputDevice:
+		o.handleOutputDevice(int(math.Round(value)))
+		return
+	}
+
+	o.logger.Debugw("Ignoring unknown OSC address", "address", message.Address)
+}
+
+func (o *oscServer) handleSlider(sliderIdx int, value float64) {
+	if value < 0 {
+		value = 0
+	} else if value > 1 {
+		value = 1
+	}
+
+	event := SliderMoveEvent{
+		SliderID:     sliderIdx,
+		PercentValue: float32(value),
+	}
+
+	o.feedbackLock.Lock()
+	o.sliderMoves[sliderIdx] = time.Now()
+	o.feedbackLock.Unlock()
+
+	for _, consumer := range o.sliderMoveConsumers {
+		consumer <- event
+	}
+}
+
+func (o *oscServer) handleMuteButton(buttonIdx int, mute bool) {
+	if o.muteButtonsConsumer == nil {
+		o.logger.Warn("No mute button consumer registered")
+		return
+	}
+
+	event := MuteButtonClickEvent{
+		MuteButtonID: buttonIdx,
+		mute:         mute,
+	}
+
+	state, err := o.muteButtonsConsumer([]MuteButtonClickEvent{event})
+	if err != nil {
+		o.logger.Warnw("Failed to handle mute button", "event", event, "error", err)
+		return
+	}
+
+	// the next feedback reports the state the button actually ended up in, even if it's the one it was sent
+	o.feedbackLock.Lock()
+	delete(o.sentMuteButtons, buttonIdx)
+	o.feedbackLock.Unlock()
+
+	if len(state.MuteButtons) > 0 {
+		event.mute = state.MuteButtons[0]
+	}
+
+	for _, consumer := range o.muteButtonEventConsumers {
+		consumer <- event
+	}
+}
+
+func (o *oscServer) handleOutputDevice(deviceIdx int) {
+	if o.toggleOutputDeviceConsumer == nil {
+		o.logger.Warn("No toggle output device consumer registered")
+		return
+	}
+
+	state, err := o.toggleOutputDeviceConsumer(ToggleOutoutDeviceClickEvent{selectedOutputDevice: deviceIdx})
+	if err != nil {
+		o.logger.Warnw("Failed to switch output device", "index", deviceIdx, "error", err)
+		return
+	}
+
+	for _, consumer := range o.outputDeviceEventConsumers {
+		consumer <- state.selectedOutputDevice
+	}
+}
+
+// addClient starts sending feedback to an app that sent a message, along with everything it missed
+func (o *oscServer) addClient(sender *net.UDPAddr) {
+	o.feedbackLock.Lock()
+	defer o.feedbackLock.Unlock()
+
+	if _, ok := o.clients[sender.IP.String()]; ok {
+		return
+	}
+
+	o.logger.Infow("Sending OSC feedback to new app", "ip", sender.IP, "port", o.config.FeedbackPort)
+
+	o.clients[sender.IP.String()] = sender.IP
+
+	// forget what was sent, so that the next feedback includes everything
+	o.sentSliders = map[int]float32{}
+	o.sentMuteButtons = map[int]bool{}
+	o.sentOutputDevice = -1
+}
+
+func (o *oscServer) resetFeedback() {
+	o.feedbackLock.Lock()
+	defer o.feedbackLock.Unlock()
+
+	o.clients = map[string]net.IP{}
+	o.sentSliders = map[int]float32{}
+	o.sentMuteButtons = map[int]bool{}
+	o.sentOutputDevice = -1
+	o.sliderMoves = map[int]time.Time{}
+}
+
+// sendFeedback sends the slider positions that match the volume of their targets, and the state of every mute
+// button and the output device, where they changed since they were last sent
+func (o *oscServer) sendFeedback() {
+	o.feedbackLock.Lock()
+	conn, config, numClients := o.conn, o.config, len(o.clients)
+	o.feedbackLock.Unlock()
+
+	if conn == nil || config.FeedbackPort == 0 || numClients == 0 {
+		return
+	}
+
+	messages := []oscMessage{}
+
+	sliderPositions := o.sliderPositions()
+	state := o.deej.sessions.getControllerState()
+
+	o.feedbackLock.Lock()
+
+	for sliderIdx, position := range sliderPositions {
+		if moved, ok := o.sliderMoves[sliderIdx]; ok && time.Since(moved) < oscFeedbackHoldoff {
+			continue
+		}
+
+		if sent, ok := o.sentSliders[sliderIdx]; ok && absDistance(sent, position) < oscFeedbackThreshold {
+			continue
+		}
+
+		o.sentSliders[sliderIdx] = position
+		messages = append(messages, oscMessage{
+			Address:   fmt.Sprintf("%s%s/%d", oscAddressPrefix, oscAddressSlider, sliderIdx),
+			Arguments: []interface{}{position},
+		})
+	}
+
+	for buttonIdx, mute := range state.MuteButtons {
+		if sent, ok := o.sentMuteButtons[buttonIdx]; ok && sent == mute {
+			continue
+		}
+
+		o.sentMuteButtons[buttonIdx] = mute
+		messages = append(messages, oscMessage{
+			Address:   fmt.Sprintf("%s%s/%d", oscAddressPrefix, oscAddressMute, buttonIdx),
+			Arguments: []interface{}{oscSwitchValue(mute)},
+		})
+	}
+
+	if state.OutputDevice >= 0 && state.OutputDevice != o.sentOutputDevice {
+		o.sentOutputDevice = state.OutputDevice
+		messages = append(messages, oscMessage{
+			Address:   oscAddressPrefix + oscAddressOutputDevice,
+			Arguments: []interface{}{int32(state.OutputDevice)},
+		})
+	}
+
+	clients := []net.IP{}
+	for _, ip := range o.clients {
+		clients = append(clients, ip)
+	}
+
+	o.feedbackLock.Unlock()
+
+	for _, message := range messages {
+		packet, err := encodeOSCMessage(message)
+		if err != nil {
+			o.logger.Warnw("Failed to encode OSC feedback", "message", message, "error", err)
+			continue
+		}
+
+		for _, ip := range clients {
+			if _, err := conn.WriteToUDP(packet, &net.UDPAddr{IP: ip, Port: config.FeedbackPort}); err != nil {
+				o.logger.Debugw("Failed to send OSC feedback", "ip", ip, "error", err)
+			}
+		}
+	}
+}
+
+// sliderPositions returns where every mapped slider has to be to match the volume of its targets,
+// going back through its volume curve. sliders whose targets have no sessions are left out
+func (o *oscServer) sliderPositions() map[int]float32 {
+	positions := map[int]float32{}
+
+	for sliderIdx := 0; sliderIdx < o.deej.config.numMappedSliders(); sliderIdx++ {
+		targets, ok := o.deej.sliderTargets(sliderIdx)
+		if !ok {
+			continue
+		}
+
+		volume, ok := o.deej.sessions.targetsVolume(targets)
+		if !ok {
+			continue
+		}
+
+		position := o.deej.config.SliderCurves.forSlider(sliderIdx).position(volume)
+		positions[sliderIdx] = float32(math.Round(float64(position)*100) / 100)
+	}
+
+	return positions
+}
+
+// oscSwitchValue is how switches are sent: as a float, which every app's toggle buttons understand
+func oscSwitchValue(on bool) float32 {
+	if on {
+		return 1
+	}
+
+	return 0
+}
+
+// broadcastConnection lets consumers know that the server started or stopped listening
+func (o *oscServer) broadcastConnection(connected bool) {
+	event := ConnectionEvent{Connected: connected, Transport: "osc://" + o.config.Address}
+
+	for _, consumer := range o.connectionEventConsumers {
+		consumer <- event
+	}
+}
+
+func (cc *CanonicalConfig) oscConfigFromConfig() (oscConfig, error) {
+	config := oscConfig{
+		Enabled:      cc.userConfig.GetBool(configKeyOSCEnabled),
+		Address:      cc.userConfig.GetString(configKeyOSCAddress),
+		FeedbackPort: cc.userConfig.GetInt(configKeyOSCFeedbackPort),
+	}
+
+	if !config.Enabled {
+		return config, nil
+	}
+
+	if _, err := net.ResolveUDPAddr("udp", config.Address); err != nil {
+		return oscConfig{}, fmt.Errorf("invalid address '%s': %w", config.Address, err)
+	}
+
+	if config.FeedbackPort < 0 || config.FeedbackPort > math.MaxUint16 {
+		return oscConfig{}, fmt.Errorf("invalid feedback port %d", config.FeedbackPort)
+	}
+
+	return config, nil
+}
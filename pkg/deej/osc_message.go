@@ -0,0 +1,285 @@
+package deej
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// OSC packets are either a single message, or a bundle of messages and other bundles. a message is an address
+// pattern, a type tag string and the arguments it describes, with strings padded to 4 bytes with zeros.
+// deej only needs numbers and booleans, but skips over every standard type so that other arguments don't break parsing
+const (
+	oscBundleTag = "#bundle"
+
+	oscTypeInt32   = 'i'
+	oscTypeFloat32 = 'f'
+	oscTypeString  = 's'
+	oscTypeBlob    = 'b'
+	oscTypeInt64   = 'h'
+	oscTypeTime    = 't'
+	oscTypeFloat64 = 'd'
+	oscTypeSymbol  = 'S'
+	oscTypeChar    = 'c'
+	oscTypeColor   = 'r'
+	oscTypeMIDI    = 'm'
+	oscTypeTrue    = 'T'
+	oscTypeFalse   = 'F'
+	oscTypeNil     = 'N'
+	oscTypeImpulse = 'I'
+
+	// bundles in bundles in bundles are fine, but not without end
+	maxOSCBundleDepth = 8
+)
+
+var errInvalidOSCPacket = errors.New("invalid OSC packet")
+
+// oscMessage is a single OSC message, with its arguments decoded into int32, int64, float32, float64,
+// string, []byte, bool or nil
+type oscMessage struct {
+	Address   string
+	Arguments []interface{}
+}
+
+// parseOSCPacket returns every message in a packet, in order
+func parseOSCPacket(packet []byte) ([]oscMessage, error) {
+	return parseOSCPacketAtDepth(packet, 0)
+}
+
+func parseOSCPacketAtDepth(packet []byte, depth int) ([]oscMessage, error) {
+	if depth > maxOSCBundleDepth {
+		return nil, fmt.Errorf("%w: bundles nested too deeply", errInvalidOSCPacket)
+	}
+
+	reader := bytes.NewReader(packet)
+
+	address, err := readOSCString(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if address != oscBundleTag {
+		message, err := parseOSCMessage(address, reader)
+		if err != nil {
+			return nil, err
+		}
+
+		return []oscMessage{message}, nil
+	}
+
+	// bundles are delivered right away, so their time tag doesn't matter
+	if reader.Len() < 8 {
+		return nil, fmt.Errorf("%w: truncated bundle", errInvalidOSCPacket)
+	}
+
+	reader.Seek(8, io.SeekCurrent)
+
+	messages := []oscMessage{}
+
+	for reader.Len() > 0 {
+		var size int32
+		if err := binary.Read(reader, binary.BigEndian, &size); err != nil || size < 0 || int(size) > reader.Len() {
+			return nil, fmt.Errorf("%w: invalid bundle element size", errInvalidOSCPacket)
+		}
+
+		element := make([]byte, size)
+		reader.Read(element)
+
+		elementMessages, err := parseOSCPacketAtDepth(element, depth+1)
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, elementMessages...)
+	}
+
+	return messages, nil
+}
+
+func parseOSCMessage(address string, reader *bytes.Reader) (oscMessage, error) {
+	if len(address) == 0 || address[0] != '/' {
+		return oscMessage{}, fmt.Errorf("%w: invalid address '%s'", errInvalidOSCPacket, address)
+	}
+
+	message := oscMessage{Address: address, Arguments: []interface{}{}}
+
+	// very old senders leave out the type tags, and with them any arguments
+	if reader.Len() == 0 {
+		return message, nil
+	}
+
+	typeTags, err := readOSCString(reader)
+	if err != nil {
+		return oscMessage{}, err
+	}
+
+	if len(typeTags) == 0 || typeTags[0] != ',' {
+		return oscMessage{}, fmt.Errorf("%w: invalid type tags '%s'", errInvalidOSCPacket, typeTags)
+	}
+
+	for _, typeTag := range typeTags[1:] {
+		argument, err := readOSCArgument(reader, typeTag)
+		if err != nil {
+			return oscMessage{}, fmt.Errorf("%s: %w", address, err)
+		}
+
+		message.Arguments = append(message.Arguments, argument)
+	}
+
+	return message, nil
+}
+
+func readOSCArgument(reader *bytes.Reader, typeTag rune) (interface{}, error) {
+	switch typeTag {
+	case oscTypeInt32, oscTypeChar, oscTypeColor, oscTypeMIDI:
+		var value int32
+		err := binary.Read(reader, binary.BigEndian, &value)
+		return value, wrapOSCReadError(err, typeTag)
+
+	case oscTypeFloat32:
+		var value float32
+		err := binary.Read(reader, binary.BigEndian, &value)
+		return value, wrapOSCReadError(err, typeTag)
+
+	case oscTypeInt64, oscTypeTime:
+		var value int64
+		err := binary.Read(reader, binary.BigEndian, &value)
+		return value, wrapOSCReadError(err, typeTag)
+
+	case oscTypeFloat64:
+		var value float64
+		err := binary.Read(reader, binary.BigEndian, &value)
+		return value, wrapOSCReadError(err, typeTag)
+
+	case oscTypeString, oscTypeSymbol:
+		return readOSCString(reader)
+
+	case oscTypeBlob:
+		var size int32
+		if err := binary.Read(reader, binary.BigEndian, &size); err != nil || size < 0 || int(size) > reader.Len() {
+			return nil, fmt.Errorf("%w: invalid blob size", errInvalidOSCPacket)
+		}
+
+		blob := make([]byte, size)
+		reader.Read(blob)
+		reader.Seek(int64(oscPadding(int(size))), io.SeekCurrent)
+
+		return blob, nil
+
+	case oscTypeTrue:
+		return true, nil
+
+	case oscTypeFalse:
+		return false, nil
+
+	case oscTypeNil, oscTypeImpulse:
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown type tag '%c'", errInvalidOSCPacket, typeTag)
+}
+
+func wrapOSCReadError(err error, typeTag rune) error {
+	if err != nil {
+		return fmt.Errorf("%w: truncated '%c' argument", errInvalidOSCPacket, typeTag)
+	}
+
+	return nil
+}
+
+// readOSCString reads a zero-terminated string, and the padding after it
+func readOSCString(reader *bytes.Reader) (string, error) {
+	value := []byte{}
+
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return "", fmt.Errorf("%w: unterminated string", errInvalidOSCPacket)
+		}
+
+		if b == 0 {
+			break
+		}
+
+		value = append(value, b)
+	}
+
+	// the terminating zero counts towards the padding
+	reader.Seek(int64(oscPadding(len(value)+1)), io.SeekCurrent)
+
+	return string(value), nil
+}
+
+// oscPadding returns how many zeros make a field of the given length a multiple of 4 bytes
+func oscPadding(length int) int {
+	return (4 - length%4) % 4
+}
+
+// encodeOSCMessage encodes a message whose arguments are int32, float32, string or bool
+func encodeOSCMessage(message oscMessage) ([]byte, error) {
+	typeTags := []byte{','}
+	arguments := &bytes.Buffer{}
+
+	for _, argument := range message.Arguments {
+		switch value := argument.(type) {
+		case int32:
+			typeTags = append(typeTags, oscTypeInt32)
+			binary.Write(arguments, binary.BigEndian, value)
+		case float32:
+			typeTags = append(typeTags, oscTypeFloat32)
+			binary.Write(arguments, binary.BigEndian, value)
+		case string:
+			typeTags = append(typeTags, oscTypeString)
+			writeOSCString(arguments, value)
+		case bool:
+			if value {
+				typeTags = append(typeTags, oscTypeTrue)
+			} else {
+				typeTags = append(typeTags, oscTypeFalse)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported OSC argument %v (%T)", argument, argument)
+		}
+	}
+
+	packet := &bytes.Buffer{}
+	writeOSCString(packet, message.Address)
+	writeOSCString(packet, string(typeTags))
+	packet.Write(arguments.Bytes())
+
+	return packet.Bytes(), nil
+}
+
+func writeOSCString(buffer *bytes.Buffer, value string) {
+	buffer.WriteString(value)
+	buffer.Write(make([]byte, 1+oscPadding(len(value)+1)))
+}
+
+// number returns a message's first argument as a number, and false if it doesn't have a numeric one.
+// booleans count as 1 and 0, which is what toggle buttons send in some apps
+func (m oscMessage) number() (float64, bool) {
+	if len(m.Arguments) == 0 {
+		return 0, false
+	}
+
+	switch value := m.Arguments[0].(type) {
+	case int32:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	case float32:
+		return float64(value), true
+	case float64:
+		return value, true
+	case bool:
+		if value {
+			return 1, true
+		}
+
+		return 0, true
+	}
+
+	return 0, false
+}
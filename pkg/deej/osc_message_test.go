@@ -0,0 +1,133 @@
+package deej
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// oscBundle wraps encoded messages in a bundle, the way apps send several at once
+func oscBundle(elements ...[]byte) []byte {
+	bundle := &bytes.Buffer{}
+	writeOSCString(bundle, oscBundleTag)
+
+	// immediately
+	binary.Write(bundle, binary.BigEndian, uint64(1))
+
+	for _, element := range elements {
+		binary.Write(bundle, binary.BigEndian, int32(len(element)))
+		bundle.Write(element)
+	}
+
+	return bundle.Bytes()
+}
+
+func encodeTestOSCMessage(t *testing.T, address string, arguments ...interface{}) []byte {
+	packet, err := encodeOSCMessage(oscMessage{Address: address, Arguments: arguments})
+	if err != nil {
+		t.Fatalf("Failed to encode %s: %v", address, err)
+	}
+
+	return packet
+}
+
+// TestOSCMessageEncoding tests that encoded messages are padded as OSC expects, and parse back to themselves
+func TestOSCMessageEncoding(t *testing.T) {
+	packet := encodeTestOSCMessage(t, "/deej/mute/1", int32(1))
+
+	expected := append([]byte("/deej/mute/1\x00\x00\x00\x00,i\x00\x00"), 0, 0, 0, 1)
+	if !bytes.Equal(packet, expected) {
+		t.Errorf("Expected %q, got %q", expected, packet)
+	}
+
+	for _, message := range []oscMessage{
+		{Address: "/deej/slider/0", Arguments: []interface{}{float32(0.25)}},
+		{Address: "/a", Arguments: []interface{}{"abc", "abcd", int32(-3), true, false}},
+		{Address: "/no/arguments", Arguments: []interface{}{}},
+	} {
+		packet, err := encodeOSCMessage(message)
+		if err != nil {
+			t.Fatalf("%s: failed to encode: %v", message.Address, err)
+		}
+
+		if len(packet)%4 != 0 {
+			t.Errorf("%s: expected a multiple of 4 bytes, got %d", message.Address, len(packet))
+		}
+
+		parsed, err := parseOSCPacket(packet)
+		if err != nil {
+			t.Fatalf("%s: failed to parse: %v", message.Address, err)
+		}
+
+		if len(parsed) != 1 || !reflect.DeepEqual(parsed[0], message) {
+			t.Errorf("Expected %+v, got %+v", message, parsed)
+		}
+	}
+
+	if _, err := encodeOSCMessage(oscMessage{Address: "/a", Arguments: []interface{}{1}}); err == nil {
+		t.Error("Expected an int argument to be refused, OSC only has sized ones")
+	}
+}
+
+// TestOSCPacketParsing tests bundles, arguments that deej skips and packets that aren't valid OSC
+func TestOSCPacketParsing(t *testing.T) {
+	slider := encodeTestOSCMessage(t, "/deej/slider/0", float32(0.5))
+	mute := encodeTestOSCMessage(t, "/deej/mute/1", true)
+
+	messages, err := parseOSCPacket(oscBundle(slider, oscBundle(mute)))
+	if err != nil {
+		t.Fatalf("Failed to parse bundle: %v", err)
+	}
+
+	if len(messages) != 2 || messages[0].Address != "/deej/slider/0" || messages[1].Address != "/deej/mute/1" {
+		t.Errorf("Expected both messages in order, got %+v", messages)
+	}
+
+	// a double, a blob and a nil before the number that deej reads
+	other := &bytes.Buffer{}
+	writeOSCString(other, "/other")
+	writeOSCString(other, ",dbNi")
+	binary.Write(other, binary.BigEndian, float64(2.5))
+	binary.Write(other, binary.BigEndian, int32(5))
+	other.Write([]byte{1, 2, 3, 4, 5, 0, 0, 0})
+	binary.Write(other, binary.BigEndian, int32(7))
+
+	messages, err = parseOSCPacket(other.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to parse message with every kind of argument: %v", err)
+	}
+
+	expected := []interface{}{float64(2.5), []byte{1, 2, 3, 4, 5}, nil, int32(7)}
+	if !reflect.DeepEqual(messages[0].Arguments, expected) {
+		t.Errorf("Expected %v, got %v", expected, messages[0].Arguments)
+	}
+
+	if value, ok := messages[0].number(); !ok || value != 2.5 {
+		t.Errorf("Expected the first argument as a number, got %v (%v)", value, ok)
+	}
+
+	deeplyNested := slider
+	for depth := 0; depth <= maxOSCBundleDepth; depth++ {
+		deeplyNested = oscBundle(deeplyNested)
+	}
+
+	for _, test := range []struct {
+		name   string
+		packet []byte
+	}{
+		{"empty", []byte{}},
+		{"no address", encodeTestOSCMessage(t, "deej", int32(1))},
+		{"unterminated address", []byte("/deej")},
+		{"truncated argument", slider[:len(slider)-2]},
+		{"unknown type tag", []byte("/a\x00\x00,x\x00\x00")},
+		{"truncated bundle", []byte("#bundle\x00\x00\x00")},
+		{"oversized bundle element", append(oscBundle(slider)[:16], 0, 0, 1, 0)},
+		{"bundles nested too deeply", deeplyNested},
+	} {
+		if _, err := parseOSCPacket(test.packet); !errors.Is(err, errInvalidOSCPacket) {
+			t.Errorf("%s: expected an invalid packet, got %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package deej
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const testOSCConfig = `
+slider_mapping:
+  0: master
+  1: spotify.exe
+slider_curve:
+  1: log
+mute_button_mapping:
+  0: mic
+available_output_device:
+  0: ["Speakers"]
+  1: ["Headphones"]
+osc:
+  enabled: true
+  address: 127.0.0.1:0
+  feedback_port: %d
+`
+
+// newTestOSCServer creates a server for a session map with the given sessions, along with an app that
+// talks to it and listens for its feedback. the server starts listening once the test calls applyConfig
+func newTestOSCServer(t *testing.T, sf *fakeSessionFinder) (*oscServer, *net.UDPConn) {
+	app, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	t.Cleanup(func() { app.Close() })
+
+	m := newTestSessionMap(t, fmt.Sprintf(testOSCConfig, app.LocalAddr().(*net.UDPAddr).Port), sf)
+	m.deej.sessions = m
+
+	o := newOSCServer(m.deej, zap.NewNop().Sugar())
+	m.deej.osc = o
+
+	m.setupOnMuteButtonClicked()
+	m.setupOnToggleOutputDeviceButtonClicked()
+
+	return o, app
+}
+
+// startTestOSCServer starts listening, and stops again when the test is over
+func startTestOSCServer(t *testing.T, o *oscServer) {
+	o.applyConfig()
+	t.Cleanup(o.Stop)
+
+	if o.conn == nil {
+		t.Fatal("Expected the server to listen")
+	}
+}
+
+func sendOSC(t *testing.T, app *net.UDPConn, o *oscServer, address string, arguments ...interface{}) {
+	if _, err := app.WriteTo(encodeTestOSCMessage(t, address, arguments...), o.conn.LocalAddr()); err != nil {
+		t.Fatalf("Failed to send %s: %v", address, err)
+	}
+}
+
+// readOSCFeedback returns the next message that the app got, and false if none came
+func readOSCFeedback(t *testing.T, app *net.UDPConn, timeout time.Duration) (oscMessage, bool) {
+	buffer := make([]byte, maxOSCPacketSize)
+
+	app.SetReadDeadline(time.Now().Add(timeout))
+
+	n, err := app.Read(buffer)
+	if err != nil {
+		return oscMessage{}, false
+	}
+
+	messages, err := parseOSCPacket(buffer[:n])
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("Expected a single message, got %v (%v)", messages, err)
+	}
+
+	return messages[0], true
+}
+
+// TestOSCConfig tests reading the osc section, and refusing addresses and ports that can't work
+func TestOSCConfig(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, "osc:\n  enabled: true\n")
+	defer cleanup()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	expected := oscConfig{Enabled: true, Address: defaultOSCAddress, FeedbackPort: defaultOSCFeedbackPort}
+	if deej.config.OSC != expected {
+		t.Errorf("Expected %+v, got %+v", expected, deej.config.OSC)
+	}
+
+	for _, test := range []struct {
+		osc   string
+		fails bool
+	}{
+		{"osc:\n  enabled: false\n  address: nonsense", false},
+		{"osc:\n  enabled: true\n  address: :9001\n  feedback_port: 0", false},
+		{"osc:\n  enabled: true\n  address: nonsense", true},
+		{"osc:\n  enabled: true\n  feedback_port: 70000", true},
+	} {
+		cleanup := createTestConfig(t, test.osc+"\n")
+
+		if err := deej.config.Load(); (err != nil) != test.fails {
+			t.Errorf("'%s': expected failure %v, got %v", test.osc, test.fails, err)
+		}
+
+		cleanup()
+	}
+}
+
+// TestOSCServer tests that slider, mute and output device messages go through the same pipeline as the
+// controller's, and that messages deej doesn't know are ignored
+func TestOSCServer(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	mic := newFakeSession(logger, inputSessionName)
+	sf := &fakeSessionFinder{sessions: []Session{mic}, currentOutputDevice: []string{"Speakers"}}
+
+	o, app := newTestOSCServer(t, sf)
+
+	sliderMoves := o.SubscribeToSliderMoveEvents()
+	muteButtons := o.SubscribeToMuteButtonEvents()
+	outputDevices := o.SubscribeToOutputDeviceEvents()
+
+	startTestOSCServer(t, o)
+
+	expectSliderMove := func(expected SliderMoveEvent) {
+		select {
+		case event := <-sliderMoves:
+			if event != expected {
+				t.Errorf("Expected %+v, got %+v", expected, event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for %+v", expected)
+		}
+	}
+
+	sendOSC(t, app, o, "/deej/slider/1", float32(0.5))
+	expectSliderMove(SliderMoveEvent{SliderID: 1, PercentValue: 0.5})
+
+	sendOSC(t, app, o, "/deej/slider/0", float32(1.5))
+	expectSliderMove(SliderMoveEvent{SliderID: 0, PercentValue: 1})
+
+	// none of these move anything, so the next move is the one after them
+	sendOSC(t, app, o, "/other/slider/0", float32(0.1))
+	sendOSC(t, app, o, "/deej/slider/x", float32(0.1))
+	sendOSC(t, app, o, "/deej/slider/0", "loud")
+	app.WriteTo([]byte("not osc"), o.conn.LocalAddr())
+	sendOSC(t, app, o, "/deej/slider/0", int32(0))
+	expectSliderMove(SliderMoveEvent{SliderID: 0, PercentValue: 0})
+
+	if _, err := app.WriteTo(oscBundle(encodeTestOSCMessage(t, "/deej/mute/0", true)), o.conn.LocalAddr()); err != nil {
+		t.Fatalf("Failed to send bundle: %v", err)
+	}
+
+	select {
+	case event := <-muteButtons:
+		if event.MuteButtonID != 0 || !event.mute || !mic.mute {
+			t.Errorf("Expected the mic to mute, got %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the mute button")
+	}
+
+	sendOSC(t, app, o, "/deej/output_device", float32(1))
+
+	select {
+	case deviceIdx := <-outputDevices:
+		if deviceIdx != 1 || !reflect.DeepEqual(sf.switchedTo, []string{"Headphones"}) {
+			t.Errorf("Expected a switch to the headphones, got %d and %v", deviceIdx, sf.switchedTo)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the output device")
+	}
+}
+
+// TestOSCFeedback tests that apps get slider positions that match their targets' volumes through the
+// slider's curve, along with mute buttons and the output device, and then only what changed
+func TestOSCFeedback(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	master := newFakeSession(logger, masterSessionName)
+	spotify := newFakeSession(logger, "spotify.exe")
+	mic := newFakeSession(logger, inputSessionName)
+	master.volume = 0.5
+	spotify.volume = 0.51
+	mic.mute = true
+
+	sf := &fakeSessionFinder{sessions: []Session{master, spotify, mic}, currentOutputDevice: []string{"Headphones"}}
+	o, app := newTestOSCServer(t, sf)
+	startTestOSCServer(t, o)
+
+	// nobody to send it to yet
+	o.sendFeedback()
+	if message, ok := readOSCFeedback(t, app, 50*time.Millisecond); ok {
+		t.Fatalf("Expected no feedback before an app sent something, got %+v", message)
+	}
+
+	waitForOSC := func(condition func() bool) {
+		deadline := time.Now().Add(time.Second)
+
+		for {
+			o.feedbackLock.Lock()
+			done := condition()
+			o.feedbackLock.Unlock()
+
+			if done {
+				return
+			} else if time.Now().After(deadline) {
+				t.Fatal("Timed out waiting for the OSC server")
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	sendOSC(t, app, o, "/hello")
+	waitForOSC(func() bool { return len(o.clients) == 1 })
+
+	o.sendFeedback()
+
+	received := map[string]interface{}{}
+	for len(received) < 4 {
+		message, ok := readOSCFeedback(t, app, time.Second)
+		if !ok {
+			t.Fatalf("Timed out waiting for feedback, got %v", received)
+		}
+
+		received[message.Address] = message.Arguments[0]
+	}
+
+	expected := map[string]interface{}{
+		"/deej/slider/0":      float32(0.5),
+		"/deej/slider/1":      float32(0.25),
+		"/deej/mute/0":        float32(1),
+		"/deej/output_device": int32(1),
+	}
+
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("Expected %v, got %v", expected, received)
+	}
+
+	// only the change goes out the next time
+	master.volume = 0.8
+	o.sendFeedback()
+
+	if message, ok := readOSCFeedback(t, app, time.Second); !ok || message.Address != "/deej/slider/0" || message.Arguments[0] != float32(0.8) {
+		t.Errorf("Expected slider 0 to move to 0.8, got %+v", message)
+	}
+
+	if message, ok := readOSCFeedback(t, app, 50*time.Millisecond); ok {
+		t.Errorf("Expected nothing else to change, got %+v", message)
+	}
+
+	// a fader that's being dragged is left alone
+	sendOSC(t, app, o, "/deej/slider/0", float32(0.3))
+	waitForOSC(func() bool { _, moved := o.sliderMoves[0]; return moved })
+
+	master.volume = 0.1
+	o.sendFeedback()
+
+	if message, ok := readOSCFeedback(t, app, 50*time.Millisecond); ok {
+		t.Errorf("Expected no feedback for a slider that just moved, got %+v", message)
+	}
+}
@@ -0,0 +1,325 @@
+package deej
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/tomerhh/deej/pkg/deej/util"
+)
+
+// profiles switch slider and mute button mappings with the app that's in focus, i.e. a "calls" profile whose
+// sliders control Teams and Zoom while either of them is focused. a profile's mappings take precedence over
+// the active layer's, and sliders and buttons that it doesn't map keep their usual targets. once no profile's
+// process is focused, the usual mappings are back. the tray menu can also pin a profile (or none) manually
+const (
+	profileKeyName              = "name"
+	profileKeyProcesses         = "processes"
+	profileKeySliderMapping     = "slider_mapping"
+	profileKeyMuteButtonMapping = "mute_button_mapping"
+
+	// how often the focused app is checked against the profiles
+	foregroundProfilePollInterval = time.Millisecond * 500
+)
+
+// profile is a named set of mappings, which is active while one of its processes is in focus
+type profile struct {
+	Name      string
+	Processes []string
+
+	// only what the profile maps itself, see sliderTargets and muteButtonTargets
+	SliderMapping     *sliderMap
+	MuteButtonMapping *sliderMap
+}
+
+// profilesFromConfig reads the profiles from the user config
+func profilesFromConfig(userConfig *viper.Viper) ([]profile, error) {
+	profiles := []profile{}
+
+	if !userConfig.IsSet(configKeyProfiles) {
+		return profiles, nil
+	}
+
+	rawProfiles, ok := userConfig.Get(configKeyProfiles).([]interface{})
+	if !ok {
+		return nil, errors.New("expected a list of profiles")
+	}
+
+	names := map[string]bool{}
+
+	for idx, rawProfile := range rawProfiles {
+		fields, ok := stringKeyedMap(rawProfile)
+		if !ok {
+			return nil, fmt.Errorf("profile %d: expected %s, %s and mappings", idx, profileKeyName, profileKeyProcesses)
+		}
+
+		name, ok := fields[profileKeyName].(string)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("profile %d: missing %s", idx, profileKeyName)
+		}
+
+		if names[strings.ToLower(name)] {
+			return nil, fmt.Errorf("profile %d: %s '%s' is taken", idx, profileKeyName, name)
+		}
+
+		names[strings.ToLower(name)] = true
+
+		p := profile{Name: name, SliderMapping: newSliderMap(), MuteButtonMapping: newSliderMap()}
+
+		switch processes := fields[profileKeyProcesses].(type) {
+		case nil:
+		case string:
+			p.Processes = []string{processes}
+		case []interface{}:
+			for _, rawProcess := range processes {
+				process, ok := rawProcess.(string)
+				if !ok || process == "" {
+					return nil, fmt.Errorf("profile %s: invalid process '%v'", name, rawProcess)
+				}
+
+				p.Processes = append(p.Processes, process)
+			}
+		default:
+			return nil, fmt.Errorf("profile %s: invalid %s '%v'", name, profileKeyProcesses, processes)
+		}
+
+		for key, mapping := range map[string]**sliderMap{
+			profileKeySliderMapping:     &p.SliderMapping,
+			profileKeyMuteButtonMapping: &p.MuteButtonMapping,
+		} {
+			if _, ok := fields[key]; !ok {
+				continue
+			}
+
+			parsed, err := sliderMapFromValue(fields[key])
+			if err != nil {
+				return nil, fmt.Errorf("profile %s: %s: %w", name, key, err)
+			}
+
+			*mapping = parsed
+		}
+
+		profiles = append(profiles, p)
+	}
+
+	return profiles, nil
+}
+
+// matches returns true if any of the given process names is one of the profile's
+func (p profile) matches(processNames []string) bool {
+	for _, processName := range processNames {
+		for _, process := range p.Processes {
+			if strings.EqualFold(process, processName) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// profile returns the profile with the given name, and false if there's none
+func (cc *CanonicalConfig) profile(name string) (profile, bool) {
+	for _, p := range cc.Profiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+
+	return profile{}, false
+}
+
+// profileNames returns the name of every profile, in order
+func (cc *CanonicalConfig) profileNames() []string {
+	names := make([]string, len(cc.Profiles))
+	for idx, p := range cc.Profiles {
+		names[idx] = p.Name
+	}
+
+	return names
+}
+
+// sliderTargets returns the targets of a slider in the given profile and layer.
+// the profile's own mapping comes first, then the layer's
+func (cc *CanonicalConfig) sliderTargets(profileName string, layer int, sliderIdx int) ([]string, bool) {
+	if p, ok := cc.profile(profileName); ok {
+		if targets, ok := p.SliderMapping.get(sliderIdx); ok {
+			return targets, true
+		}
+	}
+
+	return cc.sliderMapping(layer).get(sliderIdx)
+}
+
+// muteButtonTargets returns the targets of a mute button in the given profile,
+// which are mute_button_mapping's unless the profile maps the button itself
+func (cc *CanonicalConfig) muteButtonTargets(profileName string, buttonIdx int) ([]string, bool) {
+	if p, ok := cc.profile(profileName); ok {
+		if targets, ok := p.MuteButtonMapping.get(buttonIdx); ok {
+			return targets, true
+		}
+	}
+
+	return cc.MuteButtonMapping.get(buttonIdx)
+}
+
+// numMuteButtons returns the number of mute buttons in the given profile, counting up to the highest mapped index
+func (cc *CanonicalConfig) numMuteButtons(profileName string) int {
+	numButtons := 0
+	countButtons := func(buttonIdx int, _ []string) {
+		if buttonIdx >= numButtons {
+			numButtons = buttonIdx + 1
+		}
+	}
+
+	cc.MuteButtonMapping.iterate(countButtons)
+
+	if p, ok := cc.profile(profileName); ok {
+		p.MuteButtonMapping.iterate(countButtons)
+	}
+
+	return numButtons
+}
+
+// sliderTargets returns the targets of a slider in the active profile and layer
+func (d *Deej) sliderTargets(sliderIdx int) ([]string, bool) {
+	profileName, _ := d.ActiveProfile()
+	return d.config.sliderTargets(profileName, d.ActiveLayer(), sliderIdx)
+}
+
+// muteButtonTargets returns the targets of a mute button in the active profile
+func (d *Deej) muteButtonTargets(buttonIdx int) ([]string, bool) {
+	profileName, _ := d.ActiveProfile()
+	return d.config.muteButtonTargets(profileName, buttonIdx)
+}
+
+// ActiveProfile returns the name of the profile whose mappings are in use, or "" if there's none.
+// the second value is true if it was picked from the tray rather than by the focused app
+func (d *Deej) ActiveProfile() (string, bool) {
+	d.profileLock.Lock()
+	defer d.profileLock.Unlock()
+
+	name := d.focusedProfile
+	if d.profileOverridden {
+		name = d.profileOverride
+	}
+
+	// a config reload can take profiles away
+	if _, ok := d.config.profile(name); !ok {
+		name = ""
+	}
+
+	return name, d.profileOverridden
+}
+
+// OverrideProfile pins the given profile regardless of the focused app, or no profile at all for ""
+func (d *Deej) OverrideProfile(name string) error {
+	if _, ok := d.config.profile(name); !ok && name != "" {
+		return fmt.Errorf("no such profile: %s", name)
+	}
+
+	d.logger.Infow("Overriding profile", "profile", name)
+
+	d.updateProfile(func() {
+		d.profileOverride = name
+		d.profileOverridden = true
+	})
+
+	return nil
+}
+
+// ClearProfileOverride goes back to picking the profile by the focused app
+func (d *Deej) ClearProfileOverride() {
+	d.logger.Info("Switching profiles automatically again")
+
+	d.updateProfile(func() {
+		d.profileOverridden = false
+	})
+}
+
+// SubscribeToProfileChanges allows external components to receive the active profile whenever it changes
+func (d *Deej) SubscribeToProfileChanges() chan string {
+	d.profileLock.Lock()
+	defer d.profileLock.Unlock()
+
+	c := make(chan string)
+	d.profileConsumers = append(d.profileConsumers, c)
+
+	return c
+}
+
+// updateProfile applies a change to the profile state, and lets consumers know if the active profile changed.
+// they're also told about overrides that didn't change it, so that menus can show them
+func (d *Deej) updateProfile(change func()) {
+	before, overriddenBefore := d.ActiveProfile()
+
+	d.profileLock.Lock()
+	change()
+	consumers := d.profileConsumers
+	d.profileLock.Unlock()
+
+	after, overridden := d.ActiveProfile()
+	if after == before && overridden == overriddenBefore {
+		return
+	}
+
+	if after != before {
+		d.logger.Infow("Switched profile", "from", before, "to", after, "manual", overridden)
+	}
+
+	for _, consumer := range consumers {
+		consumer <- after
+	}
+}
+
+// checkForegroundProfile picks the first profile that has a process of the focused app.
+// apps that no profile has, and failures to tell which app is focused, pick no profile
+func (d *Deej) checkForegroundProfile(getProcessNames func() ([]string, error)) {
+	if len(d.config.Profiles) == 0 {
+		return
+	}
+
+	processNames, err := getProcessNames()
+	if err != nil {
+		if d.Verbose() {
+			d.logger.Debugw("Failed to get focused app for profiles", "error", err)
+		}
+
+		processNames = nil
+	}
+
+	focused := ""
+	for _, p := range d.config.Profiles {
+		if p.matches(processNames) {
+			focused = p.Name
+			break
+		}
+	}
+
+	d.profileLock.Lock()
+	changed := focused != d.focusedProfile
+	d.profileLock.Unlock()
+
+	if !changed {
+		return
+	}
+
+	d.updateProfile(func() {
+		d.focusedProfile = focused
+	})
+}
+
+// watchForegroundProfiles keeps checking which profile the focused app belongs to
+func (d *Deej) watchForegroundProfiles() {
+	go func() {
+		ticker := time.NewTicker(foregroundProfilePollInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			d.checkForegroundProfile(util.GetCurrentWindowProcessNames)
+		}
+	}()
+}
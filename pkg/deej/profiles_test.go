@@ -0,0 +1,241 @@
+package deej
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+const testProfilesConfig = `
+slider_mapping:
+  0: master
+  1: spotify.exe
+  2: discord.exe
+mute_button_mapping:
+  0: mic
+slider_layers:
+  - name: games
+    slider_mapping:
+      1: game.exe
+profiles:
+  - name: calls
+    processes: [Teams.exe, zoom.exe]
+    slider_mapping:
+      2: [teams.exe, zoom.exe]
+    mute_button_mapping:
+      1: teams.exe
+  - name: browsing
+    processes: firefox.exe
+    slider_mapping:
+      1: firefox.exe
+  - name: manual
+`
+
+// focusedOn returns a process name lookup for checkForegroundProfile that reports the given apps in focus
+func focusedOn(processNames ...string) func() ([]string, error) {
+	return func() ([]string, error) {
+		return processNames, nil
+	}
+}
+
+// TestProfilesConfig tests reading profiles, which only have to name themselves
+func TestProfilesConfig(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, testProfilesConfig)
+	defer cleanup()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if names := strings.Join(deej.config.profileNames(), ","); names != "calls,browsing,manual" {
+		t.Errorf("Expected profiles 'calls,browsing,manual', got '%s'", names)
+	}
+
+	for _, test := range []struct {
+		profile   string
+		processes string
+		sliders   int
+		buttons   int
+	}{
+		{"calls", "Teams.exe,zoom.exe", 1, 1},
+		{"browsing", "firefox.exe", 1, 0},
+		{"manual", "", 0, 0},
+	} {
+		p, ok := deej.config.profile(test.profile)
+		if !ok {
+			t.Errorf("Expected a profile named %s", test.profile)
+			continue
+		}
+
+		if processes := strings.Join(p.Processes, ","); processes != test.processes ||
+			p.SliderMapping.NumSliders() != test.sliders || p.MuteButtonMapping.NumSliders() != test.buttons {
+
+			t.Errorf("Expected %s to have '%s', %d sliders and %d buttons, got '%s', %d and %d", test.profile,
+				test.processes, test.sliders, test.buttons, processes, p.SliderMapping.NumSliders(), p.MuteButtonMapping.NumSliders())
+		}
+	}
+
+	for _, profiles := range []string{
+		"profiles: calls",
+		"profiles:\n  - processes: [zoom.exe]",
+		"profiles:\n  - name: calls\n  - name: Calls",
+		"profiles:\n  - name: calls\n    processes: [zoom.exe, [teams.exe]]",
+		"profiles:\n  - name: calls\n    processes: 42",
+		"profiles:\n  - name: calls\n    slider_mapping: zoom.exe",
+		"profiles:\n  - name: calls\n    mute_button_mapping:\n      first: zoom.exe",
+	} {
+		cleanup := createTestConfig(t, "slider_mapping:\n  0: master\n"+profiles+"\n")
+
+		if err := deej.config.Load(); err == nil {
+			t.Errorf("Expected an error for '%s', got %v", profiles, deej.config.profileNames())
+		}
+
+		cleanup()
+	}
+}
+
+// TestForegroundProfile tests that the focused app picks the profile, whose mappings go before the active layer's
+func TestForegroundProfile(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	spotify := newFakeSession(logger, "spotify.exe")
+	game := newFakeSession(logger, "game.exe")
+	firefox := newFakeSession(logger, "firefox.exe")
+	discord := newFakeSession(logger, "discord.exe")
+	teams := newFakeSession(logger, "teams.exe")
+
+	sf := &fakeSessionFinder{sessions: []Session{spotify, game, firefox, discord, teams}}
+	m := newTestSessionMap(t, testProfilesConfig, sf)
+	d := m.deej
+
+	targetsOf := func(sliderIdx int) string {
+		targets, _ := d.sliderTargets(sliderIdx)
+		return strings.Join(targets, ",")
+	}
+
+	for _, test := range []struct {
+		focused  []string
+		layer    int
+		expected string
+		slider1  string
+		slider2  string
+	}{
+		{[]string{"explorer.exe"}, 0, "", "spotify.exe", "discord.exe"},
+		{[]string{"ZOOM.EXE"}, 0, "calls", "spotify.exe", "teams.exe,zoom.exe"},
+		{[]string{"ApplicationFrameHost.exe", "firefox.exe"}, 0, "browsing", "firefox.exe", "discord.exe"},
+		{[]string{"firefox.exe"}, 1, "browsing", "firefox.exe", "discord.exe"},
+		{[]string{"zoom.exe"}, 1, "calls", "game.exe", "teams.exe,zoom.exe"},
+		{nil, 1, "", "game.exe", "discord.exe"},
+	} {
+		if err := d.SwitchLayer(test.layer); err != nil {
+			t.Fatalf("Failed to switch layer: %v", err)
+		}
+
+		d.checkForegroundProfile(focusedOn(test.focused...))
+
+		if active, _ := d.ActiveProfile(); active != test.expected {
+			t.Errorf("%v: expected profile '%s', got '%s'", test.focused, test.expected, active)
+		}
+
+		if slider1, slider2 := targetsOf(1), targetsOf(2); slider1 != test.slider1 || slider2 != test.slider2 {
+			t.Errorf("%v in layer %d: expected sliders 1 and 2 to control '%s' and '%s', got '%s' and '%s'",
+				test.focused, test.layer, test.slider1, test.slider2, slider1, slider2)
+		}
+	}
+
+	// not knowing which app is focused picks no profile
+	d.checkForegroundProfile(focusedOn("zoom.exe"))
+	d.checkForegroundProfile(func() ([]string, error) { return nil, errors.New("no X11 display available") })
+
+	if active, _ := d.ActiveProfile(); active != "" {
+		t.Errorf("Expected no profile when the focused app is unknown, got '%s'", active)
+	}
+
+	// profiles' mappings are mapped, even while they aren't active
+	for _, session := range []Session{spotify, game, firefox, teams} {
+		if !m.sessionMapped(session) {
+			t.Errorf("Expected %s to be mapped", session.Key())
+		}
+	}
+
+	d.checkForegroundProfile(focusedOn("teams.exe"))
+
+	if _, err := m.handleMuteButtonClickedEventsAndGetState([]MuteButtonClickEvent{{MuteButtonID: 1, mute: true}}); err != nil {
+		t.Fatalf("Failed to handle mute button event: %v", err)
+	}
+
+	if !teams.mute {
+		t.Error("Expected the calls profile's mute button to mute teams")
+	}
+
+	if state := m.getControllerState(); len(state.MuteButtons) != 2 || !state.MuteButtons[1] {
+		t.Errorf("Expected the calls profile's mute button in the controller state, got %v", state.MuteButtons)
+	}
+}
+
+// TestProfileOverride tests that a profile picked from the tray sticks, until profiles switch automatically again
+func TestProfileOverride(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, testProfilesConfig)
+	defer cleanup()
+
+	d := newTestDeej(t, logger)
+	if err := d.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	changes := d.SubscribeToProfileChanges()
+	received := make(chan []string)
+
+	go func() {
+		profiles := []string{}
+		for profile := range changes {
+			if profile == "end" {
+				break
+			}
+
+			profiles = append(profiles, profile)
+		}
+
+		received <- profiles
+	}()
+
+	d.checkForegroundProfile(focusedOn("zoom.exe"))
+
+	if err := d.OverrideProfile("manual"); err != nil {
+		t.Fatalf("Failed to override profile: %v", err)
+	}
+
+	d.checkForegroundProfile(focusedOn("firefox.exe"))
+
+	if active, overridden := d.ActiveProfile(); active != "manual" || !overridden {
+		t.Errorf("Expected the overridden profile to stick, got '%s' (overridden: %v)", active, overridden)
+	}
+
+	if err := d.OverrideProfile("gaming"); err == nil {
+		t.Error("Expected an error for a profile that doesn't exist")
+	}
+
+	if err := d.OverrideProfile(""); err != nil {
+		t.Fatalf("Failed to override profile: %v", err)
+	}
+
+	d.ClearProfileOverride()
+
+	if active, overridden := d.ActiveProfile(); active != "browsing" || overridden {
+		t.Errorf("Expected the focused app's profile after clearing the override, got '%s' (overridden: %v)", active, overridden)
+	}
+
+	// changes are sent synchronously, so a marker sent after them is received last
+	changes <- "end"
+
+	if profiles := strings.Join(<-received, ","); profiles != "calls,manual,,browsing" {
+		t.Errorf("Expected profile changes 'calls,manual,,browsing', got '%s'", profiles)
+	}
+}
@@ -8,34 +8,74 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"go.bug.st/serial"
 	"go.uber.org/zap"
 
 	"github.com/tomerhh/deej/pkg/deej/util"
 )
 
-// SerialIO provides a deej-aware abstraction layer for managing serial I/O
+// SerialIO provides a deej-aware abstraction layer for managing the controller's line protocol.
+// despite its name, the protocol can run over any Transport, serial or otherwise
 type SerialIO struct {
-	comPort  string
-	baudRate uint
+	transport        Transport
+	connectionConfig connectionConfig
+
+	// which of the configured controllers this is, see controllers.go
+	controllerIdx int
 
 	deej   *Deej
 	logger *zap.SugaredLogger
 
 	sliderMoveConsumers []chan SliderMoveEvent
+	rawSliderConsumers  []chan []int
+	deviceInfoConsumers []chan DeviceInfo
+
+	// what happened once the consumers below handled it, and the connection coming and going
+	muteButtonEventConsumers   []chan MuteButtonClickEvent
+	outputDeviceEventConsumers []chan int
+	connectionEventConsumers   []chan ConnectionEvent
 
 	muteButtonsConsumer        MuteButtonConsumer
 	toggleOutputDeviceConsumer ToggleOutputDeviceConsumer
 
 	currentSliderPercentValues []float32
 
-	conn        io.ReadWriteCloser
-	connOptions *serial.Mode
+	// every slider's smoothing stages, which are recreated whenever the config's filters change.
+	// only used by the read loop
+	sliderFilterStages [][]sliderFilterStage
+	sliderFiltersFrom  *sliderFilters
+
+	// the read loop replaces the connection when it reconnects, while replies and state pushes
+	// are written to it from other goroutines
+	conn      io.ReadWriteCloser
+	connected bool
+	connLock  sync.Mutex
 
 	stopChannel chan bool
-	connected   bool
+
+	// what the controller reported in its handshake, nil until it says hello (older firmware never does)
+	deviceInfo         *DeviceInfo
+	lastDeviceMismatch string
+	deviceInfoLock     sync.Mutex
+
+	// what the controller's LEDs show as far as deej knows, nil until the first push after connecting
+	boardState    *ControllerState
+	lastStatePush time.Time
+	stateLock     sync.Mutex
+
+	// replies and state pushes come from different goroutines, and their lines mustn't interleave
+	writeLock sync.Mutex
+
+	// the controller's framing (see frame.go). once it sends a framed line, plain lines can't be trusted anymore
+	framed        bool
+	frameSequence frameSequence
+	frameStats    FrameStats
+	frameLock     sync.Mutex
+
+	// the sequence number that replies acknowledge, only used by the read loop
+	ackSeq string
 }
 
 const (
@@ -44,22 +84,40 @@ const (
 	commandTimeout = 3 * time.Second
 
 	reconnectDelay = 500 * time.Millisecond
+
+	// state pushes are rate limited, so that flapping mute states don't flood the controller.
+	// whatever changes in between is sent with the next push
+	minTimeBetweenStatePushes = time.Second
 )
 
 var (
-	expectedLinePattern = regexp.MustCompile(`^\w+(\|\w+)*$`)
+	expectedLinePattern = regexp.MustCompile(`^\w+(\|[\w.,\-]+)*$`)
 )
 
-// NewSerialIO creates a SerialIO instance that uses auto-detection to find the ESP32
+// NewSerialIO creates a SerialIO instance for the first configured controller,
+// that uses auto-detection to find the ESP32 unless it's configured otherwise
 func NewSerialIO(deej *Deej, logger *zap.SugaredLogger) (*SerialIO, error) {
+	return newControllerSerialIO(deej, logger, 0)
+}
+
+// newControllerSerialIO creates a SerialIO instance for the configured controller at the given index
+func newControllerSerialIO(deej *Deej, logger *zap.SugaredLogger, controllerIdx int) (*SerialIO, error) {
 	logger = logger.Named("serial")
 
+	controller := deej.config.controllerConfigAt(controllerIdx)
+	if controller.Name != "" {
+		logger = logger.With("controller", controller.Name)
+	}
+
 	sio := &SerialIO{
 		deej:                       deej,
 		logger:                     logger,
+		controllerIdx:              controllerIdx,
 		sliderMoveConsumers:        []chan SliderMoveEvent{},
-		currentSliderPercentValues: make([]float32, deej.config.SliderMapping.NumSliders()),
-		stopChannel:                make(chan bool),
+		rawSliderConsumers:         []chan []int{},
+		deviceInfoConsumers:        []chan DeviceInfo{},
+		currentSliderPercentValues: make([]float32, deej.config.numMappedSliders()),
+		stopChannel:                make(chan bool, 1),
 		connected:                  false,
 	}
 
@@ -71,7 +129,13 @@ func NewSerialIO(deej *Deej, logger *zap.SugaredLogger) (*SerialIO, error) {
 	logger.Debug("Created serial i/o instance")
 
 	// Use values from config
-	sio.setupSerialConnection(deej.config.SerialConnectionInfo.COMPort, deej.config.SerialConnectionInfo.BaudRate)
+	if err := sio.setupTransport(controller.connection); err != nil {
+		logger.Warnw("Failed to set up controller connection", "error", err)
+		deej.notifier.Notify("Invalid configuration!",
+			fmt.Sprintf("Please check the connection section in %s: %s", userConfigFilepath, err))
+
+		return nil, fmt.Errorf("set up controller connection: %w", err)
+	}
 
 	// Set up config reload handling
 	sio.setupOnConfigReload()
@@ -79,53 +143,64 @@ func NewSerialIO(deej *Deej, logger *zap.SugaredLogger) (*SerialIO, error) {
 	return sio, nil
 }
 
-func (sio *SerialIO) setupSerialConnection(comPort string, baudRate uint) {
-	sio.comPort = comPort
-	sio.baudRate = baudRate
-
-	sio.connOptions = &serial.Mode{
-		BaudRate: int(sio.baudRate),
-		DataBits: 8,
-		StopBits: serial.OneStopBit,
-		Parity:   serial.NoParity,
+func (sio *SerialIO) setupTransport(cc connectionConfig) error {
+	transport, err := newTransport(cc)
+	if err != nil {
+		return fmt.Errorf("create transport: %w", err)
 	}
 
-	sio.logger.Debugw("Set up serial connection options", "comPort", comPort, "baudRate", baudRate)
+	sio.transport = transport
+	sio.connectionConfig = cc
+
+	sio.logger.Debugw("Set up connection options", "transport", transport)
+
+	return nil
 }
 
-// Start attempts to connect to the serial port and begin reading lines
+// Start attempts to connect to the controller and begin reading lines
 func (sio *SerialIO) Start() error {
+	return sio.start(true)
+}
+
+// start connects like Start does, and only notifies the user about failures if asked to
+// (i.e. not every time that a missing controller is looked for again)
+func (sio *SerialIO) start(notify bool) error {
 
 	// If no port specified, try auto-detection
-	if sio.comPort == "" || sio.comPort == "auto" {
+	if serialTransport, ok := sio.transport.(*serialTransport); ok && serialTransport.autoDetect() {
 		sio.logger.Info("Auto-detecting serial port...")
 		detectedPort, err := sio.autoDetectPort()
 		if err != nil {
 			sio.logger.Warnw("Failed to auto-detect serial port", "error", err)
 
 			// Notify user of auto-detect failure
-			sio.deej.notifier.Notify("deej - Serial Auto-Detect Failed",
-				"Could not automatically detect the serial port. Please specify a port in config.yaml.")
+			if notify {
+				sio.deej.notifier.Notify("deej - Serial Auto-Detect Failed",
+					"Could not automatically detect the serial port. Please specify a port in config.yaml.")
+			}
 
 			return fmt.Errorf("auto-detect serial port: %w", err)
 		}
 		sio.logger.Infow("Auto-detected serial port", "port", detectedPort)
-		sio.setupSerialConnection(detectedPort, sio.baudRate)
+		sio.transport = newSerialTransport(detectedPort, sio.connectionConfig.baudRate)
 	}
 
 	// Attempt first connection
-	if err := sio.connect(); err != nil {
-		sio.logger.Warnw("Failed initial serial connection", "error", err)
+	conn, err := sio.connect()
+	if err != nil {
+		sio.logger.Warnw("Failed initial connection", "transport", sio.transport, "error", err)
 
 		// Notify user of initial connection failure
-		sio.deej.notifier.Notify("deej - Serial Connection Failed",
-			fmt.Sprintf("Could not connect to serial port %s. Check the connection and config.", sio.comPort))
+		if notify {
+			sio.deej.notifier.Notify("deej - Connection Failed",
+				fmt.Sprintf("Could not connect to %s. Check the connection and config.", sio.transport))
+		}
 
-		return fmt.Errorf("initial serial connection: %w", err)
+		return fmt.Errorf("initial connection: %w", err)
 	}
 
 	// Start reading lines in a goroutine
-	go sio.readLoop()
+	go sio.readLoop(conn)
 
 	return nil
 }
@@ -134,19 +209,18 @@ func (sio *SerialIO) Start() error {
 func (sio *SerialIO) Stop() {
 	sio.logger.Debug("Stopping serial i/o")
 
-	// Close connection FIRST to unblock ReadString in readLoop
-	if sio.conn != nil {
-		sio.conn.Close()
-	}
-
-	sio.connected = false
-
-	// Then signal stop (non-blocking send in case channel is full)
+	// Signal stop FIRST, so that readLoop knows not to reconnect once its read fails
+	// (non-blocking send in case channel is full)
 	select {
 	case sio.stopChannel <- true:
 	default:
 		sio.logger.Debug("Stop channel already signaled")
 	}
+
+	// Then close the connection to unblock ReadString in readLoop
+	if sio.disconnect() {
+		sio.broadcastConnection(false)
+	}
 }
 
 // SubscribeToSliderMoveEvents returns an unbuffered channel that receives
@@ -158,6 +232,64 @@ func (sio *SerialIO) SubscribeToSliderMoveEvents() chan SliderMoveEvent {
 	return ch
 }
 
+// SubscribeToRawSliderValues returns an unbuffered channel that receives every slider's
+// raw reading, before it's calibrated, whenever the controller sends them
+func (sio *SerialIO) SubscribeToRawSliderValues() chan []int {
+	ch := make(chan []int)
+	sio.rawSliderConsumers = append(sio.rawSliderConsumers, ch)
+
+	return ch
+}
+
+// SubscribeToDeviceInfo returns an unbuffered channel that receives the controller's
+// device info whenever it identifies itself
+func (sio *SerialIO) SubscribeToDeviceInfo() chan DeviceInfo {
+	ch := make(chan DeviceInfo)
+	sio.deviceInfoConsumers = append(sio.deviceInfoConsumers, ch)
+
+	return ch
+}
+
+// SubscribeToMuteButtonEvents returns an unbuffered channel that receives
+// mute button clicks once they were handled
+func (sio *SerialIO) SubscribeToMuteButtonEvents() chan MuteButtonClickEvent {
+	ch := make(chan MuteButtonClickEvent)
+	sio.muteButtonEventConsumers = append(sio.muteButtonEventConsumers, ch)
+
+	return ch
+}
+
+// SubscribeToOutputDeviceEvents returns an unbuffered channel that receives the index of
+// the output device that's the default after the controller switched it
+func (sio *SerialIO) SubscribeToOutputDeviceEvents() chan int {
+	ch := make(chan int)
+	sio.outputDeviceEventConsumers = append(sio.outputDeviceEventConsumers, ch)
+
+	return ch
+}
+
+// SubscribeToConnectionEvents returns an unbuffered channel that receives
+// an event whenever the controller connects or disconnects
+func (sio *SerialIO) SubscribeToConnectionEvents() chan ConnectionEvent {
+	ch := make(chan ConnectionEvent)
+	sio.connectionEventConsumers = append(sio.connectionEventConsumers, ch)
+
+	return ch
+}
+
+// DeviceInfo returns what the connected controller reported about itself,
+// and false if it hasn't identified itself (yet)
+func (sio *SerialIO) DeviceInfo() (DeviceInfo, bool) {
+	sio.deviceInfoLock.Lock()
+	defer sio.deviceInfoLock.Unlock()
+
+	if sio.deviceInfo == nil {
+		return DeviceInfo{}, false
+	}
+
+	return *sio.deviceInfo, true
+}
+
 func (sio *SerialIO) setMuteButtonClickEventConsumer(consumer MuteButtonConsumer) {
 	sio.muteButtonsConsumer = consumer
 }
@@ -184,26 +316,38 @@ func (sio *SerialIO) setupOnConfigReload() {
 					}
 				}()
 
+				// the mappings might match the controller now, or stopped matching it
+				if info, ok := sio.DeviceInfo(); ok {
+					sio.validateDeviceInfo(info)
+				}
+
+				// controllers can't come and go with a reload, so a removed one keeps its connection until deej restarts
+				if sio.controllerIdx >= len(sio.deej.config.Controllers) {
+					sio.logger.Warn("Controller was removed from the config, restart deej to disconnect it")
+					continue
+				}
+
 				// If connection params have changed, update connection options
-				newPort := sio.deej.config.SerialConnectionInfo.COMPort
-				newBaud := sio.deej.config.SerialConnectionInfo.BaudRate
+				newConnectionConfig := sio.controllerConfig().connection
 
-				if newPort != sio.comPort || newBaud != sio.baudRate {
-					sio.logger.Infow("Serial config changed, updating connection",
-						"oldPort", sio.comPort,
-						"newPort", newPort,
-						"oldBaud", sio.baudRate,
-						"newBaud", newBaud)
+				if newConnectionConfig != sio.connectionConfig {
+					oldTransport := sio.transport
+
+					if err := sio.setupTransport(newConnectionConfig); err != nil {
+						sio.logger.Warnw("Invalid connection config, keeping the current connection", "error", err)
+						continue
+					}
 
-					sio.setupSerialConnection(newPort, newBaud)
+					sio.logger.Infow("Connection config changed, updating connection",
+						"oldTransport", oldTransport,
+						"newTransport", sio.transport)
 
 					// Reconnect with new settings
-					if sio.connected && sio.conn != nil {
-						sio.conn.Close()
-						sio.connected = false
+					if sio.disconnect() {
+						sio.broadcastConnection(false)
 
 						time.Sleep(reconnectDelay)
-						if err := sio.connect(); err != nil {
+						if _, err := sio.connect(); err != nil {
 							sio.logger.Warnw("Failed to reconnect with new settings", "error", err)
 						}
 					}
@@ -213,80 +357,89 @@ func (sio *SerialIO) setupOnConfigReload() {
 	}()
 }
 
-// autoDetectPort attempts to find the ESP32 by scanning available COM ports
+// controllerConfig returns this controller's part of the current config
+func (sio *SerialIO) controllerConfig() controllerConfig {
+	return sio.deej.config.controllerConfigAt(sio.controllerIdx)
+}
+
+// autoDetectPort attempts to find the ESP32 among the system's serial ports
 func (sio *SerialIO) autoDetectPort() (string, error) {
-	// On Windows, scan COM3-COM16
-	possiblePorts := []string{
-		"COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9", "COM10",
-		"COM11", "COM12", "COM13", "COM14", "COM15", "COM16",
-	}
+	sio.logger.Debug("Scanning for available serial ports...")
 
-	sio.logger.Debug("Scanning for available COM ports...")
+	detector := newPortDetector(sio.logger, sio.deej.config.SerialConnectionInfo.USBIDs, sio.connectionConfig.baudRate)
 
-	for _, port := range possiblePorts {
-		sio.logger.Debugw("Trying port", "port", port)
+	return detector.detect()
+}
 
-		// Try to open the port
-		testMode := &serial.Mode{
-			BaudRate: int(sio.baudRate),
-			DataBits: 8,
-			StopBits: serial.OneStopBit,
-			Parity:   serial.NoParity,
-		}
+// connect attempts to establish a connection over the configured transport, and returns it for the read loop
+func (sio *SerialIO) connect() (io.ReadWriteCloser, error) {
+	sio.logger.Debugw("Attempting connection", "transport", sio.transport)
 
-		conn, err := serial.Open(port, testMode)
-		if err != nil {
-			// Port doesn't exist or is in use
-			continue
-		}
+	conn, err := sio.transport.Open()
+	if err != nil {
+		return nil, fmt.Errorf("open connection: %w", err)
+	}
 
-		// Port opened successfully, try to read some data
-		sio.logger.Debugw("Port opened, testing for ESP32 data", "port", port)
+	// Set the connection
+	sio.connLock.Lock()
+	sio.conn = conn
+	sio.connected = true
+	sio.connLock.Unlock()
 
-		// Wait a moment for data
-		time.Sleep(500 * time.Millisecond)
+	// whatever is on the other end has to identify itself again, and its LEDs are anyone's guess
+	sio.deviceInfoLock.Lock()
+	sio.deviceInfo = nil
+	sio.deviceInfoLock.Unlock()
 
-		reader := bufio.NewReader(conn)
-		reader.ReadString('\n') // Discard first line (might be partial)
+	sio.stateLock.Lock()
+	sio.boardState = nil
+	sio.stateLock.Unlock()
 
-		// Try to read a valid line
-		line, err := reader.ReadString('\n')
-		conn.Close()
+	sio.frameLock.Lock()
+	sio.framed = false
+	sio.frameSequence = frameSequence{}
+	sio.frameLock.Unlock()
 
-		if err == nil && sio.isValidLine(line) {
-			sio.logger.Infow("Found ESP32", "port", port)
-			return port, nil
-		}
-	}
+	sio.logger.Infow("Connected to controller", "transport", sio.transport)
+	sio.broadcastConnection(true)
 
-	return "", errors.New("no valid serial port found")
+	// Send "Connected" message to trigger LED indication on firmware
+	sio.sendPush("Connected")
+
+	return conn, nil
 }
 
-// connect attempts to establish a serial connection
-func (sio *SerialIO) connect() error {
-	sio.logger.Debugw("Attempting serial connection", "port", sio.comPort, "baud", sio.baudRate)
+// connection returns the current connection, and nil while there's none
+func (sio *SerialIO) connection() io.ReadWriteCloser {
+	sio.connLock.Lock()
+	defer sio.connLock.Unlock()
 
-	conn, err := serial.Open(sio.comPort, sio.connOptions)
-	if err != nil {
-		return fmt.Errorf("open serial port: %w", err)
+	if !sio.connected {
+		return nil
 	}
 
-	// Set the connection
-	sio.conn = conn
-	sio.connected = true
+	return sio.conn
+}
 
-	sio.logger.Infow("Connected to serial port", "port", sio.comPort)
+// disconnect closes the current connection, and returns whether it was connected
+func (sio *SerialIO) disconnect() bool {
+	sio.connLock.Lock()
+	defer sio.connLock.Unlock()
 
-	// Send "Connected" message to trigger LED indication on firmware
-	sio.sendResponse("Connected")
+	wasConnected := sio.connected
+	sio.connected = false
 
-	return nil
+	if sio.conn != nil {
+		sio.conn.Close()
+	}
+
+	return wasConnected
 }
 
-// readLoop continuously reads lines from the serial port
-func (sio *SerialIO) readLoop() {
+// readLoop continuously reads lines from the controller, starting with the given connection
+func (sio *SerialIO) readLoop(conn io.ReadWriteCloser) {
 	sio.logger.Debug("Started read loop")
-	reader := bufio.NewReader(sio.conn)
+	reader := bufio.NewReader(conn)
 
 	for {
 		select {
@@ -294,8 +447,13 @@ func (sio *SerialIO) readLoop() {
 			sio.logger.Debug("Stopped read loop")
 			return
 		default:
-			// Read until newline
-			line, err := reader.ReadString('\n')
+			// Read until newline, or a whole binary frame if that's what comes next
+			line, sliderValues, err := sio.readNext(reader)
+
+			if errors.Is(err, errInvalidBinaryFrame) {
+				sio.logger.Warnw("Dropped a binary slider frame", "error", err)
+				continue
+			}
 
 			if err != nil {
 				// Check if we're stopping (connection closed intentionally)
@@ -308,37 +466,153 @@ func (sio *SerialIO) readLoop() {
 				}
 
 				if err == io.EOF {
-					sio.logger.Warn("Serial connection closed, attempting reconnect...")
+					sio.logger.Warn("Connection closed, attempting reconnect...")
 				} else {
-					sio.logger.Warnw("Error reading from serial", "error", err)
+					sio.logger.Warnw("Error reading from controller", "error", err)
 				}
 
-				sio.connected = false
+				// release the failed connection, a listening transport (i.e. udp) can't reopen while it's still bound.
+				// a config reload that failed to reconnect already released it
+				if sio.disconnect() {
+					sio.broadcastConnection(false)
+				}
 
-				// Try to reconnect
-				time.Sleep(reconnectDelay)
-				if reconnErr := sio.connect(); reconnErr != nil {
-					sio.logger.Warnw("Reconnection failed", "error", reconnErr)
-					continue
+				conn, ok := sio.reconnect()
+				if !ok {
+					sio.logger.Debug("Stopped read loop (while reconnecting)")
+					return
 				}
 
 				// Recreate reader after reconnection
-				reader = bufio.NewReader(sio.conn)
+				reader = bufio.NewReader(conn)
+				continue
+			}
+
+			if sliderValues != nil {
+				sio.handleBinarySliders(sliderValues)
 				continue
 			}
 
 			// Trim and process the line
-			line = strings.TrimSpace(line)
+			sio.handleIncomingLine(strings.TrimSpace(line))
+		}
+	}
+}
 
-			if line != "" && sio.isValidLine(line) {
-				sio.handleLine(line)
-			} else if line != "" {
-				sio.logger.Debugw("Invalid line format, discarding", "line", line)
-			}
+// reconnect tries to connect again every reconnectDelay, without reading in between, until it succeeds.
+// it returns false if it was stopped first
+func (sio *SerialIO) reconnect() (io.ReadWriteCloser, bool) {
+	for {
+		select {
+		case <-sio.stopChannel:
+			return nil, false
+		case <-time.After(reconnectDelay):
 		}
+
+		conn, err := sio.connect()
+		if err != nil {
+			sio.logger.Warnw("Reconnection failed", "error", err)
+			continue
+		}
+
+		return conn, true
 	}
 }
 
+// readNext reads either a line or, once they were agreed on in the handshake, a binary slider frame.
+// binary frames can contain newline bytes, which is why they're told apart before reading a line
+func (sio *SerialIO) readNext(reader *bufio.Reader) (string, []int, error) {
+	if info, ok := sio.DeviceInfo(); ok && info.BinarySliders {
+		next, err := reader.Peek(1)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if next[0] == binarySliderFrameStart {
+			values, err := readBinarySliderFrame(reader)
+			return "", values, err
+		}
+	}
+
+	line, err := reader.ReadString('\n')
+	return line, nil, err
+}
+
+// handleIncomingLine unwraps framed lines and drops whatever can't be trusted, before handling the line
+func (sio *SerialIO) handleIncomingLine(line string) {
+	if line == "" {
+		return
+	}
+
+	if isFrame(line) {
+		sio.handleFrame(line)
+		return
+	}
+
+	// a frame that lost its start to corruption looks like a plain line
+	sio.frameLock.Lock()
+	framed := sio.framed
+	if framed {
+		sio.frameStats.Corrupt++
+	}
+	sio.frameLock.Unlock()
+
+	if framed {
+		sio.logger.Warnw("Dropped a plain line from a controller that frames its lines", "line", line)
+		return
+	}
+
+	if sio.isValidLine(line) {
+		sio.handleLine(line)
+	} else {
+		sio.logger.Debugw("Invalid line format, discarding", "line", line)
+	}
+}
+
+// handleFrame verifies a framed line and its sequence number, and handles the line it carries
+func (sio *SerialIO) handleFrame(frame string) {
+	seq, line, err := parseFrame(frame)
+	if err == nil && !sio.isValidLine(line) {
+		err = fmt.Errorf("%w: invalid line %q", errCorruptFrame, line)
+	}
+
+	sio.frameLock.Lock()
+
+	if err != nil {
+		sio.frameStats.Corrupt++
+		stats := sio.frameStats
+		sio.frameLock.Unlock()
+
+		sio.logger.Warnw("Dropped a corrupt frame", "frame", frame, "error", err, "stats", stats)
+		return
+	}
+
+	if !sio.frameSequence.accept(seq) {
+		sio.frameStats.OutOfOrder++
+		stats := sio.frameStats
+		sio.frameLock.Unlock()
+
+		sio.logger.Warnw("Dropped an out-of-order frame", "frame", frame, "stats", stats)
+		return
+	}
+
+	sio.frameStats.Accepted++
+	sio.framed = true
+	sio.frameLock.Unlock()
+
+	sio.ackSeq = strconv.Itoa(int(seq))
+	sio.handleLine(line)
+	sio.ackSeq = ""
+}
+
+// FrameStats returns how many frames the controller sent, and how many of them were dropped
+func (sio *SerialIO) FrameStats() FrameStats {
+	sio.frameLock.Lock()
+	defer sio.frameLock.Unlock()
+
+	return sio.frameStats
+}
+
 // isValidLine checks if a line matches the expected protocol format
 func (sio *SerialIO) isValidLine(line string) bool {
 	line = strings.TrimSpace(line)
@@ -358,6 +632,8 @@ func (sio *SerialIO) handleLine(line string) {
 	data := parts[1:]
 
 	switch command {
+	case "Hello":
+		sio.handleHello(data)
 	case "Sliders":
 		sio.handleSliders(data)
 	case "MuteButton":
@@ -366,25 +642,75 @@ func (sio *SerialIO) handleLine(line string) {
 		sio.handleSwitchOutput(data)
 	case "GetCurrentOutputDevice":
 		sio.handleGetCurrentOutputDevice()
+	case "Layer":
+		sio.handleLayer(data)
 	default:
 		sio.logger.Debugw("Unknown command", "command", command)
 	}
 }
 
+// handleHello processes the controller's handshake, and checks it against the configured mappings
+func (sio *SerialIO) handleHello(data []string) {
+	info, err := parseDeviceInfo(data)
+	if err != nil {
+		sio.logger.Warnw("Invalid hello", "data", data, "error", err)
+		sio.sendResponse("ERROR")
+		return
+	}
+
+	sio.deviceInfoLock.Lock()
+	sio.deviceInfo = &info
+	sio.deviceInfoLock.Unlock()
+
+	sio.logger.Infow("Controller identified itself", "deviceInfo", info)
+
+	// reply with our own protocol version first, the firmware only waits so long.
+	// every capability the firmware offered is one that deej supports, and the reply confirms it
+	reply := fmt.Sprintf("Hello|%d", controllerProtocolVersion)
+	if info.BinarySliders {
+		reply += "|" + capabilityBinarySliders
+	}
+
+	sio.sendResponse(reply)
+
+	sio.validateDeviceInfo(info)
+
+	for _, consumer := range sio.deviceInfoConsumers {
+		consumer <- info
+	}
+}
+
+// validateDeviceInfo notifies the user when the controller doesn't match the configured mappings.
+// the same mismatch is only notified about once, so that reconnecting doesn't spam notifications
+func (sio *SerialIO) validateDeviceInfo(info DeviceInfo) {
+	mismatches := info.mismatches(sio.deej.config, sio.controllerConfig())
+	notice := strings.Join(mismatches, "; ")
+
+	sio.deviceInfoLock.Lock()
+	alreadyNotified := notice == sio.lastDeviceMismatch
+	sio.lastDeviceMismatch = notice
+	sio.deviceInfoLock.Unlock()
+
+	if len(mismatches) == 0 || alreadyNotified {
+		return
+	}
+
+	sio.logger.Warnw("Controller doesn't match the configuration", "deviceInfo", info, "mismatches", mismatches)
+
+	sio.deej.notifier.Notify("deej - Controller mismatch",
+		fmt.Sprintf("Please check %s: %s", userConfigFilepath, notice))
+}
+
 // handleSliders processes slider data and sends move events
 func (sio *SerialIO) handleSliders(data []string) {
-	numSliders := len(data)
-
-	if numSliders != sio.deej.config.SliderMapping.NumSliders() {
-		sio.logger.Warnw("Received unexpected number of sliders",
-			"expected", sio.deej.config.SliderMapping.NumSliders(),
-			"received", numSliders)
+	if !sio.expectSliders(len(data)) {
 		// Send OK anyway
 		sio.sendResponse("OK")
 		return
 	}
 
 	moveEvents := []SliderMoveEvent{}
+	rawValues := make([]int, 0, len(data))
 
 	for sliderIdx, stringValue := range data {
 		number, err := strconv.Atoi(stringValue)
@@ -393,55 +719,158 @@ func (sio *SerialIO) handleSliders(data []string) {
 			continue
 		}
 
-		// Validate raw values don't exceed 4095 - normalize if they do
-		var dirtyFloat float32
-		if number > 4095 {
-			dirtyFloat = 1.0
-			sio.logger.Debugw("Got value > 4095, normalizing to 1.0", "value", number, "slider", sliderIdx)
-		} else if number < 0 {
-			dirtyFloat = 0.0
-		} else {
-			// Normalize 12-bit ADC (0-4095) to 0.0-1.0
-			dirtyFloat = float32(number) / 4095.0
+		rawValues = append(rawValues, number)
+
+		if moveEvent, moved := sio.sliderValueMoved(sliderIdx, number); moved {
+			moveEvents = append(moveEvents, moveEvent)
 		}
+	}
 
-		// Normalize to 2 points of precision using util function
-		normalizedScalar := util.NormalizeScalar(dirtyFloat)
+	sio.broadcastSliderMoves(moveEvents)
 
-		// Apply invert if configured
-		if sio.deej.config.InvertSliders {
-			normalizedScalar = 1.0 - normalizedScalar
-		}
+	// raw values are only useful as a whole, so skip lines that had invalid ones
+	if len(rawValues) == len(data) {
+		sio.broadcastRawSliderValues(rawValues)
+	}
 
-		// Check if significantly different (noise reduction)
-		if util.SignificantlyDifferent(sio.currentSliderPercentValues[sliderIdx], normalizedScalar, sio.deej.config.NoiseReductionLevel) {
+	// Send OK response
+	sio.sendResponse("OK")
+}
 
-			// Update current value
-			sio.currentSliderPercentValues[sliderIdx] = normalizedScalar
+// handleBinarySliders processes the values of a binary slider frame, which aren't replied to
+func (sio *SerialIO) handleBinarySliders(values []int) {
+	if !sio.expectSliders(len(values)) {
+		return
+	}
 
-			// Create move event
-			moveEvents = append(moveEvents, SliderMoveEvent{
-				SliderID:     sliderIdx,
-				PercentValue: normalizedScalar,
-			})
+	moveEvents := []SliderMoveEvent{}
 
-			if sio.deej.Verbose() {
-				sio.logger.Debugw("Slider moved", "event", moveEvents[len(moveEvents)-1])
-			}
+	for sliderIdx, number := range values {
+		if moveEvent, moved := sio.sliderValueMoved(sliderIdx, number); moved {
+			moveEvents = append(moveEvents, moveEvent)
 		}
 	}
 
-	// Broadcast events to all consumers
-	if len(moveEvents) > 0 {
-		for _, consumer := range sio.sliderMoveConsumers {
-			for _, moveEvent := range moveEvents {
-				consumer <- moveEvent
-			}
+	sio.broadcastSliderMoves(moveEvents)
+	sio.broadcastRawSliderValues(values)
+}
+
+// expectSliders returns true if the controller is expected to send this many slider values
+func (sio *SerialIO) expectSliders(numSliders int) bool {
+
+	// a controller that identified itself is trusted over the mapping, which doesn't have to cover every slider.
+	// one that didn't is expected to send the mapped sliders from its offset on, unless the config says otherwise
+	controller := sio.controllerConfig()
+
+	expectedSliders := sio.deej.config.numMappedSliders() - controller.SliderOffset
+	if controller.Sliders > 0 {
+		expectedSliders = controller.Sliders
+	}
+
+	if info, ok := sio.DeviceInfo(); ok {
+		expectedSliders = info.NumSliders
+	}
+
+	if numSliders != expectedSliders {
+		sio.logger.Warnw("Received unexpected number of sliders",
+			"expected", expectedSliders,
+			"received", numSliders)
+
+		return false
+	}
+
+	// the slider count can grow with a config reload or a handshake
+	for len(sio.currentSliderPercentValues) < numSliders {
+		sio.currentSliderPercentValues = append(sio.currentSliderPercentValues, -1.0)
+	}
+
+	return true
+}
+
+// sliderValueMoved calibrates a raw slider value, and returns a move event if it differs enough from the current one.
+// sliderIdx is the slider's index on the controller, which the controller's offset turns into the one that's mapped
+func (sio *SerialIO) sliderValueMoved(sliderIdx int, number int) (SliderMoveEvent, bool) {
+	mappedIdx := sio.controllerConfig().SliderOffset + sliderIdx
+
+	// the calibration clamps values outside of the slider's range, and takes care of inverting it
+	calibration := sio.deej.config.SliderCalibration.forSlider(mappedIdx)
+	if number > calibration.MaxRaw && sio.deej.Verbose() {
+		sio.logger.Debugw("Got value above the slider's calibrated range, normalizing to 1.0",
+			"value", number,
+			"slider", mappedIdx,
+			"max", calibration.MaxRaw)
+	}
+
+	value := calibration.normalize(number)
+	for _, stage := range sio.filterStages(sliderIdx) {
+		value = stage.filter(value)
+	}
+
+	// Normalize to 2 points of precision using util function
+	normalizedScalar := util.NormalizeScalar(value)
+
+	// Check if significantly different (noise reduction)
+	hysteresis := sio.deej.config.SliderFilters.forSlider(mappedIdx).Hysteresis
+	if !util.SignificantlyDifferent(sio.currentSliderPercentValues[sliderIdx], normalizedScalar, hysteresis) {
+		return SliderMoveEvent{}, false
+	}
+
+	// Update current value
+	sio.currentSliderPercentValues[sliderIdx] = normalizedScalar
+
+	moveEvent := SliderMoveEvent{
+		SliderID:     mappedIdx,
+		PercentValue: normalizedScalar,
+	}
+
+	if sio.deej.Verbose() {
+		sio.logger.Debugw("Slider moved", "event", moveEvent)
+	}
+
+	return moveEvent, true
+}
+
+// filterStages returns the slider's smoothing stages, starting over with fresh ones when the filters were reconfigured.
+// they're kept by the slider's index on the controller, and configured by its mapped one
+func (sio *SerialIO) filterStages(sliderIdx int) []sliderFilterStage {
+	if filters := sio.deej.config.SliderFilters; filters != sio.sliderFiltersFrom {
+		sio.sliderFilterStages = nil
+		sio.sliderFiltersFrom = filters
+	}
+
+	sliderOffset := sio.controllerConfig().SliderOffset
+
+	for len(sio.sliderFilterStages) <= sliderIdx {
+		sio.sliderFilterStages = append(sio.sliderFilterStages,
+			sio.sliderFiltersFrom.forSlider(sliderOffset+len(sio.sliderFilterStages)).stages())
+	}
+
+	return sio.sliderFilterStages[sliderIdx]
+}
+
+// broadcastSliderMoves sends move events to all consumers
+func (sio *SerialIO) broadcastSliderMoves(moveEvents []SliderMoveEvent) {
+	for _, consumer := range sio.sliderMoveConsumers {
+		for _, moveEvent := range moveEvents {
+			consumer <- moveEvent
 		}
 	}
+}
 
-	// Send OK response
-	sio.sendResponse("OK")
+// broadcastConnection lets consumers know that the controller connected or disconnected
+func (sio *SerialIO) broadcastConnection(connected bool) {
+	event := ConnectionEvent{Connected: connected, Transport: fmt.Sprint(sio.transport)}
+
+	for _, consumer := range sio.connectionEventConsumers {
+		consumer <- event
+	}
+}
+
+// broadcastRawSliderValues sends raw slider values to all consumers
+func (sio *SerialIO) broadcastRawSliderValues(values []int) {
+	for _, consumer := range sio.rawSliderConsumers {
+		consumer <- values
+	}
 }
 
 // handleMuteButtons processes mute button data and sends state back
@@ -474,8 +903,9 @@ func (sio *SerialIO) handleMuteButton(data []string) {
 		return
 	}
 
+	// the controller's offset turns its own button index into the one that's mapped
 	event := MuteButtonClickEvent{
-		MuteButtonID: buttonIdx,
+		MuteButtonID: sio.controllerConfig().MuteButtonOffset + buttonIdx,
 		mute:         muteState,
 	}
 
@@ -484,13 +914,29 @@ func (sio *SerialIO) handleMuteButton(data []string) {
 	}
 
 	// Call consumer with single event
-	_, err = sio.muteButtonsConsumer([]MuteButtonClickEvent{event})
+	state, err := sio.muteButtonsConsumer([]MuteButtonClickEvent{event})
 	if err != nil {
 		sio.logger.Warnw("Error handling mute button", "error", err)
 		sio.sendResponse("ERROR")
 		return
 	}
 
+	// report the state the button actually ended up in, which isn't the requested one if muting failed
+	if len(state.MuteButtons) > 0 {
+		event.mute = state.MuteButtons[0]
+	}
+
+	for _, consumer := range sio.muteButtonEventConsumers {
+		consumer <- event
+	}
+
+	// the LED already shows this, as the firmware updates it optimistically
+	sio.stateLock.Lock()
+	if sio.boardState != nil && buttonIdx >= 0 && buttonIdx < len(sio.boardState.MuteButtons) {
+		sio.boardState.MuteButtons[buttonIdx] = muteState
+	}
+	sio.stateLock.Unlock()
+
 	// Respond with OK (firmware already updated LEDs optimistically)
 	sio.sendResponse("OK")
 }
@@ -525,17 +971,122 @@ func (sio *SerialIO) handleSwitchOutput(data []string) {
 	}
 
 	// Call consumer to switch device
-	_, err = sio.toggleOutputDeviceConsumer(event)
+	state, err := sio.toggleOutputDeviceConsumer(event)
 	if err != nil {
 		sio.logger.Warnw("Error handling output device switch", "error", err)
 		sio.sendResponse("ERROR")
 		return
 	}
 
+	for _, consumer := range sio.outputDeviceEventConsumers {
+		consumer <- state.selectedOutputDevice
+	}
+
+	// the LEDs already show the requested device. if the switch didn't take, the next state push corrects them
+	sio.stateLock.Lock()
+	if sio.boardState != nil {
+		sio.boardState.OutputDevice = deviceIdx
+	}
+	sio.stateLock.Unlock()
+
 	// Respond with OK (firmware already updated LEDs and state optimistically)
 	sio.sendResponse("OK")
 }
 
+// handleLayer processes slider layer switching
+func (sio *SerialIO) handleLayer(data []string) {
+
+	// Parse: Layer|index
+	if len(data) != 1 {
+		sio.logger.Warnw("Invalid layer data", "data", data)
+		sio.sendResponse("ERROR")
+		return
+	}
+
+	layer, err := strconv.Atoi(data[0])
+	if err != nil {
+		sio.logger.Warnw("Invalid layer index", "value", data[0], "error", err)
+		sio.sendResponse("ERROR")
+		return
+	}
+
+	if err := sio.deej.SwitchLayer(layer); err != nil {
+		sio.logger.Warnw("Error handling layer switch", "error", err)
+		sio.sendResponse("ERROR")
+		return
+	}
+
+	// the controller shows the layer it asked for
+	sio.stateLock.Lock()
+	if sio.boardState != nil {
+		sio.boardState.Layer = layer
+	}
+	sio.stateLock.Unlock()
+
+	sio.sendResponse("OK")
+}
+
+// pushState sends the controller whatever part of the audio state its LEDs don't show yet,
+// which is how changes made outside deej (i.e. muting the mic from the OS) reach it
+func (sio *SerialIO) pushState(getState func() ControllerState) {
+	if info, ok := sio.DeviceInfo(); sio.connection() == nil || !ok || info.ProtocolVersion < statePushProtocolVersion {
+		return
+	}
+
+	sio.stateLock.Lock()
+	rateLimited := time.Since(sio.lastStatePush) < minTimeBetweenStatePushes
+	sio.stateLock.Unlock()
+
+	if rateLimited {
+		return
+	}
+
+	// this goes through the session map, so don't hold up the read loop while it does
+	state := getState()
+	state.MuteButtons = sio.controllerMuteStates(state.MuteButtons)
+
+	sio.stateLock.Lock()
+	defer sio.stateLock.Unlock()
+
+	lines := []string{}
+
+	if len(state.MuteButtons) > 0 && (sio.boardState == nil || !muteStatesEqual(state.MuteButtons, sio.boardState.MuteButtons)) {
+		values := make([]string, len(state.MuteButtons))
+		for idx, muted := range state.MuteButtons {
+			values[idx] = "0"
+			if muted {
+				values[idx] = "1"
+			}
+		}
+
+		lines = append(lines, "MuteState|"+strings.Join(values, "|"))
+	}
+
+	if sio.boardState == nil || state.OutputDevice != sio.boardState.OutputDevice {
+		lines = append(lines, fmt.Sprintf("OutputDevice|%d", state.OutputDevice))
+	}
+
+	if info, _ := sio.DeviceInfo(); info.ProtocolVersion >= layerProtocolVersion &&
+		(sio.boardState == nil || state.Layer != sio.boardState.Layer) {
+		lines = append(lines, fmt.Sprintf("Layer|%d", state.Layer))
+	}
+
+	if len(lines) == 0 {
+		return
+	}
+
+	if sio.deej.Verbose() {
+		sio.logger.Debugw("Pushing state to controller", "state", state)
+	}
+
+	for _, line := range lines {
+		sio.sendPush(line)
+	}
+
+	sio.boardState = &state
+	sio.lastStatePush = time.Now()
+}
+
 // handleGetCurrentOutputDevice sends the current output device index
 func (sio *SerialIO) handleGetCurrentOutputDevice() {
 	if sio.toggleOutputDeviceConsumer == nil {
@@ -564,16 +1115,37 @@ func (sio *SerialIO) handleGetCurrentOutputDevice() {
 	sio.sendResponse(response)
 }
 
-// sendResponse writes a response to the serial port
+// sendResponse writes a response to the line that's currently being handled to the controller
 func (sio *SerialIO) sendResponse(response string) {
-	if !sio.connected || sio.conn == nil {
+	sio.writeLine(response, sio.ackSeq)
+}
+
+// sendPush writes a line that deej sends on its own, which doesn't acknowledge anything
+func (sio *SerialIO) sendPush(line string) {
+	sio.writeLine(line, "")
+}
+
+// writeLine writes a line to the controller, framed with the given sequence number if the controller frames its own
+func (sio *SerialIO) writeLine(response string, ackSeq string) {
+	conn := sio.connection()
+	if conn == nil {
 		sio.logger.Warn("Cannot send response: not connected")
 		return
 	}
 
+	sio.writeLock.Lock()
+	defer sio.writeLock.Unlock()
+
+	sio.frameLock.Lock()
+	framed := sio.framed
+	sio.frameLock.Unlock()
+
 	responseWithNewline := response + "\n"
+	if framed {
+		responseWithNewline = encodeFrame(ackSeq, response) + "\n"
+	}
 
-	_, err := sio.conn.Write([]byte(responseWithNewline))
+	_, err := conn.Write([]byte(responseWithNewline))
 	if err != nil {
 		sio.logger.Warnw("Error writing response", "error", err, "response", response)
 		return
@@ -581,3 +1153,34 @@ func (sio *SerialIO) sendResponse(response string) {
 
 	sio.logger.Debugw("Sent response", "response", response)
 }
+
+// controllerMuteStates returns the mute states of this controller's buttons, from its offset on.
+// a controller that shares the mute buttons with others only gets as many as it has
+func (sio *SerialIO) controllerMuteStates(muteStates []bool) []bool {
+	offset := sio.controllerConfig().MuteButtonOffset
+	if offset >= len(muteStates) {
+		return []bool{}
+	}
+
+	controllerStates := append([]bool{}, muteStates[offset:]...)
+
+	if info, ok := sio.DeviceInfo(); ok && len(sio.deej.config.Controllers) > 1 && info.NumButtons < len(controllerStates) {
+		controllerStates = controllerStates[:info.NumButtons]
+	}
+
+	return controllerStates
+}
+
+func muteStatesEqual(a []bool, b []bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,278 @@
+package deej
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.bug.st/serial/enumerator"
+	"go.uber.org/zap"
+)
+
+const (
+
+	// how long a candidate port has to send a valid line before it's rejected.
+	// most ESP32 boards reset when their port is opened, so this needs to cover booting too
+	portProbeTimeout = 3 * time.Second
+
+	// how long a single read may block while probing, so that the probe timeout is respected
+	portProbeReadTimeout = 100 * time.Millisecond
+)
+
+// the USB-to-UART bridges (and native USB chips) that ESP32 boards commonly use, as VID:PID pairs
+var defaultSerialUSBIDs = []string{
+	"10C4:EA60", // Silicon Labs CP210x
+	"1A86:7523", // WCH CH340
+	"1A86:55D4", // WCH CH9102
+	"0403:6001", // FTDI FT232R
+	"303A:1001", // Espressif native USB (ESP32-S3/C3)
+}
+
+// portCandidate is a serial port considered during auto-detection, along with why it was rejected
+type portCandidate struct {
+	name      string
+	usbID     string
+	preferred bool
+	rejection string
+}
+
+type portProbeResult struct {
+	candidate *portCandidate
+	err       error
+}
+
+// portDetector finds the controller among the system's serial ports
+type portDetector struct {
+	logger *zap.SugaredLogger
+
+	// VID:PID pairs whose ports are probed before any other USB port
+	usbIDs []string
+
+	listPorts    func() ([]*enumerator.PortDetails, error)
+	probe        func(port string) error
+	probeTimeout time.Duration
+}
+
+func newPortDetector(logger *zap.SugaredLogger, usbIDs []string, baudRate uint) *portDetector {
+	d := &portDetector{
+		logger:       logger.Named("detect"),
+		usbIDs:       usbIDs,
+		listPorts:    enumerator.GetDetailedPortsList,
+		probeTimeout: portProbeTimeout,
+	}
+
+	d.probe = func(port string) error {
+		return probeSerialPort(port, baudRate, d.probeTimeout)
+	}
+
+	return d
+}
+
+// detect returns the first port that sent a valid line, preferring ports with a configured USB ID.
+// every candidate is logged together with the reason it was rejected
+func (d *portDetector) detect() (string, error) {
+	ports, err := d.listPorts()
+	if err != nil {
+		return "", fmt.Errorf("enumerate serial ports: %w", err)
+	}
+
+	if len(ports) == 0 {
+		return "", errors.New("no serial ports found")
+	}
+
+	candidates := make([]*portCandidate, 0, len(ports))
+
+	for _, port := range ports {
+		candidate := &portCandidate{name: port.Name}
+
+		if port.IsUSB {
+			candidate.usbID = strings.ToUpper(fmt.Sprintf("%s:%s", port.VID, port.PID))
+			candidate.preferred = d.preferredUSBID(candidate.usbID)
+		} else {
+
+			// built-in and virtual (i.e. bluetooth) ports are never the controller, and can take ages to open
+			candidate.rejection = "not a USB device"
+		}
+
+		candidates = append(candidates, candidate)
+	}
+
+	// probe preferred ports first, and fall back to the remaining USB ports only if none of them respond
+	chosen := ""
+
+	for _, preferred := range []bool{true, false} {
+		if chosen = d.probeCandidates(candidates, preferred); chosen != "" {
+			break
+		}
+	}
+
+	for _, candidate := range candidates {
+		if candidate.name == chosen {
+			d.logger.Infow("Serial port candidate accepted",
+				"port", candidate.name,
+				"usbID", candidate.usbID,
+				"preferred", candidate.preferred)
+
+			continue
+		}
+
+		if candidate.rejection == "" {
+			candidate.rejection = "not probed, a preferred port responded"
+		}
+
+		d.logger.Infow("Serial port candidate rejected",
+			"port", candidate.name,
+			"usbID", candidate.usbID,
+			"preferred", candidate.preferred,
+			"reason", candidate.rejection)
+	}
+
+	if chosen != "" {
+		return chosen, nil
+	}
+
+	reasons := make([]string, len(candidates))
+	for idx, candidate := range candidates {
+		reasons[idx] = fmt.Sprintf("%s: %s", candidate.name, candidate.rejection)
+	}
+
+	return "", fmt.Errorf("no valid serial port found (%s)", strings.Join(reasons, "; "))
+}
+
+// probeCandidates probes every unrejected candidate of the given preference in parallel,
+// and returns the alphabetically first one that responded, or an empty string if none did
+func (d *portDetector) probeCandidates(candidates []*portCandidate, preferred bool) string {
+	pending := map[*portCandidate]bool{}
+	results := make(chan portProbeResult, len(candidates))
+
+	for _, candidate := range candidates {
+		if candidate.rejection != "" || candidate.preferred != preferred {
+			continue
+		}
+
+		pending[candidate] = true
+
+		go func(candidate *portCandidate) {
+			results <- portProbeResult{candidate: candidate, err: d.probe(candidate.name)}
+		}(candidate)
+	}
+
+	responded := []string{}
+
+	// probes time out on their own, this only guards against a port driver that blocks regardless
+	timeout := time.After(d.probeTimeout + time.Second)
+
+	for len(pending) > 0 {
+		select {
+		case result := <-results:
+			delete(pending, result.candidate)
+
+			if result.err != nil {
+				result.candidate.rejection = result.err.Error()
+				continue
+			}
+
+			responded = append(responded, result.candidate.name)
+
+		case <-timeout:
+			for candidate := range pending {
+				candidate.rejection = fmt.Sprintf("probe didn't finish within %s", d.probeTimeout)
+				delete(pending, candidate)
+			}
+		}
+	}
+
+	if len(responded) == 0 {
+		return ""
+	}
+
+	sort.Strings(responded)
+
+	// more than one controller is unusual, but the others should still be accounted for
+	for _, candidate := range candidates {
+		if candidate.rejection == "" && candidate.preferred == preferred && candidate.name != responded[0] {
+			candidate.rejection = fmt.Sprintf("responded too, but %s was chosen", responded[0])
+		}
+	}
+
+	return responded[0]
+}
+
+func (d *portDetector) preferredUSBID(usbID string) bool {
+	for _, preferred := range d.usbIDs {
+		if strings.EqualFold(preferred, usbID) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// probeSerialPort opens a port and waits for it to send a valid line of the deej protocol
+func probeSerialPort(port string, baudRate uint, timeout time.Duration) error {
+	conn, err := newSerialTransport(port, baudRate).Open()
+	if err != nil {
+		return fmt.Errorf("failed to open: %w", err)
+	}
+	defer conn.Close()
+
+	// without a read timeout, a silent port would keep us waiting forever
+	if port, ok := conn.(interface{ SetReadTimeout(time.Duration) error }); ok {
+		port.SetReadTimeout(portProbeReadTimeout)
+	}
+
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, maxPacketSize)
+	received := []byte{}
+	firstLine := true
+	lastInvalidLine := ""
+
+	for time.Now().Before(deadline) {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return fmt.Errorf("failed to read: %w", err)
+		}
+
+		received = append(received, buf[:n]...)
+
+		for {
+			newline := bytes.IndexByte(received, '\n')
+			if newline < 0 {
+				break
+			}
+
+			line := strings.TrimSpace(string(received[:newline]))
+			received = received[newline+1:]
+
+			// the first line might've started before we opened the port
+			if firstLine {
+				firstLine = false
+				continue
+			}
+
+			// framed lines count too, as long as they're intact
+			if isFrame(line) {
+				if _, framedLine, err := parseFrame(line); err == nil {
+					line = framedLine
+				}
+			}
+
+			if expectedLinePattern.MatchString(line) {
+				return nil
+			}
+
+			if line != "" {
+				lastInvalidLine = line
+			}
+		}
+	}
+
+	if lastInvalidLine != "" {
+		return fmt.Errorf("unexpected data: %q", lastInvalidLine)
+	}
+
+	return fmt.Errorf("no valid data within %s", timeout)
+}
@@ -0,0 +1,117 @@
+package deej
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.bug.st/serial/enumerator"
+	"go.uber.org/zap"
+)
+
+// newTestPortDetector creates a detector over the given ports, where only the ports in responding pass the probe
+func newTestPortDetector(ports []*enumerator.PortDetails, responding ...string) *portDetector {
+	detector := newPortDetector(zap.NewNop().Sugar(), defaultSerialUSBIDs, 115200)
+	detector.probeTimeout = 100 * time.Millisecond
+
+	detector.listPorts = func() ([]*enumerator.PortDetails, error) {
+		return ports, nil
+	}
+
+	detector.probe = func(port string) error {
+		for _, name := range responding {
+			if name == port {
+				return nil
+			}
+		}
+
+		return errors.New("no valid data within 3s")
+	}
+
+	return detector
+}
+
+var testSerialPorts = []*enumerator.PortDetails{
+	{Name: "/dev/ttyS0"},
+	{Name: "/dev/ttyACM0", IsUSB: true, VID: "2341", PID: "0043"},
+	{Name: "/dev/ttyUSB0", IsUSB: true, VID: "10c4", PID: "ea60"},
+}
+
+// TestPortDetectionPrefersConfiguredUSBIDs tests that ports with a configured VID:PID are probed first
+func TestPortDetectionPrefersConfiguredUSBIDs(t *testing.T) {
+	detector := newTestPortDetector(testSerialPorts, "/dev/ttyUSB0", "/dev/ttyACM0")
+
+	port, err := detector.detect()
+	if err != nil {
+		t.Fatalf("Failed to detect port: %v", err)
+	}
+
+	if port != "/dev/ttyUSB0" {
+		t.Errorf("Expected the CP210x port /dev/ttyUSB0, got %s", port)
+	}
+}
+
+// TestPortDetectionFallsBackToOtherUSBPorts tests that other USB ports are probed when no preferred one responds
+func TestPortDetectionFallsBackToOtherUSBPorts(t *testing.T) {
+	detector := newTestPortDetector(testSerialPorts, "/dev/ttyACM0")
+
+	port, err := detector.detect()
+	if err != nil {
+		t.Fatalf("Failed to detect port: %v", err)
+	}
+
+	if port != "/dev/ttyACM0" {
+		t.Errorf("Expected /dev/ttyACM0, got %s", port)
+	}
+}
+
+// TestPortDetectionReportsRejections tests that every candidate is reported along with why it was rejected
+func TestPortDetectionReportsRejections(t *testing.T) {
+	detector := newTestPortDetector(testSerialPorts)
+
+	_, err := detector.detect()
+	if err == nil {
+		t.Fatal("Expected an error when no port responds")
+	}
+
+	for _, expected := range []string{
+		"/dev/ttyS0: not a USB device",
+		"/dev/ttyACM0: no valid data within 3s",
+		"/dev/ttyUSB0: no valid data within 3s",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected the error to contain '%s', got '%v'", expected, err)
+		}
+	}
+
+	detector = newTestPortDetector(nil)
+
+	if _, err := detector.detect(); err == nil {
+		t.Error("Expected an error without any serial ports")
+	}
+}
+
+// TestPortDetectionTimesOut tests that a probe that never returns doesn't block detection
+func TestPortDetectionTimesOut(t *testing.T) {
+	detector := newTestPortDetector(testSerialPorts)
+
+	blocked := make(chan bool)
+	defer close(blocked)
+
+	detector.probe = func(port string) error {
+		<-blocked
+		return nil
+	}
+
+	start := time.Now()
+
+	_, err := detector.detect()
+	if err == nil || !strings.Contains(err.Error(), "probe didn't finish") {
+		t.Errorf("Expected the probes to time out, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected detection to give up on blocked probes, took %s", elapsed)
+	}
+}
@@ -45,7 +45,7 @@ func (m *mockSerialConnection) Close() error {
 }
 
 // Helper to create test config file
-func createTestConfig(t *testing.T, content string) func() {
+func createTestConfig(t testing.TB, content string) func() {
 	if err := os.WriteFile("config.yaml", []byte(content), 0644); err != nil {
 		t.Fatalf("Failed to write test config: %v", err)
 	}
@@ -54,6 +54,19 @@ func createTestConfig(t *testing.T, content string) func() {
 	}
 }
 
+// sendSliders hands slider data to sio on a separate goroutine, because move events are delivered
+// over unbuffered channels. The returned channel is closed once the data has been fully handled
+func sendSliders(sio *SerialIO, data []string) chan bool {
+	done := make(chan bool)
+
+	go func() {
+		sio.handleSliders(data)
+		close(done)
+	}()
+
+	return done
+}
+
 // Helper function for absolute value
 func absFloat(x float32) float32 {
 	if x < 0 {
@@ -109,7 +122,7 @@ serial_connection_info:
 	expectedValues := []float32{1.0, 0.5, 0.25, 0.125, 0.0}
 
 	// Handle sliders directly
-	sio.handleSliders(sliderData)
+	sendSliders(sio, sliderData)
 
 	// Collect events with timeout
 	receivedEvents := make(map[int]float32)
@@ -197,8 +210,8 @@ serial_connection_info:
 	})
 
 	// Handle mute button data
-	muteData := []string{"true", "false"}
-	sio.handleMuteButtons(muteData)
+	muteData := []string{"1", "true"}
+	sio.handleMuteButton(muteData)
 
 	if !consumerCalled {
 		t.Error("Consumer was not called")
@@ -210,10 +223,27 @@ serial_connection_info:
 	}
 
 	response := strings.TrimSpace(mockConn.writeBuffer[0])
-	expectedResponse := "MuteState|true|false"
+	expectedResponse := "OK"
 	if response != expectedResponse {
 		t.Errorf("Expected response '%s', got '%s'", expectedResponse, response)
 	}
+
+	// the event stream reports the state the button ended up in, not the one it asked for
+	muteButtonEvents := sio.SubscribeToMuteButtonEvents()
+	sio.setMuteButtonClickEventConsumer(func(events []MuteButtonClickEvent) (MuteButtonsState, error) {
+		return MuteButtonsState{MuteButtons: []bool{false}}, nil
+	})
+
+	go sio.handleMuteButton([]string{"1", "true"})
+
+	select {
+	case event := <-muteButtonEvents:
+		if event.mute {
+			t.Error("Expected the event to report the button as unmuted, as muting failed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the mute button event")
+	}
 }
 
 // TestDeviceSwitchHandling tests device switch request/response handling
@@ -276,13 +306,13 @@ serial_connection_info:
 		t.Error("Consumer was not called")
 	}
 
-	// Check response
+	// Check response (the firmware switches its LEDs optimistically and only waits for an acknowledgement)
 	if len(mockConn.writeBuffer) == 0 {
 		t.Fatal("No response was written")
 	}
 
 	response := strings.TrimSpace(mockConn.writeBuffer[0])
-	expectedResponse := "OutputDevice|1"
+	expectedResponse := "OK"
 	if response != expectedResponse {
 		t.Errorf("Expected response '%s', got '%s'", expectedResponse, response)
 	}
@@ -328,7 +358,7 @@ noise_reduction: "default"
 	eventChan := sio.SubscribeToSliderMoveEvents()
 
 	// Set initial value
-	sio.handleSliders([]string{"2048"})
+	sendSliders(sio, []string{"2048"})
 
 	// Drain initial event
 	select {
@@ -337,18 +367,18 @@ noise_reduction: "default"
 	}
 
 	// Send a value very close to the current one (should be filtered by noise reduction)
-	sio.handleSliders([]string{"2060"})
+	done := sendSliders(sio, []string{"2060"})
 
 	// Should NOT receive an event
 	select {
 	case event := <-eventChan:
 		t.Errorf("Received event when noise should have been filtered: %+v", event)
-	case <-time.After(100 * time.Millisecond):
-		// Expected: no event
+	case <-done:
+		// Expected: handled without an event
 	}
 
 	// Send a value significantly different (should pass through)
-	sio.handleSliders([]string{"3000"})
+	sendSliders(sio, []string{"3000"})
 
 	// Should receive an event
 	select {
@@ -401,7 +431,7 @@ invert_sliders: true
 	eventChan := sio.SubscribeToSliderMoveEvents()
 
 	// Send max value (4095)
-	sio.handleSliders([]string{"4095"})
+	sendSliders(sio, []string{"4095"})
 
 	// With invert, 4095 should become 0.0
 	select {
@@ -414,7 +444,7 @@ invert_sliders: true
 	}
 
 	// Send min value (0)
-	sio.handleSliders([]string{"0"})
+	sendSliders(sio, []string{"0"})
 
 	// With invert, 0 should become 1.0
 	select {
@@ -473,7 +503,7 @@ serial_connection_info:
 	eventChan := sio.SubscribeToSliderMoveEvents()
 
 	// Send malformed data (value > 4095)
-	sio.handleSliders([]string{"5000"})
+	done := sendSliders(sio, []string{"5000"})
 
 	// Should normalize to 1.0
 	select {
@@ -486,6 +516,7 @@ serial_connection_info:
 	}
 
 	// Check that OK was sent
+	<-done
 	if len(mockConn.writeBuffer) == 0 {
 		t.Fatal("No response was written")
 	}
@@ -560,3 +591,96 @@ serial_connection_info:
 		t.Errorf("Expected response '%s', got '%s'", expectedResponse, response)
 	}
 }
+
+// TestStatePush tests that state changes are pushed to capable controllers only, rate limited and without repeats
+func TestStatePush(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, `
+slider_mapping:
+  0: master
+mute_button_mapping:
+  0: master
+  1: mic
+`)
+	defer cleanup()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	sio, err := NewSerialIO(deej, logger)
+	if err != nil {
+		t.Fatalf("Failed to create SerialIO: %v", err)
+	}
+
+	mockConn := &mockSerialConnection{writeBuffer: []string{}}
+	sio.conn = mockConn
+	sio.connected = true
+
+	sio.setMuteButtonClickEventConsumer(func(events []MuteButtonClickEvent) (MuteButtonsState, error) {
+		return MuteButtonsState{MuteButtons: []bool{events[0].mute}}, nil
+	})
+
+	state := ControllerState{MuteButtons: []bool{false, true}, OutputDevice: 1}
+	getStateCalls := 0
+	getState := func() ControllerState {
+		getStateCalls++
+		return ControllerState{MuteButtons: append([]bool{}, state.MuteButtons...), OutputDevice: state.OutputDevice}
+	}
+
+	// pushed lines written since the last call, ignoring replies
+	pushed := func() []string {
+		lines := []string{}
+		for _, line := range mockConn.writeBuffer {
+			if line = strings.TrimSpace(line); line != "OK" && !strings.HasPrefix(line, "Hello") {
+				lines = append(lines, line)
+			}
+		}
+
+		mockConn.writeBuffer = []string{}
+		return lines
+	}
+
+	// older firmware would take pushed lines for replies
+	sio.handleLine("Hello|1|1|2|0|1.0.0")
+	sio.pushState(getState)
+
+	if lines := pushed(); len(lines) != 0 || getStateCalls != 0 {
+		t.Fatalf("Expected no push to a protocol v1 controller, got %v", lines)
+	}
+
+	sio.handleLine("Hello|2|1|2|0|1.1.0")
+	sio.pushState(getState)
+
+	if lines := pushed(); strings.Join(lines, ",") != "MuteState|0|1,OutputDevice|1" {
+		t.Fatalf("Expected the full state after connecting, got %v", lines)
+	}
+
+	// changes within the rate limit wait for a later push
+	state.MuteButtons[0] = true
+	sio.pushState(getState)
+
+	if lines := pushed(); len(lines) != 0 {
+		t.Errorf("Expected the push to be rate limited, got %v", lines)
+	}
+
+	sio.lastStatePush = time.Now().Add(-minTimeBetweenStatePushes)
+	sio.pushState(getState)
+
+	if lines := pushed(); strings.Join(lines, ",") != "MuteState|1|1" {
+		t.Errorf("Expected only the changed mute state, got %v", lines)
+	}
+
+	// a mute that came from the controller is already on its LEDs
+	state.MuteButtons[1] = false
+	sio.handleLine("MuteButton|1|false")
+
+	sio.lastStatePush = time.Now().Add(-minTimeBetweenStatePushes)
+	sio.pushState(getState)
+
+	if lines := pushed(); len(lines) != 0 {
+		t.Errorf("Expected no push for the controller's own change, got %v", lines)
+	}
+}
@@ -38,6 +38,14 @@ type baseSession struct {
 
 	// used by String(), needs to be set by child
 	humanReadableDesc string
+
+	// identifies the audio object (i.e. a stream or a device) behind this session.
+	// only set by session finders that report session events, see SessionWatcher
+	objectID string
+}
+
+func (s *baseSession) getObjectID() string {
+	return s.objectID
 }
 
 func (s *baseSession) Key() string {
@@ -1,11 +1,39 @@
 package deej
 
-import wca "github.com/moutend/go-wca/pkg/wca"
-
 // SessionFinder represents an entity that can find all current audio sessions
 type SessionFinder interface {
 	GetAllSessions() ([]Session, error)
-	getDefaultAudioEndpoints() (*wca.IMMDevice, *wca.IMMDevice, error)
+	OutputDeviceSwitcher
 
 	Release() error
 }
+
+// OutputDeviceSwitcher represents an entity that can look up and change the system's default audio output device
+type OutputDeviceSwitcher interface {
+
+	// SwitchOutputDevice makes the output device matching the given name the default one
+	SwitchOutputDevice(name string) error
+
+	// GetCurrentOutputDevice returns every name the current default output device is known by,
+	// so that it can be matched against any of the ones a user might put in their config
+	GetCurrentOutputDevice() ([]string, error)
+}
+
+// SessionWatcher represents a session finder that reports sessions as they come and go,
+// which lets the session map stay current without periodically re-acquiring every session
+type SessionWatcher interface {
+	SubscribeToSessionEvents() chan SessionEvent
+}
+
+// StateWatcher represents a session finder that reports when what controllers show might have changed outside deej
+// (i.e. something was muted from the OS, or the default device switched), so that it doesn't have to be polled for
+type StateWatcher interface {
+	SubscribeToStateChanges() chan bool
+}
+
+// SessionEvent reports the sessions that now belong to a single audio object (i.e. a stream or a device).
+// these replace whatever sessions the object had before, and an empty list means the object is gone
+type SessionEvent struct {
+	ObjectID string
+	Sessions []Session
+}
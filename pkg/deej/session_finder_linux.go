@@ -1,19 +1,64 @@
 package deej
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"strings"
+	"sync"
 
 	"github.com/jfreymuth/pulse/proto"
 	"go.uber.org/zap"
 )
 
+const (
+
+	// prefix for device sessions in logger
+	deviceSessionFormat = "device.%s"
+
+	// the property holding a sink or source's human-readable name, i.e. "Headphones"
+	devicePropertyDescription = "device.description"
+
+	// identify the PulseAudio objects behind sessions, see SessionEvent
+	sinkInputObjectFormat = "sink-input.%d"
+	sinkObjectFormat      = "sink.%d"
+	sourceObjectFormat    = "source.%d"
+	masterSinkObjectID    = "master.sink"
+	masterSourceObjectID  = "master.source"
+
+	// subscription masks and event bits, as defined by PulseAudio's def.h
+	paSubscriptionMaskSink      = 0x0001
+	paSubscriptionMaskSource    = 0x0002
+	paSubscriptionMaskSinkInput = 0x0004
+	paSubscriptionMaskServer    = 0x0080
+
+	paEventFacilityMask      = 0x000F
+	paEventFacilitySink      = 0x0000
+	paEventFacilitySource    = 0x0001
+	paEventFacilitySinkInput = 0x0002
+	paEventFacilityServer    = 0x0007
+
+	paEventTypeMask   = 0x0030
+	paEventTypeNew    = 0x0000
+	paEventTypeChange = 0x0010
+	paEventTypeRemove = 0x0020
+
+	// how many PulseAudio events can queue up while earlier ones are still being looked up
+	pulseEventQueueSize = 256
+)
+
 type paSessionFinder struct {
 	logger        *zap.SugaredLogger
 	sessionLogger *zap.SugaredLogger
 
-	client *proto.Client
+	client paClient
 	conn   net.Conn
+
+	pulseEvents chan proto.SubscribeEvent
+
+	sessionEventConsumers []chan SessionEvent
+	stateChangeConsumers  []chan bool
+	consumersLock         sync.Mutex
 }
 
 func newSessionFinder(logger *zap.SugaredLogger) (SessionFinder, error) {
@@ -23,6 +68,18 @@ func newSessionFinder(logger *zap.SugaredLogger) (SessionFinder, error) {
 		return nil, fmt.Errorf("establish PulseAudio connection: %w", err)
 	}
 
+	sf := &paSessionFinder{
+		logger:        logger.Named("session_finder"),
+		sessionLogger: logger.Named("sessions"),
+		client:        client,
+		conn:          conn,
+		pulseEvents:   make(chan proto.SubscribeEvent, pulseEventQueueSize),
+	}
+
+	// PulseAudio tells us about streams and devices as they come and go, so nobody has to poll for them.
+	// the callback goes in before the first request, so it's in place well before anything is subscribed to
+	client.Callback = sf.onPulseMessage
+
 	request := proto.SetClientName{
 		Props: proto.PropList{
 			"application.name": proto.PropListString("deej"),
@@ -34,11 +91,13 @@ func newSessionFinder(logger *zap.SugaredLogger) (SessionFinder, error) {
 		return nil, err
 	}
 
-	sf := &paSessionFinder{
-		logger:        logger.Named("session_finder"),
-		sessionLogger: logger.Named("sessions"),
-		client:        client,
-		conn:          conn,
+	subscribeRequest := proto.Subscribe{
+		Mask: paSubscriptionMaskSink | paSubscriptionMaskSource | paSubscriptionMaskSinkInput | paSubscriptionMaskServer,
+	}
+
+	if err := client.Request(&subscribeRequest, nil); err != nil {
+		logger.Warnw("Failed to subscribe to PulseAudio events", "error", err)
+		return nil, fmt.Errorf("subscribe to PulseAudio events: %w", err)
 	}
 
 	sf.logger.Debug("Created PA session finder instance")
@@ -71,6 +130,12 @@ func (sf *paSessionFinder) GetAllSessions() ([]Session, error) {
 		return nil, fmt.Errorf("enumerate audio sessions: %w", err)
 	}
 
+	// make every sink and source bindable by its description and name
+	if err := sf.enumerateAndAddDeviceSessions(&sessions); err != nil {
+		sf.logger.Warnw("Failed to enumerate device sessions", "error", err)
+		return nil, fmt.Errorf("enumerate device sessions: %w", err)
+	}
+
 	return sessions, nil
 }
 
@@ -97,7 +162,9 @@ func (sf *paSessionFinder) getMasterSinkSession() (Session, error) {
 	}
 
 	// create the master sink session
-	sink := newMasterSession(sf.sessionLogger, sf.client, reply.SinkIndex, reply.Channels, true)
+	sink := newMasterSession(sf.sessionLogger, sf.client, reply.SinkIndex, reply.Channels, true,
+		masterSessionName, masterSessionName)
+	sink.objectID = masterSinkObjectID
 
 	return sink, nil
 }
@@ -114,7 +181,9 @@ func (sf *paSessionFinder) getMasterSourceSession() (Session, error) {
 	}
 
 	// create the master source session
-	source := newMasterSession(sf.sessionLogger, sf.client, reply.SourceIndex, reply.Channels, false)
+	source := newMasterSession(sf.sessionLogger, sf.client, reply.SourceIndex, reply.Channels, false,
+		inputSessionName, inputSessionName)
+	source.objectID = masterSourceObjectID
 
 	return source, nil
 }
@@ -129,22 +198,397 @@ func (sf *paSessionFinder) enumerateAndAddSessions(sessions *[]Session) error {
 	}
 
 	for _, info := range reply {
-		name, ok := info.Properties["application.process.binary"]
-
+		newSession, ok := sf.newSinkInputSession(info)
 		if !ok {
-			sf.logger.Warnw("Failed to get sink input's process name",
-				"sinkInputIndex", info.SinkInputIndex)
+			continue
+		}
+
+		// add it to our slice
+		*sessions = append(*sessions, newSession)
+	}
+
+	return nil
+}
+
+// newSinkInputSession creates the deej session object for a sink input, which requires knowing its process
+func (sf *paSessionFinder) newSinkInputSession(info *proto.GetSinkInputInfoReply) (Session, bool) {
+	name, ok := info.Properties["application.process.binary"]
+
+	if !ok {
+		sf.logger.Warnw("Failed to get sink input's process name",
+			"sinkInputIndex", info.SinkInputIndex)
+
+		return nil, false
+	}
+
+	newSession := newPASession(sf.sessionLogger, sf.client, info.SinkInputIndex, info.Channels, name.String())
+	newSession.objectID = fmt.Sprintf(sinkInputObjectFormat, info.SinkInputIndex)
+
+	return newSession, true
+}
+
+func (sf *paSessionFinder) enumerateAndAddDeviceSessions(sessions *[]Session) error {
+	sinkRequest := proto.GetSinkInfoList{}
+	sinkReply := proto.GetSinkInfoListReply{}
+
+	if err := sf.client.Request(&sinkRequest, &sinkReply); err != nil {
+		sf.logger.Warnw("Failed to get sink list", "error", err)
+		return fmt.Errorf("get sink list: %w", err)
+	}
+
+	for _, info := range sinkReply {
+		sf.addDeviceSessions(sessions, info.SinkIndex, info.Channels, true, info.SinkName, info.Properties)
+	}
+
+	sourceRequest := proto.GetSourceInfoList{}
+	sourceReply := proto.GetSourceInfoListReply{}
 
+	if err := sf.client.Request(&sourceRequest, &sourceReply); err != nil {
+		sf.logger.Warnw("Failed to get source list", "error", err)
+		return fmt.Errorf("get source list: %w", err)
+	}
+
+	for _, info := range sourceReply {
+
+		// every sink comes with a monitor source, which isn't something anyone would want on a slider
+		if info.MonitorSourceIndex != proto.Undefined {
 			continue
 		}
 
-		// create the deej session object
-		newSession := newPASession(sf.sessionLogger, sf.client, info.SinkInputIndex, info.Channels, name.String())
+		sf.addDeviceSessions(sessions, info.SourceIndex, info.Channels, false, info.SourceName, info.Properties)
+	}
+
+	return nil
+}
+
+// addDeviceSessions adds a "master" session for a sink or source under its description (i.e. "Headphones"),
+// and another one under its name (i.e. "alsa_output.pci-0000_00_1f.3.analog-stereo")
+func (sf *paSessionFinder) addDeviceSessions(
+	sessions *[]Session,
+	index uint32,
+	channels byte,
+	isOutput bool,
+	name string,
+	properties proto.PropList,
+) {
+	description := propListString(properties, devicePropertyDescription)
+
+	sf.logger.Debugw("Enumerated device info",
+		"index", index,
+		"name", name,
+		"description", description,
+		"isOutput", isOutput)
+
+	keys := []string{name}
+	if description != "" && !strings.EqualFold(description, name) {
+		keys = append(keys, description)
+	}
+
+	objectID := fmt.Sprintf(sourceObjectFormat, index)
+	if isOutput {
+		objectID = fmt.Sprintf(sinkObjectFormat, index)
+	}
+
+	for _, key := range keys {
+		newSession := newMasterSession(sf.sessionLogger, sf.client, index, channels, isOutput,
+			key, fmt.Sprintf(deviceSessionFormat, strings.ToLower(key)))
+		newSession.objectID = objectID
 
-		// add it to our slice
 		*sessions = append(*sessions, newSession)
+	}
+}
+
+// SwitchOutputDevice makes the sink whose name or description matches the given name the default one,
+// and moves every existing sink input over to it
+func (sf *paSessionFinder) SwitchOutputDevice(name string) error {
+	sink, err := sf.findSinkByName(name)
+	if err != nil {
+		sf.logger.Warnw("Failed to find sink", "name", name, "error", err)
+		return fmt.Errorf("find sink by name: %w", err)
+	}
+
+	sf.logger.Infow("Changing default sink", "sinkName", sink.SinkName, "name", name)
+
+	if err := sf.client.Request(&proto.SetDefaultSink{SinkName: sink.SinkName}, nil); err != nil {
+		sf.logger.Warnw("Failed to set default sink", "sinkName", sink.SinkName, "error", err)
+		return fmt.Errorf("set default sink: %w", err)
+	}
+
+	// streams that are already playing stay on their old sink unless we move them ourselves
+	request := proto.GetSinkInputInfoList{}
+	reply := proto.GetSinkInputInfoListReply{}
+
+	if err := sf.client.Request(&request, &reply); err != nil {
+		sf.logger.Warnw("Failed to get sink input list", "error", err)
+		return fmt.Errorf("get sink input list: %w", err)
+	}
+
+	for _, info := range reply {
+		if info.SinkIndex == sink.SinkIndex {
+			continue
+		}
+
+		moveRequest := proto.MoveSinkInput{
+			SinkInputIndex: info.SinkInputIndex,
+			DeviceIndex:    proto.Undefined,
+			DeviceName:     sink.SinkName,
+		}
+
+		// a single stream refusing to move (or closing in the meantime) shouldn't fail the whole switch
+		if err := sf.client.Request(&moveRequest, nil); err != nil {
+			sf.logger.Warnw("Failed to move sink input to new default sink",
+				"sinkInputIndex", info.SinkInputIndex,
+				"error", err)
+		}
+	}
+
+	return nil
+}
+
+// GetCurrentOutputDevice returns the name and description of the default sink
+func (sf *paSessionFinder) GetCurrentOutputDevice() ([]string, error) {
+	request := proto.GetSinkInfo{
+		SinkIndex: proto.Undefined,
+	}
+	reply := proto.GetSinkInfoReply{}
+
+	if err := sf.client.Request(&request, &reply); err != nil {
+		sf.logger.Warnw("Failed to get default sink info", "error", err)
+		return nil, fmt.Errorf("get default sink info: %w", err)
+	}
+
+	return []string{reply.SinkName, propListString(reply.Properties, devicePropertyDescription)}, nil
+}
+
+func (sf *paSessionFinder) findSinkByName(name string) (*proto.GetSinkInfoReply, error) {
+	request := proto.GetSinkInfoList{}
+	reply := proto.GetSinkInfoListReply{}
+
+	if err := sf.client.Request(&request, &reply); err != nil {
+		sf.logger.Warnw("Failed to get sink list", "error", err)
+		return nil, fmt.Errorf("get sink list: %w", err)
+	}
+
+	for _, info := range reply {
+		if strings.EqualFold(info.SinkName, name) || strings.EqualFold(propListString(info.Properties, devicePropertyDescription), name) {
+			return info, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no sink found with name: %s", name)
+}
+
+// propListString returns a string property, i.e. a device's "device.description", or an empty string if it isn't set
+func propListString(properties proto.PropList, key string) string {
+	value, ok := properties[key]
+	if !ok {
+		return ""
+	}
 
+	return value.String()
+}
+
+// SubscribeToSessionEvents returns a channel that receives the sessions of every sink input, sink or source
+// that appears or disappears, as well as new master sessions whenever the default sink or source changes
+func (sf *paSessionFinder) SubscribeToSessionEvents() chan SessionEvent {
+	ch := make(chan SessionEvent)
+
+	sf.consumersLock.Lock()
+	defer sf.consumersLock.Unlock()
+
+	sf.sessionEventConsumers = append(sf.sessionEventConsumers, ch)
+
+	// events queue up until the first consumer subscribes, rather than being reported to nobody
+	if len(sf.sessionEventConsumers) == 1 {
+		go sf.handlePulseEvents()
+	}
+
+	return ch
+}
+
+// SubscribeToStateChanges returns a channel that's signaled whenever a sink input, sink, source or the server changed.
+// it's buffered, and a signal is dropped while the last one is still pending
+func (sf *paSessionFinder) SubscribeToStateChanges() chan bool {
+	ch := make(chan bool, 1)
+
+	sf.consumersLock.Lock()
+	defer sf.consumersLock.Unlock()
+
+	sf.stateChangeConsumers = append(sf.stateChangeConsumers, ch)
+
+	return ch
+}
+
+// onPulseMessage is called from the PulseAudio client's read loop, which also delivers request replies.
+// it mustn't make requests of its own or block, so events are only queued up for handlePulseEvents
+func (sf *paSessionFinder) onPulseMessage(message interface{}) {
+	event, ok := message.(*proto.SubscribeEvent)
+	if !ok {
+		return
+	}
+
+	facility := event.Event & paEventFacilityMask
+	eventType := event.Event & paEventTypeMask
+
+	// any of these can be a mute or a default device switch that the controllers' LEDs should show
+	switch facility {
+	case paEventFacilitySinkInput, paEventFacilitySink, paEventFacilitySource, paEventFacilityServer:
+		sf.signalStateChange()
+	}
+
+	// streams and devices change all the time (i.e. whenever their volume does), none of which affects their sessions.
+	// the server only changes when the default sink or source does, which is when master sessions need replacing
+	if eventType == paEventTypeChange && facility != paEventFacilityServer {
+		return
+	}
+
+	select {
+	case sf.pulseEvents <- *event:
+	default:
+		sf.logger.Warnw("Dropping PulseAudio event, too many are queued up", "event", event.Event, "index", event.Index)
+	}
+}
+
+func (sf *paSessionFinder) signalStateChange() {
+	sf.consumersLock.Lock()
+	defer sf.consumersLock.Unlock()
+
+	for _, consumer := range sf.stateChangeConsumers {
+		select {
+		case consumer <- true:
+		default:
+		}
+	}
+}
+
+func (sf *paSessionFinder) handlePulseEvents() {
+	for event := range sf.pulseEvents {
+		for _, sessionEvent := range sf.sessionEventsForPulseEvent(event) {
+			sf.logger.Debugw("Reporting session event",
+				"objectID", sessionEvent.ObjectID,
+				"sessions", len(sessionEvent.Sessions))
+
+			sf.consumersLock.Lock()
+			consumers := sf.sessionEventConsumers
+			sf.consumersLock.Unlock()
+
+			for _, consumer := range consumers {
+				consumer <- sessionEvent
+			}
+		}
+	}
+}
+
+// sessionEventsForPulseEvent looks up the sessions that a PulseAudio event added, removed or replaced
+func (sf *paSessionFinder) sessionEventsForPulseEvent(event proto.SubscribeEvent) []SessionEvent {
+	removed := event.Event&paEventTypeMask == paEventTypeRemove
+
+	switch event.Event & paEventFacilityMask {
+
+	case paEventFacilitySinkInput:
+		objectID := fmt.Sprintf(sinkInputObjectFormat, event.Index)
+		if removed {
+			return []SessionEvent{{ObjectID: objectID}}
+		}
+
+		return []SessionEvent{sf.getSinkInputSessionEvent(objectID, event.Index)}
+
+	case paEventFacilitySink:
+		objectID := fmt.Sprintf(sinkObjectFormat, event.Index)
+		if removed {
+			return []SessionEvent{{ObjectID: objectID}}
+		}
+
+		return []SessionEvent{sf.getSinkSessionEvent(objectID, event.Index)}
+
+	case paEventFacilitySource:
+		objectID := fmt.Sprintf(sourceObjectFormat, event.Index)
+		if removed {
+			return []SessionEvent{{ObjectID: objectID}}
+		}
+
+		return []SessionEvent{sf.getSourceSessionEvent(objectID, event.Index)}
+
+	case paEventFacilityServer:
+		events := []SessionEvent{{ObjectID: masterSinkObjectID}, {ObjectID: masterSourceObjectID}}
+
+		// without a default sink or source (i.e. the last one was unplugged) the event just removes the master session
+		if masterSink, err := sf.getMasterSinkSession(); err == nil {
+			events[0].Sessions = []Session{masterSink}
+		}
+
+		if masterSource, err := sf.getMasterSourceSession(); err == nil {
+			events[1].Sessions = []Session{masterSource}
+		}
+
+		return events
 	}
 
 	return nil
 }
+
+func (sf *paSessionFinder) getSinkInputSessionEvent(objectID string, index uint32) SessionEvent {
+	event := SessionEvent{ObjectID: objectID}
+
+	request := proto.GetSinkInputInfo{
+		SinkInputIndex: index,
+	}
+	reply := proto.GetSinkInputInfoReply{}
+
+	if err := sf.client.Request(&request, &reply); err != nil {
+
+		// short-lived streams (i.e. notification sounds) are often gone before we get to ask about them
+		if !errors.Is(err, proto.ErrNoSuchEntity) {
+			sf.logger.Warnw("Failed to get new sink input info", "sinkInputIndex", index, "error", err)
+		}
+
+		return event
+	}
+
+	if newSession, ok := sf.newSinkInputSession(&reply); ok {
+		event.Sessions = []Session{newSession}
+	}
+
+	return event
+}
+
+func (sf *paSessionFinder) getSinkSessionEvent(objectID string, index uint32) SessionEvent {
+	event := SessionEvent{ObjectID: objectID}
+
+	request := proto.GetSinkInfo{
+		SinkIndex: index,
+	}
+	reply := proto.GetSinkInfoReply{}
+
+	if err := sf.client.Request(&request, &reply); err != nil {
+		sf.logger.Warnw("Failed to get new sink info", "sinkIndex", index, "error", err)
+		return event
+	}
+
+	sf.addDeviceSessions(&event.Sessions, reply.SinkIndex, reply.Channels, true, reply.SinkName, reply.Properties)
+
+	return event
+}
+
+func (sf *paSessionFinder) getSourceSessionEvent(objectID string, index uint32) SessionEvent {
+	event := SessionEvent{ObjectID: objectID}
+
+	request := proto.GetSourceInfo{
+		SourceIndex: index,
+	}
+	reply := proto.GetSourceInfoReply{}
+
+	if err := sf.client.Request(&request, &reply); err != nil {
+		sf.logger.Warnw("Failed to get new source info", "sourceIndex", index, "error", err)
+		return event
+	}
+
+	// monitor sources are left out of the session map, same as in GetAllSessions
+	if reply.MonitorSourceIndex != proto.Undefined {
+		return event
+	}
+
+	sf.addDeviceSessions(&event.Sessions, reply.SourceIndex, reply.Channels, false, reply.SourceName, reply.Properties)
+
+	return event
+}
@@ -11,6 +11,8 @@ import (
 	ole "github.com/go-ole/go-ole"
 	wca "github.com/moutend/go-wca/pkg/wca"
 	"go.uber.org/zap"
+
+	"github.com/tomerhh/deej/pkg/deej/util"
 )
 
 type wcaSessionFinder struct {
@@ -62,34 +64,8 @@ func (sf *wcaSessionFinder) GetAllSessions() ([]Session, error) {
 	defer runtime.UnlockOSThread()
 
 	// we must call this every time we're about to list devices, i think. could be wrong
-	if err := ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED); err != nil {
-
-		// if the error is "Incorrect function" that corresponds to 0x00000001,
-		// which represents E_FALSE in COM error handling. this is fine for this function,
-		// and just means that the call was redundant.
-		const eFalse = 1
-		oleError := &ole.OleError{}
-
-		if errors.As(err, &oleError) {
-			if oleError.Code() == eFalse {
-				sf.logger.Warn("CoInitializeEx failed with E_FALSE due to redundant invocation")
-			} else {
-				sf.logger.Warnw("Failed to call CoInitializeEx",
-					"isOleError", true,
-					"error", err,
-					"oleError", oleError)
-
-				return nil, fmt.Errorf("call CoInitializeEx: %w", err)
-			}
-		} else {
-			sf.logger.Warnw("Failed to call CoInitializeEx",
-				"isOleError", false,
-				"error", err,
-				"oleError", nil)
-
-			return nil, fmt.Errorf("call CoInitializeEx: %w", err)
-		}
-
+	if err := sf.coInitialize(); err != nil {
+		return nil, err
 	}
 	defer ole.CoUninitialize()
 
@@ -163,6 +139,118 @@ func (sf *wcaSessionFinder) Release() error {
 	return nil
 }
 
+// SwitchOutputDevice makes the output device with the given friendly name the default console endpoint
+func (sf *wcaSessionFinder) SwitchOutputDevice(name string) error {
+	deviceID, err := util.GetDeviceIDByNameWinAPI(name)
+	if err != nil {
+		sf.logger.Warnw("Failed to get device ID by name", "name", name, "error", err)
+		return fmt.Errorf("get device ID by name: %w", err)
+	}
+
+	sf.logger.Infow("Changing default output device", "deviceID", deviceID, "name", name)
+
+	if !util.SetAudioDeviceByID(deviceID, sf.logger) {
+		return fmt.Errorf("set default output device (%s)", name)
+	}
+
+	return nil
+}
+
+// GetCurrentOutputDevice returns the friendly name, description and ID of the default output device
+func (sf *wcaSessionFinder) GetCurrentOutputDevice() ([]string, error) {
+
+	// Lock this goroutine to the current OS thread for COM operations
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := sf.coInitialize(); err != nil {
+		return nil, err
+	}
+	defer ole.CoUninitialize()
+
+	if err := sf.getDeviceEnumerator(); err != nil {
+		sf.logger.Warnw("Failed to get device enumerator", "error", err)
+		return nil, fmt.Errorf("get device enumerator: %w", err)
+	}
+
+	var endpoint *wca.IMMDevice
+
+	if err := sf.mmDeviceEnumerator.GetDefaultAudioEndpoint(wca.ERender, wca.EConsole, &endpoint); err != nil {
+		sf.logger.Warnw("Failed to call GetDefaultAudioEndpoint (out)", "error", err)
+		return nil, fmt.Errorf("call GetDefaultAudioEndpoint (out): %w", err)
+	}
+	defer endpoint.Release()
+
+	var endpointDeviceID string
+	if err := endpoint.GetId(&endpointDeviceID); err != nil {
+		sf.logger.Warnw("Failed to get default output device ID", "error", err)
+		return nil, fmt.Errorf("get default output device ID: %w", err)
+	}
+
+	var propertyStore *wca.IPropertyStore
+
+	if err := endpoint.OpenPropertyStore(wca.STGM_READ, &propertyStore); err != nil {
+		sf.logger.Warnw("Failed to open property store for default output device", "error", err)
+		return nil, fmt.Errorf("open default output device property store: %w", err)
+	}
+	defer propertyStore.Release()
+
+	value := &wca.PROPVARIANT{}
+
+	if err := propertyStore.GetValue(&wca.PKEY_Device_FriendlyName, value); err != nil {
+		sf.logger.Warnw("Failed to get friendly name for default output device", "error", err)
+		return nil, fmt.Errorf("get default output device friendly name: %w", err)
+	}
+
+	// device friendly name i.e. "Headphones (Realtek Audio)"
+	endpointFriendlyName := value.String()
+
+	if err := propertyStore.GetValue(&wca.PKEY_Device_DeviceDesc, value); err != nil {
+		sf.logger.Warnw("Failed to get description for default output device", "error", err)
+		return nil, fmt.Errorf("get default output device description: %w", err)
+	}
+
+	// device description i.e. "Headphones"
+	endpointDescription := value.String()
+
+	return []string{endpointFriendlyName, endpointDescription, endpointDeviceID}, nil
+}
+
+// coInitialize initializes COM for the calling thread, tolerating redundant invocations.
+// callers should lock their OS thread first, and must call ole.CoUninitialize when it returns nil
+func (sf *wcaSessionFinder) coInitialize() error {
+	if err := ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED); err != nil {
+
+		// if the error is "Incorrect function" that corresponds to 0x00000001,
+		// which represents E_FALSE in COM error handling. this is fine for this function,
+		// and just means that the call was redundant.
+		const eFalse = 1
+		oleError := &ole.OleError{}
+
+		if errors.As(err, &oleError) {
+			if oleError.Code() == eFalse {
+				sf.logger.Warn("CoInitializeEx failed with E_FALSE due to redundant invocation")
+			} else {
+				sf.logger.Warnw("Failed to call CoInitializeEx",
+					"isOleError", true,
+					"error", err,
+					"oleError", oleError)
+
+				return fmt.Errorf("call CoInitializeEx: %w", err)
+			}
+		} else {
+			sf.logger.Warnw("Failed to call CoInitializeEx",
+				"isOleError", false,
+				"error", err,
+				"oleError", nil)
+
+			return fmt.Errorf("call CoInitializeEx: %w", err)
+		}
+	}
+
+	return nil
+}
+
 func (sf *wcaSessionFinder) getDeviceEnumerator() error {
 
 	// get the IMMDeviceEnumerator (only once)
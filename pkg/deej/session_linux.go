@@ -14,12 +14,17 @@ const maxVolume = 0x10000
 
 var errNoSuchProcess = errors.New("No such process")
 
+// paClient is the part of proto.Client that sessions rely on, which lets tests substitute a fake server
+type paClient interface {
+	Request(req proto.RequestArgs, rpl proto.Reply) error
+}
+
 type paSession struct {
 	baseSession
 
 	processName string
 
-	client *proto.Client
+	client paClient
 
 	sinkInputIndex    uint32
 	sinkInputChannels byte
@@ -28,7 +33,7 @@ type paSession struct {
 type masterSession struct {
 	baseSession
 
-	client *proto.Client
+	client paClient
 
 	streamIndex    uint32
 	streamChannels byte
@@ -37,7 +42,7 @@ type masterSession struct {
 
 func newPASession(
 	logger *zap.SugaredLogger,
-	client *proto.Client,
+	client paClient,
 	sinkInputIndex uint32,
 	sinkInputChannels byte,
 	processName string,
@@ -62,10 +67,12 @@ func newPASession(
 
 func newMasterSession(
 	logger *zap.SugaredLogger,
-	client *proto.Client,
+	client paClient,
 	streamIndex uint32,
 	streamChannels byte,
 	isOutput bool,
+	key string,
+	loggerKey string,
 ) *masterSession {
 
 	s := &masterSession{
@@ -75,15 +82,7 @@ func newMasterSession(
 		isOutput:       isOutput,
 	}
 
-	var key string
-
-	if isOutput {
-		key = masterSessionName
-	} else {
-		key = inputSessionName
-	}
-
-	s.logger = logger.Named(key)
+	s.logger = logger.Named(loggerKey)
 	s.master = true
 	s.name = key
 	s.humanReadableDesc = key
@@ -125,6 +124,40 @@ func (s *paSession) SetVolume(v float32) error {
 	return nil
 }
 
+func (s *paSession) SetMute(mute bool) error {
+	request := proto.SetSinkInputMute{
+		SinkInputIndex: s.sinkInputIndex,
+		Mute:           mute,
+	}
+
+	if err := s.client.Request(&request, nil); err != nil {
+		s.logger.Warnw("Failed to set session mute",
+			"error", err,
+			"processName", s.processName,
+			"mute", mute)
+
+		return fmt.Errorf("set mute (%s): %w", s.processName, err)
+	}
+
+	s.logger.Debugw("Setting session mute", "to", fmt.Sprintf("%t", mute), "processName", s.processName)
+
+	return nil
+}
+
+func (s *paSession) GetMute() bool {
+	request := proto.GetSinkInputInfo{
+		SinkInputIndex: s.sinkInputIndex,
+	}
+	reply := proto.GetSinkInputInfoReply{}
+
+	if err := s.client.Request(&request, &reply); err != nil {
+		s.logger.Warnw("Failed to get mute", "error", err, "processName", s.processName)
+		return false
+	}
+
+	return reply.Muted
+}
+
 func (s *paSession) Release() {
 	s.logger.Debug("Releasing audio session")
 }
@@ -195,6 +228,62 @@ func (s *masterSession) SetVolume(v float32) error {
 	return nil
 }
 
+func (s *masterSession) SetMute(mute bool) error {
+	var request proto.RequestArgs
+
+	if s.isOutput {
+		request = &proto.SetSinkMute{
+			SinkIndex: s.streamIndex,
+			Mute:      mute,
+		}
+	} else {
+		request = &proto.SetSourceMute{
+			SourceIndex: s.streamIndex,
+			Mute:        mute,
+		}
+	}
+
+	if err := s.client.Request(request, nil); err != nil {
+		s.logger.Warnw("Failed to set master session mute",
+			"error", err,
+			"mute", mute)
+
+		return fmt.Errorf("set mute: %w", err)
+	}
+
+	s.logger.Debugw("Setting session mute", "to", fmt.Sprintf("%t", mute), "session", s.name)
+
+	return nil
+}
+
+func (s *masterSession) GetMute() bool {
+	if s.isOutput {
+		request := proto.GetSinkInfo{
+			SinkIndex: s.streamIndex,
+		}
+		reply := proto.GetSinkInfoReply{}
+
+		if err := s.client.Request(&request, &reply); err != nil {
+			s.logger.Warnw("Failed to get master mute", "error", err)
+			return false
+		}
+
+		return reply.Mute
+	}
+
+	request := proto.GetSourceInfo{
+		SourceIndex: s.streamIndex,
+	}
+	reply := proto.GetSourceInfoReply{}
+
+	if err := s.client.Request(&request, &reply); err != nil {
+		s.logger.Warnw("Failed to get master mute", "error", err)
+		return false
+	}
+
+	return reply.Mute
+}
+
 func (s *masterSession) Release() {
 	s.logger.Debug("Releasing audio session")
 }
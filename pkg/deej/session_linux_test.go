@@ -0,0 +1,565 @@
+package deej
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jfreymuth/pulse/proto"
+	"go.uber.org/zap"
+)
+
+// fakePAClient stands in for a PulseAudio server, keeping just enough state to answer session requests
+type fakePAClient struct {
+	lock sync.Mutex
+
+	sinks      map[uint32]*proto.GetSinkInfoReply
+	sources    map[uint32]*proto.GetSourceInfoReply
+	sinkInputs map[uint32]*proto.GetSinkInputInfoReply
+
+	defaultSink   string
+	defaultSource string
+
+	requests []proto.RequestArgs
+}
+
+func newFakePAClient() *fakePAClient {
+	return &fakePAClient{
+		sinks:      make(map[uint32]*proto.GetSinkInfoReply),
+		sources:    make(map[uint32]*proto.GetSourceInfoReply),
+		sinkInputs: make(map[uint32]*proto.GetSinkInputInfoReply),
+	}
+}
+
+func (c *fakePAClient) Request(req proto.RequestArgs, rpl proto.Reply) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.requests = append(c.requests, req)
+
+	switch r := req.(type) {
+
+	case *proto.GetSinkInputInfo:
+		info, ok := c.sinkInputs[r.SinkInputIndex]
+		if !ok {
+			return proto.ErrNoSuchEntity
+		}
+		*rpl.(*proto.GetSinkInputInfoReply) = *info
+
+	case *proto.SetSinkInputMute:
+		info, ok := c.sinkInputs[r.SinkInputIndex]
+		if !ok {
+			return proto.ErrNoSuchEntity
+		}
+		info.Muted = r.Mute
+
+	case *proto.SetSinkInputVolume:
+		info, ok := c.sinkInputs[r.SinkInputIndex]
+		if !ok {
+			return proto.ErrNoSuchEntity
+		}
+		info.ChannelVolumes = r.ChannelVolumes
+
+	case *proto.GetSinkInputInfoList:
+		reply := rpl.(*proto.GetSinkInputInfoListReply)
+		for _, info := range c.sinkInputs {
+			*reply = append(*reply, info)
+		}
+
+	case *proto.MoveSinkInput:
+		info, ok := c.sinkInputs[r.SinkInputIndex]
+		sink := c.sinkByName(r.DeviceName)
+		if !ok || sink == nil {
+			return proto.ErrNoSuchEntity
+		}
+		info.SinkIndex = sink.SinkIndex
+
+	case *proto.GetSinkInfo:
+		info, ok := c.sinks[r.SinkIndex]
+		if r.SinkIndex == proto.Undefined {
+			info = c.sinkByName(c.defaultSink)
+			ok = info != nil
+		}
+		if !ok {
+			return proto.ErrNoSuchEntity
+		}
+		*rpl.(*proto.GetSinkInfoReply) = *info
+
+	case *proto.GetSinkInfoList:
+		reply := rpl.(*proto.GetSinkInfoListReply)
+		for _, info := range c.sinks {
+			*reply = append(*reply, info)
+		}
+
+	case *proto.SetDefaultSink:
+		if c.sinkByName(r.SinkName) == nil {
+			return proto.ErrNoSuchEntity
+		}
+		c.defaultSink = r.SinkName
+
+	case *proto.SetSinkMute:
+		info, ok := c.sinks[r.SinkIndex]
+		if !ok {
+			return proto.ErrNoSuchEntity
+		}
+		info.Mute = r.Mute
+
+	case *proto.SetSinkVolume:
+		info, ok := c.sinks[r.SinkIndex]
+		if !ok {
+			return proto.ErrNoSuchEntity
+		}
+		info.ChannelVolumes = r.ChannelVolumes
+
+	case *proto.GetSourceInfo:
+		info, ok := c.sources[r.SourceIndex]
+		if r.SourceIndex == proto.Undefined {
+			info = c.sourceByName(c.defaultSource)
+			ok = info != nil
+		}
+		if !ok {
+			return proto.ErrNoSuchEntity
+		}
+		*rpl.(*proto.GetSourceInfoReply) = *info
+
+	case *proto.GetSourceInfoList:
+		reply := rpl.(*proto.GetSourceInfoListReply)
+		for _, info := range c.sources {
+			*reply = append(*reply, info)
+		}
+
+	case *proto.SetSourceMute:
+		info, ok := c.sources[r.SourceIndex]
+		if !ok {
+			return proto.ErrNoSuchEntity
+		}
+		info.Mute = r.Mute
+
+	case *proto.SetSourceVolume:
+		info, ok := c.sources[r.SourceIndex]
+		if !ok {
+			return proto.ErrNoSuchEntity
+		}
+		info.ChannelVolumes = r.ChannelVolumes
+
+	default:
+		return proto.ErrNotSupported
+	}
+
+	return nil
+}
+
+func (c *fakePAClient) sinkByName(name string) *proto.GetSinkInfoReply {
+	for _, info := range c.sinks {
+		if info.SinkName == name {
+			return info
+		}
+	}
+
+	return nil
+}
+
+func (c *fakePAClient) sourceByName(name string) *proto.GetSourceInfoReply {
+	for _, info := range c.sources {
+		if info.SourceName == name {
+			return info
+		}
+	}
+
+	return nil
+}
+
+func (c *fakePAClient) addSink(index uint32, name string, description string) {
+	info := &proto.GetSinkInfoReply{
+		SinkIndex:      index,
+		SinkName:       name,
+		ChannelVolumes: proto.ChannelVolumes{maxVolume, maxVolume},
+		Properties: proto.PropList{
+			"device.description": proto.PropListString(description),
+		},
+	}
+	info.Channels = 2
+
+	c.sinks[index] = info
+}
+
+// addSource adds a source, which monitors the given sink unless monitorOf is proto.Undefined
+func (c *fakePAClient) addSource(index uint32, name string, description string, monitorOf uint32) {
+	info := &proto.GetSourceInfoReply{
+		SourceIndex:        index,
+		SourceName:         name,
+		ChannelVolumes:     proto.ChannelVolumes{maxVolume},
+		MonitorSourceIndex: monitorOf,
+		Properties: proto.PropList{
+			"device.description": proto.PropListString(description),
+		},
+	}
+	info.Channels = 1
+
+	c.sources[index] = info
+}
+
+func (c *fakePAClient) addSinkInput(index uint32, sinkIndex uint32, processName string) {
+	info := &proto.GetSinkInputInfoReply{
+		SinkInputIndex: index,
+		SinkIndex:      sinkIndex,
+		ChannelVolumes: proto.ChannelVolumes{maxVolume, maxVolume},
+		Properties: proto.PropList{
+			"application.process.binary": proto.PropListString(processName),
+		},
+	}
+	info.Channels = 2
+
+	c.sinkInputs[index] = info
+}
+
+func newTestPASessionFinder(client *fakePAClient) *paSessionFinder {
+	logger := zap.NewNop().Sugar()
+
+	return &paSessionFinder{
+		logger:        logger,
+		sessionLogger: logger,
+		client:        client,
+	}
+}
+
+// TestPASessionMute tests muting and unmuting a sink input session
+func TestPASessionMute(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	client := newFakePAClient()
+	client.sinkInputs[7] = &proto.GetSinkInputInfoReply{
+		SinkInputIndex: 7,
+		ChannelVolumes: proto.ChannelVolumes{maxVolume, maxVolume},
+	}
+
+	session := newPASession(logger, client, 7, 2, "spotify")
+
+	if session.GetMute() {
+		t.Fatal("Expected session to start unmuted")
+	}
+
+	if err := session.SetMute(true); err != nil {
+		t.Fatalf("Failed to mute session: %v", err)
+	}
+
+	if !client.sinkInputs[7].Muted {
+		t.Error("Expected sink input to be muted on the server")
+	}
+
+	if !session.GetMute() {
+		t.Error("Expected GetMute to report true after muting")
+	}
+
+	if err := session.SetMute(false); err != nil {
+		t.Fatalf("Failed to unmute session: %v", err)
+	}
+
+	if session.GetMute() {
+		t.Error("Expected GetMute to report false after unmuting")
+	}
+}
+
+// TestPASessionMuteMissingSinkInput tests that a vanished sink input surfaces an error
+func TestPASessionMuteMissingSinkInput(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	client := newFakePAClient()
+	session := newPASession(logger, client, 3, 2, "firefox")
+
+	if err := session.SetMute(true); err == nil {
+		t.Error("Expected an error when muting a sink input that doesn't exist")
+	}
+
+	if session.GetMute() {
+		t.Error("Expected GetMute to report false for a sink input that doesn't exist")
+	}
+}
+
+// TestMasterSessionMute tests muting the master sink and the mic source
+func TestMasterSessionMute(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	client := newFakePAClient()
+	client.sinks[1] = &proto.GetSinkInfoReply{SinkIndex: 1, ChannelVolumes: proto.ChannelVolumes{maxVolume, maxVolume}}
+	client.sources[2] = &proto.GetSourceInfoReply{SourceIndex: 2, ChannelVolumes: proto.ChannelVolumes{maxVolume}}
+
+	master := newMasterSession(logger, client, 1, 2, true, masterSessionName, masterSessionName)
+	mic := newMasterSession(logger, client, 2, 1, false, inputSessionName, inputSessionName)
+
+	if err := mic.SetMute(true); err != nil {
+		t.Fatalf("Failed to mute mic: %v", err)
+	}
+
+	if !client.sources[2].Mute {
+		t.Error("Expected source to be muted on the server")
+	}
+
+	if client.sinks[1].Mute {
+		t.Error("Muting the mic should not mute the master sink")
+	}
+
+	if !mic.GetMute() || master.GetMute() {
+		t.Errorf("Unexpected mute states: mic=%t master=%t", mic.GetMute(), master.GetMute())
+	}
+
+	if err := master.SetMute(true); err != nil {
+		t.Fatalf("Failed to mute master: %v", err)
+	}
+
+	if !client.sinks[1].Mute || !master.GetMute() {
+		t.Error("Expected master sink to be muted")
+	}
+}
+
+// TestPASwitchOutputDevice tests switching the default sink by description and moving streams along
+func TestPASwitchOutputDevice(t *testing.T) {
+	client := newFakePAClient()
+	client.addSink(0, "alsa_output.pci-0000_00_1f.3.analog-stereo", "Built-in Audio Analog Stereo")
+	client.addSink(1, "bluez_sink.00_11_22_33_44_55.a2dp_sink", "Headphones")
+	client.defaultSink = "alsa_output.pci-0000_00_1f.3.analog-stereo"
+
+	client.addSinkInput(10, 0, "spotify")
+	client.addSinkInput(11, 0, "firefox")
+
+	sf := newTestPASessionFinder(client)
+
+	if err := sf.SwitchOutputDevice("headphones"); err != nil {
+		t.Fatalf("Failed to switch output device: %v", err)
+	}
+
+	if client.defaultSink != "bluez_sink.00_11_22_33_44_55.a2dp_sink" {
+		t.Errorf("Expected default sink to change, got %s", client.defaultSink)
+	}
+
+	for index, info := range client.sinkInputs {
+		if info.SinkIndex != 1 {
+			t.Errorf("Expected sink input %d to move to sink 1, still on %d", index, info.SinkIndex)
+		}
+	}
+
+	current, err := sf.GetCurrentOutputDevice()
+	if err != nil {
+		t.Fatalf("Failed to get current output device: %v", err)
+	}
+
+	if len(current) != 2 || current[1] != "Headphones" {
+		t.Errorf("Expected current output device to be known as 'Headphones', got %v", current)
+	}
+
+	// sinks can be matched by their name too
+	if err := sf.SwitchOutputDevice("alsa_output.pci-0000_00_1f.3.analog-stereo"); err != nil {
+		t.Fatalf("Failed to switch output device by sink name: %v", err)
+	}
+
+	if client.defaultSink != "alsa_output.pci-0000_00_1f.3.analog-stereo" {
+		t.Errorf("Expected default sink to change back, got %s", client.defaultSink)
+	}
+
+	if err := sf.SwitchOutputDevice("HDMI"); err == nil {
+		t.Error("Expected an error when switching to a sink that doesn't exist")
+	}
+}
+
+// TestPAGetAllSessionsDevices tests that every sink and source is bindable by description and by name
+func TestPAGetAllSessionsDevices(t *testing.T) {
+	client := newFakePAClient()
+	client.addSink(0, "alsa_output.pci-0000_00_1f.3.analog-stereo", "Built-in Audio Analog Stereo")
+	client.addSink(1, "bluez_sink.00_11_22_33_44_55.a2dp_sink", "Headphones")
+	client.addSource(2, "alsa_input.usb-blue_yeti-00.analog-stereo", "Yeti Stereo Microphone", proto.Undefined)
+	client.addSource(3, "bluez_sink.00_11_22_33_44_55.a2dp_sink.monitor", "Monitor of Headphones", 1)
+	client.defaultSink = "alsa_output.pci-0000_00_1f.3.analog-stereo"
+	client.defaultSource = "alsa_input.usb-blue_yeti-00.analog-stereo"
+	client.addSinkInput(10, 0, "spotify")
+
+	sf := newTestPASessionFinder(client)
+
+	sessions, err := sf.GetAllSessions()
+	if err != nil {
+		t.Fatalf("Failed to get all sessions: %v", err)
+	}
+
+	keys := map[string]Session{}
+	for _, session := range sessions {
+		keys[session.Key()] = session
+	}
+
+	for _, expected := range []string{
+		masterSessionName,
+		inputSessionName,
+		"spotify",
+		"headphones",
+		"bluez_sink.00_11_22_33_44_55.a2dp_sink",
+		"built-in audio analog stereo",
+		"alsa_output.pci-0000_00_1f.3.analog-stereo",
+		"yeti stereo microphone",
+		"alsa_input.usb-blue_yeti-00.analog-stereo",
+	} {
+		if _, ok := keys[expected]; !ok {
+			t.Errorf("Expected a session keyed '%s', got %v", expected, sessions)
+		}
+	}
+
+	if _, ok := keys["monitor of headphones"]; ok {
+		t.Error("Monitor sources should not be added as sessions")
+	}
+
+	// the device session should control the actual sink, not the default one
+	if err := keys["headphones"].SetVolume(0.5); err != nil {
+		t.Fatalf("Failed to set device session volume: %v", err)
+	}
+
+	if volume := parseChannelVolumes(client.sinks[1].ChannelVolumes); volume != 0.5 {
+		t.Errorf("Expected headphones sink volume 0.5, got %.2f", volume)
+	}
+
+	if volume := parseChannelVolumes(client.sinks[0].ChannelVolumes); volume != 1.0 {
+		t.Errorf("Expected default sink volume to stay at 1.0, got %.2f", volume)
+	}
+
+	if err := keys["yeti stereo microphone"].SetMute(true); err != nil {
+		t.Fatalf("Failed to mute device session: %v", err)
+	}
+
+	if !client.sources[2].Mute {
+		t.Error("Expected the microphone source to be muted")
+	}
+}
+
+// TestPASessionEventsForPulseEvents tests that PulseAudio events are turned into the sessions they add or remove
+func TestPASessionEventsForPulseEvents(t *testing.T) {
+	client := newFakePAClient()
+	client.addSink(0, "alsa_output.pci-0000_00_1f.3.analog-stereo", "Built-in Audio Analog Stereo")
+	client.addSource(2, "alsa_input.usb-blue_yeti-00.analog-stereo", "Yeti Stereo Microphone", proto.Undefined)
+	client.defaultSink = "alsa_output.pci-0000_00_1f.3.analog-stereo"
+	client.defaultSource = "alsa_input.usb-blue_yeti-00.analog-stereo"
+
+	sf := newTestPASessionFinder(client)
+
+	// a new stream
+	client.addSinkInput(10, 0, "spotify")
+
+	events := sf.sessionEventsForPulseEvent(proto.SubscribeEvent{Event: paEventFacilitySinkInput | paEventTypeNew, Index: 10})
+	if len(events) != 1 || events[0].ObjectID != "sink-input.10" || len(events[0].Sessions) != 1 {
+		t.Fatalf("Expected a single session for sink-input.10, got %v", events)
+	}
+
+	if key := events[0].Sessions[0].Key(); key != "spotify" {
+		t.Errorf("Expected a session keyed 'spotify', got '%s'", key)
+	}
+
+	// a stream that's gone before we get to ask about it, and one that's removed
+	for _, event := range []proto.SubscribeEvent{
+		{Event: paEventFacilitySinkInput | paEventTypeNew, Index: 11},
+		{Event: paEventFacilitySinkInput | paEventTypeRemove, Index: 10},
+	} {
+		events = sf.sessionEventsForPulseEvent(event)
+		if len(events) != 1 || len(events[0].Sessions) != 0 {
+			t.Errorf("Expected a removal event for sink input %d, got %v", event.Index, events)
+		}
+	}
+
+	// a new sink is bindable by its name and description
+	client.addSink(1, "bluez_sink.00_11_22_33_44_55.a2dp_sink", "Headphones")
+
+	events = sf.sessionEventsForPulseEvent(proto.SubscribeEvent{Event: paEventFacilitySink | paEventTypeNew, Index: 1})
+	if len(events) != 1 || events[0].ObjectID != "sink.1" || len(events[0].Sessions) != 2 {
+		t.Fatalf("Expected two sessions for sink.1, got %v", events)
+	}
+
+	// its monitor isn't
+	client.addSource(3, "bluez_sink.00_11_22_33_44_55.a2dp_sink.monitor", "Monitor of Headphones", 1)
+
+	events = sf.sessionEventsForPulseEvent(proto.SubscribeEvent{Event: paEventFacilitySource | paEventTypeNew, Index: 3})
+	if len(events) != 1 || len(events[0].Sessions) != 0 {
+		t.Errorf("Expected no sessions for a monitor source, got %v", events)
+	}
+
+	// the default sink changing replaces the master session
+	client.defaultSink = "bluez_sink.00_11_22_33_44_55.a2dp_sink"
+
+	events = sf.sessionEventsForPulseEvent(proto.SubscribeEvent{Event: paEventFacilityServer | paEventTypeChange})
+	if len(events) != 2 || events[0].ObjectID != masterSinkObjectID || len(events[0].Sessions) != 1 {
+		t.Fatalf("Expected a new master sink session, got %v", events)
+	}
+
+	if err := events[0].Sessions[0].SetVolume(0.5); err != nil {
+		t.Fatalf("Failed to set master session volume: %v", err)
+	}
+
+	if volume := parseChannelVolumes(client.sinks[1].ChannelVolumes); volume != 0.5 {
+		t.Errorf("Expected the new default sink's volume to be 0.5, got %.2f", volume)
+	}
+}
+
+// TestPAOnPulseMessage tests that only events that can affect sessions are queued up
+func TestPAOnPulseMessage(t *testing.T) {
+	sf := newTestPASessionFinder(newFakePAClient())
+	sf.pulseEvents = make(chan proto.SubscribeEvent, 1)
+
+	sf.onPulseMessage(&proto.SubscribeEvent{Event: paEventFacilitySinkInput | paEventTypeChange, Index: 10})
+	sf.onPulseMessage(&proto.Started{StreamIndex: 10})
+	sf.onPulseMessage(&proto.SubscribeEvent{Event: paEventFacilitySinkInput | paEventTypeNew, Index: 11})
+
+	// the queue is full now, this one gets dropped rather than blocking the client's read loop
+	sf.onPulseMessage(&proto.SubscribeEvent{Event: paEventFacilitySinkInput | paEventTypeRemove, Index: 11})
+
+	if len(sf.pulseEvents) != 1 {
+		t.Fatalf("Expected 1 queued event, got %d", len(sf.pulseEvents))
+	}
+
+	if event := <-sf.pulseEvents; event.Index != 11 {
+		t.Errorf("Expected the event for sink input 11 to be queued, got %d", event.Index)
+	}
+}
+
+// TestPASubscribeToSessionEvents tests that events that came in before anyone subscribed are reported once someone does
+func TestPASubscribeToSessionEvents(t *testing.T) {
+	client := newFakePAClient()
+	client.addSinkInput(10, 0, "spotify")
+
+	sf := newTestPASessionFinder(client)
+	sf.pulseEvents = make(chan proto.SubscribeEvent, 1)
+
+	sf.onPulseMessage(&proto.SubscribeEvent{Event: paEventFacilitySinkInput | paEventTypeNew, Index: 10})
+
+	select {
+	case event := <-sf.SubscribeToSessionEvents():
+		if event.ObjectID != "sink-input.10" || len(event.Sessions) != 1 {
+			t.Errorf("Expected the session of sink-input.10, got %v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the queued event")
+	}
+}
+
+// TestPAStateChanges tests that changes to streams and devices are signaled, even though they don't replace sessions
+func TestPAStateChanges(t *testing.T) {
+	sf := newTestPASessionFinder(newFakePAClient())
+	sf.pulseEvents = make(chan proto.SubscribeEvent, 1)
+
+	stateChanges := sf.SubscribeToStateChanges()
+
+	// a module loading isn't anything controllers show
+	sf.onPulseMessage(&proto.SubscribeEvent{Event: 0x0004 | paEventTypeChange, Index: 3})
+
+	select {
+	case <-stateChanges:
+		t.Error("Expected no signal for a module change")
+	default:
+	}
+
+	// i.e. the mic being muted, twice before anyone noticed
+	sf.onPulseMessage(&proto.SubscribeEvent{Event: paEventFacilitySource | paEventTypeChange, Index: 1})
+	sf.onPulseMessage(&proto.SubscribeEvent{Event: paEventFacilitySource | paEventTypeChange, Index: 1})
+
+	select {
+	case <-stateChanges:
+	default:
+		t.Fatal("Expected a signal for a source change")
+	}
+
+	if len(sf.pulseEvents) != 0 {
+		t.Error("Expected the change not to be queued up as a session event")
+	}
+}
@@ -1,14 +1,12 @@
 package deej
 
 import (
-	"errors"
 	"fmt"
-	"regexp"
+	"math"
 	"strings"
 	"sync"
 	"time"
 
-	ole "github.com/go-ole/go-ole"
 	"github.com/thoas/go-funk"
 	"github.com/tomerhh/deej/pkg/deej/util"
 	"go.uber.org/zap"
@@ -23,8 +21,18 @@ type sessionMap struct {
 
 	sessionFinder SessionFinder
 
+	// set when the session finder reports sessions as they come and go, making refresh heuristics unnecessary
+	watchingSessions bool
+
 	lastSessionRefresh time.Time
 	unmappedSessions   []Session
+
+	// the volume that each slider last asked for, which tells sliders in pickup mode whether they
+	// crossed a session's volume. only used by slider move handling
+	sliderVolumes map[int]float32
+
+	// asks for the controllers' state to be pushed, see requestStatePush
+	statePushRequests chan bool
 }
 
 const (
@@ -36,7 +44,7 @@ const (
 	// this prefix identifies those targets to ensure they don't contradict with another similarly-named process
 	specialTargetTransformPrefix = "deej."
 
-	// targets the currently active window (Windows-only, experimental)
+	// targets the currently active window (Windows and X11 only, experimental)
 	specialTargetCurrentWindow = "current"
 
 	// targets all currently unmapped sessions (experimental)
@@ -51,13 +59,17 @@ const (
 	// this is a bit greedy but allows us to ensure sessions are always re-acquired, which is
 	// especially important for process groups (because you can have one ongoing session
 	// always preventing lookup of other processes bound to its slider, which forces the user
-	// to manually refresh sessions). a cleaner way to do this down the line is by registering to notifications
-	// whenever a new session is added, but that's too hard to justify for how easy this solution is
+	// to manually refresh sessions). session finders that implement SessionWatcher don't need this,
+	// as they register to notifications whenever a session is added or removed
 	maxTimeBetweenSessionRefreshes = time.Second * 45
-)
 
-// this matches friendly device names (on Windows), e.g. "Headphones (Realtek Audio)"
-var deviceSessionKeyPattern = regexp.MustCompile(`^.+ \(.+\)$`)
+	// the controllers' state is pushed whenever deej changes it, or the session finder reports that it might have
+	// changed (i.e. the mic was muted from the OS). anything else only shows up when it's checked this often
+	controllerStatePollInterval = time.Second * 10
+
+	// session finders that don't report changes are checked this often instead, so that the LEDs still follow the OS
+	controllerStateFallbackPollInterval = time.Millisecond * 500
+)
 
 func newSessionMap(deej *Deej, logger *zap.SugaredLogger, sessionFinder SessionFinder) (*sessionMap, error) {
 	logger = logger.Named("sessions")
@@ -68,6 +80,10 @@ func newSessionMap(deej *Deej, logger *zap.SugaredLogger, sessionFinder SessionF
 		m:             make(map[string][]Session),
 		lock:          &sync.Mutex{},
 		sessionFinder: sessionFinder,
+		sliderVolumes: map[int]float32{},
+
+		// a single pending request covers every change until it's handled
+		statePushRequests: make(chan bool, 1),
 	}
 
 	logger.Debug("Created session map instance")
@@ -81,10 +97,12 @@ func (m *sessionMap) initialize() error {
 		return fmt.Errorf("get all sessions during init: %w", err)
 	}
 
+	m.setupOnSessionEvents()
 	m.setupOnConfigReload()
 	m.setupOnSliderMove()
 	m.setupOnMuteButtonClicked()
 	m.setupOnToggleOutputDeviceButtonClicked()
+	m.setupStatePush()
 
 	return nil
 }
@@ -99,8 +117,17 @@ func (m *sessionMap) release() error {
 }
 
 // assumes the session map is clean!
-// only call on a new session map or as part of refreshSessions which calls reset
+// only call on a new session map, refreshSessions replaces the sessions it already has
 func (m *sessionMap) getAndAddSessions() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.addAllSessions()
+}
+
+// addAllSessions gets every session from the session finder and adds it. callers hold the lock, which keeps
+// session events from being handled while some of the sessions are missing
+func (m *sessionMap) addAllSessions() error {
 
 	// mark that we're refreshing before anything else
 	m.lastSessionRefresh = time.Now()
@@ -112,19 +139,41 @@ func (m *sessionMap) getAndAddSessions() error {
 		return fmt.Errorf("get sessions from SessionFinder: %w", err)
 	}
 
+	unmappedSessions := []Session{}
+
 	for _, session := range sessions {
-		m.add(session)
+		key := session.Key()
+		m.m[key] = append(m.m[key], session)
 
 		if !m.sessionMapped(session) {
-			m.unmappedSessions = append(m.unmappedSessions, session)
+			unmappedSessions = append(unmappedSessions, session)
 		}
 	}
 
-	m.logger.Infow("Got all audio sessions successfully", "sessionMap", m)
+	m.unmappedSessions = unmappedSessions
+
+	m.logger.Infow("Got all audio sessions successfully", "sessions", len(sessions))
 
 	return nil
 }
 
+func (m *sessionMap) setupOnSessionEvents() {
+	sessionWatcher, ok := m.sessionFinder.(SessionWatcher)
+	if !ok {
+		m.logger.Debug("Session finder doesn't report session events, falling back to refreshing sessions")
+		return
+	}
+
+	m.watchingSessions = true
+	eventsChannel := sessionWatcher.SubscribeToSessionEvents()
+
+	go func() {
+		for event := range eventsChannel {
+			m.handleSessionEvent(event)
+		}
+	}()
+}
+
 func (m *sessionMap) setupOnConfigReload() {
 	configReloadedChannel := m.deej.config.SubscribeToChanges()
 
@@ -132,6 +181,14 @@ func (m *sessionMap) setupOnConfigReload() {
 		for {
 			select {
 			case <-configReloadedChannel:
+
+				// sessions are already current when they're being watched, only which of them are mapped can change
+				if m.watchingSessions {
+					m.logger.Info("Detected config reload, re-evaluating unmapped audio sessions")
+					m.refreshUnmappedSessions()
+					continue
+				}
+
 				m.logger.Info("Detected config reload, attempting to re-acquire all audio sessions")
 				m.refreshSessions(false)
 			}
@@ -140,7 +197,7 @@ func (m *sessionMap) setupOnConfigReload() {
 }
 
 func (m *sessionMap) setupOnSliderMove() {
-	eventsChannel := m.deej.deejSlidersController.SubscribeToSliderMoveEvents()
+	eventsChannel := m.deej.subscribeToSliderMoveEvents()
 
 	go func() {
 		for event := range eventsChannel {
@@ -150,16 +207,92 @@ func (m *sessionMap) setupOnSliderMove() {
 }
 
 func (m *sessionMap) setupOnMuteButtonClicked() {
-	m.deej.deejButtonsController.setMuteButtonClickEventConsumer(m.handleMuteButtonClickedEventsAndGetState)
+	for _, controller := range m.deej.buttonControllers() {
+		controller.setMuteButtonClickEventConsumer(m.handleMuteButtonClickedEventsAndGetState)
+	}
 }
 
 func (m *sessionMap) setupOnToggleOutputDeviceButtonClicked() {
-	m.deej.deejButtonsController.setToggleOutputDeviceEventConsumer(m.handleToggleOutputDeviceClickedEventAndGetState)
+	for _, controller := range m.deej.buttonControllers() {
+		controller.setToggleOutputDeviceEventConsumer(m.handleToggleOutputDeviceClickedEventAndGetState)
+	}
+}
+
+func (m *sessionMap) setupStatePush() {
+	controllers := m.deej.stateControllers()
+	if len(controllers) == 0 {
+		return
+	}
+
+	// layers, profiles and mappings decide which targets the mute buttons show
+	layerChannel := m.deej.SubscribeToLayerChanges()
+	profileChannel := m.deej.SubscribeToProfileChanges()
+	configReloadedChannel := m.deej.config.SubscribeToChanges()
+
+	// changes from outside deej are pushed as they're reported, and polled for if they aren't
+	var stateChangedChannel chan bool
+	pollInterval := controllerStateFallbackPollInterval
+
+	if stateWatcher, ok := m.sessionFinder.(StateWatcher); ok {
+		stateChangedChannel = stateWatcher.SubscribeToStateChanges()
+		pollInterval = controllerStatePollInterval
+	}
+
+	go func() {
+		for {
+			select {
+			case <-layerChannel:
+			case <-profileChannel:
+			case <-configReloadedChannel:
+			case <-stateChangedChannel:
+			}
+
+			m.requestStatePush()
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-m.statePushRequests:
+			case <-ticker.C:
+			}
+
+			for _, controller := range controllers {
+				controller.pushState(m.getControllerState)
+			}
+
+			// controllers rate limit their pushes, so whatever changes in the meantime waits for the limit to pass
+			// rather than being dropped by it
+			time.Sleep(minTimeBetweenStatePushes)
+		}
+	}()
+}
+
+// requestStatePush has the controllers' state pushed soon, after something changed mute states or the output device
+func (m *sessionMap) requestStatePush() {
+	select {
+	case m.statePushRequests <- true:
+	default:
+	}
 }
 
 // performance: explain why force == true at every such use to avoid unintended forced refresh spams
 func (m *sessionMap) refreshSessions(force bool) {
 
+	// watched sessions can't be missing or stale, so only forced refreshes (i.e. after a failure) are useful
+	if !force && m.watchingSessions {
+		return
+	}
+
+	// clearing and adding every session again happens under the lock, so a session event can't be handled
+	// in between and add its sessions a second time
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	// make sure enough time passed since the last refresh, unless force is true in which case always clear
 	if !force && m.lastSessionRefresh.Add(minTimeBetweenSessionRefreshes).After(time.Now()) {
 		return
@@ -168,11 +301,13 @@ func (m *sessionMap) refreshSessions(force bool) {
 	// clear and release sessions first
 	m.clear()
 
-	if err := m.getAndAddSessions(); err != nil {
+	if err := m.addAllSessions(); err != nil {
 		m.logger.Warnw("Failed to re-acquire all audio sessions", "error", err)
 	} else {
 		m.logger.Debug("Re-acquired sessions successfully")
 	}
+
+	m.requestStatePush()
 }
 
 // returns true if a session is not currently mapped to any slider, false otherwise
@@ -185,38 +320,62 @@ func (m *sessionMap) sessionMapped(session Session) bool {
 		return true
 	}
 
-	// count device sessions as mapped
-	if deviceSessionKeyPattern.MatchString(session.Key()) {
+	// count device sessions as mapped (these are always master sessions, on every platform)
+	if _, ok := session.(*masterSession); ok {
 		return true
 	}
 
 	matchFound := false
 
-	// look through the actual mappings
-	m.deej.config.SliderMapping.iterate(func(sliderIdx int, targets []string) {
-		for _, target := range targets {
+	// look through the actual mappings. a session that any layer or profile maps is mapped, so that
+	// switching them doesn't hand it over to deej.unmapped
+	mappings := []*sliderMap{}
+	for layer := 0; layer < m.deej.config.NumLayers(); layer++ {
+		mappings = append(mappings, m.deej.config.sliderMapping(layer))
+	}
 
-			// ignore special transforms
-			if m.targetHasSpecialTransform(target) {
-				continue
-			}
+	for _, p := range m.deej.config.Profiles {
+		mappings = append(mappings, p.SliderMapping)
+	}
 
-			// safe to assume this has a single element because we made sure there's no special transform
-			target = m.resolveTarget(target)[0]
+	for _, mapping := range mappings {
+		if matchFound {
+			break
+		}
+
+		mapping.iterate(func(sliderIdx int, targets []string) {
+			for _, target := range targets {
+
+				// ignore special transforms
+				if m.targetHasSpecialTransform(target) {
+					continue
+				}
+
+				// safe to assume this has a single element because we made sure there's no special transform
+				target = m.resolveTarget(target)[0]
 
-			if target == session.Key() {
-				matchFound = true
-				return
+				if target == session.Key() {
+					matchFound = true
+					return
+				}
 			}
-		}
-	})
+		})
+	}
 
 	return matchFound
 }
 
 func (m *sessionMap) maybeRefreshSessions() {
+	if m.watchingSessions {
+		return
+	}
+
+	m.lock.Lock()
+	stale := m.lastSessionRefresh.Add(maxTimeBetweenSessionRefreshes).Before(time.Now())
+	m.lock.Unlock()
+
 	// first of all, ensure our session map isn't moldy
-	if m.lastSessionRefresh.Add(maxTimeBetweenSessionRefreshes).Before(time.Now()) {
+	if stale {
 		m.logger.Debug("Stale session map detected on slider move, refreshing")
 		m.refreshSessions(true)
 	}
@@ -226,8 +385,8 @@ func (m *sessionMap) handleSliderMoveEvent(event SliderMoveEvent) {
 
 	m.maybeRefreshSessions()
 
-	// get the targets mapped to this slider from the config
-	targets, ok := m.deej.config.SliderMapping.get(event.SliderID)
+	// get the targets mapped to this slider in the active profile and layer from the config
+	targets, ok := m.deej.sliderTargets(event.SliderID)
 
 	// if slider not found in config, silently ignore
 	if !ok {
@@ -235,6 +394,18 @@ func (m *sessionMap) handleSliderMoveEvent(event SliderMoveEvent) {
 		return
 	}
 
+	// the slider's curve applies to every target alike
+	volume := m.deej.config.SliderCurves.forSlider(event.SliderID).apply(event.PercentValue)
+
+	pickup := m.deej.config.SlidersInPickup[event.SliderID]
+
+	previousVolume, moved := m.sliderVolumes[event.SliderID]
+	if !moved {
+		previousVolume = -1
+	}
+
+	m.sliderVolumes[event.SliderID] = volume
+
 	targetFound := false
 	adjustmentFailed := false
 
@@ -260,8 +431,21 @@ func (m *sessionMap) handleSliderMoveEvent(event SliderMoveEvent) {
 
 			// iterate all matching sessions and adjust the volume of each one
 			for _, session := range sessions {
-				if session.GetVolume() != event.PercentValue {
-					if err := session.SetVolume(event.PercentValue); err != nil {
+
+				// sliders in pickup mode leave sessions alone until they get to their volume
+				if pickup && !pickedUp(previousVolume, volume, session.GetVolume()) {
+					if m.deej.Verbose() {
+						m.logger.Debugw("Slider hasn't picked up session yet",
+							"slider", event.SliderID,
+							"volume", volume,
+							"session", session)
+					}
+
+					continue
+				}
+
+				if session.GetVolume() != volume {
+					if err := session.SetVolume(volume); err != nil {
 						m.logger.Warnw("Failed to set target session volume", "error", err)
 						adjustmentFailed = true
 					}
@@ -291,7 +475,7 @@ func (m *sessionMap) handleMuteButtonClickedEventsAndGetState(events []MuteButto
 	// get the targets mapped to this buttons from the config
 	targets_arr := make([][]string, len(events))
 	for event_index, event := range events {
-		targets, ok := m.deej.config.MuteButtonMapping.get(event.MuteButtonID)
+		targets, ok := m.deej.muteButtonTargets(event.MuteButtonID)
 		if !ok {
 			// if a button is not found in config, silently ignore
 			m.logger.Warn("Ignoring data for unmapped button (%d)", event.MuteButtonID)
@@ -353,69 +537,258 @@ func (m *sessionMap) handleMuteButtonClickedEventsAndGetState(events []MuteButto
 		// (or another, more catastrophic failure happens)
 		m.refreshSessions(true)
 	}
+
+	m.requestStatePush()
+
 	return ret, nil
 }
 
 func (m *sessionMap) handleToggleOutputDeviceClickedEventAndGetState(event ToggleOutoutDeviceClickEvent) (newState OutputDeviceState, err error) {
 	m.maybeRefreshSessions()
 
+	// a negative index doesn't select anything, it only asks which device is currently in use
+	if event.selectedOutputDevice < 0 {
+		return OutputDeviceState{selectedOutputDevice: m.getCurrentOutputDeviceIndex()}, nil
+	}
+
 	// get the device friendly name of the target device to toggle to
 	selectedDeviceFriendlyName, ok := m.deej.config.AvailableOutputDeviceMapping.get(event.selectedOutputDevice)
 	if !ok {
-		m.logger.Warn("Ignoring data for unknown output device (%d)", event.selectedOutputDevice)
-		return OutputDeviceState{}, fmt.Errorf("Ignoring data for unknown output device (%d) %w", event.selectedOutputDevice, err)
+		m.logger.Warnf("Ignoring data for unknown output device (%d)", event.selectedOutputDevice)
+		return OutputDeviceState{}, fmt.Errorf("unknown output device (%d)", event.selectedOutputDevice)
 	} else if len(selectedDeviceFriendlyName) != 1 {
-		m.logger.Warn("Multiple output device toggeling is not supported (%d), %s", event.selectedOutputDevice, selectedDeviceFriendlyName)
-		return OutputDeviceState{}, fmt.Errorf("config consists of multiple output devices to toggle %w", err)
+		m.logger.Warnf("Multiple output device toggeling is not supported (%d), %s", event.selectedOutputDevice, selectedDeviceFriendlyName)
+		return OutputDeviceState{}, fmt.Errorf("config consists of multiple output devices to toggle (%d)", event.selectedOutputDevice)
 	}
 
-	// get the UUID of the target device to toggle to
-	selectedDevice, err := util.GetDeviceIDByNameWinAPI(selectedDeviceFriendlyName[0])
+	m.logger.Infof("Changing selected device to: %s", selectedDeviceFriendlyName[0])
 
-	// if the error is "Incorrect function" that corresponds to 0x00000001,
-	// which represents E_FALSE in COM error handling. this is fine for this function,
-	// and just means that the call was redundant.
-	const eFalse = 1
-	oleError := &ole.OleError{}
+	if err := m.sessionFinder.SwitchOutputDevice(selectedDeviceFriendlyName[0]); err != nil {
+		m.logger.Warnw("Failed to switch output device", "error", err)
+		return OutputDeviceState{selectedOutputDevice: -1}, fmt.Errorf("switch output device: %w", err)
+	}
 
-	if errors.As(err, &oleError) {
-		if oleError.Code() == eFalse {
-			m.logger.Warnf("CoInitializeEx failed with E_FALSE due to redundant invocation %w", err)
-		} else {
-			m.logger.Warnw("Failed to call CoInitializeEx",
-				"isOleError", true,
-				"error", err,
-				"oleError", oleError,
-				"err", err)
+	// performance: switching devices invalidates the master session, and users can't
+	// press a physical button at a rate that's meaningful to performance. the refresh has the new device pushed
+	m.refreshSessions(true)
 
-			return OutputDeviceState{}, fmt.Errorf("call CoInitializeEx: %w", err)
+	// report whichever device actually ended up as the default, rather than assuming the switch took
+	return OutputDeviceState{selectedOutputDevice: m.getCurrentOutputDeviceIndex()}, nil
+}
+
+// handleTargetsMuteToggle unmutes the targets if every one of their sessions is muted, and mutes all of them otherwise
+// (i.e. for a hotkey). it returns whether they're muted now, and reports every session that failed
+func (m *sessionMap) handleTargetsMuteToggle(targets []string) (bool, error) {
+	m.maybeRefreshSessions()
+
+	mute := !m.targetsMuted(targets)
+
+	targetFound := false
+	failedSessions := []string{}
+
+	for _, target := range targets {
+		for _, session := range m.targetSessions(target) {
+			targetFound = true
+
+			if err := session.SetMute(mute); err != nil {
+				m.logger.Warnw("Failed to set target session mute state", "session", session.Key(), "error", err)
+				failedSessions = append(failedSessions, session.Key())
+			}
 		}
 	}
-	if err != nil {
-		m.logger.Warnw("Failed to get device ID by name", "error", err)
-		return OutputDeviceState{}, fmt.Errorf("failed to get device ID by name: %w", err)
+
+	muted := m.targetsMuted(targets)
+
+	// like for mute buttons, targets that weren't found or failed might need a refresh
+	if !targetFound {
+		m.refreshSessions(false)
+	} else if len(failedSessions) > 0 {
+		m.refreshSessions(true)
 	}
 
-	m.logger.Infof("Changing selected device to: %s (%s)", selectedDevice, selectedDeviceFriendlyName[0])
-	res := util.SetAudioDeviceByID(selectedDevice, m.logger)
-	m.refreshSessions(true)
-	if res {
-		return OutputDeviceState(event), nil
+	m.requestStatePush()
+
+	if len(failedSessions) > 0 {
+		return muted, fmt.Errorf("set mute of %s", strings.Join(failedSessions, ", "))
 	}
-	out, _, err := m.sessionFinder.getDefaultAudioEndpoints()
+
+	return muted, nil
+}
+
+// handleTargetsVolumeStep moves the volume of every one of the targets' sessions by step (i.e. for a hotkey).
+// targets that a slider controls are stepped along its volume curve, as if the slider moved, and the rest
+// in whole percents. every session that failed is reported
+func (m *sessionMap) handleTargetsVolumeStep(targets []string, step float32) error {
+	m.maybeRefreshSessions()
+
+	targetFound := false
+	failedSessions := []string{}
+
+	for _, target := range targets {
+		curve := m.targetCurve(target)
+
+		for _, session := range m.targetSessions(target) {
+			targetFound = true
+
+			position := float32(math.Round(float64(curve.position(session.GetVolume())+step)*100) / 100)
+
+			volume := curve.apply(position)
+			if session.GetVolume() == volume {
+				continue
+			}
+
+			if err := session.SetVolume(volume); err != nil {
+				m.logger.Warnw("Failed to set target session volume", "session", session.Key(), "error", err)
+				failedSessions = append(failedSessions, session.Key())
+			}
+		}
+	}
+
+	if !targetFound {
+		m.refreshSessions(false)
+	} else if len(failedSessions) > 0 {
+		m.refreshSessions(true)
+	}
+
+	m.requestStatePush()
+
+	if len(failedSessions) > 0 {
+		return fmt.Errorf("set volume of %s", strings.Join(failedSessions, ", "))
+	}
+
+	return nil
+}
+
+// targetCurve returns the volume curve of the first slider in the active mapping that controls the target,
+// and the linear one if none does
+func (m *sessionMap) targetCurve(target string) volumeCurve {
+	for sliderIdx := 0; sliderIdx < m.deej.config.numMappedSliders(); sliderIdx++ {
+		sliderTargets, ok := m.deej.sliderTargets(sliderIdx)
+		if !ok {
+			continue
+		}
+
+		for _, sliderTarget := range sliderTargets {
+			if strings.EqualFold(sliderTarget, target) {
+				return m.deej.config.SliderCurves.forSlider(sliderIdx)
+			}
+		}
+	}
+
+	return linearCurve
+}
+
+// getControllerState returns whether each mute button's targets are muted, which output device is the default
+// and which slider layer is active
+func (m *sessionMap) getControllerState() ControllerState {
+	state := ControllerState{
+		MuteButtons:  m.muteButtonStates(),
+		OutputDevice: -1,
+		Layer:        m.deej.ActiveLayer(),
+	}
+
+	// don't bother the session finder about output devices if there's nothing to switch between
+	if m.deej.config.AvailableOutputDeviceMapping.NumSliders() > 0 {
+		state.OutputDevice = m.getCurrentOutputDeviceIndex()
+	}
+
+	return state
+}
+
+// returns true if the given targets have sessions, and all of them are muted
+// muteButtonStates returns whether the targets of every mute button in the active profile are muted
+func (m *sessionMap) muteButtonStates() []bool {
+	profileName, _ := m.deej.ActiveProfile()
+	states := make([]bool, m.deej.config.numMuteButtons(profileName))
+
+	for buttonIdx := range states {
+		if targets, ok := m.deej.config.muteButtonTargets(profileName, buttonIdx); ok {
+			states[buttonIdx] = m.targetsMuted(targets)
+		}
+	}
+
+	return states
+}
+
+func (m *sessionMap) targetsMuted(targets []string) bool {
+	sessionFound := false
+
+	for _, target := range targets {
+		for _, resolvedTarget := range m.resolveTarget(target) {
+			sessions, ok := m.get(resolvedTarget)
+			if !ok {
+				continue
+			}
+
+			for _, session := range sessions {
+				if !session.GetMute() {
+					return false
+				}
+
+				sessionFound = true
+			}
+		}
+	}
+
+	return sessionFound
+}
+
+// returns the volume of the loudest session among the given targets, and false if they have no sessions
+func (m *sessionMap) targetsVolume(targets []string) (float32, bool) {
+	volume := float32(-1)
+
+	for _, target := range targets {
+		for _, session := range m.targetSessions(target) {
+			if sessionVolume := session.GetVolume(); sessionVolume > volume {
+				volume = sessionVolume
+			}
+		}
+	}
+
+	return volume, volume >= 0
+}
+
+// returns the index of the configured output device that's currently the default one, or -1 if there's none
+func (m *sessionMap) getCurrentOutputDeviceIndex() int {
+	currentDeviceNames, err := m.sessionFinder.GetCurrentOutputDevice()
 	if err != nil {
-		return OutputDeviceState{selectedOutputDevice: -1}, nil
-	}
-	var outDeviceId string
-	out.GetId(&outDeviceId)
-	for key, ids := range m.deej.config.AvailableOutputDeviceMapping.m {
-		for _, id := range ids {
-			if id == outDeviceId {
-				return OutputDeviceState{selectedOutputDevice: key}, nil
+		m.logger.Warnw("Failed to get current output device", "error", err)
+		return -1
+	}
+
+	currentDeviceIdx := -1
+
+	// the mapping is iterated in no particular order, so a device that's listed more than once is reported
+	// under its lowest index
+	m.deej.config.AvailableOutputDeviceMapping.iterate(func(deviceIdx int, deviceNames []string) {
+		if currentDeviceIdx != -1 && currentDeviceIdx < deviceIdx {
+			return
+		}
+
+		for _, deviceName := range deviceNames {
+			for _, currentDeviceName := range currentDeviceNames {
+				if strings.EqualFold(deviceName, currentDeviceName) {
+					currentDeviceIdx = deviceIdx
+					return
+				}
 			}
 		}
+	})
+
+	return currentDeviceIdx
+}
+
+// targetSessions returns every session that a target resolves to
+func (m *sessionMap) targetSessions(target string) []Session {
+	sessions := []Session{}
+
+	for _, resolvedTarget := range m.resolveTarget(target) {
+		if resolvedSessions, ok := m.get(resolvedTarget); ok {
+			sessions = append(sessions, resolvedSessions...)
+		}
 	}
-	return OutputDeviceState{selectedOutputDevice: -1}, nil
+
+	return sessions
 }
 
 func (m *sessionMap) targetHasSpecialTransform(target string) bool {
@@ -444,7 +817,7 @@ func (m *sessionMap) applyTargetTransform(specialTargetName string) []string {
 	case specialTargetCurrentWindow:
 		currentWindowProcessNames, err := util.GetCurrentWindowProcessNames()
 
-		// silently ignore errors here, as this is on deej's "hot path" (and it could just mean the user's running linux)
+		// silently ignore errors here, as this is on deej's "hot path" (and it could just mean there's no X11 display)
 		if err != nil {
 			return nil
 		}
@@ -459,6 +832,9 @@ func (m *sessionMap) applyTargetTransform(specialTargetName string) []string {
 
 	// get currently unmapped sessions
 	case specialTargetAllUnmapped:
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
 		targetKeys := make([]string, len(m.unmappedSessions))
 		for sessionIdx, session := range m.unmappedSessions {
 			targetKeys[sessionIdx] = session.Key()
@@ -484,6 +860,76 @@ func (m *sessionMap) add(value Session) {
 	}
 }
 
+// handleSessionEvent replaces the sessions belonging to the event's audio object with the ones it carries
+func (m *sessionMap) handleSessionEvent(event SessionEvent) {
+	m.logger.Debugw("Handling session event", "objectID", event.ObjectID, "sessions", event.Sessions)
+
+	// figure out which of the new sessions are unmapped before taking the lock, as that involves resolving targets
+	newUnmappedSessions := []Session{}
+	for _, session := range event.Sessions {
+		if !m.sessionMapped(session) {
+			newUnmappedSessions = append(newUnmappedSessions, session)
+		}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for key, sessions := range m.m {
+		remaining := []Session{}
+
+		for _, session := range sessions {
+			if sessionObjectID(session) == event.ObjectID {
+				session.Release()
+				continue
+			}
+
+			remaining = append(remaining, session)
+		}
+
+		if len(remaining) == 0 {
+			delete(m.m, key)
+		} else {
+			m.m[key] = remaining
+		}
+	}
+
+	unmappedSessions := []Session{}
+	for _, session := range m.unmappedSessions {
+		if sessionObjectID(session) != event.ObjectID {
+			unmappedSessions = append(unmappedSessions, session)
+		}
+	}
+
+	m.unmappedSessions = append(unmappedSessions, newUnmappedSessions...)
+
+	for _, session := range event.Sessions {
+		key := session.Key()
+		m.m[key] = append(m.m[key], session)
+	}
+
+	m.requestStatePush()
+}
+
+// refreshUnmappedSessions re-evaluates which of the current sessions aren't mapped to any slider
+func (m *sessionMap) refreshUnmappedSessions() {
+	unmappedSessions := []Session{}
+	for _, session := range m.allSessions() {
+		if !m.sessionMapped(session) {
+			unmappedSessions = append(unmappedSessions, session)
+		}
+	}
+
+	m.setUnmappedSessions(unmappedSessions)
+}
+
+func (m *sessionMap) setUnmappedSessions(sessions []Session) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.unmappedSessions = sessions
+}
+
 func (m *sessionMap) get(key string) ([]Session, bool) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
@@ -492,10 +938,21 @@ func (m *sessionMap) get(key string) ([]Session, bool) {
 	return value, ok
 }
 
-func (m *sessionMap) clear() {
+// allSessions returns every session in the map
+func (m *sessionMap) allSessions() []Session {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
+	sessions := []Session{}
+	for _, keySessions := range m.m {
+		sessions = append(sessions, keySessions...)
+	}
+
+	return sessions
+}
+
+// clear releases and removes every session. callers hold the lock
+func (m *sessionMap) clear() {
 	m.logger.Debug("Releasing and clearing all audio sessions")
 
 	for key, sessions := range m.m {
@@ -521,3 +978,12 @@ func (m *sessionMap) String() string {
 
 	return fmt.Sprintf("<%d audio sessions>", sessionCount)
 }
+
+// returns the audio object a session belongs to, which is only known for sessions from a SessionWatcher
+func sessionObjectID(session Session) string {
+	if identified, ok := session.(interface{ getObjectID() string }); ok {
+		return identified.getObjectID()
+	}
+
+	return ""
+}
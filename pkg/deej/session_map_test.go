@@ -0,0 +1,495 @@
+package deej
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// fakeSession is an in-memory Session that records the volume and mute state it was given
+type fakeSession struct {
+	baseSession
+
+	volume float32
+	mute   bool
+}
+
+func newFakeSession(logger *zap.SugaredLogger, name string) *fakeSession {
+	s := &fakeSession{volume: 1.0}
+
+	s.logger = logger
+	s.name = name
+	s.humanReadableDesc = name
+
+	return s
+}
+
+func (s *fakeSession) GetVolume() float32 {
+	return s.volume
+}
+
+func (s *fakeSession) SetVolume(v float32) error {
+	s.volume = v
+	return nil
+}
+
+func (s *fakeSession) GetMute() bool {
+	return s.mute
+}
+
+func (s *fakeSession) SetMute(m bool) error {
+	s.mute = m
+	return nil
+}
+
+func (s *fakeSession) Release() {}
+
+func (s *fakeSession) String() string {
+	return fmt.Sprintf(sessionStringFormat, s.humanReadableDesc, s.volume)
+}
+
+// fakeSessionFinder serves a fixed list of sessions and records output device switches
+type fakeSessionFinder struct {
+	sessions []Session
+
+	currentOutputDevice []string
+	switchedTo          []string
+	switchErr           error
+}
+
+func (sf *fakeSessionFinder) GetAllSessions() ([]Session, error) {
+	return sf.sessions, nil
+}
+
+func (sf *fakeSessionFinder) SwitchOutputDevice(name string) error {
+	if sf.switchErr != nil {
+		return sf.switchErr
+	}
+
+	sf.switchedTo = append(sf.switchedTo, name)
+	sf.currentOutputDevice = []string{name}
+
+	return nil
+}
+
+func (sf *fakeSessionFinder) GetCurrentOutputDevice() ([]string, error) {
+	if sf.currentOutputDevice == nil {
+		return nil, errors.New("no default output device")
+	}
+
+	return sf.currentOutputDevice, nil
+}
+
+func (sf *fakeSessionFinder) Release() error {
+	return nil
+}
+
+// stubbornSessionFinder accepts output device switches without the default device ever changing
+type stubbornSessionFinder struct {
+	fakeSessionFinder
+}
+
+func (sf *stubbornSessionFinder) SwitchOutputDevice(name string) error {
+	sf.switchedTo = append(sf.switchedTo, name)
+	return nil
+}
+
+// newTestSessionMap loads the given config and creates a session map backed by the given finder
+func newTestSessionMap(t *testing.T, configContent string, sessionFinder SessionFinder) *sessionMap {
+	cleanup := createTestConfig(t, configContent)
+	t.Cleanup(cleanup)
+
+	logger := zap.NewNop().Sugar()
+	deej := newTestDeej(t, logger)
+
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	m, err := newSessionMap(deej, logger, sessionFinder)
+	if err != nil {
+		t.Fatalf("Failed to create session map: %v", err)
+	}
+
+	if err := m.getAndAddSessions(); err != nil {
+		t.Fatalf("Failed to add sessions: %v", err)
+	}
+
+	return m
+}
+
+const outputDeviceTestConfig = `
+slider_mapping:
+  0: master
+available_output_device:
+  0: ["Speakers"]
+  1: ["Headphones"]
+`
+
+// TestSwitchOutputDevice tests that a switch event goes through the session finder's switcher
+func TestSwitchOutputDevice(t *testing.T) {
+	sf := &fakeSessionFinder{}
+	m := newTestSessionMap(t, outputDeviceTestConfig, sf)
+
+	state, err := m.handleToggleOutputDeviceClickedEventAndGetState(ToggleOutoutDeviceClickEvent{selectedOutputDevice: 1})
+	if err != nil {
+		t.Fatalf("Failed to switch output device: %v", err)
+	}
+
+	if state.selectedOutputDevice != 1 {
+		t.Errorf("Expected selected output device 1, got %d", state.selectedOutputDevice)
+	}
+
+	if len(sf.switchedTo) != 1 || sf.switchedTo[0] != "Headphones" {
+		t.Errorf("Expected a single switch to 'Headphones', got %v", sf.switchedTo)
+	}
+}
+
+// TestSwitchOutputDeviceReportsActualDevice tests that the reply reflects the device that actually became the default
+func TestSwitchOutputDeviceReportsActualDevice(t *testing.T) {
+	sf := &stubbornSessionFinder{fakeSessionFinder{currentOutputDevice: []string{"Speakers"}}}
+	m := newTestSessionMap(t, outputDeviceTestConfig, sf)
+
+	state, err := m.handleToggleOutputDeviceClickedEventAndGetState(ToggleOutoutDeviceClickEvent{selectedOutputDevice: 1})
+	if err != nil {
+		t.Fatalf("Failed to switch output device: %v", err)
+	}
+
+	if state.selectedOutputDevice != 0 {
+		t.Errorf("Expected the reply to report the unchanged device 0, got %d", state.selectedOutputDevice)
+	}
+}
+
+// TestSwitchOutputDeviceErrors tests that unknown indexes and switcher failures are reported
+func TestSwitchOutputDeviceErrors(t *testing.T) {
+	sf := &fakeSessionFinder{}
+	m := newTestSessionMap(t, outputDeviceTestConfig, sf)
+
+	if _, err := m.handleToggleOutputDeviceClickedEventAndGetState(ToggleOutoutDeviceClickEvent{selectedOutputDevice: 5}); err == nil {
+		t.Error("Expected an error for an unmapped output device index")
+	}
+
+	sf.switchErr = errors.New("device unplugged")
+
+	if _, err := m.handleToggleOutputDeviceClickedEventAndGetState(ToggleOutoutDeviceClickEvent{selectedOutputDevice: 0}); err == nil {
+		t.Error("Expected an error when the switcher fails")
+	}
+
+	if len(sf.switchedTo) != 0 {
+		t.Errorf("Expected no successful switches, got %v", sf.switchedTo)
+	}
+}
+
+// TestGetCurrentOutputDeviceIndex tests that a query event reports the configured index of the default device
+func TestGetCurrentOutputDeviceIndex(t *testing.T) {
+	sf := &fakeSessionFinder{
+		currentOutputDevice: []string{"Headphones (Realtek Audio)", "headphones", "{0.0.0.00000000}.{1234}"},
+	}
+	m := newTestSessionMap(t, outputDeviceTestConfig, sf)
+
+	query := ToggleOutoutDeviceClickEvent{selectedOutputDevice: -1}
+
+	state, err := m.handleToggleOutputDeviceClickedEventAndGetState(query)
+	if err != nil {
+		t.Fatalf("Failed to query output device: %v", err)
+	}
+
+	if state.selectedOutputDevice != 1 {
+		t.Errorf("Expected current output device 1, got %d", state.selectedOutputDevice)
+	}
+
+	sf.currentOutputDevice = []string{"HDMI Output"}
+
+	if state, _ = m.handleToggleOutputDeviceClickedEventAndGetState(query); state.selectedOutputDevice != -1 {
+		t.Errorf("Expected -1 for an unconfigured output device, got %d", state.selectedOutputDevice)
+	}
+
+	sf.currentOutputDevice = nil
+
+	if state, _ = m.handleToggleOutputDeviceClickedEventAndGetState(query); state.selectedOutputDevice != -1 {
+		t.Errorf("Expected -1 when the current output device can't be determined, got %d", state.selectedOutputDevice)
+	}
+
+	if len(sf.switchedTo) != 0 {
+		t.Errorf("Queries should never switch devices, got %v", sf.switchedTo)
+	}
+}
+
+// TestGetCurrentOutputDeviceIndexListedTwice tests that a device under more than one index is always reported under the lowest
+func TestGetCurrentOutputDeviceIndexListedTwice(t *testing.T) {
+	sf := &fakeSessionFinder{currentOutputDevice: []string{"Headphones"}}
+	m := newTestSessionMap(t, "available_output_device:\n  3: [\"Headphones\"]\n  1: [\"Speakers\", \"Headphones\"]\n  2: [\"Headphones\"]\n", sf)
+
+	for attempt := 0; attempt < 20; attempt++ {
+		if deviceIdx := m.getCurrentOutputDeviceIndex(); deviceIdx != 1 {
+			t.Fatalf("Expected current output device 1, got %d", deviceIdx)
+		}
+	}
+}
+
+// TestMuteButtonClicked tests that mute button events reach every session mapped to the button
+func TestMuteButtonClicked(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	chrome := newFakeSession(logger, "chrome.exe")
+	discord := newFakeSession(logger, "discord.exe")
+
+	sf := &fakeSessionFinder{sessions: []Session{chrome, discord}}
+	m := newTestSessionMap(t, `
+slider_mapping:
+  0: master
+mute_button_mapping:
+  0: chrome.exe
+  1: discord.exe
+`, sf)
+
+	state, err := m.handleMuteButtonClickedEventsAndGetState([]MuteButtonClickEvent{{MuteButtonID: 1, mute: true}})
+	if err != nil {
+		t.Fatalf("Failed to handle mute button event: %v", err)
+	}
+
+	if len(state.MuteButtons) != 1 || !state.MuteButtons[0] {
+		t.Errorf("Expected mute state [true], got %v", state.MuteButtons)
+	}
+
+	if !discord.mute || chrome.mute {
+		t.Errorf("Expected only discord to be muted, got chrome=%t discord=%t", chrome.mute, discord.mute)
+	}
+}
+
+// TestHandleSessionEvent tests that session events add, replace and remove sessions without a full refresh
+func TestHandleSessionEvent(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	spotify := newFakeSession(logger, "spotify")
+	spotify.objectID = "stream.1"
+
+	sf := &fakeSessionFinder{sessions: []Session{spotify}}
+	m := newTestSessionMap(t, `
+slider_mapping:
+  0: master
+  1: deej.unmapped
+`, sf)
+	m.watchingSessions = true
+
+	firefox := newFakeSession(logger, "firefox")
+	firefox.objectID = "stream.2"
+
+	m.handleSessionEvent(SessionEvent{ObjectID: "stream.2", Sessions: []Session{firefox}})
+
+	if sessions, ok := m.get("firefox"); !ok || len(sessions) != 1 {
+		t.Fatalf("Expected a firefox session after it was added, got %v", sessions)
+	}
+
+	if unmapped := m.resolveTarget("deej.unmapped"); len(unmapped) != 2 {
+		t.Errorf("Expected 2 unmapped sessions, got %v", unmapped)
+	}
+
+	// a slider moving to a missing target shouldn't trigger a refresh while sessions are watched
+	sf.sessions = nil
+	m.handleSliderMoveEvent(SliderMoveEvent{SliderID: 0, PercentValue: 0.5})
+
+	if _, ok := m.get("spotify"); !ok {
+		t.Error("Expected the session map not to be refreshed while watching sessions")
+	}
+
+	m.handleSessionEvent(SessionEvent{ObjectID: "stream.1"})
+
+	if sessions, ok := m.get("spotify"); ok {
+		t.Errorf("Expected spotify to be removed, got %v", sessions)
+	}
+
+	if unmapped := m.resolveTarget("deej.unmapped"); len(unmapped) != 1 || unmapped[0] != "firefox" {
+		t.Errorf("Expected only firefox to be unmapped, got %v", unmapped)
+	}
+
+	m.handleSliderMoveEvent(SliderMoveEvent{SliderID: 1, PercentValue: 0.25})
+
+	if firefox.volume != 0.25 {
+		t.Errorf("Expected firefox volume 0.25 through deej.unmapped, got %.2f", firefox.volume)
+	}
+}
+
+// eventfulSessionFinder has a session event handled while it's listing sessions, like PulseAudio reporting
+// a stream that starts during a refresh
+type eventfulSessionFinder struct {
+	fakeSessionFinder
+
+	onGetAllSessions func()
+}
+
+func (sf *eventfulSessionFinder) GetAllSessions() ([]Session, error) {
+	if sf.onGetAllSessions != nil {
+		sf.onGetAllSessions()
+	}
+
+	return sf.sessions, nil
+}
+
+// TestSessionEventDuringRefresh tests that a session event that comes in while the map is refreshed
+// doesn't leave its sessions in the map twice
+func TestSessionEventDuringRefresh(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	spotify := newFakeSession(logger, "spotify")
+	spotify.objectID = "stream.1"
+
+	sf := &eventfulSessionFinder{fakeSessionFinder: fakeSessionFinder{sessions: []Session{spotify}}}
+	m := newTestSessionMap(t, "slider_mapping:\n  0: spotify\n", sf)
+	m.watchingSessions = true
+
+	handled := make(chan bool)
+	sf.onGetAllSessions = func() {
+		go func() {
+			m.handleSessionEvent(SessionEvent{ObjectID: "stream.1", Sessions: []Session{spotify}})
+			close(handled)
+		}()
+
+		// give the event time to get handled, if nothing stops it
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	m.refreshSessions(true)
+	<-handled
+
+	if sessions, _ := m.get("spotify"); len(sessions) != 1 {
+		t.Errorf("Expected a single spotify session, got %v", sessions)
+	}
+}
+
+// recordingStateController reports every state push it gets
+type recordingStateController struct {
+	pushes chan ControllerState
+}
+
+func (c *recordingStateController) pushState(getState func() ControllerState) {
+	c.pushes <- getState()
+}
+
+// TestStatePushOnChanges tests that the controllers' state is pushed when deej changes it, without waiting for a poll
+func TestStatePushOnChanges(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	mic := newFakeSession(logger, inputSessionName)
+	mic.objectID = "source.1"
+
+	m := newTestSessionMap(t, "mute_button_mapping:\n  0: mic\n", &fakeSessionFinder{sessions: []Session{mic}})
+
+	controller := &recordingStateController{pushes: make(chan ControllerState, 1)}
+	m.deej.stateController = controller
+	m.setupStatePush()
+
+	expectPush := func(muted bool) {
+		select {
+		case state := <-controller.pushes:
+			if len(state.MuteButtons) != 1 || state.MuteButtons[0] != muted {
+				t.Errorf("Expected mute button 0 to be pushed as %v, got %+v", muted, state)
+			}
+		case <-time.After(minTimeBetweenStatePushes + time.Second):
+			t.Fatal("Timed out waiting for a state push")
+		}
+	}
+
+	if _, err := m.handleMuteButtonClickedEventsAndGetState([]MuteButtonClickEvent{{MuteButtonID: 0, mute: true}}); err != nil {
+		t.Fatalf("Failed to handle mute button event: %v", err)
+	}
+
+	expectPush(true)
+
+	// a new mic session that isn't muted is pushed once the rate limit is over
+	newMic := newFakeSession(logger, inputSessionName)
+	m.handleSessionEvent(SessionEvent{ObjectID: "source.1", Sessions: []Session{newMic}})
+
+	expectPush(false)
+}
+
+// stateWatchingSessionFinder reports state changes whenever the test signals them
+type stateWatchingSessionFinder struct {
+	fakeSessionFinder
+
+	stateChanges chan bool
+}
+
+func (sf *stateWatchingSessionFinder) SubscribeToStateChanges() chan bool {
+	return sf.stateChanges
+}
+
+// TestStatePushOnOSChanges tests that changes the session finder reports are pushed without waiting for a poll
+func TestStatePushOnOSChanges(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	mic := newFakeSession(logger, inputSessionName)
+	sf := &stateWatchingSessionFinder{fakeSessionFinder{sessions: []Session{mic}}, make(chan bool, 1)}
+
+	m := newTestSessionMap(t, "mute_button_mapping:\n  0: mic\n", sf)
+
+	controller := &recordingStateController{pushes: make(chan ControllerState, 1)}
+	m.deej.stateController = controller
+	m.setupStatePush()
+
+	// muted from the OS
+	mic.SetMute(true)
+	sf.stateChanges <- true
+
+	select {
+	case state := <-controller.pushes:
+		if len(state.MuteButtons) != 1 || !state.MuteButtons[0] {
+			t.Errorf("Expected mute button 0 to be pushed as muted, got %+v", state)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for a state push")
+	}
+}
+
+// TestGetControllerState tests that mute buttons report their targets' mute state, along with the current device
+func TestGetControllerState(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	master := newFakeSession(logger, masterSessionName)
+	mic := newFakeSession(logger, inputSessionName)
+	chrome := newFakeSession(logger, "chrome.exe")
+	discord := newFakeSession(logger, "discord.exe")
+
+	sf := &fakeSessionFinder{
+		sessions:            []Session{master, mic, chrome, discord},
+		currentOutputDevice: []string{"Headphones"},
+	}
+
+	m := newTestSessionMap(t, `
+slider_mapping:
+  0: master
+mute_button_mapping:
+  0: master
+  1: [chrome.exe, discord.exe]
+  3: spotify.exe
+available_output_device:
+  0: ["Speakers"]
+  1: ["Headphones"]
+`, sf)
+
+	mic.mute = true
+	chrome.mute = true
+
+	state := m.getControllerState()
+
+	if fmt.Sprint(state.MuteButtons) != "[false false false false]" {
+		t.Errorf("Expected no muted buttons, got %v", state.MuteButtons)
+	}
+
+	if state.OutputDevice != 1 {
+		t.Errorf("Expected output device 1, got %d", state.OutputDevice)
+	}
+
+	// a button only counts as muted once all of its targets are
+	master.mute = true
+	discord.mute = true
+
+	state = m.getControllerState()
+
+	if fmt.Sprint(state.MuteButtons) != "[true true false false]" {
+		t.Errorf("Expected buttons 0 and 1 muted, got %v", state.MuteButtons)
+	}
+}
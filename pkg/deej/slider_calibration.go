@@ -0,0 +1,275 @@
+package deej
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// slider calibration maps a slider's raw readings to 0.0-1.0. by default, every slider is expected
+// to cover the whole range of a 12-bit ADC. boards with other ADCs, and faders that never quite reach
+// their ends, can be calibrated for all sliders at once and for each slider on its own (see config.yaml)
+const (
+	calibrationKeyADCBits      = "adc_bits"
+	calibrationKeyMin          = "min"
+	calibrationKeyMax          = "max"
+	calibrationKeyInvert       = "invert"
+	calibrationKeyDeadZoneLow  = "dead_zone_low"
+	calibrationKeyDeadZoneHigh = "dead_zone_high"
+	calibrationKeySliders      = "sliders"
+
+	defaultADCBits = 12
+	maxADCBits     = 16
+
+	// recorded ranges are pulled in by this much, so that faders resting at their ends reliably reach 0 and 1
+	calibrationMargin = 0.01
+
+	// sliders that moved less than this much of the ADC's range while calibrating weren't calibrated
+	minCalibratedSpan = 0.1
+)
+
+// SliderCalibration describes how a slider's raw readings map to 0.0-1.0
+type SliderCalibration struct {
+	MinRaw int
+	MaxRaw int
+	Invert bool
+
+	// the fractions of the calibrated range at either end that snap to 0.0 and 1.0
+	DeadZoneLow  float32
+	DeadZoneHigh float32
+}
+
+// sliderCalibrations holds the calibration of every slider, with the global one for sliders that don't have their own
+type sliderCalibrations struct {
+	adcMax  int
+	global  SliderCalibration
+	sliders map[int]SliderCalibration
+}
+
+// sliderRange is the raw range that a slider covered while calibrating it
+type sliderRange struct {
+	Min int
+	Max int
+}
+
+// sliderCalibrationsFromConfigs reads the global and per-slider calibration from the user config, with
+// ranges recorded by 'deej calibrate' in the internal config taking precedence over the user config's
+func sliderCalibrationsFromConfigs(userConfig *viper.Viper, internalConfig *viper.Viper, invertSliders bool) (*sliderCalibrations, error) {
+	adcBits := defaultADCBits
+	if key := configKeySliderCalibration + "." + calibrationKeyADCBits; userConfig.IsSet(key) {
+		adcBits = userConfig.GetInt(key)
+	}
+
+	if adcBits < 1 || adcBits > maxADCBits {
+		return nil, fmt.Errorf("%s must be between 1 and %d, got %d", calibrationKeyADCBits, maxADCBits, adcBits)
+	}
+
+	sc := &sliderCalibrations{
+		adcMax:  1<<adcBits - 1,
+		sliders: map[int]SliderCalibration{},
+	}
+
+	sc.global = calibrationFromConfig(userConfig, configKeySliderCalibration, SliderCalibration{
+		MinRaw: 0,
+		MaxRaw: sc.adcMax,
+		Invert: invertSliders,
+	})
+
+	if err := sc.global.validate(); err != nil {
+		return nil, err
+	}
+
+	slidersKey := configKeySliderCalibration + "." + calibrationKeySliders
+
+	sliderIdxStrings := map[string]bool{}
+	for sliderIdxString := range userConfig.GetStringMap(slidersKey) {
+		sliderIdxStrings[sliderIdxString] = true
+	}
+	for sliderIdxString := range internalConfig.GetStringMap(slidersKey) {
+		sliderIdxStrings[sliderIdxString] = true
+	}
+
+	for sliderIdxString := range sliderIdxStrings {
+		sliderIdx, err := strconv.Atoi(sliderIdxString)
+		if err != nil || sliderIdx < 0 {
+			return nil, fmt.Errorf("invalid slider index '%s' in %s", sliderIdxString, slidersKey)
+		}
+
+		sliderKey := slidersKey + "." + sliderIdxString
+
+		calibration := calibrationFromConfig(userConfig, sliderKey, sc.global)
+		calibration = calibrationFromConfig(internalConfig, sliderKey, calibration)
+
+		if err := calibration.validate(); err != nil {
+			return nil, fmt.Errorf("slider %d: %w", sliderIdx, err)
+		}
+
+		sc.sliders[sliderIdx] = calibration
+	}
+
+	return sc, nil
+}
+
+// calibrationFromConfig overrides whatever fields of base are set under the given key
+func calibrationFromConfig(v *viper.Viper, key string, base SliderCalibration) SliderCalibration {
+	if v.IsSet(key + "." + calibrationKeyMin) {
+		base.MinRaw = v.GetInt(key + "." + calibrationKeyMin)
+	}
+
+	if v.IsSet(key + "." + calibrationKeyMax) {
+		base.MaxRaw = v.GetInt(key + "." + calibrationKeyMax)
+	}
+
+	if v.IsSet(key + "." + calibrationKeyInvert) {
+		base.Invert = v.GetBool(key + "." + calibrationKeyInvert)
+	}
+
+	if v.IsSet(key + "." + calibrationKeyDeadZoneLow) {
+		base.DeadZoneLow = float32(v.GetFloat64(key + "." + calibrationKeyDeadZoneLow))
+	}
+
+	if v.IsSet(key + "." + calibrationKeyDeadZoneHigh) {
+		base.DeadZoneHigh = float32(v.GetFloat64(key + "." + calibrationKeyDeadZoneHigh))
+	}
+
+	return base
+}
+
+func (c SliderCalibration) validate() error {
+	if c.MinRaw < 0 || c.MinRaw >= c.MaxRaw {
+		return fmt.Errorf("%s (%d) must be at least 0 and below %s (%d)", calibrationKeyMin, c.MinRaw, calibrationKeyMax, c.MaxRaw)
+	}
+
+	if c.DeadZoneLow < 0 || c.DeadZoneHigh < 0 || c.DeadZoneLow+c.DeadZoneHigh >= 1 {
+		return fmt.Errorf("dead zones (%.2f, %.2f) can't be negative, or cover the whole range together",
+			c.DeadZoneLow, c.DeadZoneHigh)
+	}
+
+	return nil
+}
+
+// forSlider returns the calibration of the given slider
+func (sc *sliderCalibrations) forSlider(sliderIdx int) SliderCalibration {
+	if calibration, ok := sc.sliders[sliderIdx]; ok {
+		return calibration
+	}
+
+	return sc.global
+}
+
+// String returns the per-slider calibrations in a human-readable form, for the logs
+func (sc *sliderCalibrations) String() string {
+	sliderIdxs := make([]int, 0, len(sc.sliders))
+	for sliderIdx := range sc.sliders {
+		sliderIdxs = append(sliderIdxs, sliderIdx)
+	}
+
+	sort.Ints(sliderIdxs)
+
+	result := fmt.Sprintf("global: %+v", sc.global)
+	for _, sliderIdx := range sliderIdxs {
+		result += fmt.Sprintf(", %d: %+v", sliderIdx, sc.sliders[sliderIdx])
+	}
+
+	return result
+}
+
+// normalize maps a raw reading to 0.0-1.0, clamping readings outside of the calibrated range
+func (c SliderCalibration) normalize(raw int) float32 {
+	value := float32(raw-c.MinRaw) / float32(c.MaxRaw-c.MinRaw)
+
+	if value < 0 {
+		value = 0
+	} else if value > 1 {
+		value = 1
+	}
+
+	// the rest of the range is stretched between the dead zones, so that it doesn't jump at their edges
+	switch {
+	case value <= c.DeadZoneLow:
+		value = 0
+	case value >= 1-c.DeadZoneHigh:
+		value = 1
+	default:
+		value = (value - c.DeadZoneLow) / (1 - c.DeadZoneLow - c.DeadZoneHigh)
+	}
+
+	if c.Invert {
+		value = 1 - value
+	}
+
+	return value
+}
+
+// sliderCalibrator records the lowest and highest raw reading of every slider
+type sliderCalibrator struct {
+	adcMax int
+	ranges map[int]sliderRange
+	lock   sync.Mutex
+}
+
+func newSliderCalibrator(adcMax int) *sliderCalibrator {
+	return &sliderCalibrator{
+		adcMax: adcMax,
+		ranges: map[int]sliderRange{},
+	}
+}
+
+func (c *sliderCalibrator) record(values []int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for sliderIdx, value := range values {
+		r, ok := c.ranges[sliderIdx]
+		if !ok {
+			c.ranges[sliderIdx] = sliderRange{value, value}
+			continue
+		}
+
+		if value < r.Min {
+			r.Min = value
+		}
+
+		if value > r.Max {
+			r.Max = value
+		}
+
+		c.ranges[sliderIdx] = r
+	}
+}
+
+// results returns the recorded ranges, pulled in by the calibration margin.
+// sliders that barely moved are left out, so that they keep their current calibration
+func (c *sliderCalibrator) results() map[int]sliderRange {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	results := map[int]sliderRange{}
+
+	for sliderIdx, r := range c.ranges {
+		span := r.Max - r.Min
+		if float32(span) < float32(c.adcMax)*minCalibratedSpan {
+			continue
+		}
+
+		margin := int(float32(span) * calibrationMargin)
+		results[sliderIdx] = sliderRange{r.Min + margin, r.Max - margin}
+	}
+
+	return results
+}
+
+// maxRangeIdx returns the highest slider index among the given ranges
+func maxRangeIdx(ranges map[int]sliderRange) int {
+	maxIdx := -1
+	for sliderIdx := range ranges {
+		if sliderIdx > maxIdx {
+			maxIdx = sliderIdx
+		}
+	}
+
+	return maxIdx
+}
@@ -0,0 +1,247 @@
+package deej
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// createTestPreferences writes the internal config, and returns a function that removes it along with
+// its directory if the test created it
+func createTestPreferences(t testing.TB, content string) func() {
+	_, statErr := os.Stat(internalConfigPath)
+	createdDir := os.IsNotExist(statErr)
+
+	if err := os.MkdirAll(internalConfigPath, os.ModePerm); err != nil {
+		t.Fatalf("Failed to create internal config directory: %v", err)
+	}
+
+	filepath := path.Join(internalConfigPath, internalConfigFilepath)
+	if err := os.WriteFile(filepath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test preferences: %v", err)
+	}
+
+	return func() {
+		os.Remove(filepath)
+
+		if createdDir {
+			os.Remove(internalConfigPath)
+		}
+	}
+}
+
+// TestSliderCalibrationNormalize tests mapping raw readings through calibrated ranges, inversion and dead zones
+func TestSliderCalibrationNormalize(t *testing.T) {
+	tests := []struct {
+		name        string
+		calibration SliderCalibration
+		raw         int
+		expected    float32
+	}{
+		{"12-bit bottom", SliderCalibration{MinRaw: 0, MaxRaw: 4095}, 0, 0.0},
+		{"12-bit middle", SliderCalibration{MinRaw: 0, MaxRaw: 4095}, 2048, 0.5},
+		{"12-bit top", SliderCalibration{MinRaw: 0, MaxRaw: 4095}, 4095, 1.0},
+		{"10-bit top", SliderCalibration{MinRaw: 0, MaxRaw: 1023}, 1023, 1.0},
+		{"10-bit middle", SliderCalibration{MinRaw: 0, MaxRaw: 1023}, 512, 0.5},
+		{"below calibrated range", SliderCalibration{MinRaw: 100, MaxRaw: 3900}, 40, 0.0},
+		{"above calibrated range", SliderCalibration{MinRaw: 100, MaxRaw: 3900}, 4095, 1.0},
+		{"inside calibrated range", SliderCalibration{MinRaw: 100, MaxRaw: 3900}, 2000, 0.5},
+		{"inverted", SliderCalibration{MinRaw: 0, MaxRaw: 4095, Invert: true}, 4095, 0.0},
+		{"low dead zone", SliderCalibration{MinRaw: 0, MaxRaw: 1000, DeadZoneLow: 0.05}, 40, 0.0},
+		{"high dead zone", SliderCalibration{MinRaw: 0, MaxRaw: 1000, DeadZoneHigh: 0.05}, 960, 1.0},
+		{"stretched between dead zones", SliderCalibration{MinRaw: 0, MaxRaw: 1000, DeadZoneLow: 0.1, DeadZoneHigh: 0.1}, 500, 0.5},
+		{"inverted dead zone", SliderCalibration{MinRaw: 0, MaxRaw: 1000, Invert: true, DeadZoneLow: 0.05}, 40, 1.0},
+	}
+
+	for _, test := range tests {
+		if value := test.calibration.normalize(test.raw); absFloat(value-test.expected) > 0.001 {
+			t.Errorf("%s: expected %d to become %.3f, got %.3f", test.name, test.raw, test.expected, value)
+		}
+	}
+}
+
+// TestSliderCalibrationConfig tests that per-slider calibration falls back to the global one,
+// and that recorded ranges take precedence over the user config
+func TestSliderCalibrationConfig(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, `
+slider_mapping:
+  0: master
+invert_sliders: true
+slider_calibration:
+  adc_bits: 10
+  min: 10
+  dead_zone_high: 0.02
+  sliders:
+    1:
+      max: 900
+      invert: false
+    2:
+      min: 50
+`)
+	defer cleanup()
+
+	cleanupPreferences := createTestPreferences(t, `
+slider_calibration:
+  sliders:
+    2:
+      min: 30
+      max: 1000
+    3:
+      min: 20
+      max: 980
+`)
+	defer cleanupPreferences()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	expected := map[int]SliderCalibration{
+		0: {MinRaw: 10, MaxRaw: 1023, Invert: true, DeadZoneHigh: 0.02},
+		1: {MinRaw: 10, MaxRaw: 900, Invert: false, DeadZoneHigh: 0.02},
+		2: {MinRaw: 30, MaxRaw: 1000, Invert: true, DeadZoneHigh: 0.02},
+		3: {MinRaw: 20, MaxRaw: 980, Invert: true, DeadZoneHigh: 0.02},
+		7: {MinRaw: 10, MaxRaw: 1023, Invert: true, DeadZoneHigh: 0.02},
+	}
+
+	for sliderIdx, calibration := range expected {
+		if actual := deej.config.SliderCalibration.forSlider(sliderIdx); actual != calibration {
+			t.Errorf("Expected slider %d to have %+v, got %+v", sliderIdx, calibration, actual)
+		}
+	}
+}
+
+// TestSliderCalibrationDefaults tests that sliders without any calibration cover a 12-bit ADC's whole range
+func TestSliderCalibrationDefaults(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, `
+slider_mapping:
+  0: master
+`)
+	defer cleanup()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	expected := SliderCalibration{MinRaw: 0, MaxRaw: 4095}
+	if actual := deej.config.SliderCalibration.forSlider(0); actual != expected {
+		t.Errorf("Expected %+v, got %+v", expected, actual)
+	}
+}
+
+// TestSliderCalibrationInvalid tests that calibrations which can't map readings fail to load
+func TestSliderCalibrationInvalid(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	for _, calibration := range []string{
+		"adc_bits: 20",
+		"min: 4095",
+		"max: 0",
+		"dead_zone_low: -0.1",
+		"dead_zone_low: 0.5\n  dead_zone_high: 0.5",
+		"sliders:\n    one:\n      min: 10",
+		"sliders:\n    0:\n      min: 500\n      max: 400",
+	} {
+		cleanup := createTestConfig(t, "slider_calibration:\n  "+calibration+"\n")
+
+		deej := newTestDeej(t, logger)
+		if err := deej.config.Load(); err == nil {
+			t.Errorf("Expected an error for '%s', got %s", calibration, deej.config.SliderCalibration)
+		}
+
+		cleanup()
+	}
+}
+
+// TestSliderCalibrator tests recording slider ranges, including the margin and skipping sliders that didn't move
+func TestSliderCalibrator(t *testing.T) {
+	calibrator := newSliderCalibrator(4095)
+
+	calibrator.record([]int{2000, 2000, 2000})
+	calibrator.record([]int{100, 1990, 1000})
+	calibrator.record([]int{4000, 2010, 3000})
+	calibrator.record([]int{3000, 2000, 1500})
+
+	results := calibrator.results()
+
+	expected := map[int]sliderRange{
+		0: {139, 3961},
+		2: {1020, 2980},
+	}
+
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d calibrated sliders, got %v", len(expected), results)
+	}
+
+	for sliderIdx, r := range expected {
+		if results[sliderIdx] != r {
+			t.Errorf("Expected slider %d to cover %+v, got %+v", sliderIdx, r, results[sliderIdx])
+		}
+	}
+}
+
+// TestSaveSliderRanges tests that recorded ranges are written to the internal config, and applied when it's loaded
+func TestSaveSliderRanges(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, `
+slider_mapping:
+  0: master
+`)
+	defer cleanup()
+
+	// the existing preferences are kept, apart from the recalibrated slider
+	cleanupPreferences := createTestPreferences(t, `
+slider_mapping:
+  1: chrome.exe
+slider_calibration:
+  sliders:
+    0:
+      min: 1
+      max: 2
+    4:
+      min: 100
+      max: 3000
+`)
+	defer cleanupPreferences()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	filepath, err := deej.config.saveSliderRanges(map[int]sliderRange{0: {50, 4000}})
+	if err != nil {
+		t.Fatalf("Failed to save slider ranges: %v", err)
+	}
+
+	if expected := path.Join(internalConfigPath, internalConfigFilepath); filepath != expected {
+		t.Errorf("Expected the ranges to be saved to %s, got %s", expected, filepath)
+	}
+
+	reloaded := newTestDeej(t, logger)
+	if err := reloaded.config.Load(); err != nil {
+		t.Fatalf("Failed to reload config: %v", err)
+	}
+
+	for sliderIdx, expected := range map[int]SliderCalibration{
+		0: {MinRaw: 50, MaxRaw: 4000},
+		4: {MinRaw: 100, MaxRaw: 3000},
+	} {
+		if actual := reloaded.config.SliderCalibration.forSlider(sliderIdx); actual != expected {
+			t.Errorf("Expected slider %d to have %+v, got %+v", sliderIdx, expected, actual)
+		}
+	}
+
+	if targets, ok := reloaded.config.SliderMapping.get(1); !ok || len(targets) != 1 || targets[0] != "chrome.exe" {
+		t.Errorf("Expected the internal slider mapping to survive, got %v", targets)
+	}
+}
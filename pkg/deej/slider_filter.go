@@ -0,0 +1,226 @@
+package deej
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/spf13/viper"
+)
+
+// slider filters deal with noisy readings. each slider's calibrated readings go through a median-of-N
+// and an exponential moving average, and the result only moves the slider once it's far enough from
+// the slider's current value (hysteresis). noise_reduction picks a preset for all sliders, and
+// slider_filter fine-tunes it for all sliders at once and for each slider on its own (see config.yaml)
+const (
+	filterKeyMedian     = "median"
+	filterKeyEMA        = "ema"
+	filterKeyHysteresis = "hysteresis"
+	filterKeySliders    = "sliders"
+
+	noiseReductionLow     = "low"
+	noiseReductionDefault = "default"
+	noiseReductionHigh    = "high"
+
+	maxMedianWindow = 31
+	maxHysteresis   = 0.5
+
+	// the moving average jumps to readings this close to it, so that it actually reaches them
+	emaSnapDistance = 0.005
+)
+
+// the hysteresis of each noise reduction preset. it should be a median value between two round
+// percent values, for instance 0.025 means volume can move at 3% increments
+var noiseReductionPresets = map[string]SliderFilter{
+	noiseReductionLow:     {Hysteresis: 0.015},
+	noiseReductionDefault: {Hysteresis: 0.025},
+	noiseReductionHigh:    {Hysteresis: 0.035},
+}
+
+// SliderFilter describes how a slider's readings are smoothed. zero values turn a stage off
+type SliderFilter struct {
+
+	// the number of recent readings to take the median of
+	Median int
+
+	// how much weight each new reading gets in the moving average, between 0.0 and 1.0
+	EMA float32
+
+	// how far the smoothed value has to move from the slider's current value to change it
+	Hysteresis float32
+}
+
+// sliderFilters holds the filter of every slider, with the global one for sliders that don't have their own
+type sliderFilters struct {
+	global  SliderFilter
+	sliders map[int]SliderFilter
+}
+
+// sliderFilterStage is one step of smoothing a slider's readings, which keeps whatever state it needs
+type sliderFilterStage interface {
+	filter(value float32) float32
+}
+
+// sliderFiltersFromConfig reads the global and per-slider filters from the user config,
+// starting from the noise reduction preset
+func sliderFiltersFromConfig(userConfig *viper.Viper, noiseReductionLevel string) (*sliderFilters, error) {
+	preset, ok := noiseReductionPresets[noiseReductionLevel]
+	if !ok {
+		preset = noiseReductionPresets[noiseReductionDefault]
+	}
+
+	sf := &sliderFilters{
+		global:  filterFromConfig(userConfig, configKeySliderFilter, preset),
+		sliders: map[int]SliderFilter{},
+	}
+
+	if err := sf.global.validate(); err != nil {
+		return nil, err
+	}
+
+	slidersKey := configKeySliderFilter + "." + filterKeySliders
+
+	for sliderIdxString := range userConfig.GetStringMap(slidersKey) {
+		sliderIdx, err := strconv.Atoi(sliderIdxString)
+		if err != nil || sliderIdx < 0 {
+			return nil, fmt.Errorf("invalid slider index '%s' in %s", sliderIdxString, slidersKey)
+		}
+
+		filter := filterFromConfig(userConfig, slidersKey+"."+sliderIdxString, sf.global)
+		if err := filter.validate(); err != nil {
+			return nil, fmt.Errorf("slider %d: %w", sliderIdx, err)
+		}
+
+		sf.sliders[sliderIdx] = filter
+	}
+
+	return sf, nil
+}
+
+// filterFromConfig overrides whatever fields of base are set under the given key
+func filterFromConfig(v *viper.Viper, key string, base SliderFilter) SliderFilter {
+	if v.IsSet(key + "." + filterKeyMedian) {
+		base.Median = v.GetInt(key + "." + filterKeyMedian)
+	}
+
+	if v.IsSet(key + "." + filterKeyEMA) {
+		base.EMA = float32(v.GetFloat64(key + "." + filterKeyEMA))
+	}
+
+	if v.IsSet(key + "." + filterKeyHysteresis) {
+		base.Hysteresis = float32(v.GetFloat64(key + "." + filterKeyHysteresis))
+	}
+
+	return base
+}
+
+func (f SliderFilter) validate() error {
+	if f.Median < 0 || f.Median > maxMedianWindow {
+		return fmt.Errorf("%s must be between 0 and %d, got %d", filterKeyMedian, maxMedianWindow, f.Median)
+	}
+
+	if f.EMA < 0 || f.EMA > 1 {
+		return fmt.Errorf("%s must be between 0.0 and 1.0, got %.2f", filterKeyEMA, f.EMA)
+	}
+
+	if f.Hysteresis < 0 || f.Hysteresis > maxHysteresis {
+		return fmt.Errorf("%s must be between 0.0 and %.1f, got %.3f", filterKeyHysteresis, maxHysteresis, f.Hysteresis)
+	}
+
+	return nil
+}
+
+// forSlider returns the filter of the given slider
+func (sf *sliderFilters) forSlider(sliderIdx int) SliderFilter {
+	if filter, ok := sf.sliders[sliderIdx]; ok {
+		return filter
+	}
+
+	return sf.global
+}
+
+// String returns the per-slider filters in a human-readable form, for the logs
+func (sf *sliderFilters) String() string {
+	sliderIdxs := make([]int, 0, len(sf.sliders))
+	for sliderIdx := range sf.sliders {
+		sliderIdxs = append(sliderIdxs, sliderIdx)
+	}
+
+	sort.Ints(sliderIdxs)
+
+	result := fmt.Sprintf("global: %+v", sf.global)
+	for _, sliderIdx := range sliderIdxs {
+		result += fmt.Sprintf(", %d: %+v", sliderIdx, sf.sliders[sliderIdx])
+	}
+
+	return result
+}
+
+// stages creates fresh smoothing stages for a slider. the median goes first, so that spikes
+// are gone before they can pull the moving average along
+func (f SliderFilter) stages() []sliderFilterStage {
+	stages := []sliderFilterStage{}
+
+	if f.Median > 1 {
+		stages = append(stages, &medianFilter{window: make([]float32, 0, f.Median)})
+	}
+
+	if f.EMA > 0 && f.EMA < 1 {
+		stages = append(stages, &emaFilter{alpha: f.EMA})
+	}
+
+	return stages
+}
+
+// medianFilter returns the median of the last few readings, which drops short spikes entirely
+type medianFilter struct {
+	window []float32
+	next   int
+	sorted []float32
+}
+
+func (m *medianFilter) filter(value float32) float32 {
+	if len(m.window) < cap(m.window) {
+		m.window = append(m.window, value)
+	} else {
+		m.window[m.next] = value
+		m.next = (m.next + 1) % len(m.window)
+	}
+
+	m.sorted = append(m.sorted[:0], m.window...)
+	sort.Slice(m.sorted, func(i, j int) bool {
+		return m.sorted[i] < m.sorted[j]
+	})
+
+	middle := len(m.sorted) / 2
+	if len(m.sorted)%2 == 0 {
+		return (m.sorted[middle-1] + m.sorted[middle]) / 2
+	}
+
+	return m.sorted[middle]
+}
+
+// emaFilter returns an exponential moving average of the readings, which evens out constant jitter
+type emaFilter struct {
+	alpha   float32
+	value   float32
+	started bool
+}
+
+func (e *emaFilter) filter(value float32) float32 {
+	distance := value - e.value
+	if distance < 0 {
+		distance = -distance
+	}
+
+	if !e.started || distance < emaSnapDistance {
+		e.value = value
+		e.started = true
+
+		return value
+	}
+
+	e.value += e.alpha * (value - e.value)
+
+	return e.value
+}
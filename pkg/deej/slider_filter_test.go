@@ -0,0 +1,221 @@
+package deej
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// loadRecording reads a recorded stream of raw slider readings from testdata, one per line
+func loadRecording(t *testing.T, name string) []int {
+	file, err := os.Open(path.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("Failed to open recording: %v", err)
+	}
+	defer file.Close()
+
+	samples := []int{}
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		sample, err := strconv.Atoi(line)
+		if err != nil {
+			t.Fatalf("Invalid sample '%s' in %s: %v", line, name, err)
+		}
+
+		samples = append(samples, sample)
+	}
+
+	return samples
+}
+
+// replayRecording feeds a recording to a single slider, configured by the given config lines,
+// and returns the values of the move events that it caused
+func replayRecording(t *testing.T, configLines string, samples []int) []float32 {
+	cleanup := createTestConfig(t, "slider_mapping:\n  0: master\n"+configLines)
+	defer cleanup()
+
+	logger := zap.NewNop().Sugar()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	sio, err := NewSerialIO(deej, logger)
+	if err != nil {
+		t.Fatalf("Failed to create SerialIO: %v", err)
+	}
+
+	values := []float32{}
+
+	for _, sample := range samples {
+		if event, moved := sio.sliderValueMoved(0, sample); moved {
+			values = append(values, event.PercentValue)
+		}
+	}
+
+	return values
+}
+
+// TestMedianFilter tests that the median of the last readings is returned, while the window fills up and after
+func TestMedianFilter(t *testing.T) {
+	filter := (SliderFilter{Median: 3}).stages()[0]
+
+	for idx, test := range []struct {
+		value    float32
+		expected float32
+	}{
+		{0.5, 0.5},
+		{0.9, 0.7},
+		{0.52, 0.52},
+		{0.51, 0.52},
+		{0.1, 0.51},
+		{0.53, 0.51},
+		{0.54, 0.53},
+	} {
+		if filtered := filter.filter(test.value); absFloat(filtered-test.expected) > 0.0001 {
+			t.Errorf("Reading %d: expected %.2f for %.2f, got %.4f", idx, test.expected, test.value, filtered)
+		}
+	}
+}
+
+// TestEMAFilter tests that the moving average follows readings gradually, and reaches them in the end
+func TestEMAFilter(t *testing.T) {
+	filter := (SliderFilter{EMA: 0.5}).stages()[0]
+
+	for idx, test := range []struct {
+		value    float32
+		expected float32
+	}{
+		{0.2, 0.2},
+		{0.6, 0.4},
+		{0.6, 0.5},
+		{0.6, 0.55},
+		{0.6, 0.575},
+		{0.6, 0.5875},
+		{0.6, 0.59375},
+		{0.6, 0.596875},
+		{0.6, 0.6},
+	} {
+		if filtered := filter.filter(test.value); absFloat(filtered-test.expected) > 0.0001 {
+			t.Errorf("Reading %d: expected %.4f for %.2f, got %.4f", idx, test.expected, test.value, filtered)
+		}
+	}
+}
+
+// TestSliderFilterConfig tests that noise_reduction picks the preset that slider_filter then fine-tunes
+func TestSliderFilterConfig(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, `
+slider_mapping:
+  0: master
+noise_reduction: high
+slider_filter:
+  median: 5
+  sliders:
+    1:
+      ema: 0.3
+      hysteresis: 0.01
+    2:
+      median: 0
+`)
+	defer cleanup()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	for sliderIdx, expected := range map[int]SliderFilter{
+		0: {Median: 5, Hysteresis: 0.035},
+		1: {Median: 5, EMA: 0.3, Hysteresis: 0.01},
+		2: {Median: 0, Hysteresis: 0.035},
+	} {
+		if actual := deej.config.SliderFilters.forSlider(sliderIdx); actual != expected {
+			t.Errorf("Expected slider %d to have %+v, got %+v", sliderIdx, expected, actual)
+		}
+	}
+
+	for _, filter := range []string{"median: -1", "median: 100", "ema: 1.5", "hysteresis: 0.9", "sliders:\n    x:\n      ema: 0.5"} {
+		cleanup := createTestConfig(t, "slider_filter:\n  "+filter+"\n")
+
+		if err := deej.config.Load(); err == nil {
+			t.Errorf("Expected an error for '%s', got %s", filter, deej.config.SliderFilters)
+		}
+
+		cleanup()
+	}
+}
+
+// TestSliderFilterReplayRest tests a resting slider on a noisy board: the presets alone let spikes through,
+// while smoothing keeps the slider still
+func TestSliderFilterReplayRest(t *testing.T) {
+	samples := loadRecording(t, "slider_noisy_rest.txt")
+
+	unfiltered := replayRecording(t, "noise_reduction: low\n", samples)
+	if len(unfiltered) < 10 {
+		t.Errorf("Expected spikes to move the slider without smoothing, got %d moves", len(unfiltered))
+	}
+
+	filtered := replayRecording(t, "noise_reduction: low\nslider_filter:\n  median: 5\n  ema: 0.3\n", samples)
+	if len(filtered) > 2 {
+		t.Errorf("Expected the smoothed slider to stay put, got %d moves: %v", len(filtered), filtered)
+	}
+
+	if last := filtered[len(filtered)-1]; absFloat(last-0.33) > 0.02 {
+		t.Errorf("Expected the smoothed slider at 0.33, got %.2f", last)
+	}
+}
+
+// TestSliderFilterReplaySweep tests a slider that's moved on a noisy board: smoothing follows it without
+// jittering back and forth, and still reaches where it was left
+func TestSliderFilterReplaySweep(t *testing.T) {
+	samples := loadRecording(t, "slider_noisy_sweep.txt")
+
+	if !movesBackwards(replayRecording(t, "noise_reduction: default\n", samples)) {
+		t.Error("Expected spikes to move the slider back and forth without smoothing")
+	}
+
+	for _, configLines := range []string{
+		"noise_reduction: low\nslider_filter:\n  median: 5\n  ema: 0.3\n",
+		"slider_filter:\n  median: 7\n  ema: 0.2\n  hysteresis: 0.01\n",
+	} {
+		values := replayRecording(t, configLines, samples)
+
+		if len(values) < 5 {
+			t.Errorf("%s: expected the slider to follow the sweep, got %v", configLines, values)
+			continue
+		}
+
+		if movesBackwards(values) {
+			t.Errorf("%s: expected the slider to only go up, got %v", configLines, values)
+		}
+
+		if last := values[len(values)-1]; absFloat(last-0.90) > 0.02 {
+			t.Errorf("%s: expected the slider to end at 0.90, got %.2f", configLines, last)
+		}
+	}
+}
+
+// movesBackwards returns true if a slider that's moved up went down along the way
+func movesBackwards(values []float32) bool {
+	for idx := 1; idx < len(values); idx++ {
+		if values[idx] < values[idx-1] {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,100 @@
+package deej
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// binary slider frames let high-rate controllers send slider values without formatting them as text.
+// a frame is a start byte, the number of values, and the values themselves as packed 12-bit big-endian
+// integers (two values per three bytes, with the last one padded to two bytes if the count is odd).
+// they replace Sliders lines once both sides agreed on them during the Hello handshake, and aren't replied to
+const (
+
+	// can't start a text line, which always starts with a word character or a frame start
+	binarySliderFrameStart = 0xA5
+
+	// the capability firmware offers in its Hello, and that deej confirms in its reply
+	capabilityBinarySliders = "binary"
+
+	// a bigger count means the frame is corrupt, and the stream needs to resynchronize
+	maxBinarySliders = 64
+
+	maxSliderValue = 4095
+)
+
+var errInvalidBinaryFrame = errors.New("invalid binary slider frame")
+
+// packedSliderLength returns the number of bytes that the given number of 12-bit values take up
+func packedSliderLength(count int) int {
+	return (count*3 + 1) / 2
+}
+
+// encodeBinarySliders builds a binary slider frame, clamping values to 12 bits
+func encodeBinarySliders(values []int) []byte {
+	frame := make([]byte, 2+packedSliderLength(len(values)))
+	frame[0] = binarySliderFrameStart
+	frame[1] = byte(len(values))
+
+	payload := frame[2:]
+
+	for idx, value := range values {
+		if value < 0 {
+			value = 0
+		} else if value > maxSliderValue {
+			value = maxSliderValue
+		}
+
+		offset := idx / 2 * 3
+
+		if idx%2 == 0 {
+			payload[offset] = byte(value >> 4)
+			payload[offset+1] = byte(value&0xF) << 4
+		} else {
+			payload[offset+1] |= byte(value >> 8)
+			payload[offset+2] = byte(value)
+		}
+	}
+
+	return frame
+}
+
+// decodeBinarySliders unpacks the 12-bit values of a binary slider frame's payload
+func decodeBinarySliders(count int, payload []byte) []int {
+	values := make([]int, count)
+
+	for idx := range values {
+		offset := idx / 2 * 3
+
+		if idx%2 == 0 {
+			values[idx] = int(payload[offset])<<4 | int(payload[offset+1])>>4
+		} else {
+			values[idx] = int(payload[offset+1]&0xF)<<8 | int(payload[offset+2])
+		}
+	}
+
+	return values
+}
+
+// readBinarySliderFrame reads a whole binary slider frame, including its start byte.
+// errors that wrap errInvalidBinaryFrame leave the connection usable, others come from the reader
+func readBinarySliderFrame(reader *bufio.Reader) ([]int, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	count := int(header[1])
+	if header[0] != binarySliderFrameStart || count == 0 || count > maxBinarySliders {
+		return nil, fmt.Errorf("%w: bad header %X", errInvalidBinaryFrame, header)
+	}
+
+	payload := make([]byte, packedSliderLength(count))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+
+	return decodeBinarySliders(count, payload), nil
+}
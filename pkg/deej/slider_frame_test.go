@@ -0,0 +1,239 @@
+package deej
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// repeatReader endlessly repeats the same bytes, like a controller that keeps sending the same message
+type repeatReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := 0
+
+	for n < len(p) {
+		copied := copy(p[n:], r.data[r.pos:])
+		n += copied
+		r.pos = (r.pos + copied) % len(r.data)
+	}
+
+	return n, nil
+}
+
+// newBinarySliderTestIO creates a SerialIO whose controller agreed on binary slider frames for the given slider count
+func newBinarySliderTestIO(t testing.TB, numSliders int) (*SerialIO, *mockSerialConnection) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, `
+slider_mapping:
+  0: master
+`)
+	defer cleanup()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	sio, err := NewSerialIO(deej, logger)
+	if err != nil {
+		t.Fatalf("Failed to create SerialIO: %v", err)
+	}
+
+	mockConn := &mockSerialConnection{writeBuffer: []string{}}
+	sio.conn = mockConn
+	sio.connected = true
+
+	sio.handleLine(fmt.Sprintf("Hello|2|%d|0|0|1.3.0|binary", numSliders))
+
+	return sio, mockConn
+}
+
+// TestBinarySliderEncoding tests that values survive packing into 12 bits, whether there's an odd or even number of them
+func TestBinarySliderEncoding(t *testing.T) {
+	tests := []struct {
+		values   []int
+		expected []int
+		length   int
+	}{
+		{[]int{4095}, []int{4095}, 4},
+		{[]int{0, 4095}, []int{0, 4095}, 5},
+		{[]int{1, 2048, 4095, 1024, 0}, []int{1, 2048, 4095, 1024, 0}, 10},
+		{[]int{-5, 5000}, []int{0, 4095}, 5},
+	}
+
+	for _, test := range tests {
+		frame := encodeBinarySliders(test.values)
+
+		if len(frame) != test.length {
+			t.Errorf("Expected %d bytes for %v, got %d", test.length, test.values, len(frame))
+		}
+
+		values, err := readBinarySliderFrame(bufio.NewReader(bytes.NewReader(frame)))
+		if err != nil {
+			t.Errorf("Failed to read frame for %v: %v", test.values, err)
+			continue
+		}
+
+		if fmt.Sprint(values) != fmt.Sprint(test.expected) {
+			t.Errorf("Expected %v, got %v", test.expected, values)
+		}
+	}
+
+	for _, invalid := range [][]byte{
+		{binarySliderFrameStart, 0},
+		{binarySliderFrameStart, maxBinarySliders + 1},
+	} {
+		if _, err := readBinarySliderFrame(bufio.NewReader(bytes.NewReader(invalid))); !errors.Is(err, errInvalidBinaryFrame) {
+			t.Errorf("Expected %X to be invalid, got %v", invalid, err)
+		}
+	}
+}
+
+// TestBinarySliderNegotiation tests that binary frames are confirmed in the handshake, and only read once they are
+func TestBinarySliderNegotiation(t *testing.T) {
+	sio, mockConn := newBinarySliderTestIO(t, 2)
+
+	if reply := strings.TrimSpace(mockConn.writeBuffer[0]); reply != "Hello|3|binary" {
+		t.Fatalf("Expected the reply to confirm binary frames, got '%s'", reply)
+	}
+
+	// 10 is a newline byte, which mustn't end the frame early
+	stream := append(encodeBinarySliders([]int{10, 160}), []byte("MuteButton|0|true\n")...)
+	reader := bufio.NewReader(bytes.NewReader(stream))
+
+	line, values, err := sio.readNext(reader)
+	if err != nil || fmt.Sprint(values) != "[10 160]" || line != "" {
+		t.Errorf("Expected the binary frame's values, got %v, '%s' and %v", values, line, err)
+	}
+
+	line, values, err = sio.readNext(reader)
+	if err != nil || values != nil || line != "MuteButton|0|true\n" {
+		t.Errorf("Expected the text line after the frame, got %v, '%s' and %v", values, line, err)
+	}
+
+	// firmware that didn't offer binary frames only gets to send lines
+	sio.handleLine("Hello|2|2|0|0|1.2.0")
+
+	if reply := strings.TrimSpace(mockConn.writeBuffer[1]); reply != "Hello|3" {
+		t.Errorf("Expected a reply without capabilities, got '%s'", reply)
+	}
+
+	reader = bufio.NewReader(bytes.NewReader(stream))
+	if _, values, _ := sio.readNext(reader); values != nil {
+		t.Errorf("Expected no binary frame without negotiating it, got %v", values)
+	}
+}
+
+// TestBinarySliderMoveEvents tests that binary frames become the same move events as Sliders lines
+func TestBinarySliderMoveEvents(t *testing.T) {
+	sio, mockConn := newBinarySliderTestIO(t, 3)
+	mockConn.writeBuffer = []string{}
+
+	eventChan := sio.SubscribeToSliderMoveEvents()
+	done := make(chan bool)
+
+	go func() {
+		sio.handleBinarySliders([]int{4095, 2048, 0})
+		close(done)
+	}()
+
+	expected := []float32{1.0, 0.5, 0.0}
+
+	for sliderIdx := range expected {
+		select {
+		case event := <-eventChan:
+			if event.SliderID != sliderIdx || absFloat(event.PercentValue-expected[sliderIdx]) > 0.01 {
+				t.Errorf("Expected slider %d at %.2f, got %+v", sliderIdx, expected[sliderIdx], event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for slider %d", sliderIdx)
+		}
+	}
+
+	<-done
+
+	if len(mockConn.writeBuffer) != 0 {
+		t.Errorf("Expected binary frames to go unanswered, got %v", mockConn.writeBuffer)
+	}
+}
+
+const benchmarkSliders = 16
+
+// benchmarkSliderValues alternates between two positions, so that every message moves every slider
+func benchmarkSliderValues(iteration int) []int {
+	values := make([]int, benchmarkSliders)
+	for idx := range values {
+		values[idx] = (iteration%2)*3000 + idx*10
+	}
+
+	return values
+}
+
+// BenchmarkTextSliders measures a Sliders line from the wire to move events, the way the read loop handles it
+func BenchmarkTextSliders(b *testing.B) {
+	sio, _ := newBinarySliderTestIO(b, benchmarkSliders)
+	sio.connected = false
+
+	lines := [2][]byte{}
+	for iteration := range lines {
+		fields := []string{"Sliders"}
+		for _, value := range benchmarkSliderValues(iteration) {
+			fields = append(fields, fmt.Sprint(value))
+		}
+
+		lines[iteration] = []byte(strings.Join(fields, "|") + "\n")
+	}
+
+	reader := bufio.NewReader(&repeatReader{data: append(lines[0], lines[1]...)})
+
+	b.SetBytes(int64(len(lines[0])))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		line = strings.TrimSpace(line)
+		if !sio.isValidLine(line) {
+			b.Fatalf("Invalid line '%s'", line)
+		}
+
+		sio.handleSliders(strings.Split(line, "|")[1:])
+	}
+}
+
+// BenchmarkBinarySliders measures a binary slider frame from the wire to move events, the way the read loop handles it
+func BenchmarkBinarySliders(b *testing.B) {
+	sio, _ := newBinarySliderTestIO(b, benchmarkSliders)
+	sio.connected = false
+
+	frames := append(encodeBinarySliders(benchmarkSliderValues(0)), encodeBinarySliders(benchmarkSliderValues(1))...)
+	reader := bufio.NewReader(&repeatReader{data: frames})
+
+	b.SetBytes(int64(len(frames) / 2))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, values, err := sio.readNext(reader)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		sio.handleBinarySliders(values)
+	}
+}
@@ -0,0 +1,231 @@
+package deej
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/viper"
+)
+
+// slider layers let a few sliders control more targets than they could on their own. every layer is a full
+// slider mapping, and only one of them is active at a time. slider_mapping is always the first layer, and
+// every layer under slider_layers overlays it: sliders that a layer doesn't map keep their slider_mapping
+// targets. the active layer is switched by the controller (Layer|n), the tray menu and the layer hotkey
+const (
+	layerKeyName          = "name"
+	layerKeySliderMapping = "slider_mapping"
+
+	defaultLayerName = "default"
+)
+
+// sliderLayer is one named slider mapping
+type sliderLayer struct {
+	Name    string
+	Mapping *sliderMap
+}
+
+// sliderLayersFromConfig reads the layers under slider_layers from the user config,
+// which come after the given base mapping
+func sliderLayersFromConfig(userConfig *viper.Viper, base *sliderMap) ([]sliderLayer, error) {
+	layers := []sliderLayer{{Name: defaultLayerName, Mapping: base}}
+
+	if !userConfig.IsSet(configKeySliderLayers) {
+		return layers, nil
+	}
+
+	rawLayers, ok := userConfig.Get(configKeySliderLayers).([]interface{})
+	if !ok {
+		return nil, errors.New("expected a list of layers")
+	}
+
+	for idx, rawLayer := range rawLayers {
+		layerIdx := idx + 1
+
+		fields, ok := stringKeyedMap(rawLayer)
+		if !ok {
+			return nil, fmt.Errorf("layer %d: expected %s and %s", layerIdx, layerKeyName, layerKeySliderMapping)
+		}
+
+		name := fmt.Sprintf("Layer %d", layerIdx)
+		if rawName, ok := fields[layerKeyName]; ok {
+			if name, ok = rawName.(string); !ok || name == "" {
+				return nil, fmt.Errorf("layer %d: invalid %s '%v'", layerIdx, layerKeyName, rawName)
+			}
+		}
+
+		mapping, err := sliderMapFromValue(fields[layerKeySliderMapping])
+		if err != nil {
+			return nil, fmt.Errorf("layer %d (%s): %s: %w", layerIdx, name, layerKeySliderMapping, err)
+		}
+
+		// sliders that the layer doesn't map fall back to the base mapping
+		base.iterate(func(sliderIdx int, targets []string) {
+			if _, ok := mapping.m[sliderIdx]; !ok {
+				mapping.m[sliderIdx] = targets
+			}
+		})
+
+		layers = append(layers, sliderLayer{Name: name, Mapping: mapping})
+	}
+
+	return layers, nil
+}
+
+// sliderMapFromValue reads a mapping nested in a list, such as a layer's slider_mapping,
+// where every index has a target or a list of them
+func sliderMapFromValue(value interface{}) (*sliderMap, error) {
+	rawMapping, ok := stringKeyedMap(value)
+	if !ok {
+		return nil, errors.New("expected indexes mapped to targets")
+	}
+
+	mapping := map[string][]string{}
+
+	for idxString, rawTargets := range rawMapping {
+		if idx, err := strconv.Atoi(idxString); err != nil || idx < 0 {
+			return nil, fmt.Errorf("invalid index '%s'", idxString)
+		}
+
+		switch targets := rawTargets.(type) {
+		case string:
+			mapping[idxString] = []string{targets}
+		case []interface{}:
+			for _, rawTarget := range targets {
+				target, ok := rawTarget.(string)
+				if !ok {
+					return nil, fmt.Errorf("%s: invalid target '%v'", idxString, rawTarget)
+				}
+
+				mapping[idxString] = append(mapping[idxString], target)
+			}
+		default:
+			return nil, fmt.Errorf("%s: invalid targets '%v'", idxString, rawTargets)
+		}
+	}
+
+	return sliderMapFromConfigs(mapping, nil), nil
+}
+
+// stringKeyedMap converts a map as yaml hands it over, which is keyed by interface{} inside of lists
+func stringKeyedMap(value interface{}) (map[string]interface{}, bool) {
+	switch m := value.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(m))
+		for key, value := range m {
+			result[fmt.Sprint(key)] = value
+		}
+
+		return result, true
+	}
+
+	return nil, false
+}
+
+// sliderMapping returns the slider mapping of the given layer, or the base mapping if there's no such layer
+func (cc *CanonicalConfig) sliderMapping(layer int) *sliderMap {
+	if layer < 0 || layer >= len(cc.SliderLayers) {
+		return cc.SliderMapping
+	}
+
+	return cc.SliderLayers[layer].Mapping
+}
+
+// NumLayers returns the number of slider layers, which is 1 when slider_layers isn't used
+func (cc *CanonicalConfig) NumLayers() int {
+	if len(cc.SliderLayers) == 0 {
+		return 1
+	}
+
+	return len(cc.SliderLayers)
+}
+
+// layerName returns the name of the given layer, for menus and logs
+func (cc *CanonicalConfig) layerName(layer int) string {
+	if layer < 0 || layer >= len(cc.SliderLayers) {
+		return defaultLayerName
+	}
+
+	return cc.SliderLayers[layer].Name
+}
+
+// layerNames returns the name of every layer, in order
+func (cc *CanonicalConfig) layerNames() []string {
+	names := make([]string, cc.NumLayers())
+	for layer := range names {
+		names[layer] = cc.layerName(layer)
+	}
+
+	return names
+}
+
+// numMappedSliders returns the number of sliders in the layer that maps the most of them
+func (cc *CanonicalConfig) numMappedSliders() int {
+	numSliders := cc.SliderMapping.NumSliders()
+
+	for _, layer := range cc.SliderLayers {
+		if layerSliders := layer.Mapping.NumSliders(); layerSliders > numSliders {
+			numSliders = layerSliders
+		}
+	}
+
+	return numSliders
+}
+
+// ActiveLayer returns the index of the slider layer that sliders currently control
+func (d *Deej) ActiveLayer() int {
+	d.layerLock.Lock()
+	defer d.layerLock.Unlock()
+
+	// a config reload can leave fewer layers than there were
+	if d.activeLayer >= d.config.NumLayers() {
+		return 0
+	}
+
+	return d.activeLayer
+}
+
+// SwitchLayer makes sliders control the targets of the given layer
+func (d *Deej) SwitchLayer(layer int) error {
+	if layer < 0 || layer >= d.config.NumLayers() {
+		return fmt.Errorf("no such layer: %d", layer)
+	}
+
+	d.layerLock.Lock()
+	changed := d.activeLayer != layer
+	d.activeLayer = layer
+	consumers := d.layerConsumers
+	d.layerLock.Unlock()
+
+	if !changed {
+		return nil
+	}
+
+	d.logger.Infow("Switched slider layer", "layer", layer, "name", d.config.layerName(layer))
+
+	for _, consumer := range consumers {
+		consumer <- layer
+	}
+
+	return nil
+}
+
+// NextLayer switches to the layer after the active one, going back to the first after the last
+func (d *Deej) NextLayer() {
+	if err := d.SwitchLayer((d.ActiveLayer() + 1) % d.config.NumLayers()); err != nil {
+		d.logger.Warnw("Failed to switch to the next layer", "error", err)
+	}
+}
+
+// SubscribeToLayerChanges allows external components to receive the active layer whenever it changes
+func (d *Deej) SubscribeToLayerChanges() chan int {
+	d.layerLock.Lock()
+	defer d.layerLock.Unlock()
+
+	c := make(chan int)
+	d.layerConsumers = append(d.layerConsumers, c)
+
+	return c
+}
@@ -0,0 +1,230 @@
+package deej
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const testLayersConfig = `
+slider_mapping:
+  0: master
+  1: spotify.exe
+  2: discord.exe
+slider_layers:
+  - name: games
+    slider_mapping:
+      1: game.exe
+      2: [voice.exe, mic]
+  - slider_mapping:
+      0: firefox.exe
+`
+
+// TestSliderLayersConfig tests reading named layers, which fall back to slider_mapping for sliders they don't map
+func TestSliderLayersConfig(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, testLayersConfig)
+	defer cleanup()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if names := strings.Join(deej.config.layerNames(), ","); names != "default,games,Layer 2" {
+		t.Errorf("Expected layers 'default,games,Layer 2', got '%s'", names)
+	}
+
+	for _, test := range []struct {
+		layer     int
+		sliderIdx int
+		expected  string
+	}{
+		{0, 1, "spotify.exe"},
+		{1, 0, "master"},
+		{1, 1, "game.exe"},
+		{1, 2, "voice.exe,mic"},
+		{2, 0, "firefox.exe"},
+		{2, 2, "discord.exe"},
+		{7, 1, "spotify.exe"},
+	} {
+		targets, _ := deej.config.sliderMapping(test.layer).get(test.sliderIdx)
+		if actual := strings.Join(targets, ","); actual != test.expected {
+			t.Errorf("Expected slider %d in layer %d to control '%s', got '%s'", test.sliderIdx, test.layer, test.expected, actual)
+		}
+	}
+
+	for _, layers := range []string{
+		"slider_layers: games",
+		"slider_layers:\n  - games",
+		"slider_layers:\n  - name: games",
+		"slider_layers:\n  - name: [games]\n    slider_mapping:\n      0: game.exe",
+		"slider_layers:\n  - slider_mapping:\n      first: game.exe",
+		"slider_layers:\n  - slider_mapping:\n      0: [game.exe, [mic]]",
+	} {
+		cleanup := createTestConfig(t, "slider_mapping:\n  0: master\n"+layers+"\n")
+
+		if err := deej.config.Load(); err == nil {
+			t.Errorf("Expected an error for '%s', got %v", layers, deej.config.layerNames())
+		}
+
+		cleanup()
+	}
+}
+
+// TestSwitchLayer tests that slider moves control the active layer's targets, and that sessions mapped
+// in any layer aren't treated as unmapped
+func TestSwitchLayer(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	spotify := newFakeSession(logger, "spotify.exe")
+	game := newFakeSession(logger, "game.exe")
+	firefox := newFakeSession(logger, "firefox.exe")
+	chrome := newFakeSession(logger, "chrome.exe")
+
+	sf := &fakeSessionFinder{sessions: []Session{spotify, game, firefox, chrome}}
+	m := newTestSessionMap(t, testLayersConfig+"  - slider_mapping:\n      1: deej.unmapped\n", sf)
+
+	m.handleSliderMoveEvent(SliderMoveEvent{SliderID: 1, PercentValue: 0.3})
+
+	if spotify.volume != 0.3 || game.volume == 0.3 {
+		t.Errorf("Expected only spotify to follow the default layer, got %.2f and %.2f", spotify.volume, game.volume)
+	}
+
+	if err := m.deej.SwitchLayer(1); err != nil {
+		t.Fatalf("Failed to switch layer: %v", err)
+	}
+
+	m.handleSliderMoveEvent(SliderMoveEvent{SliderID: 1, PercentValue: 0.6})
+
+	if spotify.volume != 0.3 || game.volume != 0.6 {
+		t.Errorf("Expected only the game to follow the games layer, got %.2f and %.2f", spotify.volume, game.volume)
+	}
+
+	if err := m.deej.SwitchLayer(3); err != nil {
+		t.Fatalf("Failed to switch layer: %v", err)
+	}
+
+	m.handleSliderMoveEvent(SliderMoveEvent{SliderID: 1, PercentValue: 0.8})
+
+	if chrome.volume != 0.8 || firefox.volume == 0.8 || game.volume == 0.8 {
+		t.Errorf("Expected only chrome to be unmapped, got chrome at %.2f, firefox at %.2f and the game at %.2f",
+			chrome.volume, firefox.volume, game.volume)
+	}
+
+	if err := m.deej.SwitchLayer(4); err == nil {
+		t.Error("Expected an error for a layer that doesn't exist")
+	}
+
+	if layer := m.deej.ActiveLayer(); layer != 3 {
+		t.Errorf("Expected layer 3 to stay active, got %d", layer)
+	}
+
+	m.deej.NextLayer()
+
+	if layer := m.deej.ActiveLayer(); layer != 0 {
+		t.Errorf("Expected the next layer to wrap around to 0, got %d", layer)
+	}
+}
+
+// TestLayerCommand tests switching layers from the controller, and pushing layers switched elsewhere to it
+func TestLayerCommand(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, testLayersConfig)
+	defer cleanup()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	sio, err := NewSerialIO(deej, logger)
+	if err != nil {
+		t.Fatalf("Failed to create SerialIO: %v", err)
+	}
+
+	mockConn := &mockSerialConnection{writeBuffer: []string{}}
+	sio.conn = mockConn
+	sio.connected = true
+
+	getState := func() ControllerState {
+		return ControllerState{OutputDevice: -1, Layer: deej.ActiveLayer()}
+	}
+
+	// lines written since the last call
+	written := func() string {
+		lines := []string{}
+		for _, line := range mockConn.writeBuffer {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+
+		mockConn.writeBuffer = []string{}
+		return strings.Join(lines, ",")
+	}
+
+	for _, test := range []struct {
+		line     string
+		expected string
+		layer    int
+	}{
+		{"Layer|2", "OK", 2},
+		{"Layer|3", "ERROR", 2},
+		{"Layer|x", "ERROR", 2},
+		{"Layer", "ERROR", 2},
+		{"Layer|0", "OK", 0},
+	} {
+		sio.handleLine(test.line)
+
+		if reply := written(); reply != test.expected || deej.ActiveLayer() != test.layer {
+			t.Errorf("%s: expected '%s' and layer %d, got '%s' and layer %d",
+				test.line, test.expected, test.layer, reply, deej.ActiveLayer())
+		}
+	}
+
+	// protocol v2 firmware doesn't know about layers
+	sio.handleLine("Hello|2|3|0|0|1.2.0")
+	written()
+
+	sio.pushState(getState)
+
+	if lines := written(); lines != "OutputDevice|-1" {
+		t.Errorf("Expected no layer pushed to a protocol v2 controller, got '%s'", lines)
+	}
+
+	sio.handleLine("Hello|3|3|0|0|1.3.0")
+	written()
+
+	sio.boardState = nil
+	sio.lastStatePush = time.Time{}
+	sio.pushState(getState)
+
+	if lines := written(); lines != "OutputDevice|-1,Layer|0" {
+		t.Errorf("Expected the layer in the full state, got '%s'", lines)
+	}
+
+	// a layer switched from the controller is already on its LEDs
+	sio.handleLine("Layer|1")
+	written()
+
+	sio.lastStatePush = time.Time{}
+	sio.pushState(getState)
+
+	if lines := written(); lines != "" {
+		t.Errorf("Expected no push for the controller's own layer, got '%s'", lines)
+	}
+
+	if err := deej.SwitchLayer(2); err != nil {
+		t.Fatalf("Failed to switch layer: %v", err)
+	}
+
+	sio.lastStatePush = time.Time{}
+	sio.pushState(getState)
+
+	if lines := written(); lines != "Layer|2" {
+		t.Errorf("Expected the layer switched elsewhere to be pushed, got '%s'", lines)
+	}
+}
@@ -0,0 +1,61 @@
+package deej
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/viper"
+)
+
+// sliders in pickup mode (soft takeover) don't snap their sessions to the slider's position. instead, a slider
+// only takes over a session once the volume it asks for reaches or crosses the session's current volume.
+// this keeps volumes in place when deej starts, when an app opens, and after a volume was changed outside deej
+const (
+
+	// a session counts as reached when it's this close to the slider, which also covers the rounding
+	// of volumes by the audio APIs
+	pickupTolerance = 0.015
+)
+
+// slidersInPickupFromConfig reads which sliders are in pickup mode from the user config
+func slidersInPickupFromConfig(userConfig *viper.Viper) (map[int]bool, error) {
+	pickup := map[int]bool{}
+
+	for sliderIdxString := range userConfig.GetStringMap(configKeySliderPickup) {
+		sliderIdx, err := strconv.Atoi(sliderIdxString)
+		if err != nil || sliderIdx < 0 {
+			return nil, fmt.Errorf("invalid slider index '%s'", sliderIdxString)
+		}
+
+		pickup[sliderIdx] = userConfig.GetBool(configKeySliderPickup + "." + sliderIdxString)
+	}
+
+	return pickup, nil
+}
+
+// pickedUp returns true if a slider that moved from previous to volume controls a session that's at current.
+// previous is negative when the slider hasn't moved yet
+func pickedUp(previous float32, volume float32, current float32) bool {
+	if absDistance(volume, current) <= pickupTolerance {
+		return true
+	}
+
+	if previous < 0 {
+		return false
+	}
+
+	// the slider was already there, so it's either been controlling the session or just reached it
+	if absDistance(previous, current) <= pickupTolerance {
+		return true
+	}
+
+	return (previous < current) != (volume < current)
+}
+
+func absDistance(a float32, b float32) float32 {
+	if a > b {
+		return a - b
+	}
+
+	return b - a
+}
@@ -0,0 +1,150 @@
+package deej
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// TestPickedUp tests when a moving slider reaches a session's volume
+func TestPickedUp(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous float32
+		volume   float32
+		current  float32
+		expected bool
+	}{
+		{"first move, far away", -1, 0.2, 0.6, false},
+		{"first move, close enough", -1, 0.59, 0.6, true},
+		{"still below", 0.2, 0.4, 0.6, false},
+		{"still above", 0.9, 0.7, 0.6, false},
+		{"crossed going up", 0.5, 0.7, 0.6, true},
+		{"crossed going down", 0.7, 0.5, 0.6, true},
+		{"landed on it", 0.4, 0.6, 0.6, true},
+		{"already controlling it", 0.6, 0.8, 0.6, true},
+		{"controlling it, despite rounding", 0.6, 0.8, 0.5999, true},
+		{"changed outside deej", 0.6, 0.7, 0.9, false},
+	}
+
+	for _, test := range tests {
+		if actual := pickedUp(test.previous, test.volume, test.current); actual != test.expected {
+			t.Errorf("%s: expected %v for %.2f -> %.2f with the session at %.2f, got %v",
+				test.name, test.expected, test.previous, test.volume, test.current, actual)
+		}
+	}
+}
+
+// TestSliderPickup tests that sliders in pickup mode only take over sessions once they reach their volume,
+// while other sliders snap their sessions to them
+func TestSliderPickup(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	spotify := newFakeSession(logger, "spotify.exe")
+	spotify.volume = 0.6
+	discord := newFakeSession(logger, "discord.exe")
+	discord.volume = 0.6
+
+	sf := &fakeSessionFinder{sessions: []Session{spotify, discord}}
+	m := newTestSessionMap(t, `
+slider_mapping:
+  0: spotify.exe
+  1: discord.exe
+slider_pickup:
+  0: true
+`, sf)
+
+	moveTo := func(sliderIdx int, value float32) {
+		m.handleSliderMoveEvent(SliderMoveEvent{SliderID: sliderIdx, PercentValue: value})
+	}
+
+	moveTo(0, 0.2)
+	moveTo(1, 0.2)
+
+	if spotify.volume != 0.6 {
+		t.Errorf("Expected spotify to stay at 0.6 until it's picked up, got %.2f", spotify.volume)
+	}
+
+	if discord.volume != 0.2 {
+		t.Errorf("Expected discord to snap to 0.2 without pickup mode, got %.2f", discord.volume)
+	}
+
+	moveTo(0, 0.4)
+
+	if spotify.volume != 0.6 {
+		t.Errorf("Expected spotify to stay at 0.6 before the slider gets there, got %.2f", spotify.volume)
+	}
+
+	moveTo(0, 0.7)
+
+	if spotify.volume != 0.7 {
+		t.Errorf("Expected spotify to be picked up at 0.7, got %.2f", spotify.volume)
+	}
+
+	moveTo(0, 0.5)
+
+	if spotify.volume != 0.5 {
+		t.Errorf("Expected spotify to follow the slider to 0.5, got %.2f", spotify.volume)
+	}
+
+	// a volume that changed outside deej has to be picked up again
+	spotify.volume = 0.9
+	moveTo(0, 0.45)
+
+	if spotify.volume != 0.9 {
+		t.Errorf("Expected spotify to keep the volume it was given outside deej, got %.2f", spotify.volume)
+	}
+
+	moveTo(0, 0.95)
+
+	if spotify.volume != 0.95 {
+		t.Errorf("Expected spotify to be picked up again at 0.95, got %.2f", spotify.volume)
+	}
+
+	// so does a session that opened since
+	newSpotify := newFakeSession(logger, "spotify.exe")
+	newSpotify.volume = 0.3
+	m.add(newSpotify)
+
+	moveTo(0, 0.9)
+
+	if spotify.volume != 0.9 || newSpotify.volume != 0.3 {
+		t.Errorf("Expected only the picked up session to follow the slider, got %.2f and %.2f",
+			spotify.volume, newSpotify.volume)
+	}
+}
+
+// TestSliderPickupConfig tests reading which sliders are in pickup mode from the config
+func TestSliderPickupConfig(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, `
+slider_mapping:
+  0: master
+slider_pickup:
+  0: true
+  2: false
+`)
+	defer cleanup()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	for sliderIdx, expected := range []bool{true, false, false} {
+		if actual := deej.config.SlidersInPickup[sliderIdx]; actual != expected {
+			t.Errorf("Expected slider %d in pickup mode: %v, got %v", sliderIdx, expected, actual)
+		}
+	}
+
+	cleanupInvalid := createTestConfig(t, `
+slider_pickup:
+  first: true
+`)
+	defer cleanupInvalid()
+
+	if err := deej.config.Load(); err == nil {
+		t.Error("Expected an error for an invalid slider index")
+	}
+}
@@ -0,0 +1,263 @@
+package deej
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.bug.st/serial"
+)
+
+// Transport provides the connection that deej's line protocol runs over, i.e. a serial port or a network socket
+type Transport interface {
+
+	// Open establishes a new connection. the caller closes it once it fails or deej stops
+	Open() (io.ReadWriteCloser, error)
+
+	// String describes the transport's endpoint for logs and notifications
+	String() string
+}
+
+const (
+	transportTypeSerial    = "serial"
+	transportTypeTCP       = "tcp"
+	transportTypeUDP       = "udp"
+	transportTypeWebSocket = "websocket"
+
+	dialTimeout = 5 * time.Second
+
+	// larger than any line the firmware sends, which keeps datagrams and messages from being truncated
+	maxPacketSize = 2048
+)
+
+// connectionConfig holds every config value that decides which transport to use and how to open it
+type connectionConfig struct {
+	transportType string
+	address       string
+	comPort       string
+	baudRate      uint
+}
+
+func connectionConfigFrom(config *CanonicalConfig) connectionConfig {
+	return connectionConfig{
+		transportType: strings.ToLower(config.ConnectionInfo.Type),
+		address:       config.ConnectionInfo.Address,
+		comPort:       config.SerialConnectionInfo.COMPort,
+		baudRate:      config.SerialConnectionInfo.BaudRate,
+	}
+}
+
+// newTransport creates the transport selected by the given connection config
+func newTransport(cc connectionConfig) (Transport, error) {
+	var transport Transport
+
+	switch cc.transportType {
+	case "", transportTypeSerial:
+		return newSerialTransport(cc.comPort, cc.baudRate), nil
+	case transportTypeTCP:
+		transport = &tcpTransport{address: cc.address}
+	case transportTypeUDP:
+		transport = &udpTransport{address: cc.address}
+	case transportTypeWebSocket:
+		transport = &websocketTransport{url: cc.address}
+	default:
+		return nil, fmt.Errorf("unknown connection type: %s", cc.transportType)
+	}
+
+	// unlike serial ports, network endpoints can't be auto-detected
+	if cc.address == "" {
+		return nil, fmt.Errorf("%s connection requires an address", cc.transportType)
+	}
+
+	return transport, nil
+}
+
+// serialTransport connects to the controller over a USB serial port
+type serialTransport struct {
+	port string
+	mode *serial.Mode
+}
+
+func newSerialTransport(port string, baudRate uint) *serialTransport {
+	return &serialTransport{
+		port: port,
+		mode: &serial.Mode{
+			BaudRate: int(baudRate),
+			DataBits: 8,
+			StopBits: serial.OneStopBit,
+			Parity:   serial.NoParity,
+		},
+	}
+}
+
+func (t *serialTransport) Open() (io.ReadWriteCloser, error) {
+	conn, err := serial.Open(t.port, t.mode)
+	if err != nil {
+		return nil, fmt.Errorf("open serial port: %w", err)
+	}
+
+	return conn, nil
+}
+
+// autoDetect reports whether the port should be found by scanning for a controller
+func (t *serialTransport) autoDetect() bool {
+	return t.port == "" || t.port == "auto"
+}
+
+func (t *serialTransport) String() string {
+	return fmt.Sprintf("serial port %s", t.port)
+}
+
+// tcpTransport connects to a controller that listens on a TCP socket, i.e. an ESP32 over Wi-Fi
+type tcpTransport struct {
+	address string
+}
+
+func (t *tcpTransport) Open() (io.ReadWriteCloser, error) {
+	conn, err := net.DialTimeout("tcp", t.address, dialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("dial tcp: %w", err)
+	}
+
+	return conn, nil
+}
+
+func (t *tcpTransport) String() string {
+	return fmt.Sprintf("tcp://%s", t.address)
+}
+
+// udpTransport listens for datagrams from the controller, and replies to whoever sent the last one
+type udpTransport struct {
+	address string
+}
+
+func (t *udpTransport) Open() (io.ReadWriteCloser, error) {
+	conn, err := net.ListenPacket("udp", t.address)
+	if err != nil {
+		return nil, fmt.Errorf("listen udp: %w", err)
+	}
+
+	return &udpConn{PacketConn: conn}, nil
+}
+
+func (t *udpTransport) String() string {
+	return fmt.Sprintf("udp://%s", t.address)
+}
+
+// udpConn turns a packet listener into a stream of lines, one or more per datagram
+type udpConn struct {
+	net.PacketConn
+
+	lock    sync.Mutex
+	remote  net.Addr
+	pending []byte
+}
+
+func (c *udpConn) Read(p []byte) (int, error) {
+	if len(c.pending) == 0 {
+		buf := make([]byte, maxPacketSize)
+
+		n, remote, err := c.ReadFrom(buf)
+		if err != nil {
+			return 0, err
+		}
+
+		c.lock.Lock()
+		c.remote = remote
+		c.lock.Unlock()
+
+		c.pending = terminateLine(buf[:n])
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+
+	return n, nil
+}
+
+func (c *udpConn) Write(p []byte) (int, error) {
+	c.lock.Lock()
+	remote := c.remote
+	c.lock.Unlock()
+
+	if remote == nil {
+		return 0, errors.New("no datagram received yet, nobody to reply to")
+	}
+
+	return c.WriteTo(p, remote)
+}
+
+// websocketTransport connects to a controller that runs a WebSocket server, with one line per message
+type websocketTransport struct {
+	url string
+}
+
+func (t *websocketTransport) Open() (io.ReadWriteCloser, error) {
+	dialer := websocket.Dialer{HandshakeTimeout: dialTimeout}
+
+	conn, _, err := dialer.Dial(t.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("dial websocket: %w", err)
+	}
+
+	return &websocketConn{conn: conn}, nil
+}
+
+func (t *websocketTransport) String() string {
+	return t.url
+}
+
+// websocketConn turns WebSocket messages into a stream of lines and back
+type websocketConn struct {
+	conn *websocket.Conn
+
+	// gorilla/websocket supports one concurrent writer at most
+	writeLock sync.Mutex
+	pending   []byte
+}
+
+func (c *websocketConn) Read(p []byte) (int, error) {
+	if len(c.pending) == 0 {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+
+		c.pending = terminateLine(message)
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+
+	return n, nil
+}
+
+func (c *websocketConn) Write(p []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	if err := c.conn.WriteMessage(websocket.TextMessage, bytes.TrimRight(p, "\n")); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (c *websocketConn) Close() error {
+	return c.conn.Close()
+}
+
+// terminateLine makes sure a packet ends with a newline, as firmware usually leaves it out of datagrams and messages
+func terminateLine(packet []byte) []byte {
+	if len(packet) == 0 || packet[len(packet)-1] != '\n' {
+		packet = append(packet, '\n')
+	}
+
+	return packet
+}
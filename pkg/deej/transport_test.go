@@ -0,0 +1,478 @@
+package deej
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// pipeTransport hands out one end of an in-memory pipe per connection, and the other end to the test
+type pipeTransport struct {
+	peers chan net.Conn
+}
+
+func newPipeTransport() *pipeTransport {
+	return &pipeTransport{peers: make(chan net.Conn, 1)}
+}
+
+func (t *pipeTransport) Open() (io.ReadWriteCloser, error) {
+	local, peer := net.Pipe()
+	t.peers <- peer
+
+	return local, nil
+}
+
+func (t *pipeTransport) String() string {
+	return "pipe"
+}
+
+// readLineWithTimeout reads a single line from the controller's side of a connection
+func readLineWithTimeout(t *testing.T, reader *bufio.Reader) string {
+	lines := make(chan string, 1)
+
+	go func() {
+		line, _ := reader.ReadString('\n')
+		lines <- strings.TrimSpace(line)
+	}()
+
+	select {
+	case line := <-lines:
+		return line
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for a line")
+		return ""
+	}
+}
+
+// TestNewTransport tests that the connection config selects the matching transport
+func TestNewTransport(t *testing.T) {
+	tests := []struct {
+		config   connectionConfig
+		expected string
+		fails    bool
+	}{
+		{connectionConfig{comPort: "COM4", baudRate: 115200}, "serial port COM4", false},
+		{connectionConfig{transportType: "serial", comPort: "/dev/ttyUSB0"}, "serial port /dev/ttyUSB0", false},
+		{connectionConfig{transportType: "tcp", address: "deej.local:5000"}, "tcp://deej.local:5000", false},
+		{connectionConfig{transportType: "udp", address: ":5000"}, "udp://:5000", false},
+		{connectionConfig{transportType: "websocket", address: "ws://deej.local:81/"}, "ws://deej.local:81/", false},
+		{connectionConfig{transportType: "tcp"}, "", true},
+		{connectionConfig{transportType: "bluetooth", address: "deej"}, "", true},
+	}
+
+	for _, test := range tests {
+		transport, err := newTransport(test.config)
+
+		if test.fails {
+			if err == nil {
+				t.Errorf("Expected an error for %+v, got %s", test.config, transport)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Failed to create transport for %+v: %v", test.config, err)
+			continue
+		}
+
+		if transport.String() != test.expected {
+			t.Errorf("Expected transport '%s', got '%s'", test.expected, transport)
+		}
+	}
+}
+
+// TestConnectionConfig tests that the connection section is loaded and defaults to serial
+func TestConnectionConfig(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, `
+slider_mapping:
+  0: master
+`)
+	defer cleanup()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if deej.config.ConnectionInfo.Type != "serial" {
+		t.Errorf("Expected connection type to default to 'serial', got '%s'", deej.config.ConnectionInfo.Type)
+	}
+
+	createTestConfig(t, `
+slider_mapping:
+  0: master
+connection:
+  type: UDP
+  address: ":5000"
+`)
+
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to reload config: %v", err)
+	}
+
+	cc := connectionConfigFrom(deej.config)
+	if cc.transportType != "udp" || cc.address != ":5000" {
+		t.Errorf("Expected a udp connection on ':5000', got %+v", cc)
+	}
+}
+
+// TestLineProtocolOverPipe tests that the line protocol runs over any transport, and survives reconnection
+func TestLineProtocolOverPipe(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, `
+slider_mapping:
+  0: master
+  1: chrome
+mute_button_mapping:
+  0: master
+`)
+	defer cleanup()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	sio, err := NewSerialIO(deej, logger)
+	if err != nil {
+		t.Fatalf("Failed to create SerialIO: %v", err)
+	}
+
+	pipes := newPipeTransport()
+	sio.transport = pipes
+
+	eventChan := sio.SubscribeToSliderMoveEvents()
+
+	var muteEvents []MuteButtonClickEvent
+	sio.setMuteButtonClickEventConsumer(func(events []MuteButtonClickEvent) (MuteButtonsState, error) {
+		muteEvents = append(muteEvents, events...)
+		return MuteButtonsState{MuteButtons: []bool{true}}, nil
+	})
+
+	// pipes are synchronous, so the board has to be listening while deej connects and says hello
+	started := make(chan error, 1)
+	go func() {
+		started <- sio.Start()
+	}()
+
+	board := <-pipes.peers
+	reader := bufio.NewReader(board)
+
+	if line := readLineWithTimeout(t, reader); line != "Connected" {
+		t.Fatalf("Expected 'Connected' on connect, got '%s'", line)
+	}
+
+	if err := <-started; err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	defer sio.Stop()
+
+	go board.Write([]byte("Sliders|4095|0\n"))
+
+	for expected := 0; expected < 2; expected++ {
+		select {
+		case event := <-eventChan:
+			if event.SliderID != expected {
+				t.Errorf("Expected an event for slider %d, got %d", expected, event.SliderID)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for slider %d", expected)
+		}
+	}
+
+	if line := readLineWithTimeout(t, reader); line != "OK" {
+		t.Errorf("Expected 'OK' for sliders, got '%s'", line)
+	}
+
+	go board.Write([]byte("MuteButton|0|true\n"))
+
+	if line := readLineWithTimeout(t, reader); line != "OK" {
+		t.Errorf("Expected 'OK' for a mute button, got '%s'", line)
+	}
+
+	if len(muteEvents) != 1 || !muteEvents[0].mute {
+		t.Errorf("Expected a single mute event, got %+v", muteEvents)
+	}
+
+	// the board going away should result in a new connection
+	board.Close()
+
+	select {
+	case board = <-pipes.peers:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for a reconnection")
+	}
+
+	if line := readLineWithTimeout(t, bufio.NewReader(board)); line != "Connected" {
+		t.Errorf("Expected 'Connected' after reconnecting, got '%s'", line)
+	}
+}
+
+// TestWritesDuringReconnect tests that lines can be pushed from other goroutines while the read loop reconnects
+func TestWritesDuringReconnect(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, "slider_mapping:\n  0: master\n")
+	defer cleanup()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	sio, err := NewSerialIO(deej, logger)
+	if err != nil {
+		t.Fatalf("Failed to create SerialIO: %v", err)
+	}
+
+	pipes := newPipeTransport()
+	sio.transport = pipes
+
+	started := make(chan error, 1)
+	go func() {
+		started <- sio.Start()
+	}()
+
+	board, _ := connectTestController(t, pipes)
+
+	if err := <-started; err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	defer sio.Stop()
+
+	go io.Copy(ioutil.Discard, board)
+
+	stopPushing := make(chan bool)
+	pushed := make(chan bool)
+
+	go func() {
+		defer close(pushed)
+
+		for {
+			select {
+			case <-stopPushing:
+				return
+			default:
+				sio.sendPush("Layer|0")
+			}
+		}
+	}()
+
+	// the board going away has the read loop replace the connection that's being written to
+	board.Close()
+
+	select {
+	case board = <-pipes.peers:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for a reconnection")
+	}
+
+	go io.Copy(ioutil.Discard, board)
+
+	close(stopPushing)
+	<-pushed
+}
+
+// unpluggableTransport is a pipeTransport that can't be opened while it's unplugged
+type unpluggableTransport struct {
+	*pipeTransport
+
+	unplugged bool
+	lock      sync.Mutex
+}
+
+func (t *unpluggableTransport) setUnplugged(unplugged bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.unplugged = unplugged
+}
+
+func (t *unpluggableTransport) Open() (io.ReadWriteCloser, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.unplugged {
+		return nil, errors.New("unplugged")
+	}
+
+	return t.pipeTransport.Open()
+}
+
+// TestReconnectWhileUnplugged tests that a controller that stays away is only reported as disconnected once
+func TestReconnectWhileUnplugged(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, "slider_mapping:\n  0: master\n")
+	defer cleanup()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	sio, err := NewSerialIO(deej, logger)
+	if err != nil {
+		t.Fatalf("Failed to create SerialIO: %v", err)
+	}
+
+	pipes := newPipeTransport()
+	transport := &unpluggableTransport{pipeTransport: pipes}
+	sio.transport = transport
+
+	connections := sio.SubscribeToConnectionEvents()
+
+	expectConnection := func(connected bool) {
+		t.Helper()
+
+		select {
+		case event := <-connections:
+			if event.Connected != connected {
+				t.Fatalf("Expected a connection event with connected %v, got %+v", connected, event)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for a connection event with connected %v", connected)
+		}
+	}
+
+	started := make(chan error, 1)
+	go func() {
+		started <- sio.Start()
+	}()
+
+	expectConnection(true)
+	board, _ := connectTestController(t, pipes)
+
+	if err := <-started; err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+
+	transport.setUnplugged(true)
+	board.Close()
+
+	expectConnection(false)
+
+	// a few failed reconnects later, nothing else was reported
+	select {
+	case event := <-connections:
+		t.Fatalf("Expected no more connection events while unplugged, got %+v", event)
+	case <-time.After(4 * reconnectDelay):
+	}
+
+	transport.setUnplugged(false)
+
+	expectConnection(true)
+	connectTestController(t, pipes)
+
+	go func() {
+		for range connections {
+		}
+	}()
+
+	sio.Stop()
+}
+
+// TestUDPTransport tests that datagrams become lines, and that replies go back to their sender
+func TestUDPTransport(t *testing.T) {
+	transport := &udpTransport{address: "127.0.0.1:0"}
+
+	conn, err := transport.Open()
+	if err != nil {
+		t.Fatalf("Failed to open udp transport: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("OK\n")); err == nil {
+		t.Error("Expected an error replying before any datagram arrived")
+	}
+
+	board, err := net.Dial("udp", conn.(*udpConn).LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial udp transport: %v", err)
+	}
+	defer board.Close()
+
+	// firmware usually leaves the newline out of datagrams
+	board.Write([]byte("Sliders|1024|2048"))
+
+	if line := readLineWithTimeout(t, bufio.NewReader(conn)); line != "Sliders|1024|2048" {
+		t.Errorf("Expected the datagram as a line, got '%s'", line)
+	}
+
+	if _, err := conn.Write([]byte("OK\n")); err != nil {
+		t.Fatalf("Failed to reply: %v", err)
+	}
+
+	board.SetReadDeadline(time.Now().Add(time.Second))
+
+	reply := make([]byte, maxPacketSize)
+	n, err := board.Read(reply)
+	if err != nil {
+		t.Fatalf("Failed to read reply: %v", err)
+	}
+
+	if string(reply[:n]) != "OK\n" {
+		t.Errorf("Expected reply 'OK', got '%s'", reply[:n])
+	}
+}
+
+// TestWebSocketTransport tests that messages become lines, and that lines are sent as messages
+func TestWebSocketTransport(t *testing.T) {
+	messages := make(chan string, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		board, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer board.Close()
+
+		board.WriteMessage(websocket.TextMessage, []byte("MuteButton|1|true"))
+
+		_, message, err := board.ReadMessage()
+		if err == nil {
+			messages <- string(message)
+		}
+	}))
+	defer server.Close()
+
+	transport := &websocketTransport{url: "ws" + strings.TrimPrefix(server.URL, "http")}
+
+	conn, err := transport.Open()
+	if err != nil {
+		t.Fatalf("Failed to open websocket transport: %v", err)
+	}
+	defer conn.Close()
+
+	if line := readLineWithTimeout(t, bufio.NewReader(conn)); line != "MuteButton|1|true" {
+		t.Errorf("Expected the message as a line, got '%s'", line)
+	}
+
+	if _, err := conn.Write([]byte("OK\n")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+
+	select {
+	case message := <-messages:
+		if message != "OK" {
+			t.Errorf("Expected message 'OK' without its newline, got '%s'", message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the message")
+	}
+}
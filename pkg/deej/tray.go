@@ -1,7 +1,10 @@
 package deej
 
 import (
+	"fmt"
+
 	"github.com/getlantern/systray"
+	"go.uber.org/zap"
 
 	"github.com/tomerhh/deej/pkg/deej/icon"
 	"github.com/tomerhh/deej/pkg/deej/util"
@@ -23,6 +26,13 @@ func (d *Deej) initializeTray(onDone func()) {
 		refreshSessions := systray.AddMenuItem("Re-scan audio sessions", "Manually refresh audio sessions if something's stuck")
 		refreshSessions.SetIcon(icon.RefreshSessions)
 
+		d.setupLayerMenu(logger)
+		d.setupProfileMenu(logger)
+
+		systray.AddSeparator()
+		controllerInfo := systray.AddMenuItem("Controller: not identified", "What the connected controller reported about itself")
+		controllerInfo.Disable()
+
 		if d.version != "" {
 			systray.AddSeparator()
 			versionInfo := systray.AddMenuItem(d.version, "")
@@ -32,6 +42,17 @@ func (d *Deej) initializeTray(onDone func()) {
 		systray.AddSeparator()
 		quit := systray.AddMenuItem("Quit", "Stop deej and quit")
 
+		// keep the controller's details current, it identifies itself every time it connects
+		if d.deviceInfoController != nil {
+			deviceInfoChannel := d.deviceInfoController.SubscribeToDeviceInfo()
+
+			go func() {
+				for info := range deviceInfoChannel {
+					controllerInfo.SetTitle(fmt.Sprintf("Controller: firmware %s, %d sliders", info.FirmwareVersion, info.NumSliders))
+				}
+			}()
+		}
+
 		// wait on things to happen
 		go func() {
 			for {
@@ -80,6 +101,189 @@ func (d *Deej) initializeTray(onDone func()) {
 	systray.Run(onReady, onExit)
 }
 
+// setupLayerMenu adds a menu to switch slider layers with, which is only shown when there's more than one.
+// menu items can't be removed, so layers that a config reload takes away are hidden instead
+func (d *Deej) setupLayerMenu(logger *zap.SugaredLogger) {
+	layerMenu := systray.AddMenuItem("Layer", "Switch which targets the sliders control")
+	layerItems := []*systray.MenuItem{}
+
+	update := func() {
+		numLayers := d.config.NumLayers()
+		activeLayer := d.ActiveLayer()
+
+		for len(layerItems) < numLayers {
+			layer := len(layerItems)
+			item := layerMenu.AddSubMenuItem("", "")
+			layerItems = append(layerItems, item)
+
+			go func() {
+				for range item.ClickedCh {
+					logger.Infow("Layer menu item clicked, switching layer", "layer", layer)
+
+					if err := d.SwitchLayer(layer); err != nil {
+						logger.Warnw("Failed to switch layer", "error", err)
+					}
+				}
+			}()
+		}
+
+		for layer, item := range layerItems {
+			if layer >= numLayers {
+				item.Hide()
+				continue
+			}
+
+			item.SetTitle(d.config.layerName(layer))
+			item.Show()
+
+			if layer == activeLayer {
+				item.Check()
+			} else {
+				item.Uncheck()
+			}
+		}
+
+		layerMenu.SetTitle(fmt.Sprintf("Layer: %s", d.config.layerName(activeLayer)))
+
+		if numLayers > 1 {
+			layerMenu.Show()
+		} else {
+			layerMenu.Hide()
+		}
+	}
+
+	update()
+
+	layerChannel := d.SubscribeToLayerChanges()
+	configReloadedChannel := d.config.SubscribeToChanges()
+
+	go func() {
+		for {
+			select {
+			case <-layerChannel:
+			case <-configReloadedChannel:
+			}
+
+			update()
+		}
+	}()
+}
+
+// setupProfileMenu adds a menu that shows the active profile, and lets the user pin one (or none) instead of following
+// the focused app. it's only shown when there are profiles, and like layers, profiles that go away are hidden
+func (d *Deej) setupProfileMenu(logger *zap.SugaredLogger) {
+	profileMenu := systray.AddMenuItem("Profile", "Switch mappings with the focused app, or pick them yourself")
+	automatic := profileMenu.AddSubMenuItem("Automatic", "Switch profiles with the focused app")
+	noProfile := profileMenu.AddSubMenuItem("None", "Always use the regular mappings")
+	profileItems := []*systray.MenuItem{}
+
+	go func() {
+		for {
+			select {
+			case <-automatic.ClickedCh:
+				logger.Info("Automatic profile menu item clicked, following the focused app")
+				d.ClearProfileOverride()
+
+			case <-noProfile.ClickedCh:
+				logger.Info("No profile menu item clicked, overriding profile")
+
+				if err := d.OverrideProfile(""); err != nil {
+					logger.Warnw("Failed to override profile", "error", err)
+				}
+			}
+		}
+	}()
+
+	update := func() {
+		profileNames := d.config.profileNames()
+		activeProfile, overridden := d.ActiveProfile()
+
+		for len(profileItems) < len(profileNames) {
+			profileIdx := len(profileItems)
+			item := profileMenu.AddSubMenuItem("", "")
+			profileItems = append(profileItems, item)
+
+			go func() {
+				for range item.ClickedCh {
+
+					// the item's title is whichever profile it stands for since the last config reload
+					profileNames := d.config.profileNames()
+					if profileIdx >= len(profileNames) {
+						continue
+					}
+
+					logger.Infow("Profile menu item clicked, overriding profile", "profile", profileNames[profileIdx])
+
+					if err := d.OverrideProfile(profileNames[profileIdx]); err != nil {
+						logger.Warnw("Failed to override profile", "error", err)
+					}
+				}
+			}()
+		}
+
+		for profileIdx, item := range profileItems {
+			if profileIdx >= len(profileNames) {
+				item.Hide()
+				continue
+			}
+
+			item.SetTitle(profileNames[profileIdx])
+			item.Show()
+
+			if overridden && profileNames[profileIdx] == activeProfile {
+				item.Check()
+			} else {
+				item.Uncheck()
+			}
+		}
+
+		if !overridden {
+			automatic.Check()
+		} else {
+			automatic.Uncheck()
+		}
+
+		if overridden && activeProfile == "" {
+			noProfile.Check()
+		} else {
+			noProfile.Uncheck()
+		}
+
+		title := activeProfile
+		if title == "" {
+			title = "none"
+		}
+
+		if !overridden {
+			title += " (automatic)"
+		}
+
+		profileMenu.SetTitle(fmt.Sprintf("Profile: %s", title))
+
+		if len(profileNames) > 0 {
+			profileMenu.Show()
+		} else {
+			profileMenu.Hide()
+		}
+	}
+
+	update()
+
+	profileChannel := d.SubscribeToProfileChanges()
+	configReloadedChannel := d.config.SubscribeToChanges()
+
+	go func() {
+		for {
+			select {
+			case <-profileChannel:
+			case <-configReloadedChannel:
+			}
+
+			update()
+		}
+	}()
+}
+
 func (d *Deej) stopTray() {
 	d.logger.Debug("Quitting tray")
 	systray.Quit()
@@ -39,7 +39,7 @@ func Linux() bool {
 // SetupCloseHandler creates a 'listener' on a new goroutine which will notify the
 // program if it receives an interrupt from the OS
 func SetupCloseHandler() chan os.Signal {
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
 	return c
@@ -47,7 +47,7 @@ func SetupCloseHandler() chan os.Signal {
 
 // GetCurrentWindowProcessNames returns the process names (including extension, if applicable)
 // of the current foreground window. This includes child processes belonging to the window.
-// This is currently only implemented for Windows
+// This is implemented for Windows and for Linux under X11
 func GetCurrentWindowProcessNames() ([]string, error) {
 	return getCurrentWindowProcessNames()
 }
@@ -81,33 +81,14 @@ func NormalizeScalar(v float32) float32 {
 	return float32(math.Floor(float64(v)*100) / 100.0)
 }
 
-// SignificantlyDifferent returns true if there's a significant enough volume difference between two given values
-func SignificantlyDifferent(old float32, new float32, noiseReductionLevel string) bool {
-
-	const (
-		noiseReductionHigh = "high"
-		noiseReductionLow  = "low"
-	)
-
-	// this threshold is solely responsible for dealing with hardware interference when
-	// sliders are producing noisy values. this value should be a median value between two
-	// round percent values. for instance, 0.025 means volume can move at 3% increments
-	var significantDifferenceThreshold float64
-
-	// choose our noise reduction level based on the config-provided value
-	switch noiseReductionLevel {
-	case noiseReductionHigh:
-		significantDifferenceThreshold = 0.035
-		break
-	case noiseReductionLow:
-		significantDifferenceThreshold = 0.015
-		break
-	default:
-		significantDifferenceThreshold = 0.025
-		break
+// SignificantlyDifferent returns true if two given volumes are at least threshold apart. the threshold is
+// solely responsible for dealing with hardware interference when sliders are producing noisy values
+func SignificantlyDifferent(old float32, new float32, threshold float32) bool {
+	if old == new {
+		return false
 	}
 
-	if math.Abs(float64(old-new)) >= significantDifferenceThreshold {
+	if math.Abs(float64(old-new)) >= float64(threshold) {
 		return true
 	}
 
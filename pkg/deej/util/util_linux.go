@@ -2,8 +2,216 @@ package util
 
 import (
 	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+	"github.com/mitchellh/go-ps"
+)
+
+const (
+	getCurrentWindowInternalCooldown = time.Millisecond * 350
+
+	// EWMH properties that hold the focused window (on the root window) and its owning process (on the window itself)
+	atomNameActiveWindow = "_NET_ACTIVE_WINDOW"
+	atomNameWindowPID    = "_NET_WM_PID"
 )
 
+var errNoActiveWindow = errors.New("no active window")
+
+// windowPropertySource provides the X11 window properties needed to find the process behind the active window
+type windowPropertySource interface {
+	ActiveWindow() (uint32, error)
+	WindowPID(window uint32) (uint32, error)
+}
+
+// activeWindowFinder resolves the active window to the names of the processes that own it
+type activeWindowFinder struct {
+	lock sync.Mutex
+
+	properties   windowPropertySource
+	processByPID func(pid int) (string, error)
+	lastResult   []string
+	lastCall     time.Time
+	cooldown     time.Duration
+}
+
+var currentWindowFinder = &activeWindowFinder{
+	properties:   &x11PropertySource{},
+	processByPID: processNameByPID,
+	cooldown:     getCurrentWindowInternalCooldown,
+}
+
 func getCurrentWindowProcessNames() ([]string, error) {
-	return nil, errors.New("Not implemented")
+	return currentWindowFinder.currentWindowProcessNames()
+}
+
+func (f *activeWindowFinder) currentWindowProcessNames() ([]string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	// apply an internal cooldown to avoid doing X11 round trips too frequently.
+	// return a cached value during that cooldown
+	now := time.Now()
+	if f.lastCall.Add(f.cooldown).After(now) {
+		return f.lastResult, nil
+	}
+
+	f.lastCall = now
+	f.lastResult = nil
+
+	window, err := f.properties.ActiveWindow()
+	if err != nil {
+		if errors.Is(err, errNoActiveWindow) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("get active window: %w", err)
+	}
+
+	pid, err := f.properties.WindowPID(window)
+	if err != nil {
+		return nil, fmt.Errorf("get pid for window %d: %w", window, err)
+	}
+
+	// check for windows that don't advertise their owner
+	if pid == 0 {
+		return nil, nil
+	}
+
+	processName, err := f.processByPID(int(pid))
+	if err != nil {
+		return nil, fmt.Errorf("get process name for pid %d: %w", pid, err)
+	}
+
+	// cache & return the executable name
+	f.lastResult = []string{processName}
+	return f.lastResult, nil
+}
+
+// processNameByPID returns the binary name of the given process. this is the same name PulseAudio
+// reports as a stream's application.process.binary, which /proc/<pid>/comm would truncate to 15 characters
+func processNameByPID(pid int) (string, error) {
+	if exe, err := os.Readlink(filepath.Join("/proc", strconv.Itoa(pid), "exe")); err == nil {
+		return filepath.Base(exe), nil
+	}
+
+	// the executable link isn't readable for other users' processes, fall back to the process table
+	process, err := ps.FindProcess(pid)
+	if err != nil {
+		return "", fmt.Errorf("find process: %w", err)
+	}
+
+	if process == nil {
+		return "", fmt.Errorf("no such process: %d", pid)
+	}
+
+	return process.Executable(), nil
+}
+
+// x11PropertySource reads window properties from the X server named by $DISPLAY,
+// connecting on first use and reconnecting after errors
+type x11PropertySource struct {
+	conn *xgb.Conn
+	root xproto.Window
+
+	activeWindowAtom xproto.Atom
+	windowPIDAtom    xproto.Atom
+}
+
+func (s *x11PropertySource) ActiveWindow() (uint32, error) {
+	if err := s.ensureConnected(); err != nil {
+		return 0, err
+	}
+
+	value, err := s.getCardinalProperty(s.root, s.activeWindowAtom, xproto.AtomWindow)
+	if err != nil {
+		return 0, err
+	}
+
+	// no window has focus (i.e. the desktop)
+	if value == 0 {
+		return 0, errNoActiveWindow
+	}
+
+	return value, nil
+}
+
+func (s *x11PropertySource) WindowPID(window uint32) (uint32, error) {
+	if err := s.ensureConnected(); err != nil {
+		return 0, err
+	}
+
+	return s.getCardinalProperty(xproto.Window(window), s.windowPIDAtom, xproto.AtomCardinal)
+}
+
+func (s *x11PropertySource) ensureConnected() error {
+	if s.conn != nil {
+		return nil
+	}
+
+	if os.Getenv("DISPLAY") == "" {
+		return errors.New("no X11 display available")
+	}
+
+	conn, err := xgb.NewConn()
+	if err != nil {
+		return fmt.Errorf("connect to X11 display: %w", err)
+	}
+
+	activeWindowAtom, err := internAtom(conn, atomNameActiveWindow)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	windowPIDAtom, err := internAtom(conn, atomNameWindowPID)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	s.conn = conn
+	s.root = xproto.Setup(conn).DefaultScreen(conn).Root
+	s.activeWindowAtom = activeWindowAtom
+	s.windowPIDAtom = windowPIDAtom
+
+	return nil
+}
+
+func (s *x11PropertySource) getCardinalProperty(window xproto.Window, atom xproto.Atom, atomType xproto.Atom) (uint32, error) {
+	reply, err := xproto.GetProperty(s.conn, false, window, atom, atomType, 0, 1).Reply()
+	if err != nil {
+
+		// drop the connection so that the next call starts over, i.e. after the X server restarted
+		s.conn.Close()
+		s.conn = nil
+
+		return 0, fmt.Errorf("get window property: %w", err)
+	}
+
+	// the property isn't set on this window
+	if reply.Format != 32 || len(reply.Value) < 4 {
+		return 0, nil
+	}
+
+	return xgb.Get32(reply.Value), nil
+}
+
+func internAtom(conn *xgb.Conn, name string) (xproto.Atom, error) {
+	reply, err := xproto.InternAtom(conn, true, uint16(len(name)), name).Reply()
+	if err != nil {
+		return 0, fmt.Errorf("intern atom %s: %w", name, err)
+	}
+
+	if reply.Atom == xproto.AtomNone {
+		return 0, fmt.Errorf("window manager doesn't support %s", name)
+	}
+
+	return reply.Atom, nil
 }
@@ -0,0 +1,149 @@
+package util
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// fakeWindowPropertySource serves window properties from a fixed window -> PID table
+type fakeWindowPropertySource struct {
+	activeWindow uint32
+	activeErr    error
+	pids         map[uint32]uint32
+
+	calls int
+}
+
+func (s *fakeWindowPropertySource) ActiveWindow() (uint32, error) {
+	s.calls++
+
+	if s.activeErr != nil {
+		return 0, s.activeErr
+	}
+
+	if s.activeWindow == 0 {
+		return 0, errNoActiveWindow
+	}
+
+	return s.activeWindow, nil
+}
+
+func (s *fakeWindowPropertySource) WindowPID(window uint32) (uint32, error) {
+	pid, ok := s.pids[window]
+	if !ok {
+		return 0, errors.New("bad window")
+	}
+
+	return pid, nil
+}
+
+func newTestWindowFinder(properties windowPropertySource, processes map[int]string) *activeWindowFinder {
+	return &activeWindowFinder{
+		properties: properties,
+		processByPID: func(pid int) (string, error) {
+			name, ok := processes[pid]
+			if !ok {
+				return "", errors.New("no such process")
+			}
+
+			return name, nil
+		},
+	}
+}
+
+// TestCurrentWindowProcessNames tests that the active window is resolved to its owning process
+func TestCurrentWindowProcessNames(t *testing.T) {
+	properties := &fakeWindowPropertySource{
+		activeWindow: 0x1a00004,
+		pids:         map[uint32]uint32{0x1a00004: 4242, 0x2c00001: 1337},
+	}
+
+	finder := newTestWindowFinder(properties, map[int]string{4242: "firefox", 1337: "spotify"})
+
+	names, err := finder.currentWindowProcessNames()
+	if err != nil {
+		t.Fatalf("Failed to get current window process names: %v", err)
+	}
+
+	if len(names) != 1 || names[0] != "firefox" {
+		t.Errorf("Expected [firefox], got %v", names)
+	}
+
+	properties.activeWindow = 0x2c00001
+
+	if names, _ = finder.currentWindowProcessNames(); len(names) != 1 || names[0] != "spotify" {
+		t.Errorf("Expected [spotify] after focus change, got %v", names)
+	}
+}
+
+// TestCurrentWindowProcessNamesWithoutWindow tests that no focused window or no advertised PID yields no names
+func TestCurrentWindowProcessNamesWithoutWindow(t *testing.T) {
+	properties := &fakeWindowPropertySource{pids: map[uint32]uint32{0x1a00004: 0}}
+	finder := newTestWindowFinder(properties, nil)
+
+	names, err := finder.currentWindowProcessNames()
+	if err != nil || len(names) != 0 {
+		t.Errorf("Expected no names and no error without an active window, got %v (%v)", names, err)
+	}
+
+	properties.activeWindow = 0x1a00004
+
+	names, err = finder.currentWindowProcessNames()
+	if err != nil || len(names) != 0 {
+		t.Errorf("Expected no names and no error for a window without a PID, got %v (%v)", names, err)
+	}
+}
+
+// TestCurrentWindowProcessNamesErrors tests that display and process lookup failures are reported
+func TestCurrentWindowProcessNamesErrors(t *testing.T) {
+	properties := &fakeWindowPropertySource{activeErr: errors.New("no X11 display available")}
+	finder := newTestWindowFinder(properties, nil)
+
+	if _, err := finder.currentWindowProcessNames(); err == nil {
+		t.Error("Expected an error without a display")
+	}
+
+	properties.activeErr = nil
+	properties.activeWindow = 0x1a00004
+	properties.pids = map[uint32]uint32{0x1a00004: 4242}
+
+	if _, err := finder.currentWindowProcessNames(); err == nil {
+		t.Error("Expected an error for a process that exited")
+	}
+}
+
+// TestCurrentWindowProcessNamesCooldown tests that results are cached for the duration of the cooldown
+func TestCurrentWindowProcessNamesCooldown(t *testing.T) {
+	properties := &fakeWindowPropertySource{
+		activeWindow: 0x1a00004,
+		pids:         map[uint32]uint32{0x1a00004: 4242, 0x2c00001: 1337},
+	}
+
+	finder := newTestWindowFinder(properties, map[int]string{4242: "firefox", 1337: "spotify"})
+	finder.cooldown = time.Hour
+
+	finder.currentWindowProcessNames()
+	properties.activeWindow = 0x2c00001
+
+	names, _ := finder.currentWindowProcessNames()
+	if len(names) != 1 || names[0] != "firefox" {
+		t.Errorf("Expected the cached [firefox] during the cooldown, got %v", names)
+	}
+
+	if properties.calls != 1 {
+		t.Errorf("Expected 1 X11 lookup during the cooldown, got %d", properties.calls)
+	}
+}
+
+// TestX11PropertySourceWithoutDisplay tests that a missing display fails gracefully instead of blocking or panicking
+func TestX11PropertySourceWithoutDisplay(t *testing.T) {
+	display := os.Getenv("DISPLAY")
+	os.Setenv("DISPLAY", "")
+	defer os.Setenv("DISPLAY", display)
+
+	if _, err := (&x11PropertySource{}).ActiveWindow(); err == nil {
+		t.Error("Expected an error without a display")
+	}
+}
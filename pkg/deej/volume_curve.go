@@ -0,0 +1,259 @@
+package deej
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/spf13/viper"
+)
+
+// volume curves shape how a slider's position maps to the volume of its targets. sliders are linear
+// unless configured otherwise, either with one of the named curves or with a list of [position, volume]
+// points that are connected by straight lines (see config.yaml)
+const (
+	curveLinear = "linear"
+	curveLog    = "log"
+	curveExp    = "exp"
+
+	// how much log and exp bend, which makes them each other's inverse
+	curveBase = 10
+)
+
+// curvePoint maps a slider position to a volume, both between 0.0 and 1.0
+type curvePoint struct {
+	Position float32
+	Volume   float32
+}
+
+// volumeCurve is either one of the named curves, or the points of a piecewise linear one
+type volumeCurve struct {
+	name   string
+	points []curvePoint
+}
+
+// sliderCurves holds the volume curve of every slider that doesn't use the linear one
+type sliderCurves struct {
+	curves map[int]volumeCurve
+}
+
+var linearCurve = volumeCurve{name: curveLinear}
+
+// sliderCurvesFromConfig reads the per-slider volume curves from the user config
+func sliderCurvesFromConfig(userConfig *viper.Viper) (*sliderCurves, error) {
+	sc := &sliderCurves{
+		curves: map[int]volumeCurve{},
+	}
+
+	for sliderIdxString, value := range userConfig.GetStringMap(configKeySliderCurve) {
+		sliderIdx, err := strconv.Atoi(sliderIdxString)
+		if err != nil || sliderIdx < 0 {
+			return nil, fmt.Errorf("invalid slider index '%s'", sliderIdxString)
+		}
+
+		curve, err := parseVolumeCurve(value)
+		if err != nil {
+			return nil, fmt.Errorf("slider %d: %w", sliderIdx, err)
+		}
+
+		sc.curves[sliderIdx] = curve
+	}
+
+	return sc, nil
+}
+
+// parseVolumeCurve parses either the name of a curve, or a list of [position, volume] points
+func parseVolumeCurve(value interface{}) (volumeCurve, error) {
+	switch v := value.(type) {
+	case string:
+		switch v {
+		case curveLinear, curveLog, curveExp:
+			return volumeCurve{name: v}, nil
+		}
+
+		return volumeCurve{}, fmt.Errorf("unknown curve '%s', expected %s, %s, %s or a list of points",
+			v, curveLinear, curveLog, curveExp)
+
+	case []interface{}:
+		return parseCurvePoints(v)
+	}
+
+	return volumeCurve{}, fmt.Errorf("invalid curve %v, expected a name or a list of points", value)
+}
+
+func parseCurvePoints(values []interface{}) (volumeCurve, error) {
+	if len(values) < 2 {
+		return volumeCurve{}, fmt.Errorf("a curve needs at least 2 points, got %d", len(values))
+	}
+
+	points := make([]curvePoint, len(values))
+
+	for idx, value := range values {
+		pair, ok := value.([]interface{})
+		if !ok || len(pair) != 2 {
+			return volumeCurve{}, fmt.Errorf("invalid point %v, expected [position, volume]", value)
+		}
+
+		position, positionOk := curveValue(pair[0])
+		volume, volumeOk := curveValue(pair[1])
+
+		if !positionOk || !volumeOk {
+			return volumeCurve{}, fmt.Errorf("invalid point %v, both values must be between 0.0 and 1.0", value)
+		}
+
+		points[idx] = curvePoint{position, volume}
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Position < points[j].Position
+	})
+
+	for idx := 1; idx < len(points); idx++ {
+		if points[idx].Position == points[idx-1].Position {
+			return volumeCurve{}, fmt.Errorf("more than one point at position %.2f", points[idx].Position)
+		}
+	}
+
+	return volumeCurve{points: points}, nil
+}
+
+// curveValue converts a number from the config, which yaml hands over as either an int or a float
+func curveValue(value interface{}) (float32, bool) {
+	var result float64
+
+	switch v := value.(type) {
+	case int:
+		result = float64(v)
+	case float64:
+		result = v
+	default:
+		return 0, false
+	}
+
+	return float32(result), result >= 0 && result <= 1
+}
+
+// forSlider returns the volume curve of the given slider
+func (sc *sliderCurves) forSlider(sliderIdx int) volumeCurve {
+	if curve, ok := sc.curves[sliderIdx]; ok {
+		return curve
+	}
+
+	return linearCurve
+}
+
+// String returns the per-slider curves in a human-readable form, for the logs
+func (sc *sliderCurves) String() string {
+	return fmt.Sprint(sc.curves)
+}
+
+func (c volumeCurve) String() string {
+	if c.points == nil {
+		return c.name
+	}
+
+	return fmt.Sprint(c.points)
+}
+
+// apply maps a slider position to a volume, with the same 2 points of precision as slider values
+func (c volumeCurve) apply(position float32) float32 {
+	if position < 0 {
+		position = 0
+	} else if position > 1 {
+		position = 1
+	}
+
+	return float32(math.Round(float64(c.volumeAt(position))*100) / 100)
+}
+
+func (c volumeCurve) volumeAt(position float32) float32 {
+	if c.points != nil {
+		return c.interpolate(position)
+	}
+
+	x := float64(position)
+
+	switch c.name {
+	case curveLog:
+		return float32(math.Log1p((curveBase-1)*x) / math.Log(curveBase))
+	case curveExp:
+		return float32((math.Pow(curveBase, x) - 1) / (curveBase - 1))
+	}
+
+	return position
+}
+
+// position maps a volume back to the slider position that results in it, which is where a motorized or virtual
+// slider has to go to show a volume. piecewise linear curves that reach a volume more than once use the first position
+func (c volumeCurve) position(volume float32) float32 {
+	if volume < 0 {
+		volume = 0
+	} else if volume > 1 {
+		volume = 1
+	}
+
+	if c.points != nil {
+		return c.positionOnPoints(volume)
+	}
+
+	// log and exp are each other's inverse
+	switch c.name {
+	case curveLog:
+		return volumeCurve{name: curveExp}.volumeAt(volume)
+	case curveExp:
+		return volumeCurve{name: curveLog}.volumeAt(volume)
+	}
+
+	return volume
+}
+
+func (c volumeCurve) positionOnPoints(volume float32) float32 {
+	// volumes that the curve never reaches go to the closest point's position
+	closest := c.points[0]
+	for _, point := range c.points {
+		if absDistance(point.Volume, volume) < absDistance(closest.Volume, volume) {
+			closest = point
+		}
+	}
+
+	for idx := 1; idx < len(c.points); idx++ {
+		from, to := c.points[idx-1], c.points[idx]
+
+		if (volume < from.Volume) == (volume < to.Volume) && volume != to.Volume {
+			continue
+		}
+
+		if from.Volume == to.Volume {
+			return from.Position
+		}
+
+		return from.Position + (volume-from.Volume)/(to.Volume-from.Volume)*(to.Position-from.Position)
+	}
+
+	return closest.Position
+}
+
+// interpolate connects the curve's points with straight lines, and keeps the volume of
+// the first and last point before and after them
+func (c volumeCurve) interpolate(position float32) float32 {
+	first, last := c.points[0], c.points[len(c.points)-1]
+
+	if position <= first.Position {
+		return first.Volume
+	}
+
+	if position >= last.Position {
+		return last.Volume
+	}
+
+	for idx := 1; idx < len(c.points); idx++ {
+		from, to := c.points[idx-1], c.points[idx]
+
+		if position <= to.Position {
+			return from.Volume + (position-from.Position)/(to.Position-from.Position)*(to.Volume-from.Volume)
+		}
+	}
+
+	return last.Volume
+}
@@ -0,0 +1,211 @@
+package deej
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// TestVolumeCurveApply tests mapping slider positions to volumes with every kind of curve
+func TestVolumeCurveApply(t *testing.T) {
+	points := volumeCurve{points: []curvePoint{{0.1, 0}, {0.5, 0.2}, {1, 1}}}
+
+	tests := []struct {
+		name     string
+		curve    volumeCurve
+		position float32
+		expected float32
+	}{
+		{"linear bottom", linearCurve, 0, 0},
+		{"linear middle", linearCurve, 0.37, 0.37},
+		{"linear top", linearCurve, 1, 1},
+		{"log bottom", volumeCurve{name: curveLog}, 0, 0},
+		{"log quarter", volumeCurve{name: curveLog}, 0.25, 0.51},
+		{"log middle", volumeCurve{name: curveLog}, 0.5, 0.74},
+		{"log top", volumeCurve{name: curveLog}, 1, 1},
+		{"exp bottom", volumeCurve{name: curveExp}, 0, 0},
+		{"exp middle", volumeCurve{name: curveExp}, 0.5, 0.24},
+		{"exp three quarters", volumeCurve{name: curveExp}, 0.75, 0.51},
+		{"exp top", volumeCurve{name: curveExp}, 1, 1},
+		{"points before the first", points, 0.05, 0},
+		{"points on a point", points, 0.5, 0.2},
+		{"points between points", points, 0.3, 0.1},
+		{"points on the steep part", points, 0.75, 0.6},
+		{"points top", points, 1, 1},
+		{"clamped below", volumeCurve{name: curveLog}, -0.5, 0},
+		{"clamped above", volumeCurve{name: curveExp}, 1.5, 1},
+	}
+
+	for _, test := range tests {
+		if volume := test.curve.apply(test.position); absFloat(volume-test.expected) > 0.001 {
+			t.Errorf("%s: expected %.2f to become %.2f, got %.4f", test.name, test.position, test.expected, volume)
+		}
+	}
+}
+
+// TestVolumeCurvePosition tests mapping volumes back to the slider positions that result in them
+func TestVolumeCurvePosition(t *testing.T) {
+	points := volumeCurve{points: []curvePoint{{0.1, 0}, {0.5, 0.2}, {1, 1}}}
+	flat := volumeCurve{points: []curvePoint{{0, 0.2}, {0.5, 0.2}, {1, 0.8}}}
+
+	tests := []struct {
+		name     string
+		curve    volumeCurve
+		volume   float32
+		expected float32
+	}{
+		{"linear", linearCurve, 0.37, 0.37},
+		{"log", volumeCurve{name: curveLog}, 0.74, 0.5},
+		{"exp", volumeCurve{name: curveExp}, 0.24, 0.5},
+		{"points bottom", points, 0, 0.1},
+		{"points between points", points, 0.1, 0.3},
+		{"points on the steep part", points, 0.6, 0.75},
+		{"points top", points, 1, 1},
+		{"flat part takes its start", flat, 0.2, 0},
+		{"below the curve", flat, 0.1, 0},
+		{"above the curve", flat, 0.9, 1},
+		{"clamped", volumeCurve{name: curveLog}, 1.5, 1},
+	}
+
+	for _, test := range tests {
+		if position := test.curve.position(test.volume); absFloat(position-test.expected) > 0.01 {
+			t.Errorf("%s: expected %.2f to come from %.2f, got %.4f", test.name, test.volume, test.expected, position)
+		}
+	}
+
+	// going there and back again ends up where it started
+	for _, curve := range []volumeCurve{linearCurve, {name: curveLog}, {name: curveExp}, points} {
+		for position := float32(0.1); position <= 1; position += 0.1 {
+			if result := curve.position(curve.volumeAt(position)); absFloat(result-position) > 0.001 {
+				t.Errorf("%s: expected %.2f to map back to itself, got %.4f", curve, position, result)
+			}
+		}
+	}
+}
+
+// TestParseVolumeCurve tests parsing named curves and lists of points, as yaml hands them over
+func TestParseVolumeCurve(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected string
+		fails    bool
+	}{
+		{"linear", "linear", false},
+		{"log", "log", false},
+		{"exp", "exp", false},
+		{[]interface{}{[]interface{}{1, 1}, []interface{}{0, 0.25}}, "[{0 0.25} {1 1}]", false},
+		{[]interface{}{[]interface{}{0, 0}, []interface{}{0.5, 0.1}, []interface{}{1, 1}}, "[{0 0} {0.5 0.1} {1 1}]", false},
+		{"logarithmic", "", true},
+		{42, "", true},
+		{[]interface{}{[]interface{}{0, 0}}, "", true},
+		{[]interface{}{[]interface{}{0, 0}, []interface{}{1}}, "", true},
+		{[]interface{}{[]interface{}{0, 0}, []interface{}{1, 1.5}}, "", true},
+		{[]interface{}{[]interface{}{0, 0}, []interface{}{"1", 1}}, "", true},
+		{[]interface{}{[]interface{}{0.5, 0}, []interface{}{0.5, 1}}, "", true},
+	}
+
+	for _, test := range tests {
+		curve, err := parseVolumeCurve(test.value)
+
+		if test.fails {
+			if err == nil {
+				t.Errorf("Expected an error for %v, got %s", test.value, curve)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Failed to parse %v: %v", test.value, err)
+			continue
+		}
+
+		if curve.String() != test.expected {
+			t.Errorf("Expected %s for %v, got %s", test.expected, test.value, curve)
+		}
+	}
+}
+
+// TestSliderCurveConfig tests reading per-slider curves from the config, with linear ones for the rest
+func TestSliderCurveConfig(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	cleanup := createTestConfig(t, `
+slider_mapping:
+  0: master
+slider_curve:
+  0: log
+  2:
+    - [0, 0]
+    - [0.5, 0.1]
+    - [1, 1]
+`)
+	defer cleanup()
+
+	deej := newTestDeej(t, logger)
+	if err := deej.config.Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	for sliderIdx, expected := range map[int]string{
+		0: "log",
+		1: "linear",
+		2: "[{0 0} {0.5 0.1} {1 1}]",
+	} {
+		if curve := deej.config.SliderCurves.forSlider(sliderIdx); curve.String() != expected {
+			t.Errorf("Expected slider %d to use %s, got %s", sliderIdx, expected, curve)
+		}
+	}
+
+	cleanupInvalid := createTestConfig(t, `
+slider_curve:
+  0: logarithmic
+`)
+	defer cleanupInvalid()
+
+	if err := deej.config.Load(); err == nil {
+		t.Error("Expected an error for an unknown curve")
+	}
+}
+
+// TestSliderCurveTargets tests that a slider's curve applies to every kind of target it controls
+func TestSliderCurveTargets(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+
+	master := newFakeSession(logger, masterSessionName)
+	mic := newFakeSession(logger, inputSessionName)
+	speakers := newFakeSession(logger, "speakers")
+	spotify := newFakeSession(logger, "spotify.exe")
+	firefox := newFakeSession(logger, "firefox.exe")
+
+	sf := &fakeSessionFinder{sessions: []Session{master, mic, speakers, spotify, firefox}}
+	m := newTestSessionMap(t, `
+slider_mapping:
+  0: [master, mic, speakers, spotify.exe]
+  1: deej.unmapped
+  2: master
+slider_curve:
+  0: exp
+  1: log
+`, sf)
+
+	m.handleSliderMoveEvent(SliderMoveEvent{SliderID: 0, PercentValue: 0.5})
+
+	for _, session := range []*fakeSession{master, mic, speakers, spotify} {
+		if session.volume != 0.24 {
+			t.Errorf("Expected %s at 0.24 with the exp curve, got %.2f", session.name, session.volume)
+		}
+	}
+
+	m.handleSliderMoveEvent(SliderMoveEvent{SliderID: 1, PercentValue: 0.5})
+
+	if firefox.volume != 0.74 {
+		t.Errorf("Expected the unmapped firefox at 0.74 with the log curve, got %.2f", firefox.volume)
+	}
+
+	m.handleSliderMoveEvent(SliderMoveEvent{SliderID: 2, PercentValue: 0.5})
+
+	if master.volume != 0.5 {
+		t.Errorf("Expected master at 0.5 with the linear curve, got %.2f", master.volume)
+	}
+}